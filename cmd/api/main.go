@@ -6,11 +6,15 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"slices"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/dragondarkon/bqredis-crud/internal/delivery/http"
+	"github.com/dragondarkon/bqredis-crud/internal/encryption"
+	"github.com/dragondarkon/bqredis-crud/internal/masking"
 	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
 	"github.com/dragondarkon/bqredis-crud/internal/usecase"
 	"github.com/dragondarkon/bqredis-crud/pkg/config"
 	"github.com/go-redis/redis/v8"
@@ -25,7 +29,7 @@ func main() {
 	ctx := context.Background()
 
 	// Initialize BigQuery client
-	bqClient, err := bigquery.NewClient(ctx, cfg.GoogleCloudProject)
+	bqClient, err := bigquery.NewClient(ctx, cfg.GoogleCloudProject, cfg.BigQueryOptions()...)
 	if err != nil {
 		log.Fatalf("Failed to create BigQuery client: %v", err)
 	}
@@ -45,18 +49,250 @@ func main() {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 
+	// Resolve how tenants are separated
+	tenantMode, err := repository.ParseTenantMode(cfg.TenantMode)
+	if err != nil {
+		log.Fatalf("Invalid TENANT_MODE: %v", err)
+	}
+	tenancy := repository.Tenancy{Mode: tenantMode, Column: cfg.TenantColumn}
+
 	// Initialize repositories
-	primaryRepo := repository.NewBigQueryRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryTable)
+	primaryRepo := repository.NewBigQueryRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryTable, tenancy)
 	cacheRepo := repository.NewRedisRepository(redisClient, primaryRepo, cfg.RedisTTL)
+	cacheRepo.WithTTL(repository.TTLPolicy{
+		Item:     cfg.CacheItemTTL,
+		ItemSoft: cfg.CacheItemSoftTTL,
+		List:     cfg.CacheListTTL,
+		ListSoft: cfg.CacheListSoftTTL,
+		Missing:  cfg.CacheMissingTTL,
+	})
+	writeStrategy, err := repository.ParseCacheWriteStrategy(cfg.CacheWriteStrategy)
+	if err != nil {
+		log.Fatalf("Invalid CACHE_WRITE_STRATEGY: %v", err)
+	}
+	cacheRepo.WithWriteStrategy(writeStrategy)
+	if cfg.CacheLock {
+		cacheRepo.WithLock(repository.CacheLock{TTL: cfg.CacheLockTTL, Wait: cfg.CacheLockWait})
+	}
+	if cfg.LocalCacheSize > 0 {
+		localCache, err := repository.NewLocalCache(redisClient, cfg.LocalCacheSize, cfg.LocalCacheTTL)
+		if err != nil {
+			log.Fatalf("Invalid LOCAL_CACHE_SIZE: %v", err)
+		}
+		if err := localCache.Listen(ctx); err != nil {
+			log.Fatalf("Failed to listen for cache invalidations: %v", err)
+		}
+		cacheRepo.WithLocalCache(localCache)
+	}
+	// Skip Redis while it is failing, so that an outage does not slow down every request
+	var breakers []*repository.CircuitBreaker
+	if cfg.RedisBreakerFailures > 0 {
+		breaker := repository.NewCircuitBreaker("redis", repository.CircuitBreakerSettings{
+			Failures:    cfg.RedisBreakerFailures,
+			OpenTimeout: cfg.RedisBreakerOpenTimeout,
+			Probes:      cfg.RedisBreakerProbes,
+		})
+		cacheRepo.WithCircuitBreaker(breaker)
+		breakers = append(breakers, breaker)
+	}
+	warmupSelection, err := usecase.ParseWarmupSelection(cfg.WarmupUsersBy)
+	if err != nil {
+		log.Fatalf("Invalid WARMUP_USERS_BY: %v", err)
+	}
+	if warmupSelection == usecase.WarmupFrequent {
+		// Count reads of users so that the most read can be warmed
+		cacheRepo.WithAccessTracking(cfg.WarmupUsers)
+	}
+	groupRepo := repository.NewBigQueryGroupRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryGroupsTable, tenancy)
+	groupCacheRepo := repository.NewRedisGroupRepository(redisClient, groupRepo, cfg.RedisTTL)
+	membershipRepo := repository.NewBigQueryMembershipRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryMembershipsTable, tenancy)
+	membershipCacheRepo := repository.NewRedisMembershipRepository(redisClient, membershipRepo, cfg.RedisTTL)
+	emailIndex := repository.NewRedisEmailIndex(redisClient, primaryRepo)
+	erasureRepo := repository.NewBigQueryErasureRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryErasuresTable, tenancy)
+	statsCacheRepo := repository.NewRedisStatsRepository(redisClient, primaryRepo, cfg.StatsCacheTTL)
+	changeFeed := repository.NewRedisChangeFeed(redisClient, cfg.ChangeStreamMaxLen)
+	webhookRepo := repository.NewBigQueryWebhookRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryWebhooksTable, tenancy)
+	webhookCacheRepo := repository.NewRedisWebhookRepository(redisClient, webhookRepo, cfg.RedisTTL)
+	webhookQueue := repository.NewRedisWebhookQueue(redisClient, cfg.WebhookRetention)
+	outbox := repository.NewRedisOutbox(redisClient)
+	idempotencyStore := repository.NewRedisIdempotencyStore(redisClient)
+
+	// Resolve how long BigQuery keeps historical copies of erased data
+	snapshotPolicy, err := repository.NewSnapshotPolicy(cfg.SnapshotRetention)
+	if err != nil {
+		log.Fatalf("Invalid SNAPSHOT_RETENTION: %v", err)
+	}
+	if cfg.ApplySnapshotRetention {
+		if err := snapshotPolicy.Apply(ctx, bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset); err != nil {
+			log.Printf("Failed to apply snapshot retention: %v", err)
+		}
+	}
 
-	// Initialize use case with primary and cache repositories
-	userUseCase := usecase.NewUserUseCase(primaryRepo, cacheRepo)
+	// Encrypt PII before it reaches BigQuery or Redis when a keyfile is configured
+	var users, cachedUsers repository.UserRepository = primaryRepo, cacheRepo
+	var purger repository.UserPurger = cacheRepo
+	var envelope *encryption.Envelope
+	if cfg.EncryptionKeyFile != "" {
+		keys, err := encryption.LoadKeyFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load encryption keys: %v", err)
+		}
+		envelope = encryption.NewEnvelope(keys)
+		if slices.Contains(cfg.EncryptedFields, "email") {
+			primaryRepo.WithEmailHMAC()
+		}
+		encryptedUsers, err := repository.NewEncryptedUserRepository(primaryRepo, envelope, cfg.EncryptedFields)
+		if err != nil {
+			log.Fatalf("Invalid ENCRYPTED_FIELDS: %v", err)
+		}
+		encryptedCache, err := repository.NewEncryptedUserRepository(cacheRepo, envelope, cfg.EncryptedFields)
+		if err != nil {
+			log.Fatalf("Invalid ENCRYPTED_FIELDS: %v", err)
+		}
+		users, cachedUsers, purger = encryptedUsers, encryptedCache, encryptedCache
+	}
+
+	// Initialize use cases with primary and cache repositories
+	webhookUseCase := usecase.NewWebhookUseCase(webhookCacheRepo, webhookQueue)
+	if cfg.WebhookPrivateTargets {
+		webhookUseCase.WithPrivateTargets()
+	}
+	userUseCase := usecase.NewUserUseCase(users, cachedUsers, membershipCacheRepo, emailIndex, changeFeed, outbox)
+	groupUseCase := usecase.NewGroupUseCase(groupCacheRepo, cachedUsers, membershipCacheRepo)
+	erasureUseCase := usecase.NewErasureUseCase(users, cachedUsers, membershipCacheRepo, purger, erasureRepo, snapshotPolicy, outbox)
+	if envelope != nil {
+		// Tombstones keep an HMAC of the email under the email lookup key
+		erasureUseCase.WithEmailHasher(envelope)
+	}
+	if cfg.ApplySnapshotRetention && tenantMode == repository.TenantModeDataset {
+		// Tenant datasets are created outside of the service, so the policy is
+		// applied to each one when it first erases a user
+		erasureUseCase.WithSnapshotEnforcer(repository.NewDatasetSnapshotEnforcer(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, tenancy, snapshotPolicy))
+	}
+	statsUseCase := usecase.NewStatsUseCase(statsCacheRepo)
+	healthUseCase := usecase.NewHealthUseCase(breakers...)
+
+	// Warm the cache as stored, so that encrypted fields need no keys
+	cacheWarmer := usecase.NewCacheWarmer(cacheRepo, primaryRepo, primaryRepo, usecase.CacheWarmupPlan{
+		Pages:    cfg.WarmupPages,
+		PageSize: cfg.WarmupPageSize,
+		Users:    cfg.WarmupUsers,
+		UsersBy:  warmupSelection,
+	}, float64(cfg.WarmupRate))
 
 	// Initialize Echo framework
 	e := echo.New()
 
+	// Resolve a tenant per request when tenancy is enabled
+	var tenantConfig *http.TenantConfig
+	if tenantMode != repository.TenantModeNone {
+		tenantConfig = &http.TenantConfig{
+			Header:      cfg.TenantHeader,
+			JWTClaim:    cfg.TenantJWTClaim,
+			JWTSecret:   cfg.JWTSecret,
+			TrustHeader: cfg.TenantTrustHeader,
+		}
+	}
+
+	// Mask user fields in responses by the roles of the caller when a policy is configured
+	var maskingConfig *http.MaskingConfig
+	if cfg.MaskingPolicy != "" {
+		policy, err := masking.ParsePolicy(cfg.MaskingPolicy)
+		if err != nil {
+			log.Fatalf("Invalid MASKING_POLICY: %v", err)
+		}
+		maskingConfig = &http.MaskingConfig{
+			Policy:      policy,
+			Header:      cfg.RoleHeader,
+			JWTClaim:    cfg.RoleJWTClaim,
+			JWTSecret:   cfg.JWTSecret,
+			TrustHeader: cfg.RoleTrustHeader,
+		}
+	}
+
+	// Replay the responses to retried user creations
+	idempotencyConfig := &http.IdempotencyConfig{
+		Store:       idempotencyStore,
+		Window:      cfg.IdempotencyWindow,
+		LockTimeout: cfg.IdempotencyLockTimeout,
+		JWTSecret:   cfg.JWTSecret,
+	}
+
 	// Setup routes
-	http.SetupRoutes(e, userUseCase)
+	http.SetupRoutes(e, http.RouteDeps{
+		Users:       userUseCase,
+		Groups:      groupUseCase,
+		Erasures:    erasureUseCase,
+		Stats:       statsUseCase,
+		Webhooks:    webhookUseCase,
+		Health:      healthUseCase,
+		CacheWarmer: cacheWarmer,
+		Tenancy:     tenantConfig,
+		Masking:     maskingConfig,
+		Idempotency: idempotencyConfig,
+		Admin: http.AdminConfig{
+			Role:      cfg.AdminRole,
+			JWTClaim:  cfg.RoleJWTClaim,
+			JWTSecret: cfg.JWTSecret,
+		},
+	})
+
+	// Deliver queued webhook events until shutdown
+	dispatcherCtx, stopDispatcher := context.WithCancel(ctx)
+	defer stopDispatcher()
+	dispatcher := usecase.NewWebhookDispatcher(webhookCacheRepo, webhookQueue, usecase.WebhookRetryPolicy{
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		Backoff:     cfg.WebhookBackoff,
+		MaxBackoff:  cfg.WebhookMaxBackoff,
+	}, cfg.WebhookTimeout)
+	if cfg.WebhookPrivateTargets {
+		dispatcher.WithPrivateTargets()
+	}
+	go dispatcher.Run(dispatcherCtx, cfg.WebhookPollInterval)
+
+	// Publish the user changes recorded in the outbox until shutdown
+	var sinks []usecase.EventSink
+	for _, name := range cfg.OutboxSinks {
+		switch name {
+		case usecase.SinkRedisStream:
+			sinks = append(sinks, usecase.NewChangeFeedSink(changeFeed))
+		case usecase.SinkStdout:
+			sinks = append(sinks, usecase.NewWriterSink(os.Stdout))
+		case usecase.SinkWebhooks:
+			sinks = append(sinks, usecase.NewWebhookSink(webhookUseCase))
+		default:
+			log.Fatalf("Invalid OUTBOX_SINKS: unknown sink %q", name)
+		}
+	}
+	relay := usecase.NewOutboxRelay(outbox, users, sinks, cfg.OutboxLease, cfg.OutboxReconcileAfter)
+	go relay.Run(dispatcherCtx, cfg.OutboxPollInterval)
+
+	// Warm the cache in the background, so that the server starts right away
+	if cfg.WarmupOnStart {
+		warmupContexts := []context.Context{dispatcherCtx}
+		if tenantMode != repository.TenantModeNone {
+			if len(cfg.WarmupTenants) == 0 {
+				log.Fatal("WARMUP_TENANTS is required to warm the cache on start when TENANT_MODE is set")
+			}
+			warmupContexts = nil
+			for _, tenantID := range cfg.WarmupTenants {
+				if err := tenant.Validate(tenantID); err != nil {
+					log.Fatalf("Invalid WARMUP_TENANTS: %v", err)
+				}
+				warmupContexts = append(warmupContexts, tenant.WithID(dispatcherCtx, tenantID))
+			}
+		}
+		go func() {
+			for _, ctx := range warmupContexts {
+				report, err := cacheWarmer.Warm(ctx)
+				if err != nil {
+					log.Printf("Failed to warm the cache: %v", err)
+					continue
+				}
+				log.Printf("Warmed the cache with %d pages and %d users, %d failed", report.Pages, report.Users, report.Failed)
+			}
+		}()
+	}
 
 	// Start server in a goroutine
 	go func() {
@@ -0,0 +1,125 @@
+// Command reencrypt rewrites every user whose encrypted fields do not use the
+// primary key of the keyfile, e.g. after a key rotation or after encryption
+// was enabled for existing data.
+//
+// Usage:
+//
+//	ENCRYPTION_KEYFILE=keys.json go run ./cmd/reencrypt [-tenant acme] [-dry-run]
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"slices"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/dragondarkon/bqredis-crud/internal/encryption"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+	"github.com/dragondarkon/bqredis-crud/pkg/config"
+	"github.com/go-redis/redis/v8"
+)
+
+func main() {
+	tenantID := flag.String("tenant", "", "tenant to re-encrypt when TENANT_MODE is set")
+	pageSize := flag.Int("page-size", 100, "users read per BigQuery query")
+	dryRun := flag.Bool("dry-run", false, "count the users to re-encrypt without writing them")
+	flag.Parse()
+
+	// Load configuration
+	cfg := config.LoadConfig()
+	if cfg.EncryptionKeyFile == "" {
+		log.Fatal("ENCRYPTION_KEYFILE is required")
+	}
+
+	// Initialize context, scoped to the tenant if one is given
+	ctx := context.Background()
+	if *tenantID != "" {
+		if err := tenant.Validate(*tenantID); err != nil {
+			log.Fatalf("Invalid tenant: %v", err)
+		}
+		ctx = tenant.WithID(ctx, *tenantID)
+	}
+
+	tenantMode, err := repository.ParseTenantMode(cfg.TenantMode)
+	if err != nil {
+		log.Fatalf("Invalid TENANT_MODE: %v", err)
+	}
+	tenancy := repository.Tenancy{Mode: tenantMode, Column: cfg.TenantColumn}
+
+	keys, err := encryption.LoadKeyFile(cfg.EncryptionKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+
+	// Initialize BigQuery and Redis clients
+	bqClient, err := bigquery.NewClient(ctx, cfg.GoogleCloudProject, cfg.BigQueryOptions()...)
+	if err != nil {
+		log.Fatalf("Failed to create BigQuery client: %v", err)
+	}
+	defer bqClient.Close()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       0,
+	})
+	defer redisClient.Close()
+
+	// Initialize repositories; writes go through the cache so stale entries are invalidated
+	primaryRepo := repository.NewBigQueryRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryTable, tenancy)
+	if slices.Contains(cfg.EncryptedFields, "email") {
+		primaryRepo.WithEmailHMAC()
+	}
+	cacheRepo := repository.NewRedisRepository(redisClient, primaryRepo, cfg.RedisTTL)
+	if cfg.LocalCacheSize > 0 {
+		// Invalidations are broadcast to the in-process tiers of the API instances
+		localCache, err := repository.NewLocalCache(redisClient, cfg.LocalCacheSize, cfg.LocalCacheTTL)
+		if err != nil {
+			log.Fatalf("Invalid LOCAL_CACHE_SIZE: %v", err)
+		}
+		cacheRepo.WithLocalCache(localCache)
+	}
+	encrypted, err := repository.NewEncryptedUserRepository(cacheRepo, encryption.NewEnvelope(keys), cfg.EncryptedFields)
+	if err != nil {
+		log.Fatalf("Invalid ENCRYPTED_FIELDS: %v", err)
+	}
+
+	// Updates keep created_at, so the GetAll order is stable while paging
+	var scanned, rewritten int
+	for page := 1; ; page++ {
+		users, err := primaryRepo.GetAll(ctx, repository.PaginationParams{Page: page, PageSize: *pageSize})
+		if err != nil {
+			log.Fatalf("Failed to read users: %v", err)
+		}
+
+		for _, stored := range users {
+			scanned++
+			user, changed, err := encrypted.Reencrypt(ctx, stored)
+			if err != nil {
+				log.Fatalf("Failed to re-encrypt user %s: %v", stored.ID, err)
+			}
+			if !changed {
+				continue
+			}
+			rewritten++
+			if *dryRun {
+				continue
+			}
+			if err := cacheRepo.Update(ctx, user); err != nil {
+				log.Fatalf("Failed to write user %s: %v", user.ID, err)
+			}
+		}
+
+		if len(users) < *pageSize {
+			break
+		}
+	}
+
+	if *dryRun {
+		log.Printf("Scanned %d users, %d need re-encryption", scanned, rewritten)
+		return
+	}
+	log.Printf("Scanned %d users, re-encrypted %d", scanned, rewritten)
+}
@@ -0,0 +1,93 @@
+// Command warmup preloads the user cache, e.g. after a Redis flush, with the
+// first list pages and the most recently updated or most read users. It reads
+// the WARMUP_* settings, which the flags override.
+//
+// Usage:
+//
+//	go run ./cmd/warmup [-tenant acme] [-pages 5] [-users 100] [-by recent|frequent] [-rate 5]
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+	"github.com/dragondarkon/bqredis-crud/internal/usecase"
+	"github.com/dragondarkon/bqredis-crud/pkg/config"
+	"github.com/go-redis/redis/v8"
+)
+
+func main() {
+	// Load configuration, whose warm-up settings are the flag defaults
+	cfg := config.LoadConfig()
+
+	tenantID := flag.String("tenant", "", "tenant to warm when TENANT_MODE is set")
+	pages := flag.Int("pages", cfg.WarmupPages, "list pages to load, from the first")
+	pageSize := flag.Int("page-size", cfg.WarmupPageSize, "size of the list pages to load")
+	users := flag.Int("users", cfg.WarmupUsers, "users to load")
+	usersBy := flag.String("by", cfg.WarmupUsersBy, "users to load: recent or frequent")
+	queriesPerSecond := flag.Float64("rate", float64(cfg.WarmupRate), "BigQuery queries per second; 0 does not limit them")
+	flag.Parse()
+
+	selection, err := usecase.ParseWarmupSelection(*usersBy)
+	if err != nil {
+		log.Fatalf("Invalid -by: %v", err)
+	}
+
+	// Initialize context, scoped to the tenant if one is given
+	ctx := context.Background()
+	if *tenantID != "" {
+		if err := tenant.Validate(*tenantID); err != nil {
+			log.Fatalf("Invalid tenant: %v", err)
+		}
+		ctx = tenant.WithID(ctx, *tenantID)
+	}
+
+	tenantMode, err := repository.ParseTenantMode(cfg.TenantMode)
+	if err != nil {
+		log.Fatalf("Invalid TENANT_MODE: %v", err)
+	}
+	tenancy := repository.Tenancy{Mode: tenantMode, Column: cfg.TenantColumn}
+
+	// Initialize BigQuery and Redis clients
+	bqClient, err := bigquery.NewClient(ctx, cfg.GoogleCloudProject, cfg.BigQueryOptions()...)
+	if err != nil {
+		log.Fatalf("Failed to create BigQuery client: %v", err)
+	}
+	defer bqClient.Close()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       0,
+	})
+	defer redisClient.Close()
+
+	// Initialize repositories; users are cached as stored, so encrypted fields need no keys
+	primaryRepo := repository.NewBigQueryRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryTable, tenancy)
+	cacheRepo := repository.NewRedisRepository(redisClient, primaryRepo, cfg.RedisTTL)
+	cacheRepo.WithTTL(repository.TTLPolicy{
+		Item:     cfg.CacheItemTTL,
+		ItemSoft: cfg.CacheItemSoftTTL,
+		List:     cfg.CacheListTTL,
+		ListSoft: cfg.CacheListSoftTTL,
+		Missing:  cfg.CacheMissingTTL,
+	})
+
+	warmer := usecase.NewCacheWarmer(cacheRepo, primaryRepo, primaryRepo, usecase.CacheWarmupPlan{
+		Pages:    *pages,
+		PageSize: *pageSize,
+		Users:    *users,
+		UsersBy:  selection,
+	}, *queriesPerSecond)
+	report, err := warmer.Warm(ctx)
+	if err != nil {
+		log.Fatalf("Failed to warm the cache: %v", err)
+	}
+	log.Printf("Warmed the cache with %d pages and %d users in %s, %d failed",
+		report.Pages, report.Users, report.CompletedAt.Sub(report.StartedAt).Round(time.Millisecond), report.Failed)
+}
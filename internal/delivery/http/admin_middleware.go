@@ -0,0 +1,49 @@
+package http
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminConfig configures who may call the admin routes
+type AdminConfig struct {
+	// Role must be among the roles of the bearer token claim
+	Role string
+	// JWTClaim names the claim of a bearer token holding a role, a space-separated
+	// scope string or a list of roles
+	JWTClaim string
+	// JWTSecret verifies HS256 bearer tokens; empty refuses every admin request
+	JWTSecret string
+}
+
+// AdminMiddleware admits only requests with a verified bearer token granting
+// the admin role; headers are never trusted for it
+func AdminMiddleware(config AdminConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.JWTSecret == "" || config.Role == "" {
+				return c.JSON(http.StatusForbidden, ErrorResponse{
+					Code:    ErrCodeForbidden,
+					Message: "Admin routes are disabled",
+				})
+			}
+
+			claims, err := bearerClaims(c.Request(), config.JWTSecret)
+			if err != nil || claims == nil {
+				return c.JSON(http.StatusUnauthorized, ErrorResponse{
+					Code:    ErrCodeUnauthorized,
+					Message: "Missing or invalid bearer token",
+				})
+			}
+			if !slices.Contains(rolesFromClaim(claims[config.JWTClaim]), config.Role) {
+				return c.JSON(http.StatusForbidden, ErrorResponse{
+					Code:    ErrCodeForbidden,
+					Message: "Admin role required",
+				})
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	delivery "github.com/dragondarkon/bqredis-crud/internal/delivery/http"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func TestAdminMiddleware(t *testing.T) {
+	adminToken := signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"role": []string{"support", "admin"}})
+
+	tests := []struct {
+		name       string
+		secret     string
+		header     string
+		token      string
+		wantStatus int
+	}{
+		{name: "admin token", secret: testJWTSecret, token: adminToken, wantStatus: http.StatusOK},
+		{
+			name:       "admin scope",
+			secret:     testJWTSecret,
+			token:      signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"role": "users:read admin"}),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "token without admin role",
+			secret:     testJWTSecret,
+			token:      signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"role": "support"}),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "wrong secret",
+			secret:     testJWTSecret,
+			token:      signToken(t, jwt.SigningMethodHS256, []byte("other-secret"), jwt.MapClaims{"role": "admin"}),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{name: "missing token", secret: testJWTSecret, wantStatus: http.StatusUnauthorized},
+		{name: "role header", secret: testJWTSecret, header: "admin", wantStatus: http.StatusUnauthorized},
+		{name: "no secret", token: adminToken, header: "admin", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(delivery.AdminMiddleware(delivery.AdminConfig{
+				Role:      "admin",
+				JWTClaim:  "role",
+				JWTSecret: tt.secret,
+			}))
+			e.GET("/", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Roles", tt.header)
+			}
+			if tt.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/dragondarkon/bqredis-crud/internal/usecase"
+	"github.com/labstack/echo/v4"
+)
+
+// CacheHandler handles HTTP requests administering the cache
+type CacheHandler struct {
+	cacheWarmer *usecase.CacheWarmer
+}
+
+// NewCacheHandler creates a new cache handler
+func NewCacheHandler(cacheWarmer *usecase.CacheWarmer) *CacheHandler {
+	return &CacheHandler{
+		cacheWarmer: cacheWarmer,
+	}
+}
+
+// WarmCache handles POST /admin/cache/warmup
+func (h *CacheHandler) WarmCache(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	report, err := h.cacheWarmer.Warm(ctx)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// changeHeartbeat keeps idle change streams open through proxies
+	changeHeartbeat = 15 * time.Second
+
+	// changeWriteTimeout bounds a single WebSocket write
+	changeWriteTimeout = 10 * time.Second
+)
+
+// changeUpgrader upgrades change feed requests to WebSockets; like browsers'
+// EventSource it only accepts same-origin pages
+var changeUpgrader = websocket.Upgrader{}
+
+// changeFilters reads the user_id filters, repeated or comma-separated, and the
+// event ID to resume from, which EventSource sends in the Last-Event-ID header
+func changeFilters(c echo.Context) (string, []string) {
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("last_event_id")
+	}
+
+	var userIDs []string
+	for _, value := range c.QueryParams()["user_id"] {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				userIDs = append(userIDs, id)
+			}
+		}
+	}
+	return lastEventID, userIDs
+}
+
+// maskChange masks the user of a change for the caller
+func (h *UserHandler) maskChange(ctx context.Context, change entity.UserChange) entity.UserChange {
+	if change.User != nil {
+		user := h.policy.MaskUser(ctx, *change.User)
+		change.User = &user
+	}
+	return change
+}
+
+// WatchChanges handles GET /users/changes as a Server-Sent Events stream
+func (h *UserHandler) WatchChanges(c echo.Context) error {
+	ctx := c.Request().Context()
+	lastEventID, userIDs := changeFilters(c)
+
+	changes, err := h.userUseCase.WatchChanges(ctx, lastEventID, userIDs)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	heartbeat := time.NewTicker(changeHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case change, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(h.maskChange(ctx, change))
+			if err != nil {
+				log.Printf("Failed to encode change %s: %v", change.ID, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(res, "id: %s\nevent: %s\ndata: %s\n\n", change.ID, change.Type, data); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// WatchChangesWebSocket handles GET /users/changes/ws, sending each change as a JSON message
+func (h *UserHandler) WatchChangesWebSocket(c echo.Context) error {
+	// The request context outlives a hijacked connection, so stop on read errors instead
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	lastEventID, userIDs := changeFilters(c)
+
+	changes, err := h.userUseCase.WatchChanges(ctx, lastEventID, userIDs)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	conn, err := changeUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// The upgrader has already written an error response
+		return nil
+	}
+	defer conn.Close()
+
+	// Clients only send control frames; reading processes them and detects the close
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(changeHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(changeWriteTimeout)); err != nil {
+				return nil
+			}
+		case change, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			conn.SetWriteDeadline(time.Now().Add(changeWriteTimeout))
+			if err := conn.WriteJSON(h.maskChange(ctx, change)); err != nil {
+				return nil
+			}
+		}
+	}
+}
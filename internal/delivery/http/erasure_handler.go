@@ -0,0 +1,51 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/usecase"
+	"github.com/labstack/echo/v4"
+)
+
+// ErasureHandler handles HTTP requests for erasing users' personal data
+type ErasureHandler struct {
+	erasureUseCase *usecase.ErasureUseCase
+}
+
+// NewErasureHandler creates a new erasure handler
+func NewErasureHandler(erasureUseCase *usecase.ErasureUseCase) *ErasureHandler {
+	return &ErasureHandler{
+		erasureUseCase: erasureUseCase,
+	}
+}
+
+// ErasureResponse is an erasure report with the result of verifying its digest
+type ErasureResponse struct {
+	entity.ErasureReport
+	Verified bool `json:"verified"`
+}
+
+// EraseUser handles POST /users/:id/erasure
+func (h *ErasureHandler) EraseUser(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	report, err := h.erasureUseCase.EraseUser(ctx, c.Param("id"))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// GetErasure handles GET /erasures/:id
+func (h *ErasureHandler) GetErasure(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	report, verified, err := h.erasureUseCase.GetErasure(ctx, c.Param("id"))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, ErasureResponse{ErasureReport: report, Verified: verified})
+}
@@ -0,0 +1,174 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/masking"
+	"github.com/dragondarkon/bqredis-crud/internal/usecase"
+	"github.com/labstack/echo/v4"
+)
+
+// GroupHandler handles HTTP requests for group and membership operations
+type GroupHandler struct {
+	groupUseCase *usecase.GroupUseCase
+	policy       *masking.Policy
+}
+
+// NewGroupHandler creates a new group handler; a nil policy disables masking of members
+func NewGroupHandler(groupUseCase *usecase.GroupUseCase, policy *masking.Policy) *GroupHandler {
+	return &GroupHandler{
+		groupUseCase: groupUseCase,
+		policy:       policy,
+	}
+}
+
+// GetGroups handles GET /groups
+func (h *GroupHandler) GetGroups(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	page, pageSize := parsePagination(c)
+
+	groups, err := h.groupUseCase.GetAllGroups(ctx, page, pageSize)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": groups,
+		"pagination": map[string]int{
+			"page":     page,
+			"pageSize": pageSize,
+		},
+	})
+}
+
+// GetGroup handles GET /groups/:id
+func (h *GroupHandler) GetGroup(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	group, err := h.groupUseCase.GetGroupByID(ctx, id)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, group)
+}
+
+// CreateGroup handles POST /groups
+func (h *GroupHandler) CreateGroup(c echo.Context) error {
+	ctx := c.Request().Context()
+	var group entity.Group
+
+	if err := c.Bind(&group); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    ErrCodeValidation,
+			Message: "Invalid request payload",
+		})
+	}
+
+	createdGroup, err := h.groupUseCase.CreateGroup(ctx, group)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, createdGroup)
+}
+
+// UpdateGroup handles PUT /groups/:id
+func (h *GroupHandler) UpdateGroup(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	var group entity.Group
+	if err := c.Bind(&group); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    ErrCodeValidation,
+			Message: "Invalid request payload",
+		})
+	}
+
+	// Ensure ID matches
+	group.ID = id
+
+	updatedGroup, err := h.groupUseCase.UpdateGroup(ctx, group)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, updatedGroup)
+}
+
+// DeleteGroup handles DELETE /groups/:id
+func (h *GroupHandler) DeleteGroup(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	if err := h.groupUseCase.DeleteGroup(ctx, id); err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Group deleted successfully"})
+}
+
+// AddMember handles POST /groups/:id/members/:userId
+func (h *GroupHandler) AddMember(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	membership, err := h.groupUseCase.AddMember(ctx, c.Param("id"), c.Param("userId"))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, membership)
+}
+
+// RemoveMember handles DELETE /groups/:id/members/:userId
+func (h *GroupHandler) RemoveMember(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := h.groupUseCase.RemoveMember(ctx, c.Param("id"), c.Param("userId")); err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Member removed successfully"})
+}
+
+// GetMembers handles GET /groups/:id/members
+func (h *GroupHandler) GetMembers(c echo.Context) error {
+	ctx := c.Request().Context()
+	page, pageSize := parsePagination(c)
+
+	users, err := h.groupUseCase.GetGroupMembers(ctx, c.Param("id"), page, pageSize)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": h.policy.MaskUsers(ctx, users),
+		"pagination": map[string]int{
+			"page":     page,
+			"pageSize": pageSize,
+		},
+	})
+}
+
+// GetUserGroups handles GET /users/:id/groups
+func (h *GroupHandler) GetUserGroups(c echo.Context) error {
+	ctx := c.Request().Context()
+	page, pageSize := parsePagination(c)
+
+	groups, err := h.groupUseCase.GetUserGroups(ctx, c.Param("id"), page, pageSize)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": groups,
+		"pagination": map[string]int{
+			"page":     page,
+			"pageSize": pageSize,
+		},
+	})
+}
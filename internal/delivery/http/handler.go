@@ -2,10 +2,15 @@ package http
 
 import (
 	"errors"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/masking"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
 	"github.com/dragondarkon/bqredis-crud/internal/usecase"
 	"github.com/labstack/echo/v4"
 )
@@ -18,20 +23,26 @@ type ErrorResponse struct {
 
 // Common error codes
 const (
-	ErrCodeValidation = "VALIDATION_ERROR"
-	ErrCodeNotFound   = "NOT_FOUND"
-	ErrCodeInternal   = "INTERNAL_ERROR"
+	ErrCodeValidation   = "VALIDATION_ERROR"
+	ErrCodeNotFound     = "NOT_FOUND"
+	ErrCodeUnauthorized = "UNAUTHORIZED"
+	ErrCodeForbidden    = "FORBIDDEN"
+	ErrCodeConflict     = "CONFLICT"
+	ErrCodeUnsupported  = "UNSUPPORTED_MEDIA_TYPE"
+	ErrCodeInternal     = "INTERNAL_ERROR"
 )
 
 // UserHandler handles HTTP requests for user operations
 type UserHandler struct {
 	userUseCase *usecase.UserUseCase
+	policy      *masking.Policy
 }
 
-// NewUserHandler creates a new user handler
-func NewUserHandler(userUseCase *usecase.UserUseCase) *UserHandler {
+// NewUserHandler creates a new user handler; a nil policy disables masking
+func NewUserHandler(userUseCase *usecase.UserUseCase, policy *masking.Policy) *UserHandler {
 	return &UserHandler{
 		userUseCase: userUseCase,
+		policy:      policy,
 	}
 }
 
@@ -46,12 +57,60 @@ func handleError(c echo.Context, err error) error {
 			Message: "User not found",
 		}
 		return c.JSON(http.StatusNotFound, response)
+	case errors.Is(err, usecase.ErrGroupNotFound):
+		response = ErrorResponse{
+			Code:    ErrCodeNotFound,
+			Message: "Group not found",
+		}
+		return c.JSON(http.StatusNotFound, response)
+	case errors.Is(err, usecase.ErrMembershipNotFound):
+		response = ErrorResponse{
+			Code:    ErrCodeNotFound,
+			Message: "Membership not found",
+		}
+		return c.JSON(http.StatusNotFound, response)
+	case errors.Is(err, usecase.ErrErasureNotFound):
+		response = ErrorResponse{
+			Code:    ErrCodeNotFound,
+			Message: "Erasure not found",
+		}
+		return c.JSON(http.StatusNotFound, response)
+	case errors.Is(err, usecase.ErrWebhookNotFound):
+		response = ErrorResponse{
+			Code:    ErrCodeNotFound,
+			Message: "Webhook not found",
+		}
+		return c.JSON(http.StatusNotFound, response)
+	case errors.Is(err, usecase.ErrDeliveryNotFound):
+		response = ErrorResponse{
+			Code:    ErrCodeNotFound,
+			Message: "Delivery not found",
+		}
+		return c.JSON(http.StatusNotFound, response)
 	case errors.Is(err, usecase.ErrValidation):
 		response = ErrorResponse{
 			Code:    ErrCodeValidation,
 			Message: err.Error(),
 		}
 		return c.JSON(http.StatusBadRequest, response)
+	case errors.Is(err, usecase.ErrConflict):
+		response = ErrorResponse{
+			Code:    ErrCodeConflict,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusConflict, response)
+	case errors.Is(err, usecase.ErrUnsupportedPatch):
+		response = ErrorResponse{
+			Code:    ErrCodeUnsupported,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusUnsupportedMediaType, response)
+	case errors.Is(err, tenant.ErrMissing), errors.Is(err, tenant.ErrInvalid):
+		response = ErrorResponse{
+			Code:    ErrCodeValidation,
+			Message: err.Error(),
+		}
+		return c.JSON(http.StatusBadRequest, response)
 	default:
 		response = ErrorResponse{
 			Code:    ErrCodeInternal,
@@ -61,11 +120,8 @@ func handleError(c echo.Context, err error) error {
 	}
 }
 
-// GetUsers handles GET /users
-func (h *UserHandler) GetUsers(c echo.Context) error {
-	ctx := c.Request().Context()
-
-	// Parse pagination parameters
+// parsePagination reads the page and pageSize query parameters with their defaults
+func parsePagination(c echo.Context) (int, int) {
 	page := 1
 	pageSize := 10
 
@@ -81,13 +137,22 @@ func (h *UserHandler) GetUsers(c echo.Context) error {
 		}
 	}
 
+	return page, pageSize
+}
+
+// GetUsers handles GET /users
+func (h *UserHandler) GetUsers(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	page, pageSize := parsePagination(c)
+
 	users, err := h.userUseCase.GetAllUsers(ctx, page, pageSize)
 	if err != nil {
 		return handleError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"data": users,
+		"data": h.policy.MaskUsers(ctx, users),
 		"pagination": map[string]int{
 			"page":     page,
 			"pageSize": pageSize,
@@ -105,7 +170,26 @@ func (h *UserHandler) GetUser(c echo.Context) error {
 		return handleError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, user)
+	return c.JSON(http.StatusOK, h.policy.MaskUser(ctx, user))
+}
+
+// GetUserByEmail handles GET /users/by-email/:email
+func (h *UserHandler) GetUserByEmail(c echo.Context) error {
+	ctx := c.Request().Context()
+	email, err := url.PathUnescape(c.Param("email"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    ErrCodeValidation,
+			Message: "Invalid email",
+		})
+	}
+
+	user, err := h.userUseCase.GetUserByEmail(ctx, email)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, h.policy.MaskUser(ctx, user))
 }
 
 // CreateUser handles POST /users
@@ -125,7 +209,7 @@ func (h *UserHandler) CreateUser(c echo.Context) error {
 		return handleError(c, err)
 	}
 
-	return c.JSON(http.StatusCreated, createdUser)
+	return c.JSON(http.StatusCreated, h.policy.MaskUser(ctx, createdUser))
 }
 
 // UpdateUser handles PUT /users/:id
@@ -149,7 +233,29 @@ func (h *UserHandler) UpdateUser(c echo.Context) error {
 		return handleError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, updatedUser)
+	return c.JSON(http.StatusOK, h.policy.MaskUser(ctx, updatedUser))
+}
+
+// PatchUser handles PATCH /users/:id with a JSON Merge Patch or JSON Patch body
+func (h *UserHandler) PatchUser(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	patchType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	patch, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    ErrCodeValidation,
+			Message: "Invalid request payload",
+		})
+	}
+
+	patchedUser, err := h.userUseCase.PatchUser(ctx, id, patchType, patch)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, h.policy.MaskUser(ctx, patchedUser))
 }
 
 // DeleteUser handles DELETE /users/:id
@@ -0,0 +1,28 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/dragondarkon/bqredis-crud/internal/usecase"
+	"github.com/labstack/echo/v4"
+)
+
+// HealthHandler handles HTTP requests for the health of the service
+type HealthHandler struct {
+	healthUseCase *usecase.HealthUseCase
+}
+
+// NewHealthHandler creates a new health handler
+func NewHealthHandler(healthUseCase *usecase.HealthUseCase) *HealthHandler {
+	return &HealthHandler{
+		healthUseCase: healthUseCase,
+	}
+}
+
+// GetHealth handles GET /health. A degraded service still serves requests,
+// so it answers 200 as well.
+func (h *HealthHandler) GetHealth(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	return c.JSON(http.StatusOK, h.healthUseCase.GetHealth(ctx))
+}
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/masking"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/labstack/echo/v4"
+)
+
+// Idempotency headers
+const (
+	// IdempotencyKeyHeader carries the key a client reuses when retrying a request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a response replayed for a retried request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKeyLength bounds the length of idempotency keys
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyConfig configures how requests carrying an idempotency key are deduplicated
+type IdempotencyConfig struct {
+	Store repository.IdempotencyStore
+	// Window is how long the response to a key is replayed
+	Window time.Duration
+	// LockTimeout is how long a key stays reserved by a request that never completes
+	LockTimeout time.Duration
+	// JWTSecret verifies HS256 bearer tokens, whose subject scopes the keys so
+	// that callers cannot replay each other's responses; empty disables it
+	JWTSecret string
+}
+
+// responseRecorder keeps a copy of the body written to a response
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware stores the response to each request carrying an
+// Idempotency-Key and replays it when the request is retried with the same key.
+// A retry while the first request is in progress gets 409, and reusing a key
+// for a different request gets 422. Server errors are not stored, so the
+// request can be retried. Keys are scoped by the subject of the bearer token,
+// on top of the tenant scoping of the store.
+func IdempotencyMiddleware(config IdempotencyConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return c.JSON(http.StatusBadRequest, ErrorResponse{
+					Code:    ErrCodeValidation,
+					Message: "Idempotency-Key is too long",
+				})
+			}
+
+			claims, err := bearerClaims(c.Request(), config.JWTSecret)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, ErrorResponse{
+					Code:    ErrCodeUnauthorized,
+					Message: "Invalid bearer token",
+				})
+			}
+			subject, _ := claims["sub"].(string)
+			key = subjectKey(subject, key)
+
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, ErrorResponse{
+					Code:    ErrCodeValidation,
+					Message: "Invalid request payload",
+				})
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := c.Request().Context()
+			fingerprint := requestFingerprint(c.Request(), body)
+			stored, reserved, err := config.Store.Reserve(ctx, key, fingerprint, config.LockTimeout)
+			if err != nil {
+				return handleError(c, err)
+			}
+			if !reserved {
+				return replayResponse(c, stored, fingerprint)
+			}
+
+			recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+			err = next(c)
+
+			// The response is stored even if the client went away, which is when it retries
+			ctx = context.WithoutCancel(ctx)
+			status := c.Response().Status
+			if err != nil || !c.Response().Committed || status >= http.StatusInternalServerError {
+				if err := config.Store.Release(ctx, key); err != nil {
+					log.Printf("Failed to release idempotency key %s: %v", key, err)
+				}
+				return err
+			}
+			response := repository.IdempotentResponse{
+				Fingerprint: fingerprint,
+				Status:      status,
+				ContentType: c.Response().Header().Get(echo.HeaderContentType),
+				Body:        recorder.body.Bytes(),
+			}
+			if err := config.Store.Save(ctx, key, response, config.Window); err != nil {
+				log.Printf("Failed to save response for idempotency key %s: %v", key, err)
+			}
+			return nil
+		}
+	}
+}
+
+// subjectKey scopes a key to a subject. The subject is escaped so that it
+// cannot contain the separator, and callers without one share the empty subject.
+func subjectKey(subject, key string) string {
+	return url.QueryEscape(subject) + ":" + key
+}
+
+// requestFingerprint identifies what a request asks for. The roles of the
+// caller are part of it since responses are masked by role.
+func requestFingerprint(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	for _, part := range []string{req.Method, req.URL.Path, strings.Join(masking.RolesFromContext(req.Context()), ",")} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// replayResponse answers a retried request with the stored response
+func replayResponse(c echo.Context, stored repository.IdempotentResponse, fingerprint string) error {
+	switch {
+	case stored.Fingerprint != fingerprint:
+		return c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+			Code:    ErrCodeValidation,
+			Message: "Idempotency-Key was already used for a different request",
+		})
+	case stored.Status == 0:
+		return c.JSON(http.StatusConflict, ErrorResponse{
+			Code:    ErrCodeConflict,
+			Message: "A request with this Idempotency-Key is in progress",
+		})
+	}
+
+	c.Response().Header().Set(IdempotentReplayedHeader, "true")
+	return c.Blob(stored.Status, stored.ContentType, stored.Body)
+}
@@ -0,0 +1,162 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	delivery "github.com/dragondarkon/bqredis-crud/internal/delivery/http"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	e := echo.New()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	e.POST("/users", func(c echo.Context) error {
+		n := calls.Add(1)
+		switch c.Request().Header.Get("X-Behavior") {
+		case "block":
+			<-release
+		case "fail":
+			return c.JSON(http.StatusInternalServerError, delivery.ErrorResponse{Code: delivery.ErrCodeInternal})
+		}
+		return c.JSON(http.StatusCreated, map[string]int32{"call": n})
+	}, delivery.IdempotencyMiddleware(delivery.IdempotencyConfig{
+		Store:       repository.NewRedisIdempotencyStore(client),
+		Window:      time.Hour,
+		LockTimeout: time.Minute,
+	}))
+
+	post := func(key, body, behavior string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if key != "" {
+			req.Header.Set(delivery.IdempotencyKeyHeader, key)
+		}
+		if behavior != "" {
+			req.Header.Set("X-Behavior", behavior)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A retry replays the first response without running the handler again
+	first := post("key-1", `{"name":"Ada"}`, "")
+	retry := post("key-1", `{"name":"Ada"}`, "")
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %s, want %d %s", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get(delivery.IdempotentReplayedHeader) != "true" || retry.Header().Get(echo.HeaderContentType) != first.Header().Get(echo.HeaderContentType) {
+		t.Errorf("retry headers = %v, want a replayed JSON response", retry.Header())
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler calls = %d, want 1", n)
+	}
+
+	if rec := post("key-1", `{"name":"Grace"}`, ""); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key for another request status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if rec := post(strings.Repeat("k", 256), `{}`, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("long key status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// Requests without a key are not deduplicated
+	post("", `{"name":"Ada"}`, "")
+	post("", `{"name":"Ada"}`, "")
+	if n := calls.Load(); n != 3 {
+		t.Errorf("handler calls without key = %d, want 3", n)
+	}
+
+	// A duplicate of a request in progress conflicts
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post("key-2", `{}`, "block") }()
+	deadline := time.Now().Add(2 * time.Second)
+	for calls.Load() != 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if rec := post("key-2", `{}`, "block"); rec.Code != http.StatusConflict {
+		t.Errorf("concurrent duplicate status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	close(release)
+	if rec := <-done; rec.Code != http.StatusCreated {
+		t.Errorf("blocked request status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	// Server errors are not stored, so the request can be retried
+	if rec := post("key-3", `{}`, "fail"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("failing request status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if rec := post("key-3", `{}`, "fail"); rec.Header().Get(delivery.IdempotentReplayedHeader) != "" || calls.Load() != 6 {
+		t.Errorf("retry after server error was replayed, want the handler to run again")
+	}
+
+	// Keys expire after the window
+	mr.FastForward(2 * time.Hour)
+	if rec := post("key-1", `{"name":"Grace"}`, ""); rec.Code != http.StatusCreated || rec.Header().Get(delivery.IdempotentReplayedHeader) != "" {
+		t.Errorf("request after window = %d %v, want a new response", rec.Code, rec.Header())
+	}
+}
+
+func TestIdempotencyMiddlewareScopesKeysBySubject(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	e := echo.New()
+	var calls atomic.Int32
+	e.POST("/users", func(c echo.Context) error {
+		return c.JSON(http.StatusCreated, map[string]int32{"call": calls.Add(1)})
+	}, delivery.IdempotencyMiddleware(delivery.IdempotencyConfig{
+		Store:       repository.NewRedisIdempotencyStore(client),
+		Window:      time.Hour,
+		LockTimeout: time.Minute,
+		JWTSecret:   testJWTSecret,
+	}))
+
+	post := func(token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"Ada"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(delivery.IdempotencyKeyHeader, "key-1")
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	alice := signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"sub": "alice"})
+	bob := signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"sub": "bob"})
+
+	// Each subject gets its own response for the same key
+	first := post(alice)
+	if rec := post(bob); rec.Code != http.StatusCreated || rec.Header().Get(delivery.IdempotentReplayedHeader) != "" {
+		t.Errorf("same key of another subject = %d %s, want a new response", rec.Code, rec.Body)
+	}
+	if rec := post(""); rec.Code != http.StatusCreated || rec.Header().Get(delivery.IdempotentReplayedHeader) != "" {
+		t.Errorf("same key without a token = %d %s, want a new response", rec.Code, rec.Body)
+	}
+	if rec := post(alice); rec.Header().Get(delivery.IdempotentReplayedHeader) != "true" || rec.Body.String() != first.Body.String() {
+		t.Errorf("retry of the subject = %d %s, want the replayed %s", rec.Code, rec.Body, first.Body)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("handler calls = %d, want 3", n)
+	}
+
+	if rec := post("not-a-token"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,845 @@
+//go:build integration
+
+// Integration tests for the HTTP API against a local BigQuery emulator and Redis.
+//
+// Start the dependencies with `docker compose up -d` and run:
+//
+//	BIGQUERY_ENDPOINT=http://localhost:9050 BIGQUERY_NO_AUTH=true \
+//	GOOGLE_CLOUD_PROJECT=test REDIS_ADDR=localhost:6379 \
+//	go test -tags integration ./internal/delivery/http/...
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	delivery "github.com/dragondarkon/bqredis-crud/internal/delivery/http"
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/masking"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/dragondarkon/bqredis-crud/internal/usecase"
+	"github.com/dragondarkon/bqredis-crud/pkg/config"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"google.golang.org/api/googleapi"
+)
+
+// newIntegrationServer wires the full application against the configured backends
+func newIntegrationServer(t *testing.T) *echo.Echo {
+	t.Helper()
+
+	cfg := config.LoadConfig()
+	if cfg.BigQueryEndpoint == "" || cfg.GoogleCloudProject == "" {
+		t.Skip("BIGQUERY_ENDPOINT and GOOGLE_CLOUD_PROJECT must point at a BigQuery emulator")
+	}
+	ctx := context.Background()
+
+	bqClient, err := bigquery.NewClient(ctx, cfg.GoogleCloudProject, cfg.BigQueryOptions()...)
+	if err != nil {
+		t.Fatalf("failed to create BigQuery client: %v", err)
+	}
+	t.Cleanup(func() { bqClient.Close() })
+	provisionTable(t, bqClient, cfg.BigQueryDataset, cfg.BigQueryTable, entity.User{})
+	provisionTable(t, bqClient, cfg.BigQueryDataset, cfg.BigQueryGroupsTable, entity.Group{})
+	provisionTable(t, bqClient, cfg.BigQueryDataset, cfg.BigQueryMembershipsTable, entity.Membership{})
+	provisionTable(t, bqClient, cfg.BigQueryDataset, cfg.BigQueryErasuresTable, entity.ErasureTombstone{})
+	provisionTable(t, bqClient, cfg.BigQueryDataset, cfg.BigQueryWebhooksTable, entity.Webhook{})
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+	})
+	t.Cleanup(func() { redisClient.Close() })
+	if err := redisClient.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("failed to flush Redis: %v", err)
+	}
+
+	primaryRepo := repository.NewBigQueryRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryTable, repository.Tenancy{})
+	cacheRepo := repository.NewRedisRepository(redisClient, primaryRepo, cfg.RedisTTL)
+	cacheRepo.WithTTL(repository.TTLPolicy{Item: cfg.CacheItemTTL, List: cfg.CacheListTTL, Missing: cfg.CacheMissingTTL})
+	groupRepo := repository.NewBigQueryGroupRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryGroupsTable, repository.Tenancy{})
+	groupCacheRepo := repository.NewRedisGroupRepository(redisClient, groupRepo, cfg.RedisTTL)
+	membershipRepo := repository.NewBigQueryMembershipRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryMembershipsTable, repository.Tenancy{})
+	membershipCacheRepo := repository.NewRedisMembershipRepository(redisClient, membershipRepo, cfg.RedisTTL)
+	emailIndex := repository.NewRedisEmailIndex(redisClient, primaryRepo)
+	erasureRepo := repository.NewBigQueryErasureRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryErasuresTable, repository.Tenancy{})
+	webhookRepo := repository.NewBigQueryWebhookRepository(bqClient, cfg.GoogleCloudProject, cfg.BigQueryDataset, cfg.BigQueryWebhooksTable, repository.Tenancy{})
+	webhookCacheRepo := repository.NewRedisWebhookRepository(redisClient, webhookRepo, cfg.RedisTTL)
+	webhookQueue := repository.NewRedisWebhookQueue(redisClient, cfg.WebhookRetention)
+	changeFeed := repository.NewRedisChangeFeed(redisClient, cfg.ChangeStreamMaxLen)
+	outbox := repository.NewRedisOutbox(redisClient)
+	snapshotPolicy, err := repository.NewSnapshotPolicy(cfg.SnapshotRetention)
+	if err != nil {
+		t.Fatalf("invalid snapshot retention: %v", err)
+	}
+
+	// Webhooks post to local test servers
+	webhookUseCase := usecase.NewWebhookUseCase(webhookCacheRepo, webhookQueue).WithPrivateTargets()
+
+	// Relay events and retry failed webhook deliveries quickly so tests can observe them
+	dispatcherCtx, stopDispatcher := context.WithCancel(ctx)
+	dispatcher := usecase.NewWebhookDispatcher(webhookCacheRepo, webhookQueue, testRetryPolicy, 5*time.Second).WithPrivateTargets()
+	relay := usecase.NewOutboxRelay(outbox, primaryRepo, []usecase.EventSink{
+		usecase.NewChangeFeedSink(changeFeed),
+		usecase.NewWebhookSink(webhookUseCase),
+	}, 5*time.Second, time.Minute)
+	var stopped sync.WaitGroup
+	stopped.Add(2)
+	go func() {
+		defer stopped.Done()
+		dispatcher.Run(dispatcherCtx, 20*time.Millisecond)
+	}()
+	go func() {
+		defer stopped.Done()
+		relay.Run(dispatcherCtx, 20*time.Millisecond)
+	}()
+	t.Cleanup(func() {
+		stopDispatcher()
+		stopped.Wait()
+	})
+
+	e := echo.New()
+	delivery.SetupRoutes(e, delivery.RouteDeps{
+		Users:       usecase.NewUserUseCase(primaryRepo, cacheRepo, membershipCacheRepo, emailIndex, changeFeed, outbox),
+		Groups:      usecase.NewGroupUseCase(groupCacheRepo, cacheRepo, membershipCacheRepo),
+		Erasures:    usecase.NewErasureUseCase(primaryRepo, cacheRepo, membershipCacheRepo, cacheRepo, erasureRepo, snapshotPolicy, outbox),
+		Stats:       usecase.NewStatsUseCase(repository.NewRedisStatsRepository(redisClient, primaryRepo, cfg.StatsCacheTTL)),
+		Webhooks:    webhookUseCase,
+		Health:      usecase.NewHealthUseCase(),
+		CacheWarmer: usecase.NewCacheWarmer(cacheRepo, primaryRepo, primaryRepo, usecase.CacheWarmupPlan{Pages: 2, PageSize: 10, Users: 10, UsersBy: usecase.WarmupRecent}, 0),
+		Masking:     &delivery.MaskingConfig{Policy: maskingPolicy(t), Header: testRoleHeader},
+		Idempotency: &delivery.IdempotencyConfig{Store: repository.NewRedisIdempotencyStore(redisClient), Window: time.Hour, LockTimeout: time.Minute},
+		Admin:       delivery.AdminConfig{Role: "admin", JWTClaim: "role", JWTSecret: testJWTSecret},
+	})
+	return e
+}
+
+// testRetryPolicy retries failed webhook deliveries within milliseconds
+var testRetryPolicy = usecase.WebhookRetryPolicy{MaxAttempts: 3, Backoff: 20 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+
+// testRoleHeader carries the caller roles in the integration tests
+const testRoleHeader = "X-Roles"
+
+// maskingPolicy shows everything to callers without a role and masks PII for support
+func maskingPolicy(t *testing.T) *masking.Policy {
+	t.Helper()
+
+	policy, err := masking.ParsePolicy("*:;support:email=partial,name=redact")
+	if err != nil {
+		t.Fatalf("failed to parse masking policy: %v", err)
+	}
+	return policy
+}
+
+// provisionTable creates the dataset if needed and recreates an empty table for the entity
+func provisionTable(t *testing.T, client *bigquery.Client, datasetID, tableID string, row interface{}) {
+	t.Helper()
+	ctx := context.Background()
+
+	dataset := client.Dataset(datasetID)
+	if err := dataset.Create(ctx, &bigquery.DatasetMetadata{}); err != nil && !isHTTPStatus(err, http.StatusConflict) {
+		t.Fatalf("failed to create dataset: %v", err)
+	}
+
+	table := dataset.Table(tableID)
+	if err := table.Delete(ctx); err != nil && !isHTTPStatus(err, http.StatusNotFound) {
+		t.Fatalf("failed to drop table: %v", err)
+	}
+
+	schema, err := bigquery.InferSchema(row)
+	if err != nil {
+		t.Fatalf("failed to infer schema: %v", err)
+	}
+	if err := table.Create(ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+}
+
+func isHTTPStatus(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// doRequest sends a request to the server and decodes the JSON response into out
+func doRequest(t *testing.T, e *echo.Echo, method, path, body string, out interface{}) int {
+	t.Helper()
+	return doRequestWithToken(t, e, "", method, path, body, out)
+}
+
+// doAdminRequest sends a request with a bearer token granting the admin role
+func doAdminRequest(t *testing.T, e *echo.Echo, method, path, body string, out interface{}) int {
+	t.Helper()
+	token := signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"role": "admin"})
+	return doRequestWithToken(t, e, token, method, path, body, out)
+}
+
+// doRequestWithToken sends a request with an optional bearer token to the
+// server and decodes the JSON response into out
+func doRequestWithToken(t *testing.T, e *echo.Echo, token, method, path, body string, out interface{}) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: failed to decode response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestUserRoutesIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+
+	var created entity.User
+	status := doRequest(t, e, http.MethodPost, "/users", `{"name":"Ada Lovelace","email":"ada@example.com"}`, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+	}
+	if created.ID == "" || created.CreatedAt.IsZero() {
+		t.Fatalf("POST /users returned incomplete user %+v", created)
+	}
+
+	var conflict delivery.ErrorResponse
+	if status := doRequest(t, e, http.MethodPost, "/users", `{"name":"Ada Copy","email":"ada@example.com"}`, &conflict); status != http.StatusConflict {
+		t.Errorf("POST /users with taken email status = %d, want %d", status, http.StatusConflict)
+	}
+	if conflict.Code != delivery.ErrCodeConflict {
+		t.Errorf("POST /users with taken email code = %q, want %q", conflict.Code, delivery.ErrCodeConflict)
+	}
+
+	// A retried creation with the same Idempotency-Key returns the first user
+	var replayed [2]entity.User
+	for i := range replayed {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"Grace Hopper","email":"grace@example.com"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(delivery.IdempotencyKeyHeader, "create-grace")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("POST /users with Idempotency-Key status = %d, want %d", rec.Code, http.StatusCreated)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &replayed[i]); err != nil {
+			t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+		}
+	}
+	if replayed[0].ID == "" || replayed[1].ID != replayed[0].ID {
+		t.Errorf("retried POST /users created %s and %s, want one user", replayed[0].ID, replayed[1].ID)
+	}
+	if status := doRequest(t, e, http.MethodDelete, "/users/"+replayed[0].ID, "", nil); status != http.StatusOK {
+		t.Fatalf("DELETE /users/:id status = %d, want %d", status, http.StatusOK)
+	}
+
+	var invalid delivery.ErrorResponse
+	if status := doRequest(t, e, http.MethodPost, "/users", `{"name":"No Email"}`, &invalid); status != http.StatusBadRequest {
+		t.Errorf("POST /users without email status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var updated entity.User
+	status = doRequest(t, e, http.MethodPut, "/users/"+created.ID, `{"name":"Ada King","email":"ada@example.com"}`, &updated)
+	if status != http.StatusOK {
+		t.Fatalf("PUT /users/:id status = %d, want %d", status, http.StatusOK)
+	}
+
+	var fetched entity.User
+	if status := doRequest(t, e, http.MethodGet, "/users/"+created.ID, "", &fetched); status != http.StatusOK {
+		t.Fatalf("GET /users/:id status = %d, want %d", status, http.StatusOK)
+	}
+	if fetched.Name != "Ada King" {
+		t.Errorf("GET /users/:id name = %q, want %q", fetched.Name, "Ada King")
+	}
+
+	var byEmail entity.User
+	if status := doRequest(t, e, http.MethodGet, "/users/by-email/ADA%40example.com", "", &byEmail); status != http.StatusOK {
+		t.Fatalf("GET /users/by-email/:email status = %d, want %d", status, http.StatusOK)
+	}
+	if byEmail.ID != created.ID {
+		t.Errorf("GET /users/by-email/:email id = %q, want %q", byEmail.ID, created.ID)
+	}
+
+	var list struct {
+		Data []entity.User `json:"data"`
+	}
+	if status := doRequest(t, e, http.MethodGet, "/users?page=1&pageSize=10", "", &list); status != http.StatusOK {
+		t.Fatalf("GET /users status = %d, want %d", status, http.StatusOK)
+	}
+	if len(list.Data) != 1 || list.Data[0].ID != created.ID {
+		t.Errorf("GET /users data = %+v, want only user %s", list.Data, created.ID)
+	}
+
+	if status := doRequest(t, e, http.MethodDelete, "/users/"+created.ID, "", nil); status != http.StatusOK {
+		t.Fatalf("DELETE /users/:id status = %d, want %d", status, http.StatusOK)
+	}
+
+	var notFound delivery.ErrorResponse
+	if status := doRequest(t, e, http.MethodGet, "/users/"+created.ID, "", &notFound); status != http.StatusNotFound {
+		t.Errorf("GET /users/:id after delete status = %d, want %d", status, http.StatusNotFound)
+	}
+	if notFound.Code != delivery.ErrCodeNotFound {
+		t.Errorf("GET /users/:id after delete code = %q, want %q", notFound.Code, delivery.ErrCodeNotFound)
+	}
+}
+
+func TestPatchUserIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+
+	var created entity.User
+	if status := doRequest(t, e, http.MethodPost, "/users", `{"name":"Ada Lovelace","email":"ada@example.com"}`, &created); status != http.StatusCreated {
+		t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+	}
+	patch := func(contentType, body string, out interface{}) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPatch, "/users/"+created.ID, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, contentType)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if out != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+				t.Fatalf("PATCH /users/:id: failed to decode response %q: %v", rec.Body.String(), err)
+			}
+		}
+		return rec.Code
+	}
+
+	// A merge patch changes the given fields and keeps the others; BigQuery
+	// stores timestamps in microseconds
+	var merged entity.User
+	if status := patch(usecase.MergePatchType, `{"name":"Ada King"}`, &merged); status != http.StatusOK {
+		t.Fatalf("PATCH merge status = %d, want %d", status, http.StatusOK)
+	}
+	if merged.Name != "Ada King" || merged.Email != "ada@example.com" || !merged.CreatedAt.Equal(created.CreatedAt.Truncate(time.Microsecond)) || !merged.UpdatedAt.After(created.UpdatedAt) {
+		t.Errorf("PATCH merge = %+v, want the new name with the rest kept", merged)
+	}
+
+	var patched entity.User
+	body := `[{"op":"test","path":"/name","value":"Ada King"},{"op":"replace","path":"/email","value":"Countess@Example.com"}]`
+	if status := patch(usecase.JSONPatchType+"; charset=utf-8", body, &patched); status != http.StatusOK {
+		t.Fatalf("PATCH json-patch status = %d, want %d", status, http.StatusOK)
+	}
+	var fetched entity.User
+	doRequest(t, e, http.MethodGet, "/users/"+created.ID, "", &fetched)
+	if patched.Email != "countess@example.com" || fetched.Email != patched.Email || fetched.Name != "Ada King" || !fetched.CreatedAt.Equal(created.CreatedAt.Truncate(time.Microsecond)) {
+		t.Errorf("GET /users/:id after PATCH = %+v, want the normalized new email and the merged name", fetched)
+	}
+
+	var failed delivery.ErrorResponse
+	for _, tt := range []struct {
+		name, contentType, body string
+		want                    int
+	}{
+		{"failed test", usecase.JSONPatchType, `[{"op":"test","path":"/name","value":"Ada Lovelace"}]`, http.StatusConflict},
+		{"read-only field", usecase.MergePatchType, `{"created_at":"2000-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"removed email", usecase.JSONPatchType, `[{"op":"remove","path":"/email"}]`, http.StatusBadRequest},
+		{"malformed patch", usecase.JSONPatchType, `{"op":"replace"}`, http.StatusBadRequest},
+		{"plain JSON", echo.MIMEApplicationJSON, `{"name":"Ada"}`, http.StatusUnsupportedMediaType},
+	} {
+		if status := patch(tt.contentType, tt.body, &failed); status != tt.want {
+			t.Errorf("PATCH with %s status = %d, want %d", tt.name, status, tt.want)
+		}
+	}
+}
+
+func TestGroupRoutesIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+
+	var user entity.User
+	if status := doRequest(t, e, http.MethodPost, "/users", `{"name":"Grace Hopper","email":"grace@example.com"}`, &user); status != http.StatusCreated {
+		t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+	}
+
+	var group entity.Group
+	status := doRequest(t, e, http.MethodPost, "/groups", `{"name":"Navy","description":"Compiler pioneers"}`, &group)
+	if status != http.StatusCreated {
+		t.Fatalf("POST /groups status = %d, want %d", status, http.StatusCreated)
+	}
+
+	var updated entity.Group
+	if status := doRequest(t, e, http.MethodPut, "/groups/"+group.ID, `{"name":"US Navy"}`, &updated); status != http.StatusOK {
+		t.Fatalf("PUT /groups/:id status = %d, want %d", status, http.StatusOK)
+	}
+
+	var fetched entity.Group
+	if status := doRequest(t, e, http.MethodGet, "/groups/"+group.ID, "", &fetched); status != http.StatusOK {
+		t.Fatalf("GET /groups/:id status = %d, want %d", status, http.StatusOK)
+	}
+	if fetched.Name != "US Navy" {
+		t.Errorf("GET /groups/:id name = %q, want %q", fetched.Name, "US Navy")
+	}
+
+	var groups struct {
+		Data []entity.Group `json:"data"`
+	}
+	if status := doRequest(t, e, http.MethodGet, "/groups", "", &groups); status != http.StatusOK {
+		t.Fatalf("GET /groups status = %d, want %d", status, http.StatusOK)
+	}
+	if len(groups.Data) != 1 {
+		t.Errorf("GET /groups returned %d groups, want 1", len(groups.Data))
+	}
+
+	var membership entity.Membership
+	if status := doRequest(t, e, http.MethodPost, "/groups/"+group.ID+"/members/"+user.ID, "", &membership); status != http.StatusCreated {
+		t.Fatalf("POST /groups/:id/members/:userId status = %d, want %d", status, http.StatusCreated)
+	}
+
+	var members struct {
+		Data []entity.User `json:"data"`
+	}
+	if status := doRequest(t, e, http.MethodGet, "/groups/"+group.ID+"/members", "", &members); status != http.StatusOK {
+		t.Fatalf("GET /groups/:id/members status = %d, want %d", status, http.StatusOK)
+	}
+	if len(members.Data) != 1 || members.Data[0].ID != user.ID {
+		t.Errorf("GET /groups/:id/members data = %+v, want only user %s", members.Data, user.ID)
+	}
+
+	var userGroups struct {
+		Data []entity.Group `json:"data"`
+	}
+	if status := doRequest(t, e, http.MethodGet, "/users/"+user.ID+"/groups", "", &userGroups); status != http.StatusOK {
+		t.Fatalf("GET /users/:id/groups status = %d, want %d", status, http.StatusOK)
+	}
+	if len(userGroups.Data) != 1 || userGroups.Data[0].ID != group.ID {
+		t.Errorf("GET /users/:id/groups data = %+v, want only group %s", userGroups.Data, group.ID)
+	}
+
+	if status := doRequest(t, e, http.MethodDelete, "/groups/"+group.ID+"/members/"+user.ID, "", nil); status != http.StatusOK {
+		t.Fatalf("DELETE /groups/:id/members/:userId status = %d, want %d", status, http.StatusOK)
+	}
+	if status := doRequest(t, e, http.MethodDelete, "/groups/"+group.ID+"/members/"+user.ID, "", nil); status != http.StatusNotFound {
+		t.Errorf("DELETE of missing membership status = %d, want %d", status, http.StatusNotFound)
+	}
+
+	if status := doRequest(t, e, http.MethodDelete, "/groups/"+group.ID, "", nil); status != http.StatusOK {
+		t.Fatalf("DELETE /groups/:id status = %d, want %d", status, http.StatusOK)
+	}
+	if status := doRequest(t, e, http.MethodGet, "/groups/"+group.ID+"/members", "", nil); status != http.StatusNotFound {
+		t.Errorf("GET /groups/:id/members of deleted group status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestErasureRoutesIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+
+	var user entity.User
+	if status := doRequest(t, e, http.MethodPost, "/users", `{"name":"Alan Turing","email":"alan@example.com"}`, &user); status != http.StatusCreated {
+		t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+	}
+	// Cache the user so the erasure has keys to purge
+	if status := doRequest(t, e, http.MethodGet, "/users/"+user.ID, "", nil); status != http.StatusOK {
+		t.Fatalf("GET /users/:id status = %d, want %d", status, http.StatusOK)
+	}
+
+	// Erasing a user takes an admin token
+	if status := doRequest(t, e, http.MethodPost, "/users/"+user.ID+"/erasure", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("POST /users/:id/erasure without token status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	userToken := signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"role": "support"})
+	if status := doRequestWithToken(t, e, userToken, http.MethodPost, "/users/"+user.ID+"/erasure", "", nil); status != http.StatusForbidden {
+		t.Errorf("POST /users/:id/erasure without admin role status = %d, want %d", status, http.StatusForbidden)
+	}
+
+	var report entity.ErasureReport
+	if status := doAdminRequest(t, e, http.MethodPost, "/users/"+user.ID+"/erasure", "", &report); status != http.StatusOK {
+		t.Fatalf("POST /users/:id/erasure status = %d, want %d", status, http.StatusOK)
+	}
+	if !report.Complete || report.Digest == "" || report.UserID != user.ID {
+		t.Errorf("POST /users/:id/erasure report = %+v, want a complete report for %s", report, user.ID)
+	}
+
+	if status := doRequest(t, e, http.MethodGet, "/users/"+user.ID, "", nil); status != http.StatusNotFound {
+		t.Errorf("GET /users/:id after erasure status = %d, want %d", status, http.StatusNotFound)
+	}
+	// A retried erasure returns the recorded report
+	var retried entity.ErasureReport
+	if status := doAdminRequest(t, e, http.MethodPost, "/users/"+user.ID+"/erasure", "", &retried); status != http.StatusOK {
+		t.Fatalf("POST /users/:id/erasure again status = %d, want %d", status, http.StatusOK)
+	}
+	if retried.ID != report.ID || retried.Digest != report.Digest {
+		t.Errorf("POST /users/:id/erasure again = %+v, want report %s", retried, report.ID)
+	}
+	// The email is free again
+	if status := doRequest(t, e, http.MethodPost, "/users", `{"name":"New Alan","email":"alan@example.com"}`, nil); status != http.StatusCreated {
+		t.Errorf("POST /users with erased email status = %d, want %d", status, http.StatusCreated)
+	}
+
+	if status := doRequest(t, e, http.MethodGet, "/erasures/"+report.ID, "", nil); status != http.StatusUnauthorized {
+		t.Errorf("GET /erasures/:id without token status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	var stored delivery.ErasureResponse
+	if status := doAdminRequest(t, e, http.MethodGet, "/erasures/"+report.ID, "", &stored); status != http.StatusOK {
+		t.Fatalf("GET /erasures/:id status = %d, want %d", status, http.StatusOK)
+	}
+	if !stored.Verified || stored.Digest != report.Digest {
+		t.Errorf("GET /erasures/:id = %+v, want verified digest %s", stored, report.Digest)
+	}
+	if strings.Contains(stored.EmailHash, "alan") {
+		t.Errorf("GET /erasures/:id leaks the email: %+v", stored)
+	}
+}
+
+func TestMaskingIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+
+	var created entity.User
+	status := doRequest(t, e, http.MethodPost, "/users", `{"name":"Ada Lovelace","email":"ada@example.com"}`, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+	}
+
+	// The second support read is served from the cache, which must stay unmasked
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/users/"+created.ID, nil)
+		req.Header.Set(testRoleHeader, "support")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		var masked entity.User
+		if err := json.Unmarshal(rec.Body.Bytes(), &masked); err != nil {
+			t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+		}
+		if masked.Email != "a***@example.com" || masked.Name != "***" {
+			t.Errorf("GET /users/:id as support = %+v, want masked email and name", masked)
+		}
+	}
+
+	var fetched entity.User
+	if status := doRequest(t, e, http.MethodGet, "/users/"+created.ID, "", &fetched); status != http.StatusOK {
+		t.Fatalf("GET /users/:id status = %d, want %d", status, http.StatusOK)
+	}
+	if fetched.Email != "ada@example.com" || fetched.Name != "Ada Lovelace" {
+		t.Errorf("GET /users/:id without role = %+v, want the full user", fetched)
+	}
+}
+
+func TestStatsRoutesIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+
+	for _, body := range []string{
+		`{"name":"Ada Lovelace","email":"ada@example.com"}`,
+		`{"name":"Grace Hopper","email":"grace@example.com"}`,
+		`{"name":"Alan Turing","email":"alan@example.org"}`,
+	} {
+		if status := doRequest(t, e, http.MethodPost, "/users", body, nil); status != http.StatusCreated {
+			t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+		}
+	}
+
+	var stats entity.UserStats
+	if status := doRequest(t, e, http.MethodGet, "/users/stats?top=1", "", &stats); status != http.StatusOK {
+		t.Fatalf("GET /users/stats status = %d, want %d", status, http.StatusOK)
+	}
+	if stats.Total != 3 || stats.SignupsTotal != 3 {
+		t.Errorf("GET /users/stats totals = %d and %d, want 3", stats.Total, stats.SignupsTotal)
+	}
+	if len(stats.Signups) != 30 || stats.Interval != entity.StatsIntervalDay {
+		t.Errorf("GET /users/stats returned %d %s buckets, want 30 days", len(stats.Signups), stats.Interval)
+	}
+	if len(stats.TopDomains) != 1 || stats.TopDomains[0] != (entity.DomainCount{Domain: "example.com", Count: 2}) {
+		t.Errorf("GET /users/stats top domains = %+v, want example.com with 2 users", stats.TopDomains)
+	}
+
+	for _, query := range []string{"interval=month", "from=2024-02-01&to=2024-01-01", "from=yesterday", "top=many"} {
+		var invalid delivery.ErrorResponse
+		if status := doRequest(t, e, http.MethodGet, "/users/stats?"+query, "", &invalid); status != http.StatusBadRequest {
+			t.Errorf("GET /users/stats?%s status = %d, want %d", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCacheWarmupIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+
+	for _, body := range []string{
+		`{"name":"Ada Lovelace","email":"ada@example.com"}`,
+		`{"name":"Grace Hopper","email":"grace@example.com"}`,
+		`{"name":"Alan Turing","email":"alan@example.org"}`,
+	} {
+		if status := doRequest(t, e, http.MethodPost, "/users", body, nil); status != http.StatusCreated {
+			t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+		}
+	}
+
+	// Warm-up is an admin route
+	if status := doRequest(t, e, http.MethodPost, "/admin/cache/warmup", "", nil); status != http.StatusUnauthorized {
+		t.Fatalf("POST /admin/cache/warmup without token status = %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	// The first page holds every user, so the second is not loaded
+	var report entity.CacheWarmup
+	if status := doAdminRequest(t, e, http.MethodPost, "/admin/cache/warmup", "", &report); status != http.StatusOK {
+		t.Fatalf("POST /admin/cache/warmup status = %d, want %d", status, http.StatusOK)
+	}
+	if report.Pages != 1 || report.Users != 3 || report.Failed != 0 {
+		t.Errorf("POST /admin/cache/warmup = %+v, want 1 page and 3 users", report)
+	}
+}
+
+func TestHealthIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+
+	var health entity.Health
+	if status := doRequest(t, e, http.MethodGet, "/health", "", &health); status != http.StatusOK {
+		t.Fatalf("GET /health status = %d, want %d", status, http.StatusOK)
+	}
+	if health.Status != entity.HealthOK {
+		t.Errorf("GET /health status = %q, want %q", health.Status, entity.HealthOK)
+	}
+}
+
+// readEvents parses a Server-Sent Events stream into changes until it ends
+func readEvents(t *testing.T, body *bufio.Reader, changes chan<- entity.UserChange) {
+	defer close(changes)
+
+	var data string
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			var change entity.UserChange
+			if err := json.Unmarshal([]byte(data), &change); err != nil {
+				t.Errorf("failed to decode event %q: %v", data, err)
+				return
+			}
+			changes <- change
+			data = ""
+		}
+	}
+}
+
+func TestChangeFeedIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+
+	var ada, grace entity.User
+	if status := doRequest(t, e, http.MethodPost, "/users", `{"name":"Ada Lovelace","email":"ada@example.com"}`, &ada); status != http.StatusCreated {
+		t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+	}
+	if status := doRequest(t, e, http.MethodPost, "/users", `{"name":"Grace Hopper","email":"grace@example.com"}`, &grace); status != http.StatusCreated {
+		t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/users/changes?user_id="+ada.ID, nil)
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /users/changes error = %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get(echo.HeaderContentType) != "text/event-stream" {
+		t.Fatalf("GET /users/changes = %d %s, want an event stream", res.StatusCode, res.Header.Get(echo.HeaderContentType))
+	}
+	events := make(chan entity.UserChange)
+	go readEvents(t, bufio.NewReader(res.Body), events)
+
+	doRequest(t, e, http.MethodPut, "/users/"+grace.ID, `{"name":"Grace Brewster Hopper","email":"grace@example.com"}`, nil)
+	doRequest(t, e, http.MethodPut, "/users/"+ada.ID, `{"name":"Ada King","email":"ada@example.com"}`, nil)
+
+	// The relay may publish Ada's creation after the stream was opened
+	for received := false; !received; {
+		select {
+		case change := <-events:
+			if change.Type == entity.ChangeCreated {
+				continue
+			}
+			if change.Type != entity.ChangeUpdated || change.UserID != ada.ID || change.User == nil || change.User.Name != "Ada King" {
+				t.Errorf("SSE change = %+v, want Ada's update with her new name", change)
+			}
+			received = true
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for an SSE change")
+		}
+	}
+
+	// The WebSocket replays Ada's changes from the start of the stream, then continues live
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/users/changes/ws?last_event_id=0-0&user_id=" + ada.ID
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		t.Fatalf("GET /users/changes/ws error = %v", err)
+	}
+	defer conn.Close()
+
+	doRequest(t, e, http.MethodDelete, "/users/"+ada.ID, "", nil)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, want := range []string{entity.ChangeCreated, entity.ChangeUpdated, entity.ChangeDeleted} {
+		var change entity.UserChange
+		if err := conn.ReadJSON(&change); err != nil {
+			t.Fatalf("failed to read WebSocket change: %v", err)
+		}
+		if change.Type != want || change.UserID != ada.ID {
+			t.Errorf("WebSocket change = %+v, want %s of %s", change, want, ada.ID)
+		}
+		if want == entity.ChangeDeleted && change.User != nil {
+			t.Errorf("deleted change carries user %+v", change.User)
+		}
+	}
+
+	var invalid delivery.ErrorResponse
+	if status := doRequest(t, e, http.MethodGet, "/users/changes?last_event_id=yesterday", "", &invalid); status != http.StatusBadRequest {
+		t.Errorf("GET /users/changes with malformed event ID status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// The relay reports what it published
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if metrics := rec.Body.String(); !strings.Contains(metrics, `outbox_published_total{sink="redis_stream"}`) || !strings.Contains(metrics, "outbox_lag_seconds") {
+		t.Errorf("GET /metrics does not report the outbox relay:\n%s", metrics)
+	}
+}
+
+// receivedWebhook is a webhook request seen by the test receiver
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhookIntegration(t *testing.T) {
+	e := newIntegrationServer(t)
+
+	// The receiver fails the first request to exercise retries
+	received := make(chan receivedWebhook, 10)
+	var requests atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header.Clone(), body: body}
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(receiver.Close)
+	receive := func() receivedWebhook {
+		t.Helper()
+		select {
+		case req := <-received:
+			return req
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a webhook request")
+			return receivedWebhook{}
+		}
+	}
+
+	// Webhooks are admin routes
+	if status := doRequest(t, e, http.MethodGet, "/webhooks", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("GET /webhooks without token status = %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	var invalid delivery.ErrorResponse
+	if status := doAdminRequest(t, e, http.MethodPost, "/webhooks", `{"url":"ftp://example.com"}`, &invalid); status != http.StatusBadRequest {
+		t.Errorf("POST /webhooks with ftp URL status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var webhook entity.Webhook
+	body := `{"url":"` + receiver.URL + `","events":["user.created","user.deleted"]}`
+	if status := doAdminRequest(t, e, http.MethodPost, "/webhooks", body, &webhook); status != http.StatusCreated {
+		t.Fatalf("POST /webhooks status = %d, want %d", status, http.StatusCreated)
+	}
+	if webhook.Secret == "" || !webhook.Active {
+		t.Fatalf("POST /webhooks = %+v, want an active webhook with a secret", webhook)
+	}
+	var fetched entity.Webhook
+	if status := doAdminRequest(t, e, http.MethodGet, "/webhooks/"+webhook.ID, "", &fetched); status != http.StatusOK || fetched.Secret != "" {
+		t.Errorf("GET /webhooks/:id = %d %+v, want the webhook without its secret", status, fetched)
+	}
+
+	var user entity.User
+	if status := doRequest(t, e, http.MethodPost, "/users", `{"name":"Ada Lovelace","email":"ada@example.com"}`, &user); status != http.StatusCreated {
+		t.Fatalf("POST /users status = %d, want %d", status, http.StatusCreated)
+	}
+	// Updates are not subscribed to
+	doRequest(t, e, http.MethodPut, "/users/"+user.ID, `{"name":"Ada King","email":"ada@example.com"}`, nil)
+
+	first, retried := receive(), receive()
+	if first.header.Get(usecase.WebhookDeliveryHeader) != retried.header.Get(usecase.WebhookDeliveryHeader) {
+		t.Errorf("retry delivery = %s, want %s", retried.header.Get(usecase.WebhookDeliveryHeader), first.header.Get(usecase.WebhookDeliveryHeader))
+	}
+	timestamp, _ := strconv.ParseInt(retried.header.Get(usecase.WebhookTimestampHeader), 10, 64)
+	if got, want := retried.header.Get(usecase.WebhookSignatureHeader), usecase.SignWebhook(webhook.Secret, timestamp, retried.body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	var event entity.WebhookEvent
+	if err := json.Unmarshal(retried.body, &event); err != nil {
+		t.Fatalf("failed to decode event %q: %v", retried.body, err)
+	}
+	if event.Type != entity.WebhookUserCreated || event.UserID != user.ID {
+		t.Errorf("event = %+v, want user.created of %s", event, user.ID)
+	}
+
+	// The history records both attempts once the retry succeeded
+	var history struct {
+		Data []entity.WebhookDelivery `json:"data"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if status := doAdminRequest(t, e, http.MethodGet, "/webhooks/"+webhook.ID+"/deliveries", "", &history); status != http.StatusOK {
+			t.Fatalf("GET /webhooks/:id/deliveries status = %d, want %d", status, http.StatusOK)
+		}
+		if len(history.Data) == 1 && history.Data[0].Status == entity.DeliverySucceeded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET /webhooks/:id/deliveries = %+v, want one succeeded delivery", history.Data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if d := history.Data[0]; d.Attempts != 2 || d.LastStatus != http.StatusNoContent || d.Event.ID != event.ID {
+		t.Errorf("delivery = %+v, want event %s delivered on attempt 2", d, event.ID)
+	}
+
+	var redelivery entity.WebhookDelivery
+	path := "/webhooks/" + webhook.ID + "/deliveries/" + history.Data[0].ID + "/redeliver"
+	if status := doAdminRequest(t, e, http.MethodPost, path, "", &redelivery); status != http.StatusAccepted {
+		t.Fatalf("POST redeliver status = %d, want %d", status, http.StatusAccepted)
+	}
+	if redelivery.RedeliveryOf != history.Data[0].ID || redelivery.Event.ID != event.ID {
+		t.Errorf("redelivery = %+v, want a new delivery of event %s", redelivery, event.ID)
+	}
+	if req := receive(); req.header.Get(usecase.WebhookDeliveryHeader) != redelivery.ID {
+		t.Errorf("redelivered request delivery = %s, want %s", req.header.Get(usecase.WebhookDeliveryHeader), redelivery.ID)
+	}
+
+	var missing delivery.ErrorResponse
+	path = "/webhooks/" + webhook.ID + "/deliveries/unknown/redeliver"
+	if status := doAdminRequest(t, e, http.MethodPost, path, "", &missing); status != http.StatusNotFound {
+		t.Errorf("POST redeliver of unknown delivery status = %d, want %d", status, http.StatusNotFound)
+	}
+
+	if status := doAdminRequest(t, e, http.MethodDelete, "/webhooks/"+webhook.ID, "", nil); status != http.StatusOK {
+		t.Fatalf("DELETE /webhooks/:id status = %d, want %d", status, http.StatusOK)
+	}
+	if status := doAdminRequest(t, e, http.MethodGet, "/webhooks/"+webhook.ID, "", &missing); status != http.StatusNotFound {
+		t.Errorf("GET /webhooks/:id after delete status = %d, want %d", status, http.StatusNotFound)
+	}
+}
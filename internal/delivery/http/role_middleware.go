@@ -0,0 +1,86 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dragondarkon/bqredis-crud/internal/masking"
+	"github.com/labstack/echo/v4"
+)
+
+// MaskingConfig configures how the roles of a request are resolved and which
+// user fields each role may see
+type MaskingConfig struct {
+	// Policy declares the masking per role
+	Policy *masking.Policy
+	// Header carries comma-separated roles; only set it behind a gateway that
+	// strips the header from client requests. It is ignored once JWTSecret is
+	// set, unless TrustHeader is
+	Header string
+	// JWTClaim names the claim of a bearer token holding a role, a space-separated
+	// scope string or a list of roles
+	JWTClaim string
+	// JWTSecret verifies HS256 bearer tokens; empty disables JWT resolution
+	JWTSecret string
+	// TrustHeader reads the header of requests without a token even though
+	// JWTSecret is set
+	TrustHeader bool
+}
+
+// RoleMiddleware resolves the roles of each request from the bearer token
+// claim, or else the header, and stores them in the request context
+func RoleMiddleware(config MaskingConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := bearerClaims(c.Request(), config.JWTSecret)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, ErrorResponse{
+					Code:    ErrCodeUnauthorized,
+					Message: "Invalid bearer token",
+				})
+			}
+
+			// A verified token always wins; the header is read only when tokens
+			// are disabled or the header is trusted
+			var roles []string
+			if claims != nil {
+				roles = rolesFromClaim(claims[config.JWTClaim])
+			} else if config.Header != "" && (config.JWTSecret == "" || config.TrustHeader) {
+				roles = splitRoles(c.Request().Header.Get(config.Header), ",")
+			}
+
+			ctx := masking.WithRoles(c.Request().Context(), roles...)
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// rolesFromClaim reads the roles of a claim holding a string or a list of strings
+func rolesFromClaim(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return splitRoles(value, " ")
+	case []interface{}:
+		var roles []string
+		for _, item := range value {
+			if role, ok := item.(string); ok && role != "" {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	default:
+		return nil
+	}
+}
+
+// splitRoles splits a list of roles and drops empty entries
+func splitRoles(value, sep string) []string {
+	var roles []string
+	for _, role := range strings.Split(value, sep) {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
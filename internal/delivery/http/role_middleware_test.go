@@ -0,0 +1,94 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	delivery "github.com/dragondarkon/bqredis-crud/internal/delivery/http"
+	"github.com/dragondarkon/bqredis-crud/internal/masking"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func TestRoleMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		token       string
+		trustHeader bool
+		wantStatus  int
+		wantRoles   []string
+	}{
+		{name: "no roles", wantStatus: http.StatusOK},
+		{name: "untrusted header", header: "support, admin", wantStatus: http.StatusOK},
+		{name: "trusted header", header: "support, admin", trustHeader: true, wantStatus: http.StatusOK, wantRoles: []string{"support", "admin"}},
+		{
+			name:       "token with role",
+			token:      signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"role": "support"}),
+			wantStatus: http.StatusOK,
+			wantRoles:  []string{"support"},
+		},
+		{
+			name:       "token with scopes",
+			token:      signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"role": "users:read support"}),
+			wantStatus: http.StatusOK,
+			wantRoles:  []string{"users:read", "support"},
+		},
+		{
+			name:       "token with role list",
+			token:      signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"role": []string{"support", "admin"}}),
+			wantStatus: http.StatusOK,
+			wantRoles:  []string{"support", "admin"},
+		},
+		{
+			name:        "token wins over header",
+			header:      "admin",
+			token:       signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"role": "support"}),
+			trustHeader: true,
+			wantStatus:  http.StatusOK,
+			wantRoles:   []string{"support"},
+		},
+		{
+			name:       "wrong secret",
+			token:      signToken(t, jwt.SigningMethodHS256, []byte("other-secret"), jwt.MapClaims{"role": "admin"}),
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(delivery.RoleMiddleware(delivery.MaskingConfig{
+				Header:      "X-Roles",
+				JWTClaim:    "role",
+				JWTSecret:   testJWTSecret,
+				TrustHeader: tt.trustHeader,
+			}))
+
+			var gotRoles []string
+			e.GET("/", func(c echo.Context) error {
+				gotRoles = masking.RolesFromContext(c.Request().Context())
+				return c.NoContent(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Roles", tt.header)
+			}
+			if tt.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if !slices.Equal(gotRoles, tt.wantRoles) {
+				t.Errorf("roles = %q, want %q", gotRoles, tt.wantRoles)
+			}
+		})
+	}
+}
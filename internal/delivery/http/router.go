@@ -1,25 +1,135 @@
 package http
 
 import (
+	"slices"
+
+	"github.com/dragondarkon/bqredis-crud/internal/masking"
 	"github.com/dragondarkon/bqredis-crud/internal/usecase"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// healthPath is the route reporting the health of the service
+	healthPath = "/health"
+	// metricsPath is the route exposing Prometheus metrics
+	metricsPath = "/metrics"
 )
 
+// RouteDeps holds the use cases and optional configs the routes are built from
+type RouteDeps struct {
+	Users    *usecase.UserUseCase
+	Groups   *usecase.GroupUseCase
+	Erasures *usecase.ErasureUseCase
+	Stats    *usecase.StatsUseCase
+	Webhooks *usecase.WebhookUseCase
+	Health   *usecase.HealthUseCase
+	// CacheWarmer is exposed to warm the cache on demand when set
+	CacheWarmer *usecase.CacheWarmer
+	// Tenancy resolves a tenant for every request when set
+	Tenancy *TenantConfig
+	// Masking masks user fields in responses by the roles of the caller when set
+	Masking *MaskingConfig
+	// Idempotency replays responses to retried user creations when set
+	Idempotency *IdempotencyConfig
+	// Admin authorizes the admin and webhook routes, which are refused without
+	// a JWT secret
+	Admin AdminConfig
+}
+
 // SetupRoutes configures the HTTP routes using Echo framework
-func SetupRoutes(e *echo.Echo, userUseCase *usecase.UserUseCase) {
+func SetupRoutes(e *echo.Echo, deps RouteDeps) {
 	// Add middlewares
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
+	if deps.Tenancy != nil {
+		// Health probes and metrics scrapes do not act for a tenant
+		e.Use(unlessPath(TenantMiddleware(*deps.Tenancy), healthPath, metricsPath))
+	}
+	var policy *masking.Policy
+	if deps.Masking != nil {
+		e.Use(RoleMiddleware(*deps.Masking))
+		policy = deps.Masking.Policy
+	}
+	// Routes that act on any user or make the service call out are admin only
+	adminOnly := AdminMiddleware(deps.Admin)
+	var idempotent []echo.MiddlewareFunc
+	if deps.Idempotency != nil {
+		idempotent = append(idempotent, IdempotencyMiddleware(*deps.Idempotency))
+	}
 
-	// Create handler
-	handler := NewUserHandler(userUseCase)
+	// Create handlers
+	handler := NewUserHandler(deps.Users, policy)
+	groupHandler := NewGroupHandler(deps.Groups, policy)
+	erasureHandler := NewErasureHandler(deps.Erasures)
+	statsHandler := NewStatsHandler(deps.Stats)
+	webhookHandler := NewWebhookHandler(deps.Webhooks)
+	healthHandler := NewHealthHandler(deps.Health)
 
 	// User routes
 	e.GET("/users", handler.GetUsers)
+	e.GET("/users/stats", statsHandler.GetUserStats)
+	e.GET("/users/changes", handler.WatchChanges)
+	e.GET("/users/changes/ws", handler.WatchChangesWebSocket)
 	e.GET("/users/:id", handler.GetUser)
-	e.POST("/users", handler.CreateUser)
+	e.GET("/users/by-email/:email", handler.GetUserByEmail)
+	e.POST("/users", handler.CreateUser, idempotent...)
 	e.PUT("/users/:id", handler.UpdateUser)
+	e.PATCH("/users/:id", handler.PatchUser)
 	e.DELETE("/users/:id", handler.DeleteUser)
+	e.GET("/users/:id/groups", groupHandler.GetUserGroups)
+
+	// Erasure routes, which irreversibly remove a user and so are admin only
+	e.POST("/users/:id/erasure", erasureHandler.EraseUser, adminOnly)
+	e.GET("/erasures/:id", erasureHandler.GetErasure, adminOnly)
+
+	// Group routes
+	e.GET("/groups", groupHandler.GetGroups)
+	e.GET("/groups/:id", groupHandler.GetGroup)
+	e.POST("/groups", groupHandler.CreateGroup)
+	e.PUT("/groups/:id", groupHandler.UpdateGroup)
+	e.DELETE("/groups/:id", groupHandler.DeleteGroup)
+
+	// Membership routes
+	e.GET("/groups/:id/members", groupHandler.GetMembers)
+	e.POST("/groups/:id/members/:userId", groupHandler.AddMember)
+	e.DELETE("/groups/:id/members/:userId", groupHandler.RemoveMember)
+
+	// Webhook routes, which make the service call out and so are admin only
+	webhooks := e.Group("/webhooks", adminOnly)
+	webhooks.GET("", webhookHandler.GetWebhooks)
+	webhooks.GET("/:id", webhookHandler.GetWebhook)
+	webhooks.POST("", webhookHandler.CreateWebhook)
+	webhooks.PUT("/:id", webhookHandler.UpdateWebhook)
+	webhooks.DELETE("/:id", webhookHandler.DeleteWebhook)
+	webhooks.GET("/:id/deliveries", webhookHandler.GetDeliveries)
+	webhooks.POST("/:id/deliveries/:deliveryId/redeliver", webhookHandler.Redeliver)
+
+	// Health route
+	e.GET(healthPath, healthHandler.GetHealth)
+
+	// Admin routes
+	admin := e.Group("/admin", adminOnly)
+	if deps.CacheWarmer != nil {
+		cacheHandler := NewCacheHandler(deps.CacheWarmer)
+		admin.POST("/cache/warmup", cacheHandler.WarmCache)
+	}
+
+	// Prometheus metrics
+	e.GET(metricsPath, echo.WrapHandler(promhttp.Handler()))
+}
+
+// unlessPath skips a middleware for requests to one of the paths
+func unlessPath(m echo.MiddlewareFunc, paths ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		handler := m(next)
+		return func(c echo.Context) error {
+			if slices.Contains(paths, c.Request().URL.Path) {
+				return next(c)
+			}
+			return handler(c)
+		}
+	}
 }
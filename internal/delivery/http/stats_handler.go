@@ -0,0 +1,44 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dragondarkon/bqredis-crud/internal/usecase"
+	"github.com/labstack/echo/v4"
+)
+
+// StatsHandler handles HTTP requests for aggregate statistics
+type StatsHandler struct {
+	statsUseCase *usecase.StatsUseCase
+}
+
+// NewStatsHandler creates a new statistics handler
+func NewStatsHandler(statsUseCase *usecase.StatsUseCase) *StatsHandler {
+	return &StatsHandler{
+		statsUseCase: statsUseCase,
+	}
+}
+
+// GetUserStats handles GET /users/stats
+func (h *StatsHandler) GetUserStats(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var top int
+	if topStr := c.QueryParam("top"); topStr != "" {
+		var err error
+		if top, err = strconv.Atoi(topStr); err != nil {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Code:    ErrCodeValidation,
+				Message: "Invalid top",
+			})
+		}
+	}
+
+	stats, err := h.statsUseCase.GetUserStats(ctx, c.QueryParam("from"), c.QueryParam("to"), c.QueryParam("interval"), top)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
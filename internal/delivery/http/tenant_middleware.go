@@ -0,0 +1,113 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// TenantConfig configures how the tenant of a request is resolved
+type TenantConfig struct {
+	// Header carries the tenant ID; empty disables header resolution
+	Header string
+	// JWTClaim names the claim of a bearer token holding the tenant ID
+	JWTClaim string
+	// JWTSecret verifies HS256 bearer tokens; empty disables JWT resolution.
+	// Once set, every request must carry a token with the tenant claim
+	JWTSecret string
+	// TrustHeader accepts the header without a token even though JWTSecret is
+	// set; only enable it behind a gateway that strips the header from client
+	// requests
+	TrustHeader bool
+}
+
+// TenantMiddleware resolves the tenant of each request from the header or the
+// bearer token claim and stores it in the request context
+func TenantMiddleware(config TenantConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var headerID string
+			if config.Header != "" {
+				headerID = c.Request().Header.Get(config.Header)
+			}
+
+			claimID, err := tenantFromToken(c.Request(), config)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, ErrorResponse{
+					Code:    ErrCodeUnauthorized,
+					Message: "Invalid bearer token",
+				})
+			}
+
+			// A verified claim always wins; the header may only repeat it, and
+			// stands alone only when tokens are disabled or the header is trusted
+			id := headerID
+			if claimID == "" && config.JWTSecret != "" && !config.TrustHeader {
+				return c.JSON(http.StatusUnauthorized, ErrorResponse{
+					Code:    ErrCodeUnauthorized,
+					Message: "Missing bearer token",
+				})
+			}
+			if claimID != "" {
+				if headerID != "" && headerID != claimID {
+					return c.JSON(http.StatusForbidden, ErrorResponse{
+						Code:    ErrCodeForbidden,
+						Message: "Tenant header does not match token",
+					})
+				}
+				id = claimID
+			}
+
+			if id == "" {
+				return handleError(c, tenant.ErrMissing)
+			}
+			if err := tenant.Validate(id); err != nil {
+				return handleError(c, err)
+			}
+
+			ctx := tenant.WithID(c.Request().Context(), id)
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// tenantFromToken returns the tenant claim of a verified bearer token, or ""
+// when the request carries no token or JWT resolution is disabled
+func tenantFromToken(req *http.Request, config TenantConfig) (string, error) {
+	claims, err := bearerClaims(req, config.JWTSecret)
+	if err != nil || claims == nil {
+		return "", err
+	}
+
+	id, ok := claims[config.JWTClaim].(string)
+	if !ok {
+		return "", errors.New("token has no tenant claim")
+	}
+	return id, nil
+}
+
+// bearerClaims returns the claims of a verified HS256 bearer token, or nil
+// when the request carries no token or the secret is empty
+func bearerClaims(req *http.Request, secret string) (jwt.MapClaims, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	raw, ok := strings.CutPrefix(req.Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !ok {
+		return nil, nil
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,92 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	delivery "github.com/dragondarkon/bqredis-crud/internal/delivery/http"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const testJWTSecret = "test-secret"
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	acmeToken := signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"tenant_id": "acme"})
+
+	tests := []struct {
+		name        string
+		header      string
+		token       string
+		trustHeader bool
+		wantStatus  int
+		wantTenant  string
+	}{
+		{name: "untrusted header", header: "acme", wantStatus: http.StatusUnauthorized},
+		{name: "trusted header", header: "acme", trustHeader: true, wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "token", token: acmeToken, wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "matching header and token", header: "acme", token: acmeToken, wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "mismatched header and token", header: "globex", token: acmeToken, wantStatus: http.StatusForbidden},
+		{name: "mismatched trusted header and token", header: "globex", token: acmeToken, trustHeader: true, wantStatus: http.StatusForbidden},
+		{name: "missing token", wantStatus: http.StatusUnauthorized},
+		{name: "missing tenant", trustHeader: true, wantStatus: http.StatusBadRequest},
+		{name: "invalid tenant", header: "acme/../globex", trustHeader: true, wantStatus: http.StatusBadRequest},
+		{
+			name:       "wrong secret",
+			token:      signToken(t, jwt.SigningMethodHS256, []byte("other-secret"), jwt.MapClaims{"tenant_id": "acme"}),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "token without claim",
+			token:      signToken(t, jwt.SigningMethodHS256, []byte(testJWTSecret), jwt.MapClaims{"sub": "ada"}),
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(delivery.TenantMiddleware(delivery.TenantConfig{
+				Header:      "X-Tenant-ID",
+				JWTClaim:    "tenant_id",
+				JWTSecret:   testJWTSecret,
+				TrustHeader: tt.trustHeader,
+			}))
+
+			var gotTenant string
+			e.GET("/", func(c echo.Context) error {
+				gotTenant, _ = tenant.FromContext(c.Request().Context())
+				return c.NoContent(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			if tt.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if gotTenant != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", gotTenant, tt.wantTenant)
+			}
+		})
+	}
+}
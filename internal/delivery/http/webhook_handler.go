@@ -0,0 +1,150 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/usecase"
+	"github.com/labstack/echo/v4"
+)
+
+// WebhookHandler handles HTTP requests for webhook subscriptions and their deliveries
+type WebhookHandler struct {
+	webhookUseCase *usecase.WebhookUseCase
+}
+
+// NewWebhookHandler creates a new webhook handler
+func NewWebhookHandler(webhookUseCase *usecase.WebhookUseCase) *WebhookHandler {
+	return &WebhookHandler{
+		webhookUseCase: webhookUseCase,
+	}
+}
+
+// bindWebhook decodes a webhook payload; webhooks are active unless the payload says otherwise
+func bindWebhook(c echo.Context) (entity.Webhook, error) {
+	webhook := entity.Webhook{Active: true}
+	err := c.Bind(&webhook)
+	return webhook, err
+}
+
+// GetWebhooks handles GET /webhooks
+func (h *WebhookHandler) GetWebhooks(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	page, pageSize := parsePagination(c)
+
+	webhooks, err := h.webhookUseCase.GetAllWebhooks(ctx, page, pageSize)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": webhooks,
+		"pagination": map[string]int{
+			"page":     page,
+			"pageSize": pageSize,
+		},
+	})
+}
+
+// GetWebhook handles GET /webhooks/:id
+func (h *WebhookHandler) GetWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	webhook, err := h.webhookUseCase.GetWebhookByID(ctx, id)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// CreateWebhook handles POST /webhooks
+func (h *WebhookHandler) CreateWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	webhook, err := bindWebhook(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    ErrCodeValidation,
+			Message: "Invalid request payload",
+		})
+	}
+
+	createdWebhook, err := h.webhookUseCase.CreateWebhook(ctx, webhook)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, createdWebhook)
+}
+
+// UpdateWebhook handles PUT /webhooks/:id
+func (h *WebhookHandler) UpdateWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	webhook, err := bindWebhook(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    ErrCodeValidation,
+			Message: "Invalid request payload",
+		})
+	}
+
+	// Ensure ID matches
+	webhook.ID = id
+
+	updatedWebhook, err := h.webhookUseCase.UpdateWebhook(ctx, webhook)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, updatedWebhook)
+}
+
+// DeleteWebhook handles DELETE /webhooks/:id
+func (h *WebhookHandler) DeleteWebhook(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	if err := h.webhookUseCase.DeleteWebhook(ctx, id); err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Webhook deleted successfully"})
+}
+
+// GetDeliveries handles GET /webhooks/:id/deliveries
+func (h *WebhookHandler) GetDeliveries(c echo.Context) error {
+	ctx := c.Request().Context()
+	id := c.Param("id")
+
+	limit := 0
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	deliveries, err := h.webhookUseCase.GetDeliveries(ctx, id, limit)
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"data": deliveries})
+}
+
+// Redeliver handles POST /webhooks/:id/deliveries/:deliveryId/redeliver
+func (h *WebhookHandler) Redeliver(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	delivery, err := h.webhookUseCase.Redeliver(ctx, c.Param("id"), c.Param("deliveryId"))
+	if err != nil {
+		return handleError(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, delivery)
+}
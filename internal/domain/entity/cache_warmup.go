@@ -0,0 +1,15 @@
+package entity
+
+import (
+	"time"
+)
+
+// CacheWarmup reports what a cache warm-up loaded. Failed counts the pages and
+// users that could not be loaded or cached.
+type CacheWarmup struct {
+	Pages       int       `json:"pages"`
+	Users       int       `json:"users"`
+	Failed      int       `json:"failed"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
@@ -0,0 +1,35 @@
+package entity
+
+import (
+	"time"
+)
+
+// User change types
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// UserChange is an event describing a mutation of a user. Published events only
+// identify the user; User is filled with its current state on delivery.
+type UserChange struct {
+	// ID is assigned when the event is published and orders events
+	ID string `json:"id"`
+	// EventID is the ID of the outbox event the change was published from
+	EventID    string    `json:"event_id,omitempty"`
+	Type       string    `json:"type"`
+	UserID     string    `json:"user_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	User       *User     `json:"user,omitempty"`
+}
+
+// OutboxEvent is a user change recorded in the outbox before the change is
+// written, and published to every sink once it is
+type OutboxEvent struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	UserID string `json:"user_id"`
+	// OccurredAt is also the updated_at written by an update
+	OccurredAt time.Time `json:"occurred_at"`
+}
@@ -0,0 +1,50 @@
+package entity
+
+import (
+	"time"
+)
+
+// Erasure step statuses
+const (
+	ErasureStepDone      = "done"
+	ErasureStepFailed    = "failed"
+	ErasureStepScheduled = "scheduled"
+)
+
+// ErasureStep records the outcome of one part of an erasure
+type ErasureStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ErasureReport describes what erasing a user removed, without any personal data.
+// Digest is the SHA-256 of the report encoded as JSON with an empty digest.
+type ErasureReport struct {
+	ID                string        `json:"id"`
+	UserID            string        `json:"user_id"`
+	EmailHash         string        `json:"email_hash"`
+	RequestedAt       time.Time     `json:"requested_at"`
+	CompletedAt       time.Time     `json:"completed_at"`
+	SnapshotsPurgedBy time.Time     `json:"snapshots_purged_by"`
+	Steps             []ErasureStep `json:"steps"`
+	Complete          bool          `json:"complete"`
+	Digest            string        `json:"digest"`
+}
+
+// ErasureTombstone is the redacted audit record left behind by an erasure. A
+// pending one, without a digest, is written before the user is deleted and a
+// completed one with the same ID once the erasure finishes.
+type ErasureTombstone struct {
+	ID          string    `json:"id" bigquery:"id"`
+	UserID      string    `json:"user_id" bigquery:"user_id"`
+	EmailHash   string    `json:"email_hash" bigquery:"email_hash"`
+	Report      string    `json:"report" bigquery:"report"`
+	Digest      string    `json:"digest" bigquery:"digest"`
+	CompletedAt time.Time `json:"completed_at" bigquery:"completed_at"`
+}
+
+// Pending reports whether the erasure recorded by the tombstone has not completed
+func (t ErasureTombstone) Pending() bool {
+	return t.Digest == ""
+}
@@ -0,0 +1,21 @@
+package entity
+
+import (
+	"time"
+)
+
+// Group represents a named collection of users
+type Group struct {
+	ID          string    `json:"id" bigquery:"id"`
+	Name        string    `json:"name" bigquery:"name"`
+	Description string    `json:"description" bigquery:"description"`
+	CreatedAt   time.Time `json:"created_at" bigquery:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" bigquery:"updated_at"`
+}
+
+// Membership links a user to a group
+type Membership struct {
+	GroupID   string    `json:"group_id" bigquery:"group_id"`
+	UserID    string    `json:"user_id" bigquery:"user_id"`
+	CreatedAt time.Time `json:"created_at" bigquery:"created_at"`
+}
@@ -0,0 +1,14 @@
+package entity
+
+// Health statuses
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// Health reports whether the service is healthy, with the state of the
+// circuit breakers around its dependencies by name
+type Health struct {
+	Status          string            `json:"status"`
+	CircuitBreakers map[string]string `json:"circuit_breakers,omitempty"`
+}
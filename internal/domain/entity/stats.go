@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"time"
+)
+
+// Signup statistics intervals
+const (
+	StatsIntervalDay  = "day"
+	StatsIntervalWeek = "week"
+)
+
+// SignupCount is the number of users created in the period starting at Period
+type SignupCount struct {
+	Period time.Time `json:"period" bigquery:"period"`
+	Count  int64     `json:"count" bigquery:"signups"`
+}
+
+// DomainCount is the number of users with an email at Domain
+type DomainCount struct {
+	Domain string `json:"domain" bigquery:"domain"`
+	Count  int64  `json:"count" bigquery:"users"`
+}
+
+// UserStats summarizes the users and their signups over a date range.
+// From and To are inclusive dates formatted as YYYY-MM-DD.
+type UserStats struct {
+	Total        int64         `json:"total"`
+	From         string        `json:"from"`
+	To           string        `json:"to"`
+	Interval     string        `json:"interval"`
+	SignupsTotal int64         `json:"signups_total"`
+	Signups      []SignupCount `json:"signups"`
+	TopDomains   []DomainCount `json:"top_domains"`
+	GeneratedAt  time.Time     `json:"generated_at"`
+}
@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"strings"
 	"time"
 )
 
@@ -11,4 +12,20 @@ type User struct {
 	Email     string    `json:"email" bigquery:"email"`
 	CreatedAt time.Time `json:"created_at" bigquery:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" bigquery:"updated_at"`
+	// EmailHMAC is the deterministic digest used to look up an encrypted email.
+	// It is set by the repository only, so clients can neither see nor send it.
+	EmailHMAC string `json:"-" bigquery:"email_hmac"`
+}
+
+// LookupEmail returns the value stored for equality lookups by email
+func (u User) LookupEmail() string {
+	if u.EmailHMAC != "" {
+		return u.EmailHMAC
+	}
+	return u.Email
+}
+
+// NormalizeEmail returns the canonical form of an email used for storage and lookups
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"time"
+)
+
+// Webhook event types, one per user change type
+const (
+	WebhookUserCreated = "user." + ChangeCreated
+	WebhookUserUpdated = "user." + ChangeUpdated
+	WebhookUserDeleted = "user." + ChangeDeleted
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a subscription of an endpoint to user lifecycle events
+type Webhook struct {
+	ID  string `json:"id" bigquery:"id"`
+	URL string `json:"url" bigquery:"url"`
+	// Secret signs the payloads; it is only returned when the webhook is created
+	Secret    string    `json:"secret,omitempty" bigquery:"secret"`
+	Events    []string  `json:"events" bigquery:"events"`
+	Active    bool      `json:"active" bigquery:"active"`
+	CreatedAt time.Time `json:"created_at" bigquery:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bigquery:"updated_at"`
+}
+
+// Subscribes reports whether the webhook is active and wants events of that type
+func (w Webhook) Subscribes(eventType string) bool {
+	if !w.Active {
+		return false
+	}
+	for _, event := range w.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is the payload posted to webhooks. It only identifies the user;
+// receivers fetch the current state through the API.
+type WebhookEvent struct {
+	// ID is shared by every delivery of the event so receivers can deduplicate
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	UserID     string    `json:"user_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// WebhookDelivery records the attempts to deliver an event to a webhook
+type WebhookDelivery struct {
+	ID            string       `json:"id"`
+	WebhookID     string       `json:"webhook_id"`
+	Event         WebhookEvent `json:"event"`
+	Status        string       `json:"status"`
+	Attempts      int          `json:"attempts"`
+	LastStatus    int          `json:"last_status,omitempty"`
+	LastError     string       `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time   `json:"next_attempt_at,omitempty"`
+	// RedeliveryOf is the delivery a manual redelivery was requested for
+	RedeliveryOf string    `json:"redelivery_of,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
@@ -0,0 +1,196 @@
+// Package encryption provides envelope encryption of individual field values.
+//
+// Every value is encrypted with its own random data key, and the data key is
+// wrapped with a key encryption key from a KeyProvider. Rotating the key
+// encryption key only requires re-encrypting values, never the other way round.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// envelopePrefix marks encrypted values and their format version
+	envelopePrefix = "enc:v1:"
+
+	// dataKeySize is the size of the per-value AES-256 data key
+	dataKeySize = 32
+)
+
+// Encryption errors
+var (
+	ErrUnknownKey       = errors.New("unknown encryption key")
+	ErrMalformedValue   = errors.New("malformed encrypted value")
+	ErrDecryptionFailed = errors.New("decryption failed")
+)
+
+// Key is a key encryption key
+type Key struct {
+	ID       string
+	Material []byte
+}
+
+// KeyProvider supplies the keys used by an Envelope
+type KeyProvider interface {
+	// PrimaryKey returns the key new values are encrypted with
+	PrimaryKey(ctx context.Context) (Key, error)
+	// Key returns the key with the given ID to decrypt older values
+	Key(ctx context.Context, id string) (Key, error)
+	// HMACKey returns the key for deterministic lookup digests; it never rotates
+	HMACKey(ctx context.Context) ([]byte, error)
+}
+
+// Envelope encrypts and decrypts field values
+type Envelope struct {
+	keys KeyProvider
+}
+
+// NewEnvelope creates an envelope using keys from the provider
+func NewEnvelope(keys KeyProvider) *Envelope {
+	return &Envelope{keys: keys}
+}
+
+// Encrypt encrypts a value as enc:v1:<key id>:<wrapped data key>:<ciphertext>
+func (e *Envelope) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	kek, err := e.keys.PrimaryKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get primary key: %w", err)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := seal(kek.Material, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	return envelopePrefix + kek.ID + ":" + encoding.EncodeToString(wrapped) + ":" + encoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt. Values without the envelope
+// prefix are returned unchanged so that rows written before encryption was
+// enabled stay readable.
+func (e *Envelope) Decrypt(ctx context.Context, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformedValue
+	}
+	encoding := base64.RawURLEncoding
+	wrapped, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformedValue
+	}
+	ciphertext, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformedValue
+	}
+
+	kek, err := e.keys.Key(ctx, parts[0])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(kek.Material, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether a value is plaintext or encrypted with a key other than the primary key
+func (e *Envelope) NeedsRotation(ctx context.Context, value string) (bool, error) {
+	kek, err := e.keys.PrimaryKey(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get primary key: %w", err)
+	}
+	id, ok := KeyID(value)
+	return !ok || id != kek.ID, nil
+}
+
+// HMAC returns a deterministic hex digest of a value for equality lookups
+func (e *Envelope) HMAC(ctx context.Context, value string) (string, error) {
+	key, err := e.keys.HMACKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get HMAC key: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// IsEncrypted reports whether a value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix)
+}
+
+// KeyID returns the ID of the key encryption key of an encrypted value
+func KeyID(value string) (string, bool) {
+	if !IsEncrypted(value) {
+		return "", false
+	}
+	id, _, ok := strings.Cut(strings.TrimPrefix(value, envelopePrefix), ":")
+	return id, ok
+}
+
+// seal encrypts data with AES-GCM, prefixing the random nonce
+func seal(key, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// open decrypts data produced by seal
+func open(key, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, ErrMalformedValue
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// newGCM creates an AES-GCM cipher for a 256-bit key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,114 @@
+package encryption_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dragondarkon/bqredis-crud/internal/encryption"
+)
+
+// writeKeyFile writes a keyfile with deterministic keys and returns its provider
+func writeKeyFile(t *testing.T, primary string, ids ...string) *encryption.LocalKeyProvider {
+	t.Helper()
+
+	key := func(seed string) string {
+		return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(seed, 32)[:32]))
+	}
+	keys := make(map[string]string)
+	for _, id := range ids {
+		keys[id] = key(id)
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"primary":  primary,
+		"keys":     keys,
+		"hmac_key": key("h"),
+	})
+	if err != nil {
+		t.Fatalf("failed to encode keyfile: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write keyfile: %v", err)
+	}
+	provider, err := encryption.LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyFile() error = %v", err)
+	}
+	return provider
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	envelope := encryption.NewEnvelope(writeKeyFile(t, "k1", "k1"))
+
+	encrypted, err := envelope.Encrypt(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if strings.Contains(encrypted, "ada") || !encryption.IsEncrypted(encrypted) {
+		t.Fatalf("Encrypt() = %q, want an opaque envelope", encrypted)
+	}
+	if again, _ := envelope.Encrypt(ctx, "ada@example.com"); again == encrypted {
+		t.Error("Encrypt() is deterministic, want a fresh data key per value")
+	}
+
+	decrypted, err := envelope.Decrypt(ctx, encrypted)
+	if err != nil || decrypted != "ada@example.com" {
+		t.Errorf("Decrypt() = %q, %v, want the plaintext", decrypted, err)
+	}
+	if plain, err := envelope.Decrypt(ctx, "legacy@example.com"); err != nil || plain != "legacy@example.com" {
+		t.Errorf("Decrypt() of plaintext = %q, %v, want it unchanged", plain, err)
+	}
+
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	if _, err := envelope.Decrypt(ctx, tampered); !errors.Is(err, encryption.ErrDecryptionFailed) {
+		t.Errorf("Decrypt() of tampered value error = %v, want %v", err, encryption.ErrDecryptionFailed)
+	}
+
+	first, _ := envelope.HMAC(ctx, "ada@example.com")
+	second, _ := envelope.HMAC(ctx, "ada@example.com")
+	if first == "" || first != second {
+		t.Errorf("HMAC() = %q and %q, want equal digests", first, second)
+	}
+}
+
+func TestEnvelopeRotation(t *testing.T) {
+	ctx := context.Background()
+
+	old := encryption.NewEnvelope(writeKeyFile(t, "k1", "k1"))
+	encrypted, err := old.Encrypt(ctx, "Ada Lovelace")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	rotated := encryption.NewEnvelope(writeKeyFile(t, "k2", "k1", "k2"))
+	if needed, err := rotated.NeedsRotation(ctx, encrypted); err != nil || !needed {
+		t.Errorf("NeedsRotation() of old value = %v, %v, want true", needed, err)
+	}
+	if plain, err := rotated.Decrypt(ctx, encrypted); err != nil || plain != "Ada Lovelace" {
+		t.Errorf("Decrypt() of old value = %q, %v, want the plaintext", plain, err)
+	}
+
+	current, err := rotated.Encrypt(ctx, "Ada Lovelace")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if id, _ := encryption.KeyID(current); id != "k2" {
+		t.Errorf("KeyID() = %q, want k2", id)
+	}
+	if needed, _ := rotated.NeedsRotation(ctx, current); needed {
+		t.Error("NeedsRotation() of current value = true, want false")
+	}
+
+	retired := encryption.NewEnvelope(writeKeyFile(t, "k2", "k2"))
+	if _, err := retired.Decrypt(ctx, encrypted); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Errorf("Decrypt() with retired key error = %v, want %v", err, encryption.ErrUnknownKey)
+	}
+}
@@ -0,0 +1,92 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keyFile is the JSON layout of a local keyfile:
+//
+//	{
+//	  "primary": "2024-06",
+//	  "keys": {"2024-01": "<base64 32 bytes>", "2024-06": "<base64 32 bytes>"},
+//	  "hmac_key": "<base64 32 bytes>"
+//	}
+type keyFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+	HMACKey string            `json:"hmac_key"`
+}
+
+// LocalKeyProvider serves keys loaded from a local keyfile
+type LocalKeyProvider struct {
+	primary string
+	keys    map[string][]byte
+	hmacKey []byte
+}
+
+// LoadKeyFile reads a local keyfile. To rotate, add a new key, make it the
+// primary and re-encrypt; old keys must stay until no value uses them.
+func LoadKeyFile(path string) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keyfile: %w", err)
+	}
+
+	p := &LocalKeyProvider{primary: file.Primary, keys: make(map[string][]byte)}
+	for id, encoded := range file.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if p.keys[id], err = decodeKey(encoded); err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+	}
+	if _, ok := p.keys[file.Primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyfile", file.Primary)
+	}
+	if p.hmacKey, err = decodeKey(file.HMACKey); err != nil {
+		return nil, fmt.Errorf("hmac_key: %w", err)
+	}
+	return p, nil
+}
+
+// PrimaryKey returns the key new values are encrypted with
+func (p *LocalKeyProvider) PrimaryKey(ctx context.Context) (Key, error) {
+	return p.Key(ctx, p.primary)
+}
+
+// Key returns the key with the given ID
+func (p *LocalKeyProvider) Key(_ context.Context, id string) (Key, error) {
+	material, ok := p.keys[id]
+	if !ok {
+		return Key{}, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return Key{ID: id, Material: material}, nil
+}
+
+// HMACKey returns the key for deterministic lookup digests
+func (p *LocalKeyProvider) HMACKey(context.Context) ([]byte, error) {
+	return p.hmacKey, nil
+}
+
+// decodeKey decodes a base64 AES-256 key
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+	return key, nil
+}
@@ -0,0 +1,196 @@
+// Package masking redacts personal data in responses according to the roles
+// of the caller, which are carried through the context.
+package masking
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+)
+
+// DefaultRole is the policy entry for callers without a listed role
+const DefaultRole = "*"
+
+// redacted replaces values that must not be shown at all
+const redacted = "***"
+
+// Mode is how much of a field a role may see, ordered from least to most
+type Mode int
+
+// Masking modes
+const (
+	ModeRedact Mode = iota
+	ModePartial
+	ModeFull
+)
+
+// modes maps the names used in a policy to modes
+var modes = map[string]Mode{
+	"redact":  ModeRedact,
+	"partial": ModePartial,
+	"full":    ModeFull,
+}
+
+// userFields maps the names of maskable user fields to the fields and their partial masks
+var userFields = map[string]struct {
+	field   func(*entity.User) *string
+	partial func(string) string
+}{
+	"name":  {field: func(u *entity.User) *string { return &u.Name }, partial: partialName},
+	"email": {field: func(u *entity.User) *string { return &u.Email }, partial: partialEmail},
+}
+
+type contextKey struct{}
+
+// WithRoles returns a copy of ctx carrying the roles of the caller
+func WithRoles(ctx context.Context, roles ...string) context.Context {
+	return context.WithValue(ctx, contextKey{}, roles)
+}
+
+// RolesFromContext returns the roles carried by ctx
+func RolesFromContext(ctx context.Context) []string {
+	roles, _ := ctx.Value(contextKey{}).([]string)
+	return roles
+}
+
+// Policy declares per role how each user field is shown. Fields a role does
+// not list are shown in full; callers without a listed role get the DefaultRole
+// entry, and every field is redacted for them if there is none.
+type Policy struct {
+	roles map[string]map[string]Mode
+}
+
+// ParsePolicy parses a policy of the form
+//
+//	admin:;support:email=partial;*:email=redact,name=partial
+//
+// where each role lists field=mode pairs and the modes are full, partial or redact
+func ParsePolicy(spec string) (*Policy, error) {
+	p := &Policy{roles: make(map[string]map[string]Mode)}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		role, rules, ok := strings.Cut(entry, ":")
+		role = strings.TrimSpace(role)
+		if !ok || role == "" {
+			return nil, fmt.Errorf("invalid policy entry %q", entry)
+		}
+		if _, ok := p.roles[role]; ok {
+			return nil, fmt.Errorf("role %q is listed twice", role)
+		}
+
+		fields := make(map[string]Mode)
+		for _, rule := range strings.Split(rules, ",") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+			name, modeName, ok := strings.Cut(rule, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid rule %q for role %q", rule, role)
+			}
+			name = strings.TrimSpace(name)
+			if _, ok := userFields[name]; !ok {
+				return nil, fmt.Errorf("user has no maskable field %q", name)
+			}
+			mode, ok := modes[strings.TrimSpace(modeName)]
+			if !ok {
+				return nil, fmt.Errorf("unknown masking mode %q", modeName)
+			}
+			fields[name] = mode
+		}
+		p.roles[role] = fields
+	}
+	return p, nil
+}
+
+// modeFor returns the most permissive mode of a field across the roles
+func (p *Policy) modeFor(field string, roles []string) Mode {
+	mode, matched := ModeRedact, false
+	for _, role := range roles {
+		fields, ok := p.roles[role]
+		if !ok {
+			continue
+		}
+		matched = true
+		roleMode, ok := fields[field]
+		if !ok {
+			roleMode = ModeFull
+		}
+		mode = max(mode, roleMode)
+	}
+	if matched {
+		return mode
+	}
+
+	if fields, ok := p.roles[DefaultRole]; ok {
+		if roleMode, ok := fields[field]; ok {
+			return roleMode
+		}
+		return ModeFull
+	}
+	return ModeRedact
+}
+
+// MaskUser returns a copy of the user masked for the roles carried by ctx; a
+// nil policy returns the user unchanged
+func (p *Policy) MaskUser(ctx context.Context, user entity.User) entity.User {
+	if p == nil {
+		return user
+	}
+	roles := RolesFromContext(ctx)
+	for name, field := range userFields {
+		value := field.field(&user)
+		switch p.modeFor(name, roles) {
+		case ModePartial:
+			*value = field.partial(*value)
+		case ModeRedact:
+			*value = redacted
+		}
+	}
+	return user
+}
+
+// MaskUsers returns copies of the users masked for the roles carried by ctx
+func (p *Policy) MaskUsers(ctx context.Context, users []entity.User) []entity.User {
+	if p == nil {
+		return users
+	}
+	masked := make([]entity.User, len(users))
+	for i, user := range users {
+		masked[i] = p.MaskUser(ctx, user)
+	}
+	return masked
+}
+
+// partialEmail keeps the first character of the local part and the domain, e.g. j***@example.com
+func partialEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return partialWord(email)
+	}
+	return partialWord(local) + "@" + domain
+}
+
+// partialName keeps the initial of every word, e.g. A*** L***
+func partialName(name string) string {
+	words := strings.Fields(name)
+	for i, word := range words {
+		words[i] = partialWord(word)
+	}
+	return strings.Join(words, " ")
+}
+
+// partialWord keeps the first character of a word
+func partialWord(word string) string {
+	r, size := utf8.DecodeRuneInString(word)
+	if size == 0 {
+		return redacted
+	}
+	return string(r) + redacted
+}
@@ -0,0 +1,78 @@
+package masking_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/masking"
+)
+
+func TestPolicyMaskUser(t *testing.T) {
+	policy, err := masking.ParsePolicy("admin:; support: email=partial, name=full ; *:email=redact,name=partial")
+	if err != nil {
+		t.Fatalf("ParsePolicy() error = %v", err)
+	}
+	user := entity.User{ID: "user-1", Name: "Ada Lovelace", Email: "ada@example.com"}
+
+	tests := []struct {
+		name      string
+		roles     []string
+		wantName  string
+		wantEmail string
+	}{
+		{name: "admin", roles: []string{"admin"}, wantName: "Ada Lovelace", wantEmail: "ada@example.com"},
+		{name: "support", roles: []string{"support"}, wantName: "Ada Lovelace", wantEmail: "a***@example.com"},
+		{name: "most permissive role wins", roles: []string{"support", "admin"}, wantName: "Ada Lovelace", wantEmail: "ada@example.com"},
+		{name: "unlisted role", roles: []string{"viewer"}, wantName: "A*** L***", wantEmail: "***"},
+		{name: "no role", wantName: "A*** L***", wantEmail: "***"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := masking.WithRoles(context.Background(), tt.roles...)
+			got := policy.MaskUser(ctx, user)
+			if got.Name != tt.wantName || got.Email != tt.wantEmail {
+				t.Errorf("MaskUser() = %q <%s>, want %q <%s>", got.Name, got.Email, tt.wantName, tt.wantEmail)
+			}
+			if got.ID != user.ID {
+				t.Errorf("MaskUser() id = %q, want %q", got.ID, user.ID)
+			}
+		})
+	}
+
+	if got := policy.MaskUsers(context.Background(), []entity.User{user}); got[0].Email != "***" {
+		t.Errorf("MaskUsers() email = %q, want it redacted", got[0].Email)
+	}
+
+	var disabled *masking.Policy
+	if got := disabled.MaskUser(context.Background(), user); got != user {
+		t.Errorf("MaskUser() of nil policy = %+v, want the user unchanged", got)
+	}
+}
+
+func TestPolicyWithoutDefaultRole(t *testing.T) {
+	policy, err := masking.ParsePolicy("admin:")
+	if err != nil {
+		t.Fatalf("ParsePolicy() error = %v", err)
+	}
+	got := policy.MaskUser(context.Background(), entity.User{Name: "Ada", Email: "ada@example.com"})
+	if got.Name != "***" || got.Email != "***" {
+		t.Errorf("MaskUser() without role = %+v, want every field redacted", got)
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	for _, spec := range []string{
+		"support",
+		":email=partial",
+		"support:phone=partial",
+		"support:email=hidden",
+		"support:email",
+		"support:;support:email=full",
+	} {
+		if _, err := masking.ParsePolicy(spec); err == nil {
+			t.Errorf("ParsePolicy(%q) error = nil", spec)
+		}
+	}
+}
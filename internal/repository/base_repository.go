@@ -22,6 +22,14 @@ type BaseRepository[T any] interface {
 	Delete(ctx context.Context, id string) error
 }
 
+// PartialUpdater is implemented by repositories that can update some columns
+// of an entity without writing the others
+type PartialUpdater[T any] interface {
+	// UpdateColumns updates the named columns of an existing entity to the
+	// entity's values
+	UpdateColumns(ctx context.Context, entity T, columns []string) error
+}
+
 // BaseRepositoryImpl provides a base implementation of common repository functionality
 type BaseRepositoryImpl[T any] struct {
 	// Common fields and utilities can be added here
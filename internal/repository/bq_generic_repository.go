@@ -0,0 +1,409 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// TableDescriptor describes how an entity type is stored in BigQuery.
+// Columns are derived from the entity's `bigquery` struct tags.
+type TableDescriptor struct {
+	// Name is the singular entity name used in error messages, e.g. "user"
+	Name string
+
+	ProjectID string
+	Dataset   string
+	Table     string
+
+	// PrimaryKey is the column that identifies an entity; it must map to a string field
+	PrimaryKey string
+
+	// OrderBy is the column GetAll sorts by, newest first
+	OrderBy string
+
+	// Immutable lists columns that Update never changes, in addition to the primary key
+	Immutable []string
+
+	// Omit lists columns of the entity that the table does not have
+	Omit []string
+
+	// Tenancy scopes the table to the tenant carried in the request context
+	Tenancy Tenancy
+}
+
+// column maps a BigQuery column to a struct field
+type column struct {
+	name  string
+	index []int
+}
+
+// GenericBigQueryRepository implements BaseRepository for any struct type
+// tagged with `bigquery` column names
+type GenericBigQueryRepository[T any] struct {
+	BaseRepositoryImpl[T]
+	client     *bigquery.Client
+	descriptor TableDescriptor
+	columns    []column
+	primaryKey column
+	updatable  []column
+	schema     bigquery.Schema
+
+	// Statements hold a %s placeholder for the tenant's table reference
+	selectSQL  string
+	getAllSQL  string
+	getByIDSQL string
+	updateSQL  string
+	deleteSQL  string
+}
+
+// NewGenericBigQueryRepository creates a BigQuery repository for entities of type T
+func NewGenericBigQueryRepository[T any](client *bigquery.Client, descriptor TableDescriptor) (*GenericBigQueryRepository[T], error) {
+	entityType := reflect.TypeOf((*T)(nil)).Elem()
+	columns, err := bigQueryColumns(entityType)
+	if err != nil {
+		return nil, err
+	}
+	columns = slices.DeleteFunc(columns, func(col column) bool {
+		return slices.Contains(descriptor.Omit, col.name)
+	})
+
+	r := &GenericBigQueryRepository[T]{
+		client:     client,
+		descriptor: descriptor,
+		columns:    columns,
+	}
+
+	immutable := map[string]bool{descriptor.PrimaryKey: true}
+	for _, name := range descriptor.Immutable {
+		immutable[name] = true
+	}
+	var names []string
+	var foundKey, foundOrder bool
+	for _, col := range columns {
+		names = append(names, col.name)
+		if col.name == descriptor.PrimaryKey {
+			r.primaryKey = col
+			foundKey = true
+		}
+		if col.name == descriptor.OrderBy {
+			foundOrder = true
+		}
+		if !immutable[col.name] {
+			r.updatable = append(r.updatable, col)
+		}
+	}
+	if !foundKey {
+		return nil, fmt.Errorf("primary key column %q not found in %s", descriptor.PrimaryKey, entityType)
+	}
+	if !foundOrder {
+		return nil, fmt.Errorf("order by column %q not found in %s", descriptor.OrderBy, entityType)
+	}
+	if kind := entityType.FieldByIndex(r.primaryKey.index).Type.Kind(); kind != reflect.String {
+		return nil, fmt.Errorf("primary key column %q must be a string, got %s", descriptor.PrimaryKey, kind)
+	}
+	if len(r.updatable) == 0 {
+		return nil, fmt.Errorf("entity type %s has no updatable columns", entityType)
+	}
+	if r.schema, err = bigquery.InferSchema(reflect.New(entityType).Elem().Interface()); err != nil {
+		return nil, fmt.Errorf("failed to infer schema of %s: %w", entityType, err)
+	}
+	// The inferred schema is shared, so it is copied before columns are dropped
+	r.schema = slices.DeleteFunc(slices.Clone(r.schema), func(field *bigquery.FieldSchema) bool {
+		return slices.Contains(descriptor.Omit, field.Name)
+	})
+
+	tenancy := descriptor.Tenancy
+	byKey := fmt.Sprintf("%s = @%s", descriptor.PrimaryKey, descriptor.PrimaryKey)
+	r.selectSQL = fmt.Sprintf("SELECT %s FROM %%s", strings.Join(names, ", "))
+	r.getAllSQL = fmt.Sprintf("%s %s ORDER BY %s DESC LIMIT @pageSize OFFSET @offset", r.selectSQL, tenancy.where(), descriptor.OrderBy)
+	r.getByIDSQL = fmt.Sprintf("%s %s", r.selectSQL, tenancy.where(byKey))
+	r.deleteSQL = fmt.Sprintf("DELETE FROM %%s %s", tenancy.where(byKey))
+
+	var assignments []string
+	for _, col := range r.updatable {
+		assignments = append(assignments, fmt.Sprintf("%s = @%s", col.name, col.name))
+	}
+	r.updateSQL = fmt.Sprintf("UPDATE %%s SET %s %s", strings.Join(assignments, ", "), tenancy.where(byKey))
+
+	return r, nil
+}
+
+// bigQueryColumns lists the columns of a struct type from its `bigquery` tags
+func bigQueryColumns(t reflect.Type) ([]column, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("entity type %s must be a struct", t)
+	}
+
+	var columns []column
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("bigquery"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		columns = append(columns, column{name: name, index: field.Index})
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("entity type %s has no columns", t)
+	}
+	return columns, nil
+}
+
+// newQuery builds a query for the tenant in the context from a statement template
+func (r *GenericBigQueryRepository[T]) newQuery(ctx context.Context, statement string, params ...bigquery.QueryParameter) (*bigquery.Query, error) {
+	table, err := r.descriptor.Tenancy.tableRef(ctx, r.descriptor.ProjectID, r.descriptor.Dataset, r.descriptor.Table)
+	if err != nil {
+		return nil, err
+	}
+	tenantParams, err := r.descriptor.Tenancy.params(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.client.Query(fmt.Sprintf(statement, table))
+	query.Parameters = append(params, tenantParams...)
+	return query, nil
+}
+
+// entityID returns the primary key value of an entity
+func (r *GenericBigQueryRepository[T]) entityID(entity T) string {
+	return reflect.ValueOf(entity).FieldByIndex(r.primaryKey.index).String()
+}
+
+// GetAll retrieves all entities from BigQuery with pagination
+func (r *GenericBigQueryRepository[T]) GetAll(ctx context.Context, params PaginationParams) ([]T, error) {
+	r.ValidatePagination(&params)
+	offset := r.CalculateOffset(params)
+
+	query, err := r.newQuery(ctx, r.getAllSQL,
+		bigquery.QueryParameter{Name: "pageSize", Value: params.PageSize},
+		bigquery.QueryParameter{Name: "offset", Value: offset},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.executeQuery(ctx, query)
+}
+
+// GetByID retrieves an entity by its primary key from BigQuery
+func (r *GenericBigQueryRepository[T]) GetByID(ctx context.Context, id string) (T, error) {
+	var zero T
+	if err := r.ValidateID(id); err != nil {
+		return zero, err
+	}
+
+	query, err := r.newQuery(ctx, r.getByIDSQL, bigquery.QueryParameter{Name: r.descriptor.PrimaryKey, Value: id})
+	if err != nil {
+		return zero, err
+	}
+
+	entities, err := r.executeQuery(ctx, query)
+	if err != nil {
+		return zero, err
+	}
+	if len(entities) == 0 {
+		return zero, fmt.Errorf("%s %s: %w", r.descriptor.Name, id, ErrNotFound)
+	}
+	return entities[0], nil
+}
+
+// FindBy retrieves the entities whose column equals value, newest first
+func (r *GenericBigQueryRepository[T]) FindBy(ctx context.Context, column string, value interface{}) ([]T, error) {
+	if !r.hasColumn(column) {
+		return nil, fmt.Errorf("%s has no column %q", r.descriptor.Name, column)
+	}
+
+	statement := fmt.Sprintf("%s %s ORDER BY %s DESC", r.selectSQL, r.descriptor.Tenancy.where(column+" = @value"), r.descriptor.OrderBy)
+	query, err := r.newQuery(ctx, statement, bigquery.QueryParameter{Name: "value", Value: value})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.executeQuery(ctx, query)
+}
+
+// hasColumn reports whether the entity maps a column of that name
+func (r *GenericBigQueryRepository[T]) hasColumn(name string) bool {
+	for _, col := range r.columns {
+		if col.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// executeQuery is a helper method to execute BigQuery queries and return entities
+func (r *GenericBigQueryRepository[T]) executeQuery(ctx context.Context, query *bigquery.Query) ([]T, error) {
+	return readRows[T](ctx, query, r.descriptor.Name)
+}
+
+// Create inserts a new entity into BigQuery
+func (r *GenericBigQueryRepository[T]) Create(ctx context.Context, entity T) error {
+	dataset, err := r.descriptor.Tenancy.dataset(ctx, r.descriptor.Dataset)
+	if err != nil {
+		return err
+	}
+	row, err := r.descriptor.Tenancy.saver(ctx, entity, r.schema)
+	if err != nil {
+		return err
+	}
+
+	inserter := r.client.DatasetInProject(r.descriptor.ProjectID, dataset).Table(r.descriptor.Table).Inserter()
+	if err := inserter.Put(ctx, row); err != nil {
+		return fmt.Errorf("failed to insert %s: %w", r.descriptor.Name, err)
+	}
+	return nil
+}
+
+// Update updates the mutable columns of an existing entity in BigQuery
+func (r *GenericBigQueryRepository[T]) Update(ctx context.Context, entity T) error {
+	id := r.entityID(entity)
+	if err := r.ValidateID(id); err != nil {
+		return err
+	}
+
+	// First check if entity exists
+	if _, err := r.GetByID(ctx, id); err != nil {
+		return err
+	}
+
+	value := reflect.ValueOf(entity)
+	params := []bigquery.QueryParameter{{Name: r.descriptor.PrimaryKey, Value: id}}
+	for _, col := range r.updatable {
+		params = append(params, bigquery.QueryParameter{
+			Name:  col.name,
+			Value: value.FieldByIndex(col.index).Interface(),
+		})
+	}
+	query, err := r.newQuery(ctx, r.updateSQL, params...)
+	if err != nil {
+		return err
+	}
+
+	return r.executeUpdateQuery(ctx, query)
+}
+
+// UpdateColumns updates only the named mutable columns of an existing entity in BigQuery
+func (r *GenericBigQueryRepository[T]) UpdateColumns(ctx context.Context, entity T, columns []string) error {
+	id := r.entityID(entity)
+	if err := r.ValidateID(id); err != nil {
+		return err
+	}
+
+	value := reflect.ValueOf(entity)
+	params := []bigquery.QueryParameter{{Name: r.descriptor.PrimaryKey, Value: id}}
+	var assignments []string
+	for _, name := range columns {
+		col, ok := r.updatableColumn(name)
+		if !ok {
+			return fmt.Errorf("%s has no updatable column %q", r.descriptor.Name, name)
+		}
+		assignments = append(assignments, fmt.Sprintf("%s = @%s", col.name, col.name))
+		params = append(params, bigquery.QueryParameter{
+			Name:  col.name,
+			Value: value.FieldByIndex(col.index).Interface(),
+		})
+	}
+	if len(assignments) == 0 {
+		return fmt.Errorf("no columns of %s %s to update", r.descriptor.Name, id)
+	}
+
+	// First check if entity exists
+	if _, err := r.GetByID(ctx, id); err != nil {
+		return err
+	}
+
+	byKey := fmt.Sprintf("%s = @%s", r.descriptor.PrimaryKey, r.descriptor.PrimaryKey)
+	statement := fmt.Sprintf("UPDATE %%s SET %s %s", strings.Join(assignments, ", "), r.descriptor.Tenancy.where(byKey))
+	query, err := r.newQuery(ctx, statement, params...)
+	if err != nil {
+		return err
+	}
+
+	return r.executeUpdateQuery(ctx, query)
+}
+
+// updatableColumn returns the mutable column of that name
+func (r *GenericBigQueryRepository[T]) updatableColumn(name string) (column, bool) {
+	for _, col := range r.updatable {
+		if col.name == name {
+			return col, true
+		}
+	}
+	return column{}, false
+}
+
+// Delete removes an entity from BigQuery
+func (r *GenericBigQueryRepository[T]) Delete(ctx context.Context, id string) error {
+	if err := r.ValidateID(id); err != nil {
+		return err
+	}
+
+	// First check if entity exists
+	if _, err := r.GetByID(ctx, id); err != nil {
+		return err
+	}
+
+	query, err := r.newQuery(ctx, r.deleteSQL, bigquery.QueryParameter{Name: r.descriptor.PrimaryKey, Value: id})
+	if err != nil {
+		return err
+	}
+
+	return r.executeUpdateQuery(ctx, query)
+}
+
+// executeUpdateQuery is a helper method to execute update/delete queries
+func (r *GenericBigQueryRepository[T]) executeUpdateQuery(ctx context.Context, query *bigquery.Query) error {
+	return runDML(ctx, query)
+}
+
+// readRows executes a query and scans every row into a T
+func readRows[T any](ctx context.Context, query *bigquery.Query, name string) ([]T, error) {
+	it, err := query.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	var rows []T
+	for {
+		var row T
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", name, err)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// runDML executes a data manipulation statement and waits for it to complete
+func runDML(ctx context.Context, query *bigquery.Query) error {
+	job, err := query.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to execute query: %w", err)
+	}
+
+	_, err = job.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"cloud.google.com/go/bigquery"
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+)
+
+// BigQueryGroupRepository implements GroupRepository using BigQuery
+type BigQueryGroupRepository struct {
+	*GenericBigQueryRepository[entity.Group]
+}
+
+// GroupTableDescriptor describes the BigQuery table holding groups
+func GroupTableDescriptor(projectID, dataset, table string) TableDescriptor {
+	return TableDescriptor{
+		Name:       "group",
+		ProjectID:  projectID,
+		Dataset:    dataset,
+		Table:      table,
+		PrimaryKey: "id",
+		OrderBy:    "created_at",
+		Immutable:  []string{"created_at"},
+	}
+}
+
+// NewBigQueryGroupRepository creates a new BigQuery group repository
+func NewBigQueryGroupRepository(client *bigquery.Client, projectID, dataset, table string, tenancy Tenancy) *BigQueryGroupRepository {
+	descriptor := GroupTableDescriptor(projectID, dataset, table)
+	descriptor.Tenancy = tenancy
+	repo, err := NewGenericBigQueryRepository[entity.Group](client, descriptor)
+	if err != nil {
+		// The group mapping is static, so this only fails on a programming error
+		panic(err)
+	}
+	return &BigQueryGroupRepository{GenericBigQueryRepository: repo}
+}
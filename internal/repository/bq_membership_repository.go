@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+)
+
+// BigQueryMembershipRepository implements MembershipRepository using BigQuery
+type BigQueryMembershipRepository struct {
+	BaseRepositoryImpl[entity.Membership]
+	client    *bigquery.Client
+	projectID string
+	dataset   string
+	table     string
+	tenancy   Tenancy
+	schema    bigquery.Schema
+}
+
+// NewBigQueryMembershipRepository creates a new BigQuery membership repository
+func NewBigQueryMembershipRepository(client *bigquery.Client, projectID, dataset, table string, tenancy Tenancy) *BigQueryMembershipRepository {
+	schema, err := bigquery.InferSchema(entity.Membership{})
+	if err != nil {
+		// The membership mapping is static, so this only fails on a programming error
+		panic(err)
+	}
+	return &BigQueryMembershipRepository{
+		client:    client,
+		projectID: projectID,
+		dataset:   dataset,
+		table:     table,
+		tenancy:   tenancy,
+		schema:    schema,
+	}
+}
+
+// newQuery builds a query for the tenant in the context. The statement
+// receives the table reference and the WHERE clause for the conditions.
+func (r *BigQueryMembershipRepository) newQuery(ctx context.Context, statement string, conditions []string, params ...bigquery.QueryParameter) (*bigquery.Query, error) {
+	table, err := r.tenancy.tableRef(ctx, r.projectID, r.dataset, r.table)
+	if err != nil {
+		return nil, err
+	}
+	tenantParams, err := r.tenancy.params(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.client.Query(fmt.Sprintf(statement, table, r.tenancy.where(conditions...)))
+	query.Parameters = append(params, tenantParams...)
+	return query, nil
+}
+
+// get retrieves a single membership
+func (r *BigQueryMembershipRepository) get(ctx context.Context, groupID, userID string) (entity.Membership, error) {
+	query, err := r.newQuery(ctx, `
+		SELECT group_id, user_id, created_at
+		FROM %s
+		%s
+	`, []string{"group_id = @groupId", "user_id = @userId"},
+		bigquery.QueryParameter{Name: "groupId", Value: groupID},
+		bigquery.QueryParameter{Name: "userId", Value: userID},
+	)
+	if err != nil {
+		return entity.Membership{}, err
+	}
+
+	memberships, err := readRows[entity.Membership](ctx, query, "membership")
+	if err != nil {
+		return entity.Membership{}, err
+	}
+	if len(memberships) == 0 {
+		return entity.Membership{}, fmt.Errorf("membership of user %s in group %s: %w", userID, groupID, ErrNotFound)
+	}
+	return memberships[0], nil
+}
+
+// AddMember inserts a membership unless it already exists
+func (r *BigQueryMembershipRepository) AddMember(ctx context.Context, membership entity.Membership) error {
+	if err := r.ValidateID(membership.GroupID); err != nil {
+		return err
+	}
+	if err := r.ValidateID(membership.UserID); err != nil {
+		return err
+	}
+
+	_, err := r.get(ctx, membership.GroupID, membership.UserID)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	dataset, err := r.tenancy.dataset(ctx, r.dataset)
+	if err != nil {
+		return err
+	}
+	row, err := r.tenancy.saver(ctx, membership, r.schema)
+	if err != nil {
+		return err
+	}
+
+	inserter := r.client.DatasetInProject(r.projectID, dataset).Table(r.table).Inserter()
+	if err := inserter.Put(ctx, row); err != nil {
+		return fmt.Errorf("failed to insert membership: %w", err)
+	}
+	return nil
+}
+
+// RemoveMember deletes a membership
+func (r *BigQueryMembershipRepository) RemoveMember(ctx context.Context, groupID, userID string) error {
+	if err := r.ValidateID(groupID); err != nil {
+		return err
+	}
+	if err := r.ValidateID(userID); err != nil {
+		return err
+	}
+
+	// First check if membership exists
+	if _, err := r.get(ctx, groupID, userID); err != nil {
+		return err
+	}
+
+	query, err := r.newQuery(ctx, `
+		DELETE FROM %s
+		%s
+	`, []string{"group_id = @groupId", "user_id = @userId"},
+		bigquery.QueryParameter{Name: "groupId", Value: groupID},
+		bigquery.QueryParameter{Name: "userId", Value: userID},
+	)
+	if err != nil {
+		return err
+	}
+
+	return runDML(ctx, query)
+}
+
+// ListByGroup retrieves the memberships of a group, newest first
+func (r *BigQueryMembershipRepository) ListByGroup(ctx context.Context, groupID string, params PaginationParams) ([]entity.Membership, error) {
+	return r.list(ctx, "group_id", groupID, params)
+}
+
+// ListByUser retrieves the memberships of a user, newest first
+func (r *BigQueryMembershipRepository) ListByUser(ctx context.Context, userID string, params PaginationParams) ([]entity.Membership, error) {
+	return r.list(ctx, "user_id", userID, params)
+}
+
+// list retrieves the memberships matching a column value
+func (r *BigQueryMembershipRepository) list(ctx context.Context, column, id string, params PaginationParams) ([]entity.Membership, error) {
+	if err := r.ValidateID(id); err != nil {
+		return nil, err
+	}
+	r.ValidatePagination(&params)
+	offset := r.CalculateOffset(params)
+
+	query, err := r.newQuery(ctx, `
+		SELECT group_id, user_id, created_at
+		FROM %s
+		%s
+		ORDER BY created_at DESC
+		LIMIT @pageSize
+		OFFSET @offset
+	`, []string{column + " = @id"},
+		bigquery.QueryParameter{Name: "id", Value: id},
+		bigquery.QueryParameter{Name: "pageSize", Value: params.PageSize},
+		bigquery.QueryParameter{Name: "offset", Value: offset},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return readRows[entity.Membership](ctx, query, "membership")
+}
+
+// DeleteByGroup removes every membership of a group
+func (r *BigQueryMembershipRepository) DeleteByGroup(ctx context.Context, groupID string) error {
+	return r.deleteBy(ctx, "group_id", groupID)
+}
+
+// DeleteByUser removes every membership of a user
+func (r *BigQueryMembershipRepository) DeleteByUser(ctx context.Context, userID string) error {
+	return r.deleteBy(ctx, "user_id", userID)
+}
+
+// deleteBy removes the memberships matching a column value
+func (r *BigQueryMembershipRepository) deleteBy(ctx context.Context, column, id string) error {
+	if err := r.ValidateID(id); err != nil {
+		return err
+	}
+
+	query, err := r.newQuery(ctx, `
+		DELETE FROM %s
+		%s
+	`, []string{column + " = @id"}, bigquery.QueryParameter{Name: "id", Value: id})
+	if err != nil {
+		return err
+	}
+
+	return runDML(ctx, query)
+}
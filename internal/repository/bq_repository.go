@@ -6,176 +6,92 @@ import (
 
 	"cloud.google.com/go/bigquery"
 	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
-	"google.golang.org/api/iterator"
 )
 
 // BigQueryRepository implements UserRepository using BigQuery
 type BigQueryRepository struct {
-	BaseRepositoryImpl[entity.User]
-	client    *bigquery.Client
-	projectID string
-	dataset   string
-	table     string
+	*GenericBigQueryRepository[entity.User]
+	emailColumn string
 }
 
-// NewBigQueryRepository creates a new BigQuery repository
-func NewBigQueryRepository(client *bigquery.Client, projectID, dataset, table string) *BigQueryRepository {
-	return &BigQueryRepository{
-		client:    client,
-		projectID: projectID,
-		dataset:   dataset,
-		table:     table,
+// UserTableDescriptor describes the BigQuery table holding users
+func UserTableDescriptor(projectID, dataset, table string) TableDescriptor {
+	return TableDescriptor{
+		Name:       "user",
+		ProjectID:  projectID,
+		Dataset:    dataset,
+		Table:      table,
+		PrimaryKey: "id",
+		OrderBy:    "created_at",
+		Immutable:  []string{"created_at"},
 	}
 }
 
-// GetAll retrieves all users from BigQuery with pagination
-func (r *BigQueryRepository) GetAll(ctx context.Context, params PaginationParams) ([]entity.User, error) {
-	r.ValidatePagination(&params)
-	offset := r.CalculateOffset(params)
+// emailHMACColumn holds the digest encrypted emails are looked up by
+const emailHMACColumn = "email_hmac"
 
-	query := r.client.Query(`
-		SELECT id, name, email, created_at, updated_at
-		FROM @dataset.@table
-		ORDER BY created_at DESC
-		LIMIT @pageSize
-		OFFSET @offset
-	`)
-	query.Parameters = []bigquery.QueryParameter{
-		{Name: "dataset", Value: r.dataset},
-		{Name: "table", Value: r.table},
-		{Name: "pageSize", Value: params.PageSize},
-		{Name: "offset", Value: offset},
-	}
-
-	return r.executeQuery(ctx, query)
+// NewBigQueryRepository creates a new BigQuery repository for a users table
+// without the email_hmac column
+func NewBigQueryRepository(client *bigquery.Client, projectID, dataset, table string, tenancy Tenancy) *BigQueryRepository {
+	descriptor := UserTableDescriptor(projectID, dataset, table)
+	descriptor.Tenancy = tenancy
+	descriptor.Omit = []string{emailHMACColumn}
+	return &BigQueryRepository{GenericBigQueryRepository: newUserTable(client, descriptor), emailColumn: "email"}
 }
 
-// GetByID retrieves a user by ID from BigQuery
-func (r *BigQueryRepository) GetByID(ctx context.Context, id string) (entity.User, error) {
-	if err := r.ValidateID(id); err != nil {
-		return entity.User{}, err
-	}
-
-	query := r.client.Query(`
-		SELECT id, name, email, created_at, updated_at
-		FROM @dataset.@table
-		WHERE id = @id
-	`)
-	query.Parameters = []bigquery.QueryParameter{
-		{Name: "dataset", Value: r.dataset},
-		{Name: "table", Value: r.table},
-		{Name: "id", Value: id},
-	}
-
-	users, err := r.executeQuery(ctx, query)
+// newUserTable creates the generic repository of a users table
+func newUserTable(client *bigquery.Client, descriptor TableDescriptor) *GenericBigQueryRepository[entity.User] {
+	repo, err := NewGenericBigQueryRepository[entity.User](client, descriptor)
 	if err != nil {
-		return entity.User{}, err
-	}
-	if len(users) == 0 {
-		return entity.User{}, fmt.Errorf("user %s: %w", id, ErrNotFound)
-	}
-	return users[0], nil
-}
-
-// executeQuery is a helper method to execute BigQuery queries and return users
-func (r *BigQueryRepository) executeQuery(ctx context.Context, query *bigquery.Query) ([]entity.User, error) {
-	it, err := query.Read(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute query: %w", err)
-	}
-
-	var users []entity.User
-	for {
-		var user entity.User
-		err := it.Next(&user)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
-		}
-		users = append(users, user)
+		// The user mapping is static, so this only fails on a programming error
+		panic(err)
 	}
-
-	return users, nil
+	return repo
 }
 
-// Create inserts a new user into BigQuery
-func (r *BigQueryRepository) Create(ctx context.Context, user entity.User) error {
-	inserter := r.client.Dataset(r.dataset).Table(r.table).Inserter()
-	if err := inserter.Put(ctx, user); err != nil {
-		return fmt.Errorf("failed to insert user: %w", err)
-	}
-	return nil
+// WithEmailHMAC reads and writes the email_hmac column and makes email lookups
+// match it, for tables whose emails are encrypted
+func (r *BigQueryRepository) WithEmailHMAC() *BigQueryRepository {
+	descriptor := r.descriptor
+	descriptor.Omit = nil
+	r.GenericBigQueryRepository = newUserTable(r.client, descriptor)
+	r.emailColumn = emailHMACColumn
+	return r
 }
 
-// Update updates an existing user in BigQuery
-func (r *BigQueryRepository) Update(ctx context.Context, user entity.User) error {
-	if err := r.ValidateID(user.ID); err != nil {
-		return err
-	}
-
-	// First check if user exists
-	_, err := r.GetByID(ctx, user.ID)
+// GetByEmail retrieves the most recently created user with the email lookup value
+func (r *BigQueryRepository) GetByEmail(ctx context.Context, email string) (entity.User, error) {
+	users, err := r.FindBy(ctx, r.emailColumn, email)
 	if err != nil {
-		return err
+		return entity.User{}, err
 	}
-
-	query := r.client.Query(`
-		UPDATE @dataset.@table
-		SET name = @name, 
-			email = @email, 
-			updated_at = @updatedAt
-		WHERE id = @id
-	`)
-	query.Parameters = []bigquery.QueryParameter{
-		{Name: "dataset", Value: r.dataset},
-		{Name: "table", Value: r.table},
-		{Name: "name", Value: user.Name},
-		{Name: "email", Value: user.Email},
-		{Name: "updatedAt", Value: user.UpdatedAt},
-		{Name: "id", Value: user.ID},
+	if len(users) == 0 {
+		return entity.User{}, fmt.Errorf("user with email %s: %w", email, ErrNotFound)
 	}
-
-	return r.executeUpdateQuery(ctx, query)
+	return users[0], nil
 }
 
-// Delete removes a user from BigQuery
-func (r *BigQueryRepository) Delete(ctx context.Context, id string) error {
-	if err := r.ValidateID(id); err != nil {
-		return err
-	}
-
-	// First check if user exists
-	_, err := r.GetByID(ctx, id)
+// FindIDsByEmail returns the IDs of the users stored with the email lookup value
+func (r *BigQueryRepository) FindIDsByEmail(ctx context.Context, email string) ([]string, error) {
+	users, err := r.FindBy(ctx, r.emailColumn, email)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	query := r.client.Query(`
-		DELETE FROM @dataset.@table
-		WHERE id = @id
-	`)
-	query.Parameters = []bigquery.QueryParameter{
-		{Name: "dataset", Value: r.dataset},
-		{Name: "table", Value: r.table},
-		{Name: "id", Value: id},
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
 	}
-
-	return r.executeUpdateQuery(ctx, query)
+	return ids, nil
 }
 
-// executeUpdateQuery is a helper method to execute update/delete queries
-func (r *BigQueryRepository) executeUpdateQuery(ctx context.Context, query *bigquery.Query) error {
-	job, err := query.Run(ctx)
+// GetRecentlyUpdated retrieves up to limit users, most recently updated first
+func (r *BigQueryRepository) GetRecentlyUpdated(ctx context.Context, limit int) ([]entity.User, error) {
+	statement := fmt.Sprintf("%s %s ORDER BY updated_at DESC LIMIT @limit", r.selectSQL, r.descriptor.Tenancy.where())
+	query, err := r.newQuery(ctx, statement, bigquery.QueryParameter{Name: "limit", Value: limit})
 	if err != nil {
-		return fmt.Errorf("failed to execute query: %w", err)
+		return nil, err
 	}
 
-	_, err = job.Wait(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to complete job: %w", err)
-	}
-
-	return nil
+	return r.executeQuery(ctx, query)
 }
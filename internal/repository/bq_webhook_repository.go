@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+)
+
+// BigQueryWebhookRepository implements WebhookRepository using BigQuery
+type BigQueryWebhookRepository struct {
+	*GenericBigQueryRepository[entity.Webhook]
+}
+
+// WebhookTableDescriptor describes the BigQuery table holding webhook subscriptions
+func WebhookTableDescriptor(projectID, dataset, table string) TableDescriptor {
+	return TableDescriptor{
+		Name:       "webhook",
+		ProjectID:  projectID,
+		Dataset:    dataset,
+		Table:      table,
+		PrimaryKey: "id",
+		OrderBy:    "created_at",
+		Immutable:  []string{"created_at"},
+	}
+}
+
+// NewBigQueryWebhookRepository creates a new BigQuery webhook repository
+func NewBigQueryWebhookRepository(client *bigquery.Client, projectID, dataset, table string, tenancy Tenancy) *BigQueryWebhookRepository {
+	descriptor := WebhookTableDescriptor(projectID, dataset, table)
+	descriptor.Tenancy = tenancy
+	repo, err := NewGenericBigQueryRepository[entity.Webhook](client, descriptor)
+	if err != nil {
+		// The webhook mapping is static, so this only fails on a programming error
+		panic(err)
+	}
+	return &BigQueryWebhookRepository{GenericBigQueryRepository: repo}
+}
+
+// ListActive retrieves every active webhook, newest first
+func (r *BigQueryWebhookRepository) ListActive(ctx context.Context) ([]entity.Webhook, error) {
+	return r.FindBy(ctx, "active", true)
+}
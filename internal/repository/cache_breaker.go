@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sony/gobreaker"
+)
+
+// ErrCacheUnavailable is returned instead of calling Redis while the circuit breaker is open
+var ErrCacheUnavailable = errors.New("cache unavailable")
+
+// Circuit breaker states, as reported by health and metrics
+const (
+	CircuitClosed   = "closed"
+	CircuitHalfOpen = "half-open"
+	CircuitOpen     = "open"
+)
+
+// CircuitBreakerSettings configures when a circuit breaker trips and how it recovers
+type CircuitBreakerSettings struct {
+	// Failures is how many consecutive failures or timeouts open the breaker
+	Failures int
+	// OpenTimeout is how long the breaker stays open before it lets probes through
+	OpenTimeout time.Duration
+	// Probes is how many requests a half-open breaker lets through; it closes
+	// once they all succeed and opens again on the first failure
+	Probes int
+}
+
+// CircuitBreaker stops calling Redis after consecutive failures, so that an
+// outage fails cache calls right away instead of after a timeout each, and
+// lets a few probes through after a while to find out whether Redis recovered
+type CircuitBreaker struct {
+	breaker *gobreaker.CircuitBreaker
+
+	// pending holds the invalidations that failed, replayed before the next
+	// call so that the breaker cannot close while they are outstanding
+	mu      sync.Mutex
+	pending []pendingInvalidation
+}
+
+// pendingInvalidation is an invalidation to retry with the context it was made in
+type pendingInvalidation struct {
+	ctx          context.Context
+	invalidation func(context.Context) error
+}
+
+// bypassBreakerKey marks contexts whose Redis calls are made even while the breaker is open
+type bypassBreakerKey struct{}
+
+// NewCircuitBreaker creates a closed circuit breaker, named in logs and metrics
+func NewCircuitBreaker(name string, settings CircuitBreakerSettings) *CircuitBreaker {
+	circuitBreakerState.WithLabelValues(name).Set(circuitStateValue(gobreaker.StateClosed))
+	return &CircuitBreaker{breaker: gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        name,
+		MaxRequests: uint32(settings.Probes),
+		Timeout:     settings.OpenTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= uint32(settings.Failures)
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			log.Printf("Circuit breaker %s changed from %s to %s", name, circuitState(from), circuitState(to))
+			circuitBreakerState.WithLabelValues(name).Set(circuitStateValue(to))
+			circuitBreakerTransitions.WithLabelValues(name, circuitState(to)).Inc()
+		},
+		IsSuccessful: redisSucceeded,
+	})}
+}
+
+// Name returns the name of the breaker
+func (b *CircuitBreaker) Name() string {
+	return b.breaker.Name()
+}
+
+// State returns whether the breaker is closed, half-open or open
+func (b *CircuitBreaker) State() string {
+	return circuitState(b.breaker.State())
+}
+
+// open tells whether the breaker rejects calls; a nil breaker never does
+func (b *CircuitBreaker) open() bool {
+	return b != nil && b.breaker.State() == gobreaker.StateOpen
+}
+
+// execute runs a Redis operation unless the breaker rejects it, replaying
+// the pending invalidations first. A replay that fails fails the operation, so
+// a half-open breaker opens again instead of closing over stale entries.
+func (b *CircuitBreaker) execute(operation func() error) error {
+	_, err := b.breaker.Execute(func() (interface{}, error) {
+		if err := b.replay(); err != nil {
+			return nil, err
+		}
+		return nil, operation()
+	})
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		circuitBreakerRejections.WithLabelValues(b.Name()).Inc()
+		return fmt.Errorf("circuit breaker %s is %s: %w", b.Name(), b.State(), ErrCacheUnavailable)
+	}
+	return err
+}
+
+// enqueue keeps an invalidation that failed for the next call to replay. The
+// context must bypass the breaker, since it is replayed from within a call.
+func (b *CircuitBreaker) enqueue(ctx context.Context, invalidation func(context.Context) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, pendingInvalidation{ctx: ctx, invalidation: invalidation})
+}
+
+// replay runs the pending invalidations in order, keeping the one that
+// failed and those after it for the next call. Concurrent calls wait for the
+// replay, so that none of them reads an entry it is about to drop.
+func (b *CircuitBreaker) replay() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.pending) > 0 {
+		p := b.pending[0]
+		if err := p.invalidation(p.ctx); err != nil {
+			return fmt.Errorf("failed to replay cache invalidation: %w", err)
+		}
+		b.pending = b.pending[1:]
+	}
+	return nil
+}
+
+// redisSucceeded tells whether Redis answered, even if only that a key is missing.
+// Callers that gave up are not held against Redis.
+func redisSucceeded(err error) bool {
+	return err == nil ||
+		errors.Is(err, errCacheMiss) ||
+		errors.Is(err, redis.Nil) ||
+		errors.Is(err, context.Canceled)
+}
+
+// withoutBreaker makes the Redis calls of ctx even while the breaker is open
+func withoutBreaker(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassBreakerKey{}, true)
+}
+
+// circuitState names a breaker state
+func circuitState(state gobreaker.State) string {
+	switch state {
+	case gobreaker.StateHalfOpen:
+		return CircuitHalfOpen
+	case gobreaker.StateOpen:
+		return CircuitOpen
+	default:
+		return CircuitClosed
+	}
+}
+
+// circuitStateValue encodes a breaker state for the state gauge
+func circuitStateValue(state gobreaker.State) float64 {
+	switch state {
+	case gobreaker.StateHalfOpen:
+		return 1
+	case gobreaker.StateOpen:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// WithCircuitBreaker skips Redis while the breaker is open, so that reads go
+// straight to the underlying repository. Invalidations are then made in the
+// background past the breaker, so that writes do not wait on Redis but still
+// drop what they replaced if Redis answers. Invalidations that fail are
+// replayed before the next cache call, so none are lost once Redis recovers.
+func (r *CachedRepository[T]) WithCircuitBreaker(breaker *CircuitBreaker) *CachedRepository[T] {
+	r.breaker = breaker
+	return r
+}
+
+// invalidate runs an invalidation, or starts it in the background past the
+// breaker while the breaker is open, queueing it for replay if it fails
+func (r *CachedRepository[T]) invalidate(ctx context.Context, invalidation func(context.Context) error) error {
+	if !r.breaker.open() {
+		err := invalidation(ctx)
+		if err != nil && r.breaker != nil {
+			r.breaker.enqueue(withoutBreaker(context.WithoutCancel(ctx)), invalidation)
+		}
+		return err
+	}
+
+	go func() {
+		ctx := withoutBreaker(context.WithoutCancel(ctx))
+		if err := invalidation(ctx); err != nil {
+			log.Printf("Failed to invalidate %s cache while the circuit breaker is open, retrying once Redis recovers: %v", r.namespace, err)
+			r.breaker.enqueue(ctx, invalidation)
+		}
+	}()
+	return nil
+}
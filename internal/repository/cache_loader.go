@@ -0,0 +1,326 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// lockKeyPrefix prefixes the keys locking the load of a cache entry
+	lockKeyPrefix = "lock:"
+
+	// lockPollInterval is how often an instance waiting for a lock checks
+	// whether the entry was filled
+	lockPollInterval = 20 * time.Millisecond
+
+	// revalidateFlightPrefix keeps background refreshes of a key apart from
+	// the loads of callers waiting for it
+	revalidateFlightPrefix = "revalidate:"
+)
+
+// releaseLockScript deletes KEYS[1] if it still holds the token ARGV[1], so
+// that a lock that expired and was taken by another instance is left alone
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// CacheLock configures a Redis lock letting a single instance load a missing
+// cache entry while the others wait for it
+type CacheLock struct {
+	// TTL bounds how long an instance that dies while loading holds the lock
+	TTL time.Duration
+	// Wait is how long the other instances wait for the entry before loading
+	// it themselves
+	Wait time.Duration
+}
+
+// WithLock makes cache misses take a lock in Redis before loading, so that
+// the fleet loads each missing entry once
+func (r *CachedRepository[T]) WithLock(lock CacheLock) *CachedRepository[T] {
+	r.lock = &lock
+	return r
+}
+
+// entryTTL is how long an entry is fresh and how long it is kept
+type entryTTL struct {
+	soft time.Duration
+	hard time.Duration
+	// missing is how long a value found missing is remembered; zero does not cache misses
+	missing time.Duration
+}
+
+// cacheEntry is a cached value with the time after which it is refreshed
+type cacheEntry[V any] struct {
+	Value V `json:"value"`
+	// SoftExpiry is zero for entries refreshed only once they are gone
+	SoftExpiry time.Time `json:"soft_expiry"`
+	// Missing marks a value the underlying repository did not find
+	Missing bool `json:"missing,omitempty"`
+}
+
+// newCacheEntry wraps a value loaded now
+func newCacheEntry[V any](value V, ttl entryTTL) cacheEntry[V] {
+	entry := cacheEntry[V]{Value: value}
+	if ttl.soft > 0 && ttl.soft < ttl.hard {
+		entry.SoftExpiry = time.Now().Add(ttl.soft)
+	}
+	return entry
+}
+
+// stale tells whether the entry is past its soft TTL
+func (e cacheEntry[V]) stale() bool {
+	return !e.SoftExpiry.IsZero() && time.Now().After(e.SoftExpiry)
+}
+
+// expiry returns how long the entry is kept
+func (e cacheEntry[V]) expiry(ttl entryTTL) time.Duration {
+	if e.Missing {
+		return ttl.missing
+	}
+	return ttl.hard
+}
+
+// result returns the cached value, or ErrNotFound for a cached miss
+func (e cacheEntry[V]) result(key string) (V, error) {
+	if e.Missing {
+		return e.Value, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return e.Value, nil
+}
+
+// getShared returns the cached value of a key, from the local tier if any and
+// then Redis, refreshing it in the background once stale, or loads it when it
+// is not cached or the circuit breaker is open
+func getShared[T, V any](ctx context.Context, r *CachedRepository[T], key string, ttl entryTTL, load func(context.Context) (V, error)) (V, error) {
+	serve := func(entry cacheEntry[V]) (V, error) {
+		if entry.Missing {
+			cacheNegativeHits.WithLabelValues(r.namespace).Inc()
+		}
+		if entry.stale() {
+			cacheStaleHits.WithLabelValues(r.namespace).Inc()
+			revalidate(ctx, r, key, ttl, load)
+		}
+		return entry.result(key)
+	}
+
+	if r.local != nil {
+		if held, ok := r.local.get(r.namespace, r.scopedKey(ctx, key)); ok {
+			if entry, ok := held.(cacheEntry[V]); ok {
+				return serve(entry)
+			}
+		}
+	}
+
+	// While Redis is failing, go straight to the underlying repository
+	if r.breaker.open() {
+		cacheBypasses.WithLabelValues(r.namespace).Inc()
+		return load(ctx)
+	}
+
+	var entry cacheEntry[V]
+	if err := r.cacheGet(ctx, key, &entry); err == nil {
+		holdLocally(ctx, r, key, entry, ttl)
+		return serve(entry)
+	}
+
+	// Cache miss, get from underlying repository
+	return loadShared(ctx, r, key, ttl, load)
+}
+
+// loadShared loads a missing cache entry and caches it. Concurrent misses of
+// the key in this process share one load and, with a lock, other instances
+// wait for the entry instead of loading it too. Each caller stops waiting
+// when its own ctx is done, without cancelling the shared load.
+func loadShared[T, V any](ctx context.Context, r *CachedRepository[T], key string, ttl entryTTL, load func(context.Context) (V, error)) (V, error) {
+	var value V
+	loaded := false
+	results := r.loads.DoChan(r.scopedKey(ctx, key), func() (interface{}, error) {
+		loaded = true
+		return loadEntry(context.WithoutCancel(ctx), r, key, ttl, load)
+	})
+
+	select {
+	case result := <-results:
+		if !loaded {
+			cacheCoalesced.WithLabelValues(r.namespace, coalescedSingleflight).Inc()
+		}
+		if result.Err != nil {
+			return value, result.Err
+		}
+		return result.Val.(V), nil
+	case <-ctx.Done():
+		return value, ctx.Err()
+	}
+}
+
+// loadEntry loads an entry on behalf of the process, taking the lock if one
+// is configured. The lock is best effort: when Redis fails the entry is loaded
+// without it.
+func loadEntry[T, V any](ctx context.Context, r *CachedRepository[T], key string, ttl entryTTL, load func(context.Context) (V, error)) (V, error) {
+	if r.lock != nil {
+		token, acquired, err := r.acquireLock(ctx, key)
+		switch {
+		case err != nil:
+			log.Printf("Failed to lock %s: %v", key, err)
+		case acquired:
+			defer r.releaseLock(ctx, key, token)
+		default:
+			if entry, ok := waitForEntry[T, V](ctx, r, key); ok {
+				cacheCoalesced.WithLabelValues(r.namespace, coalescedLock).Inc()
+				return entry.result(key)
+			}
+			cacheLockTimeouts.WithLabelValues(r.namespace).Inc()
+		}
+	}
+
+	cacheLoads.WithLabelValues(r.namespace).Inc()
+	started := time.Now()
+	value, err := load(ctx)
+	entry, ok := loadedEntry(value, err, ttl)
+	if !ok {
+		return value, err
+	}
+
+	// Waiting instances poll for the entry, so it is cached before the lock is released
+	if r.lock != nil {
+		storeEntry(ctx, r, key, entry, ttl, started)
+		return value, err
+	}
+
+	// Update cache in background
+	go storeEntry(ctx, r, key, entry, ttl, started)
+	return value, err
+}
+
+// loadedEntry returns the entry caching the result of a load, if it is cached
+func loadedEntry[V any](value V, err error, ttl entryTTL) (cacheEntry[V], bool) {
+	switch {
+	case err == nil:
+		return newCacheEntry(value, ttl), true
+	case errors.Is(err, ErrNotFound) && ttl.missing > 0:
+		return cacheEntry[V]{Missing: true}, true
+	default:
+		return cacheEntry[V]{}, false
+	}
+}
+
+// revalidate refreshes a stale entry in the background. It is skipped while
+// the entry is being refreshed by this process or, with a lock, by another
+// instance, which keeps serving it stale until then.
+func revalidate[T, V any](ctx context.Context, r *CachedRepository[T], key string, ttl entryTTL, load func(context.Context) (V, error)) {
+	ctx = context.WithoutCancel(ctx)
+	// Nobody waits for the refresh, so its result channel is dropped
+	r.loads.DoChan(revalidateFlightPrefix+r.scopedKey(ctx, key), func() (interface{}, error) {
+		if r.lock != nil {
+			token, acquired, err := r.acquireLock(ctx, key)
+			if err != nil {
+				log.Printf("Failed to lock %s: %v", key, err)
+				return nil, err
+			}
+			if !acquired {
+				return nil, nil
+			}
+			defer r.releaseLock(ctx, key, token)
+		}
+
+		cacheLoads.WithLabelValues(r.namespace).Inc()
+		started := time.Now()
+		value, err := load(ctx)
+		entry, ok := loadedEntry(value, err, ttl)
+		if !ok {
+			log.Printf("Failed to refresh %s: %v", key, err)
+			return nil, err
+		}
+		storeEntry(ctx, r, key, entry, ttl, started)
+		return nil, nil
+	})
+}
+
+// storeEntry caches an entry loaded since started until it expires, unless
+// what it was loaded from was overwritten or invalidated meanwhile. A found
+// entity is versioned by its own version, if it has one, and anything else by
+// when its load started; only the versions of found entities are recorded, so
+// that a cached miss or list page never holds back the fills of others. A miss
+// is also dropped when the entity was written after the load started.
+func storeEntry[T, V any](ctx context.Context, r *CachedRepository[T], key string, entry cacheEntry[V], ttl entryTTL, started time.Time) {
+	var (
+		stored bool
+		err    error
+	)
+	switch entity, ok := any(entry.Value).(T); {
+	case entry.Missing:
+		stored, err = r.storeMissing(ctx, key, entry, started, entry.expiry(ttl))
+	case ok && r.version != nil:
+		stored, err = r.storeIfNewer(ctx, key, entry, r.version(entity), entry.expiry(ttl), true)
+	default:
+		stored, err = r.storeIfNewer(ctx, key, entry, started, entry.expiry(ttl), false)
+	}
+	if err != nil {
+		log.Printf("Failed to cache %s: %v", key, err)
+		return
+	}
+	if stored {
+		holdLocally(ctx, r, key, entry, ttl)
+	}
+}
+
+// holdLocally keeps an entry in the local tier, if any, for no longer than it is kept in Redis
+func holdLocally[T, V any](ctx context.Context, r *CachedRepository[T], key string, entry cacheEntry[V], ttl entryTTL) {
+	if r.local != nil {
+		r.local.set(r.namespace, r.scopedKey(ctx, key), entry, entry.expiry(ttl))
+	}
+}
+
+// acquireLock takes the lock of a key, returning the token releasing it
+func (r *CachedRepository[T]) acquireLock(ctx context.Context, key string) (string, bool, error) {
+	token := uuid.NewString()
+	lockKey := r.scopedKey(ctx, lockKeyPrefix+key)
+
+	var acquired bool
+	err := r.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		acquired, err = r.client.SetNX(ctx, lockKey, token, r.lock.TTL).Result()
+		return err
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to acquire cache lock: %w", err)
+	}
+	return token, acquired, nil
+}
+
+// releaseLock frees the lock of a key if it is still held with the token
+func (r *CachedRepository[T]) releaseLock(ctx context.Context, key, token string) {
+	lockKey := r.scopedKey(ctx, lockKeyPrefix+key)
+	err := r.executeWithTimeout(ctx, func(ctx context.Context) error {
+		return releaseLockScript.Run(ctx, r.client, []string{lockKey}, token).Err()
+	})
+	if err != nil {
+		log.Printf("Failed to release cache lock %s: %v", key, err)
+	}
+}
+
+// waitForEntry polls the cache for the entry another instance is loading and
+// tells whether it showed up within the lock wait
+func waitForEntry[T, V any](ctx context.Context, r *CachedRepository[T], key string) (cacheEntry[V], bool) {
+	deadline := time.Now().Add(r.lock.Wait)
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+
+	var entry cacheEntry[V]
+	for time.Now().Before(deadline) {
+		<-ticker.C
+		if err := r.cacheGet(ctx, key, &entry); err == nil {
+			return entry, true
+		}
+	}
+	return entry, false
+}
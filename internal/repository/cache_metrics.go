@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Cache metrics, labelled by the namespace of the cached repository
+var (
+	cacheLoads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_loads_total",
+		Help: "Cache misses loaded from the underlying repository, by namespace.",
+	}, []string{"namespace"})
+	cacheStaleHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_stale_hits_total",
+		Help: "Cache entries served past their soft TTL while refreshed in the background, by namespace.",
+	}, []string{"namespace"})
+	cacheNegativeHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_negative_hits_total",
+		Help: "Lookups answered by a cached miss instead of the underlying repository, by namespace.",
+	}, []string{"namespace"})
+	cacheCoalesced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_coalesced_requests_total",
+		Help: "Cache misses answered by a load another request made, by namespace and how the load was shared.",
+	}, []string{"namespace", "via"})
+	cacheLockTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_lock_timeouts_total",
+		Help: "Cache misses loaded after waiting in vain for the instance holding the lock, by namespace.",
+	}, []string{"namespace"})
+	cacheWriteConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_write_conflicts_total",
+		Help: "Cache writes and fills skipped because a newer version was cached or invalidated, by namespace.",
+	}, []string{"namespace"})
+	cacheBypasses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_bypassed_requests_total",
+		Help: "Lookups sent straight to the underlying repository while the circuit breaker was open, by namespace.",
+	}, []string{"namespace"})
+	localCacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_local_hits_total",
+		Help: "Lookups answered by the in-process cache tier, by namespace.",
+	}, []string{"namespace"})
+	localCacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_local_misses_total",
+		Help: "Lookups the in-process cache tier passed on to Redis, by namespace.",
+	}, []string{"namespace"})
+	localCacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_local_evictions_total",
+		Help: "Entries evicted from the full in-process cache tier, by namespace of the entry added.",
+	}, []string{"namespace"})
+	localCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_local_entries",
+		Help: "Entries held by the in-process cache tier.",
+	})
+)
+
+// Ways a load is shared between cache misses
+const (
+	coalescedSingleflight = "singleflight"
+	coalescedLock         = "lock"
+)
+
+// Circuit breaker metrics, labelled by the name of the breaker
+var (
+	circuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cache_circuit_breaker_state",
+		Help: "State of the circuit breaker around Redis: 0 closed, 1 half-open, 2 open.",
+	}, []string{"breaker"})
+	circuitBreakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_circuit_breaker_transitions_total",
+		Help: "Changes of state of the circuit breaker around Redis, by the state changed to.",
+	}, []string{"breaker", "state"})
+	circuitBreakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_circuit_breaker_rejections_total",
+		Help: "Redis calls skipped because the circuit breaker was open or busy probing.",
+	}, []string{"breaker"})
+)
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/go-redis/redis/v8"
+)
+
+// userAccessKey holds the read counts of users by ID. It is outside the user
+// namespace so that invalidating the lists leaves it alone.
+const userAccessKey = "users_by_access"
+
+// trackAccessScript counts a read of ARGV[1] in KEYS[1] and, once it holds
+// more than twice ARGV[2] members, drops the least read down to ARGV[2]. The
+// slack lets newly read members build up a count before the next trim.
+var trackAccessScript = redis.NewScript(`
+redis.call('ZINCRBY', KEYS[1], 1, ARGV[1])
+local size = redis.call('ZCARD', KEYS[1])
+local keep = tonumber(ARGV[2])
+if size > 2 * keep then
+	redis.call('ZREMRANGEBYRANK', KEYS[1], 0, size - keep - 1)
+end
+return 1
+`)
+
+// Prime caches entities loaded from the underlying repository at loadedAt,
+// e.g. to warm the cache, and returns how many were cached. Like a fill, an
+// entity is not cached over what was written or invalidated since.
+func (r *CachedRepository[T]) Prime(ctx context.Context, entities []T, loadedAt time.Time) (int, error) {
+	ttl := r.ttl.item()
+	primed := 0
+	for _, entity := range entities {
+		key := r.generateKey(r.id(entity))
+		entry := newCacheEntry(entity, ttl)
+		version, record := loadedAt, false
+		if r.version != nil {
+			version, record = r.version(entity), true
+		}
+
+		stored, err := r.storeIfNewer(ctx, key, entry, version, ttl.hard, record)
+		if err != nil {
+			return primed, err
+		}
+		if stored {
+			holdLocally(ctx, r, key, entry, ttl)
+			primed++
+		}
+	}
+	return primed, nil
+}
+
+// WithAccessTracking counts reads of users by ID so that the most read can be
+// warmed, keeping the counts of about the given number of users
+func (r *RedisRepository) WithAccessTracking(users int) *RedisRepository {
+	r.trackedUsers = users
+	return r
+}
+
+// GetByID retrieves a user by ID, using cache if possible, and counts the read
+// when access tracking is enabled
+func (r *RedisRepository) GetByID(ctx context.Context, id string) (entity.User, error) {
+	user, err := r.CachedRepository.GetByID(ctx, id)
+	if err == nil && r.trackedUsers > 0 {
+		r.trackAccess(ctx, id)
+	}
+	return user, err
+}
+
+// trackAccess counts a read of a user in the background so that reads never wait on it
+func (r *RedisRepository) trackAccess(ctx context.Context, id string) {
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		key := r.scopedKey(ctx, userAccessKey)
+		err := r.executeWithTimeout(ctx, func(ctx context.Context) error {
+			return trackAccessScript.Run(ctx, r.client, []string{key}, id, r.trackedUsers).Err()
+		})
+		if err != nil {
+			log.Printf("Failed to track access of user %s: %v", id, err)
+		}
+	}()
+}
+
+// FrequentUserIDs returns up to limit IDs of the most read users, most read first
+func (r *RedisRepository) FrequentUserIDs(ctx context.Context, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	key := r.scopedKey(ctx, userAccessKey)
+	var ids []string
+	err := r.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		ids, err = r.client.ZRevRange(ctx, key, 0, int64(limit-1)).Result()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get frequently read users: %w", err)
+	}
+	return ids, nil
+}
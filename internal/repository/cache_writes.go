@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// CacheWriteStrategy is how writes through a CachedRepository update the cache
+type CacheWriteStrategy string
+
+const (
+	// CacheWriteInvalidate drops the written entity and the list pages, so that
+	// the next read loads them
+	CacheWriteInvalidate CacheWriteStrategy = "invalidate"
+	// CacheWriteAround writes around the cache; it drops entries like
+	// CacheWriteInvalidate and is accepted under its usual name
+	CacheWriteAround CacheWriteStrategy = "write-around"
+	// CacheWriteThrough caches the written entity right away and drops the list pages
+	CacheWriteThrough CacheWriteStrategy = "write-through"
+)
+
+// ParseCacheWriteStrategy validates a cache write strategy name
+func ParseCacheWriteStrategy(strategy string) (CacheWriteStrategy, error) {
+	switch s := CacheWriteStrategy(strategy); s {
+	case CacheWriteInvalidate, CacheWriteAround, CacheWriteThrough:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown cache write strategy %q", strategy)
+	}
+}
+
+const (
+	// versionKeyPrefix prefixes the keys holding the newest version written or
+	// invalidated for a cache key
+	versionKeyPrefix = "version:"
+
+	// writtenKeyPrefix prefixes the keys holding when an entity was last
+	// written, which is later than its version when that is stamped before the write
+	writtenKeyPrefix = "written:"
+
+	// minVersionTTL is how long a version is kept at least, so that it outlives
+	// the fills and writes racing with it
+	minVersionTTL = time.Minute
+)
+
+// storeIfNewerScript stores ARGV[1] under KEYS[1] for ARGV[3] milliseconds
+// unless KEYS[2], or KEYS[3] if given, holds a version newer than ARGV[2], and
+// records ARGV[2] under KEYS[2] for ARGV[4] milliseconds unless that is zero.
+// It returns whether the value was stored.
+var storeIfNewerScript = redis.NewScript(`
+for i = 2, #KEYS do
+	local current = tonumber(redis.call('GET', KEYS[i]))
+	if current and current > tonumber(ARGV[2]) then
+		return 0
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+if tonumber(ARGV[4]) > 0 then
+	redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[4])
+end
+return 1
+`)
+
+// tombstoneScript records the version ARGV[1] under KEYS[1] for ARGV[2]
+// milliseconds, unless KEYS[1] holds a newer version, and deletes KEYS[2] if given
+var tombstoneScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]))
+if not current or current < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+end
+if KEYS[2] then
+	redis.call('DEL', KEYS[2])
+end
+return 1
+`)
+
+// WithWriteStrategy sets how writes update the cache. Write-through needs
+// entities with a version and otherwise invalidates.
+func (r *CachedRepository[T]) WithWriteStrategy(strategy CacheWriteStrategy) *CachedRepository[T] {
+	r.writes = strategy
+	return r
+}
+
+// versionKey returns the key holding the version of a cache key. List pages
+// share the version of the lists.
+func (r *CachedRepository[T]) versionKey(key string) string {
+	if strings.HasPrefix(key, r.listKeyPrefix()) {
+		return versionKeyPrefix + r.listKeyPrefix()
+	}
+	return versionKeyPrefix + key
+}
+
+// versionTTL returns how long the version of a kind of entry is kept
+func versionTTL(ttl entryTTL) time.Duration {
+	if ttl.hard > minVersionTTL {
+		return ttl.hard
+	}
+	return minVersionTTL
+}
+
+// entityVersion returns the version of a written entity, or now if entities have none
+func (r *CachedRepository[T]) entityVersion(entity T) time.Time {
+	if r.version == nil {
+		return time.Now()
+	}
+	return r.version(entity)
+}
+
+// cacheWritten updates the cache after an entity was written
+func (r *CachedRepository[T]) cacheWritten(ctx context.Context, entity T) error {
+	id := r.id(entity)
+	if r.writes != CacheWriteThrough || r.version == nil {
+		return r.invalidateCache(ctx, id, r.entityVersion(entity))
+	}
+
+	key := r.generateKey(id)
+	ttl := r.ttl.item()
+	return r.invalidate(ctx, func(ctx context.Context) error {
+		if err := r.markWritten(ctx, key); err != nil {
+			return err
+		}
+		_, err := r.storeIfNewer(ctx, key, newCacheEntry(entity, ttl), r.version(entity), ttl.hard, true)
+
+		// List pages cannot be updated in place
+		if err := r.invalidateLists(ctx); err != nil {
+			log.Printf("Failed to invalidate %s lists: %v", r.namespace, err)
+		}
+		r.evictLocal(ctx, []string{key}, []string{r.listKeyPrefix()})
+		return err
+	})
+}
+
+// storeIfNewer caches an entry of the given version for ttl unless a newer
+// version was written or invalidated, and tells whether it did. A recorded
+// version holds back the older versions written after it.
+func (r *CachedRepository[T]) storeIfNewer(ctx context.Context, key string, entry interface{}, version time.Time, ttl time.Duration, record bool) (bool, error) {
+	var recordTTL time.Duration
+	if record {
+		recordTTL = versionTTL(r.ttl.item())
+	}
+	return r.storeCounted(ctx, key, entry, version, ttl, recordTTL, r.versionKey(key))
+}
+
+// storeMissing caches a miss loaded since started for ttl unless the key was
+// written or invalidated since, and tells whether it did. Writes are compared
+// by when they finished, since a miss read before the write landed may have
+// started after the written entity was stamped with its version.
+func (r *CachedRepository[T]) storeMissing(ctx context.Context, key string, entry interface{}, started time.Time, ttl time.Duration) (bool, error) {
+	return r.storeCounted(ctx, key, entry, started, ttl, 0, r.versionKey(key), writtenKeyPrefix+key)
+}
+
+// storeCounted stores a versioned entry and counts the entries held back
+func (r *CachedRepository[T]) storeCounted(ctx context.Context, key string, entry interface{}, version time.Time, ttl, recordTTL time.Duration, versionKeys ...string) (bool, error) {
+	stored, err := r.storeVersioned(ctx, key, entry, version, ttl, recordTTL, versionKeys...)
+	if err == nil && !stored {
+		cacheWriteConflicts.WithLabelValues(r.namespace).Inc()
+	}
+	return stored, err
+}
+
+// markWritten records that an entity was just written, so that misses read
+// before are not cached
+func (r *CachedRepository[T]) markWritten(ctx context.Context, key string) error {
+	if err := r.recordVersion(ctx, writtenKeyPrefix+key, time.Now(), versionTTL(r.ttl.item())); err != nil {
+		return fmt.Errorf("failed to record write of %s: %w", key, err)
+	}
+	return nil
+}
+
+// tombstone deletes a cache key and records the version it was invalidated at,
+// so that values older than that are not cached again
+func (r *CachedRepository[T]) tombstone(ctx context.Context, key string, version time.Time, ttl entryTTL) error {
+	keys := []string{r.scopedKey(ctx, r.versionKey(key)), r.scopedKey(ctx, key)}
+	err := r.executeWithTimeout(ctx, func(ctx context.Context) error {
+		return tombstoneScript.Run(ctx, r.client, keys, version.UnixMicro(), versionTTL(ttl).Milliseconds()).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invalidate %s: %w", key, err)
+	}
+	return nil
+}
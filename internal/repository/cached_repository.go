@@ -0,0 +1,272 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	listKeySegment = ":list:"
+	pageKeyFormat  = "page_%d:size_%d"
+)
+
+// TTLPolicy defines how long each kind of cache entry is kept. An entry older
+// than its soft TTL is still served but refreshed in the background, while one
+// older than its hard TTL is gone and loaded before it is served.
+type TTLPolicy struct {
+	// Item is the hard TTL of single entities
+	Item time.Duration
+	// ItemSoft is the soft TTL of single entities; zero refreshes them only once gone
+	ItemSoft time.Duration
+	// List is the hard TTL of list pages
+	List time.Duration
+	// ListSoft is the soft TTL of list pages; zero refreshes them only once gone
+	ListSoft time.Duration
+	// Missing is how long an ID found missing is remembered; zero does not cache misses
+	Missing time.Duration
+}
+
+// FixedTTL returns a policy using the same TTL for every entry
+func FixedTTL(ttl time.Duration) TTLPolicy {
+	return TTLPolicy{Item: ttl, List: ttl}
+}
+
+// item returns the TTLs of single entities
+func (p TTLPolicy) item() entryTTL {
+	return entryTTL{soft: p.ItemSoft, hard: p.Item, missing: p.Missing}
+}
+
+// list returns the TTLs of list pages
+func (p TTLPolicy) list() entryTTL {
+	return entryTTL{soft: p.ListSoft, hard: p.List}
+}
+
+// CacheOptions configures a CachedRepository
+type CacheOptions[T any] struct {
+	// Namespace prefixes every cache key, e.g. "users"
+	Namespace string
+	// ID extracts the identifier of an entity
+	ID func(entity T) string
+	// Codec encodes cached values; defaults to JSONCodec
+	Codec Codec
+	// TTL defines how long entries are cached
+	TTL TTLPolicy
+	// Version returns when an entity was last written, e.g. its updated_at;
+	// entities without one are never written through
+	Version func(entity T) time.Time
+}
+
+// CachedRepository implements a Redis caching layer over any BaseRepository
+type CachedRepository[T any] struct {
+	BaseRepositoryImpl[T]
+	redisCache
+	repository BaseRepository[T]
+	namespace  string
+	id         func(T) string
+	ttl        TTLPolicy
+	version    func(T) time.Time
+	writes     CacheWriteStrategy
+	// loads coalesces concurrent misses of a key into one load
+	loads singleflight.Group
+	// lock is the lock taken in Redis before loading, if any
+	lock *CacheLock
+	// local is the in-process tier in front of Redis, if any
+	local *LocalCache
+}
+
+// NewCachedRepository creates a new Redis cache decorator
+func NewCachedRepository[T any](client *redis.Client, repository BaseRepository[T], opts CacheOptions[T]) *CachedRepository[T] {
+	return &CachedRepository[T]{
+		redisCache: newRedisCache(client, opts.Codec),
+		repository: repository,
+		namespace:  opts.Namespace,
+		id:         opts.ID,
+		ttl:        opts.TTL,
+		version:    opts.Version,
+		writes:     CacheWriteInvalidate,
+	}
+}
+
+// WithTTL replaces the TTL policy of the cache
+func (r *CachedRepository[T]) WithTTL(ttl TTLPolicy) *CachedRepository[T] {
+	r.ttl = ttl
+	return r
+}
+
+// WithLocalCache puts an in-process tier in front of Redis
+func (r *CachedRepository[T]) WithLocalCache(local *LocalCache) *CachedRepository[T] {
+	r.local = local
+	return r
+}
+
+// generateKey creates cache keys for different types of data
+func (r *CachedRepository[T]) generateKey(id string) string {
+	return r.namespace + ":" + id
+}
+
+func (r *CachedRepository[T]) generateListKey(params PaginationParams) string {
+	return r.listKeyPrefix() + fmt.Sprintf(pageKeyFormat, params.Page, params.PageSize)
+}
+
+func (r *CachedRepository[T]) listKeyPrefix() string {
+	return r.namespace + listKeySegment
+}
+
+// cached returns the cached entity with an ID, fresh or stale
+func (r *CachedRepository[T]) cached(ctx context.Context, id string) (T, error) {
+	key := r.generateKey(id)
+	var entry cacheEntry[T]
+	if err := r.cacheGet(ctx, key, &entry); err != nil {
+		return entry.Value, err
+	}
+	return entry.result(key)
+}
+
+// invalidateCache removes the entity and every cached list page, leaving
+// tombstones so that fills of what was read before the write are not cached.
+// The entity is invalidated at the version written, or deleted at.
+func (r *CachedRepository[T]) invalidateCache(ctx context.Context, id string, version time.Time) error {
+	key := r.generateKey(id)
+	return r.invalidate(ctx, func(ctx context.Context) error {
+		// Redis goes first so that the local tier is not refilled from it
+		err := errors.Join(r.markWritten(ctx, key), r.tombstone(ctx, key, version, r.ttl.item()), r.invalidateLists(ctx))
+		r.evictLocal(ctx, []string{key}, []string{r.listKeyPrefix()})
+		return err
+	})
+}
+
+// invalidateLists removes every cached list page, leaving a tombstone first so
+// that no page loaded before is cached again
+func (r *CachedRepository[T]) invalidateLists(ctx context.Context) error {
+	if err := r.tombstone(ctx, r.listKeyPrefix(), time.Now(), r.ttl.list()); err != nil {
+		return err
+	}
+	return r.deleteKeys(ctx, nil, r.listKeyPrefix())
+}
+
+// evictLocal drops keys and prefixed keys from the local tier of every instance
+func (r *CachedRepository[T]) evictLocal(ctx context.Context, keys, prefixes []string) {
+	if r.local == nil {
+		return
+	}
+
+	var invalidation cacheInvalidation
+	for _, key := range keys {
+		invalidation.Keys = append(invalidation.Keys, r.scopedKey(ctx, key))
+	}
+	for _, prefix := range prefixes {
+		invalidation.Prefixes = append(invalidation.Prefixes, r.scopedKey(ctx, prefix))
+	}
+	r.local.invalidate(ctx, invalidation)
+}
+
+// GetAll retrieves all entities with pagination, using cache if possible
+func (r *CachedRepository[T]) GetAll(ctx context.Context, params PaginationParams) ([]T, error) {
+	r.ValidatePagination(&params)
+	cacheKey := r.generateListKey(params)
+
+	return getShared(ctx, r, cacheKey, r.ttl.list(), func(ctx context.Context) ([]T, error) {
+		entities, err := r.repository.GetAll(ctx, params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s from repository: %w", r.namespace, err)
+		}
+		return entities, nil
+	})
+}
+
+// GetByID retrieves an entity by ID, using cache if possible
+func (r *CachedRepository[T]) GetByID(ctx context.Context, id string) (T, error) {
+	var entity T
+	if err := r.ValidateID(id); err != nil {
+		return entity, err
+	}
+
+	return getShared(ctx, r, r.generateKey(id), r.ttl.item(), func(ctx context.Context) (T, error) {
+		entity, err := r.repository.GetByID(ctx, id)
+		if err != nil {
+			return entity, fmt.Errorf("failed to get %s from repository: %w", r.namespace, err)
+		}
+		return entity, nil
+	})
+}
+
+// Create creates an entity and updates the cache per the write strategy,
+// replacing a cached miss of its ID
+func (r *CachedRepository[T]) Create(ctx context.Context, entity T) error {
+	if err := r.repository.Create(ctx, entity); err != nil {
+		return fmt.Errorf("failed to create %s in repository: %w", r.namespace, err)
+	}
+
+	if err := r.cacheWritten(ctx, entity); err != nil {
+		log.Printf("Failed to update cache after create: %v", err)
+	}
+
+	return nil
+}
+
+// Update updates an entity and updates the cache per the write strategy
+func (r *CachedRepository[T]) Update(ctx context.Context, entity T) error {
+	id := r.id(entity)
+	if err := r.ValidateID(id); err != nil {
+		return err
+	}
+
+	if err := r.repository.Update(ctx, entity); err != nil {
+		return fmt.Errorf("failed to update %s in repository: %w", r.namespace, err)
+	}
+
+	if err := r.cacheWritten(ctx, entity); err != nil {
+		log.Printf("Failed to update cache after update: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateColumns updates some columns of an entity and invalidates the cache,
+// updating the whole entity when the underlying repository cannot do less.
+// The other fields of the entity may be unset, so it is never written through.
+func (r *CachedRepository[T]) UpdateColumns(ctx context.Context, entity T, columns []string) error {
+	id := r.id(entity)
+	if err := r.ValidateID(id); err != nil {
+		return err
+	}
+
+	var err error
+	if updater, ok := r.repository.(PartialUpdater[T]); ok {
+		err = updater.UpdateColumns(ctx, entity, columns)
+	} else {
+		err = r.repository.Update(ctx, entity)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s in repository: %w", r.namespace, err)
+	}
+
+	if err := r.invalidateCache(ctx, id, r.entityVersion(entity)); err != nil {
+		log.Printf("Failed to invalidate cache after update: %v", err)
+	}
+
+	return nil
+}
+
+// Delete removes an entity and invalidates the cache
+func (r *CachedRepository[T]) Delete(ctx context.Context, id string) error {
+	if err := r.ValidateID(id); err != nil {
+		return err
+	}
+
+	if err := r.repository.Delete(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete %s from repository: %w", r.namespace, err)
+	}
+
+	if err := r.invalidateCache(ctx, id, time.Now()); err != nil {
+		log.Printf("Failed to invalidate cache after delete: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// userChangesKey names both the Redis Stream keeping recent changes and the
+	// Pub/Sub channel announcing them
+	userChangesKey = "user_changes"
+
+	// changeReplayBatch is how many stream entries are read per replay request
+	changeReplayBatch = 100
+
+	// publishedEventKeyPrefix prefixes the stream entry ID of each published outbox event
+	publishedEventKeyPrefix = "user_changes_published:"
+
+	// publishedEventTTL is how long a published outbox event is remembered
+	publishedEventTTL = 7 * 24 * time.Hour
+)
+
+// ErrInvalidEventID is returned when resuming from a malformed event ID
+var ErrInvalidEventID = errors.New("invalid event id")
+
+// publishChangeScript appends a change to the stream and announces it with its
+// entry ID in one step, so that subscribers never see an event before it can be
+// replayed. With a second key it publishes each outbox event only once,
+// returning the entry ID of the first publication on repeats.
+var publishChangeScript = redis.NewScript(`
+if KEYS[2] then
+	local existing = redis.call('GET', KEYS[2])
+	if existing then
+		return existing
+	end
+end
+local id = redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], '*', 'change', ARGV[2])
+redis.call('PUBLISH', ARGV[3], id .. ' ' .. ARGV[2])
+if KEYS[2] then
+	redis.call('SET', KEYS[2], id, 'PX', ARGV[4])
+end
+return id
+`)
+
+// ChangeFeed publishes user changes and fans them out to subscribers
+type ChangeFeed interface {
+	// Publish records a change and returns its event ID; a change published
+	// from an outbox event is only recorded once
+	Publish(ctx context.Context, change entity.UserChange) (string, error)
+	// Subscribe streams the changes published after lastEventID, or only new
+	// changes when it is empty, until ctx is done
+	Subscribe(ctx context.Context, lastEventID string) (<-chan entity.UserChange, error)
+}
+
+// RedisChangeFeed implements ChangeFeed with a Pub/Sub channel for live events
+// and a capped Redis Stream to resume from
+type RedisChangeFeed struct {
+	redisCache
+	maxLen int64
+}
+
+// NewRedisChangeFeed creates a change feed keeping about maxLen recent changes
+func NewRedisChangeFeed(client *redis.Client, maxLen int64) *RedisChangeFeed {
+	return &RedisChangeFeed{
+		redisCache: newRedisCache(client, nil),
+		maxLen:     maxLen,
+	}
+}
+
+// Publish records a change in the stream and announces it on the channel
+func (f *RedisChangeFeed) Publish(ctx context.Context, change entity.UserChange) (string, error) {
+	change.ID, change.User = "", nil
+	data, err := f.codec.Marshal(change)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal change: %w", err)
+	}
+
+	key := f.scopedKey(ctx, userChangesKey)
+	keys := []string{key}
+	if change.EventID != "" {
+		keys = append(keys, f.scopedKey(ctx, publishedEventKeyPrefix+change.EventID))
+	}
+	var id string
+	err = f.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = publishChangeScript.Run(ctx, f.client, keys, f.maxLen, data, key, publishedEventTTL.Milliseconds()).Text()
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to publish change: %w", err)
+	}
+	return id, nil
+}
+
+// Subscribe streams changes: first the ones recorded after lastEventID, then
+// live ones, skipping any that were already replayed. The channel is closed
+// when ctx is done or the subscription fails.
+func (f *RedisChangeFeed) Subscribe(ctx context.Context, lastEventID string) (<-chan entity.UserChange, error) {
+	if lastEventID != "" {
+		if _, _, err := parseEventID(lastEventID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Subscribe before replaying so that no change falls between the two
+	key := f.scopedKey(ctx, userChangesKey)
+	pubsub := f.client.Subscribe(ctx, key)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to changes: %w", err)
+	}
+
+	out := make(chan entity.UserChange)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+
+		last := lastEventID
+		send := func(change entity.UserChange) bool {
+			if last != "" && compareEventIDs(change.ID, last) <= 0 {
+				return true
+			}
+			select {
+			case out <- change:
+				last = change.ID
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if last != "" {
+			for start := "(" + last; ; {
+				messages, err := f.client.XRangeN(ctx, key, start, "+", changeReplayBatch).Result()
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Failed to replay changes: %v", err)
+					}
+					return
+				}
+				for _, message := range messages {
+					change, err := f.decodeChange(message.ID, message.Values["change"])
+					if err != nil {
+						log.Printf("Failed to decode change %s: %v", message.ID, err)
+						continue
+					}
+					if !send(change) {
+						return
+					}
+				}
+				if len(messages) < changeReplayBatch {
+					break
+				}
+				start = "(" + messages[len(messages)-1].ID
+			}
+		}
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				id, payload, _ := strings.Cut(message.Payload, " ")
+				change, err := f.decodeChange(id, payload)
+				if err != nil {
+					log.Printf("Failed to decode change %s: %v", id, err)
+					continue
+				}
+				if !send(change) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// decodeChange decodes a published change and sets its event ID
+func (f *RedisChangeFeed) decodeChange(id string, payload interface{}) (entity.UserChange, error) {
+	data, ok := payload.(string)
+	if !ok {
+		return entity.UserChange{}, fmt.Errorf("unexpected payload %T", payload)
+	}
+	var change entity.UserChange
+	if err := f.codec.Unmarshal([]byte(data), &change); err != nil {
+		return change, err
+	}
+	change.ID = id
+	return change, nil
+}
+
+// parseEventID splits a stream entry ID of the form <milliseconds>-<sequence>
+func parseEventID(id string) (uint64, uint64, error) {
+	msPart, seqPart, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidEventID, id)
+	}
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidEventID, id)
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %q", ErrInvalidEventID, id)
+	}
+	return ms, seq, nil
+}
+
+// compareEventIDs orders two stream entry IDs; malformed IDs sort first
+func compareEventIDs(a, b string) int {
+	aMs, aSeq, _ := parseEventID(a)
+	bMs, bSeq, _ := parseEventID(b)
+	if c := cmp.Compare(aMs, bMs); c != 0 {
+		return c
+	}
+	return cmp.Compare(aSeq, bSeq)
+}
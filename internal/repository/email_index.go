@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// emailKeyPrefix prefixes the email→id index keys; it is kept apart from the
+	// user cache namespace so that no user ID can collide with an index key
+	emailKeyPrefix = "user_emails:"
+
+	// pendingEmailTTL bounds how long a reservation outlives a write that
+	// neither confirmed nor released it
+	pendingEmailTTL = time.Minute
+)
+
+// reserveScript stores ARGV[1] under KEYS[1] for ARGV[2] milliseconds unless
+// the key is taken and returns the ID now holding the key and 1 while the
+// reservation is still pending
+var reserveScript = redis.NewScript(`
+local holder = redis.call('GET', KEYS[1])
+if holder then
+	local pending = 0
+	if redis.call('PTTL', KEYS[1]) > 0 then
+		pending = 1
+	end
+	return {holder, pending}
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return {ARGV[1], 1}
+`)
+
+// takeOverScript replaces KEYS[1] with a pending reservation of ARGV[2] for
+// ARGV[3] milliseconds only while it still holds ARGV[1]
+var takeOverScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// confirmScript keeps KEYS[1] for good while it holds ARGV[1]
+var confirmScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('PERSIST', KEYS[1])
+`)
+
+// swapScript replaces KEYS[1] with ARGV[2], or deletes it when ARGV[2] is
+// empty, only while it still holds ARGV[1]
+var swapScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == '' then
+	redis.call('DEL', KEYS[1])
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// EmailIndex guarantees that an email address belongs to at most one user
+type EmailIndex interface {
+	// Reserve claims the email for the user or returns ErrConflict if another user holds it
+	Reserve(ctx context.Context, email, userID string) error
+	// Confirm keeps the reservation of the user once the write storing the email succeeded
+	Confirm(ctx context.Context, email, userID string) error
+	// Release frees the email if the user still holds it
+	Release(ctx context.Context, email, userID string) error
+}
+
+// EmailOwnerFinder looks up the users stored with an email in the primary store
+type EmailOwnerFinder interface {
+	FindIDsByEmail(ctx context.Context, email string) ([]string, error)
+}
+
+// RedisEmailIndex implements EmailIndex with an email→id index in Redis.
+// Reservations are made atomically in Redis and then checked against the
+// primary store, which catches users written before the index existed. They
+// expire unless confirmed, and a confirmed reservation whose holder no longer
+// stores the email is taken over, so a failed write never locks an email.
+type RedisEmailIndex struct {
+	redisCache
+	owners EmailOwnerFinder
+}
+
+// NewRedisEmailIndex creates a new Redis email index
+func NewRedisEmailIndex(client *redis.Client, owners EmailOwnerFinder) *RedisEmailIndex {
+	return &RedisEmailIndex{
+		redisCache: newRedisCache(client, nil),
+		owners:     owners,
+	}
+}
+
+// emailKey returns the index key of an email for the tenant in the context
+func (i *RedisEmailIndex) emailKey(ctx context.Context, email string) string {
+	return i.scopedKey(ctx, emailKeyPrefix+email)
+}
+
+// Reserve claims the email for the user or returns ErrConflict if another user holds it
+func (i *RedisEmailIndex) Reserve(ctx context.Context, email, userID string) error {
+	key := i.emailKey(ctx, email)
+
+	var reservation []interface{}
+	err := i.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		reservation, err = reserveScript.Run(ctx, i.client, []string{key}, userID, pendingEmailTTL.Milliseconds()).Slice()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reserve email: %w", err)
+	}
+	holder, _ := reservation[0].(string)
+	pending, _ := reservation[1].(int64)
+
+	if holder != userID {
+		// A pending reservation belongs to a write in flight and expires by itself
+		if pending == 1 {
+			return fmt.Errorf("email %s is used by another user: %w", email, ErrConflict)
+		}
+		return i.takeOver(ctx, key, email, holder, userID)
+	}
+
+	ids, err := i.owners.FindIDsByEmail(ctx, email)
+	if err != nil {
+		i.swap(ctx, key, userID, "")
+		return fmt.Errorf("failed to check email in repository: %w", err)
+	}
+	return i.checkOwners(ctx, key, email, userID, ids)
+}
+
+// takeOver claims a confirmed reservation whose holder does not store the
+// email in the primary store, as left behind when releasing it failed
+func (i *RedisEmailIndex) takeOver(ctx context.Context, key, email, holder, userID string) error {
+	ids, err := i.owners.FindIDsByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("failed to check email in repository: %w", err)
+	}
+	if slices.Contains(ids, holder) {
+		return fmt.Errorf("email %s is used by another user: %w", email, ErrConflict)
+	}
+
+	var taken bool
+	err = i.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		taken, err = takeOverScript.Run(ctx, i.client, []string{key}, holder, userID, pendingEmailTTL.Milliseconds()).Bool()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reserve email: %w", err)
+	}
+	if !taken {
+		// Another user claimed the email meanwhile
+		return fmt.Errorf("email %s is used by another user: %w", email, ErrConflict)
+	}
+	return i.checkOwners(ctx, key, email, userID, ids)
+}
+
+// checkOwners keeps the reservation of the user only if no other user stores
+// the email in the primary store
+func (i *RedisEmailIndex) checkOwners(ctx context.Context, key, email, userID string, ids []string) error {
+	for _, id := range ids {
+		if id != userID {
+			// Point the index at the existing owner so later reservations fail fast
+			i.swap(ctx, key, userID, id)
+			return fmt.Errorf("email %s is used by another user: %w", email, ErrConflict)
+		}
+	}
+	return nil
+}
+
+// Confirm keeps the reservation of the user once the write storing the email succeeded
+func (i *RedisEmailIndex) Confirm(ctx context.Context, email, userID string) error {
+	key := i.emailKey(ctx, email)
+	return i.executeWithTimeout(ctx, func(ctx context.Context) error {
+		return confirmScript.Run(ctx, i.client, []string{key}, userID).Err()
+	})
+}
+
+// Release frees the email if the user still holds it
+func (i *RedisEmailIndex) Release(ctx context.Context, email, userID string) error {
+	key := i.emailKey(ctx, email)
+	return i.executeWithTimeout(ctx, func(ctx context.Context) error {
+		return swapScript.Run(ctx, i.client, []string{key}, userID, "").Err()
+	})
+}
+
+// swap replaces the holder of a reservation, logging failures. It runs even
+// if the request was canceled so that no stale reservation is left behind.
+func (i *RedisEmailIndex) swap(ctx context.Context, key, from, to string) {
+	err := i.executeWithTimeout(context.WithoutCancel(ctx), func(ctx context.Context) error {
+		return swapScript.Run(ctx, i.client, []string{key}, from, to).Err()
+	})
+	if err != nil {
+		log.Printf("Failed to update email reservation %s: %v", key, err)
+	}
+}
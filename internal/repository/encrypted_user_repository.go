@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/encryption"
+)
+
+// userFields maps the names of encryptable user fields to the fields
+var userFields = map[string]func(*entity.User) *string{
+	"name":  func(u *entity.User) *string { return &u.Name },
+	"email": func(u *entity.User) *string { return &u.Email },
+}
+
+// EmailKeyer is implemented by repositories that look emails up by a derived value
+type EmailKeyer interface {
+	// EmailKey returns the value stored for equality lookups of an email
+	EmailKey(ctx context.Context, email string) (string, error)
+}
+
+// EncryptedUserRepository encrypts the configured user fields before they reach
+// another UserRepository and decrypts them on the way back. Encrypted emails are
+// looked up through the deterministic email_hmac column.
+type EncryptedUserRepository struct {
+	repository UserRepository
+	envelope   *encryption.Envelope
+	fields     []func(*entity.User) *string
+	email      bool
+}
+
+// NewEncryptedUserRepository creates a new encrypting decorator for the named fields
+func NewEncryptedUserRepository(repository UserRepository, envelope *encryption.Envelope, fields []string) (*EncryptedUserRepository, error) {
+	r := &EncryptedUserRepository{repository: repository, envelope: envelope}
+	for _, name := range fields {
+		field, ok := userFields[name]
+		if !ok {
+			return nil, fmt.Errorf("user has no encryptable field %q", name)
+		}
+		r.fields = append(r.fields, field)
+		r.email = r.email || name == "email"
+	}
+	return r, nil
+}
+
+// encrypt returns a copy of the user with the configured fields encrypted
+func (r *EncryptedUserRepository) encrypt(ctx context.Context, user entity.User) (entity.User, error) {
+	var err error
+	if r.email {
+		if user.EmailHMAC, err = r.EmailKey(ctx, user.Email); err != nil {
+			return user, err
+		}
+	}
+	for _, field := range r.fields {
+		value := field(&user)
+		if *value, err = r.envelope.Encrypt(ctx, *value); err != nil {
+			return user, fmt.Errorf("failed to encrypt user: %w", err)
+		}
+	}
+	return user, nil
+}
+
+// decrypt returns a copy of the user with the configured fields decrypted
+func (r *EncryptedUserRepository) decrypt(ctx context.Context, user entity.User) (entity.User, error) {
+	var err error
+	for _, field := range r.fields {
+		value := field(&user)
+		if *value, err = r.envelope.Decrypt(ctx, *value); err != nil {
+			return user, fmt.Errorf("failed to decrypt user %s: %w", user.ID, err)
+		}
+	}
+	user.EmailHMAC = ""
+	return user, nil
+}
+
+// EmailKey returns the HMAC of an encrypted email, or the email itself
+func (r *EncryptedUserRepository) EmailKey(ctx context.Context, email string) (string, error) {
+	if !r.email {
+		return email, nil
+	}
+	key, err := r.envelope.HMAC(ctx, email)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive email key: %w", err)
+	}
+	return key, nil
+}
+
+// GetAll retrieves and decrypts a page of users
+func (r *EncryptedUserRepository) GetAll(ctx context.Context, params PaginationParams) ([]entity.User, error) {
+	users, err := r.repository.GetAll(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		if users[i], err = r.decrypt(ctx, users[i]); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// GetByID retrieves and decrypts a user
+func (r *EncryptedUserRepository) GetByID(ctx context.Context, id string) (entity.User, error) {
+	user, err := r.repository.GetByID(ctx, id)
+	if err != nil {
+		return user, err
+	}
+	return r.decrypt(ctx, user)
+}
+
+// GetByEmail retrieves and decrypts a user by the lookup value of the email
+func (r *EncryptedUserRepository) GetByEmail(ctx context.Context, email string) (entity.User, error) {
+	key, err := r.EmailKey(ctx, email)
+	if err != nil {
+		return entity.User{}, err
+	}
+	user, err := r.repository.GetByEmail(ctx, key)
+	if err != nil {
+		return user, err
+	}
+	return r.decrypt(ctx, user)
+}
+
+// Create encrypts and stores a user
+func (r *EncryptedUserRepository) Create(ctx context.Context, user entity.User) error {
+	encrypted, err := r.encrypt(ctx, user)
+	if err != nil {
+		return err
+	}
+	return r.repository.Create(ctx, encrypted)
+}
+
+// Update encrypts and stores a user
+func (r *EncryptedUserRepository) Update(ctx context.Context, user entity.User) error {
+	encrypted, err := r.encrypt(ctx, user)
+	if err != nil {
+		return err
+	}
+	return r.repository.Update(ctx, encrypted)
+}
+
+// UpdateColumns encrypts a user and stores the named columns, along with the
+// email lookup column when the email changes
+func (r *EncryptedUserRepository) UpdateColumns(ctx context.Context, user entity.User, columns []string) error {
+	encrypted, err := r.encrypt(ctx, user)
+	if err != nil {
+		return err
+	}
+	updater, ok := r.repository.(PartialUpdater[entity.User])
+	if !ok {
+		return r.repository.Update(ctx, encrypted)
+	}
+	if r.email && slices.Contains(columns, "email") {
+		columns = append(slices.Clone(columns), "email_hmac")
+	}
+	return updater.UpdateColumns(ctx, encrypted, columns)
+}
+
+// Delete removes a user
+func (r *EncryptedUserRepository) Delete(ctx context.Context, id string) error {
+	return r.repository.Delete(ctx, id)
+}
+
+// PurgeUser purges the cached copies of a decrypted user from the underlying repository
+func (r *EncryptedUserRepository) PurgeUser(ctx context.Context, user entity.User) (int64, error) {
+	purger, ok := r.repository.(UserPurger)
+	if !ok {
+		return 0, errors.New("underlying repository cannot purge users")
+	}
+	var err error
+	if r.email {
+		if user.EmailHMAC, err = r.EmailKey(ctx, user.Email); err != nil {
+			return 0, err
+		}
+	}
+	return purger.PurgeUser(ctx, user)
+}
+
+// Reencrypt returns a stored user with every configured field encrypted under
+// the primary key, and whether anything changed. Plaintext fields written
+// before encryption was enabled are encrypted as well.
+func (r *EncryptedUserRepository) Reencrypt(ctx context.Context, stored entity.User) (entity.User, bool, error) {
+	rotate := false
+	for _, field := range r.fields {
+		needed, err := r.envelope.NeedsRotation(ctx, *field(&stored))
+		if err != nil {
+			return stored, false, err
+		}
+		rotate = rotate || needed
+	}
+	if r.email && stored.EmailHMAC == "" {
+		rotate = true
+	}
+	if !rotate {
+		return stored, false, nil
+	}
+
+	user, err := r.decrypt(ctx, stored)
+	if err != nil {
+		return stored, false, err
+	}
+	user, err = r.encrypt(ctx, user)
+	if err != nil {
+		return stored, false, err
+	}
+	return user, true, nil
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+)
+
+// bigQueryFailSafePeriod is how long BigQuery keeps data after the time travel window
+const bigQueryFailSafePeriod = 7 * 24 * time.Hour
+
+// ErasureRepository stores the tombstones of erased users. It is append-only:
+// an erasure writes a pending tombstone before the user is deleted and a
+// completed one with the same ID once it finishes. BigQuery cannot update rows
+// still in its streaming buffer, so tombstones are never updated nor removed.
+type ErasureRepository interface {
+	Create(ctx context.Context, tombstone entity.ErasureTombstone) error
+	// GetByID retrieves the tombstone of an erasure, the completed one once written
+	GetByID(ctx context.Context, id string) (entity.ErasureTombstone, error)
+	// FindByUser retrieves the tombstones of a user's erasures, the completed
+	// one of each once written, the most recently completed first
+	FindByUser(ctx context.Context, userID string) ([]entity.ErasureTombstone, error)
+}
+
+// UserPurger removes every cached copy of a user's personal data
+type UserPurger interface {
+	// PurgeUser deletes the user's cache keys and returns how many existed
+	PurgeUser(ctx context.Context, user entity.User) (int64, error)
+}
+
+// BigQueryErasureRepository implements ErasureRepository using BigQuery
+type BigQueryErasureRepository struct {
+	*GenericBigQueryRepository[entity.ErasureTombstone]
+}
+
+// ErasureTableDescriptor describes the BigQuery audit table holding erasure tombstones
+func ErasureTableDescriptor(projectID, dataset, table string) TableDescriptor {
+	return TableDescriptor{
+		Name:       "erasure",
+		ProjectID:  projectID,
+		Dataset:    dataset,
+		Table:      table,
+		PrimaryKey: "id",
+		OrderBy:    "completed_at",
+		Immutable:  []string{"completed_at"},
+	}
+}
+
+// NewBigQueryErasureRepository creates a new BigQuery erasure repository
+func NewBigQueryErasureRepository(client *bigquery.Client, projectID, dataset, table string, tenancy Tenancy) *BigQueryErasureRepository {
+	descriptor := ErasureTableDescriptor(projectID, dataset, table)
+	descriptor.Tenancy = tenancy
+	repo, err := NewGenericBigQueryRepository[entity.ErasureTombstone](client, descriptor)
+	if err != nil {
+		// The tombstone mapping is static, so this only fails on a programming error
+		panic(err)
+	}
+	return &BigQueryErasureRepository{GenericBigQueryRepository: repo}
+}
+
+// GetByID retrieves the tombstone of an erasure, the completed one once written
+func (r *BigQueryErasureRepository) GetByID(ctx context.Context, id string) (entity.ErasureTombstone, error) {
+	if err := r.ValidateID(id); err != nil {
+		return entity.ErasureTombstone{}, err
+	}
+
+	rows, err := r.FindBy(ctx, "id", id)
+	if err != nil {
+		return entity.ErasureTombstone{}, err
+	}
+	tombstones := latestTombstones(rows)
+	if len(tombstones) == 0 {
+		return entity.ErasureTombstone{}, fmt.Errorf("erasure %s: %w", id, ErrNotFound)
+	}
+	return tombstones[0], nil
+}
+
+// FindByUser retrieves the tombstones of a user's erasures, the completed one
+// of each once written, the most recently completed first
+func (r *BigQueryErasureRepository) FindByUser(ctx context.Context, userID string) ([]entity.ErasureTombstone, error) {
+	rows, err := r.FindBy(ctx, "user_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return latestTombstones(rows), nil
+}
+
+// latestTombstones keeps one tombstone per erasure, the completed one when it
+// was written, in the order of rows
+func latestTombstones(rows []entity.ErasureTombstone) []entity.ErasureTombstone {
+	index := make(map[string]int, len(rows))
+	tombstones := make([]entity.ErasureTombstone, 0, len(rows))
+	for _, row := range rows {
+		i, ok := index[row.ID]
+		if !ok {
+			index[row.ID] = len(tombstones)
+			tombstones = append(tombstones, row)
+			continue
+		}
+		if tombstones[i].Pending() && !row.Pending() {
+			tombstones[i] = row
+		}
+	}
+	return tombstones
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+)
+
+// GroupRepository extends BaseRepository for Group entities
+type GroupRepository interface {
+	BaseRepository[entity.Group]
+}
+
+// MembershipRepository manages which users belong to which groups
+type MembershipRepository interface {
+	// AddMember adds a user to a group; adding an existing member is a no-op
+	AddMember(ctx context.Context, membership entity.Membership) error
+
+	// RemoveMember removes a user from a group
+	RemoveMember(ctx context.Context, groupID, userID string) error
+
+	// ListByGroup retrieves the memberships of a group with pagination
+	ListByGroup(ctx context.Context, groupID string, params PaginationParams) ([]entity.Membership, error)
+
+	// ListByUser retrieves the memberships of a user with pagination
+	ListByUser(ctx context.Context, userID string, params PaginationParams) ([]entity.Membership, error)
+
+	// DeleteByGroup removes every membership of a group
+	DeleteByGroup(ctx context.Context, groupID string) error
+
+	// DeleteByUser removes every membership of a user
+	DeleteByUser(ctx context.Context, userID string) error
+}
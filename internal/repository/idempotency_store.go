@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// idempotencyKeyPrefix prefixes the keys holding the responses of idempotent requests
+const idempotencyKeyPrefix = "idempotency:"
+
+// reserveKeyScript stores ARGV[1] under KEYS[1] for ARGV[2] milliseconds unless
+// the key is taken, and returns what the key holds otherwise
+var reserveKeyScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return false
+end
+return redis.call('GET', KEYS[1])
+`)
+
+// IdempotentResponse is what is stored for an idempotency key: the fingerprint
+// of the request that reserved it and, once that request completed, its response
+type IdempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	// Status is zero while the request is in progress
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// IdempotencyStore remembers the responses of requests by idempotency key
+type IdempotencyStore interface {
+	// Reserve claims a key for ttl on behalf of the request with the fingerprint
+	// and returns true, or returns what the key holds if it is taken
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (IdempotentResponse, bool, error)
+	// Save stores the response of the request holding a key for ttl
+	Save(ctx context.Context, key string, response IdempotentResponse, ttl time.Duration) error
+	// Release frees a key so that its request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// RedisIdempotencyStore implements IdempotencyStore with a key per idempotency key
+type RedisIdempotencyStore struct {
+	redisCache
+}
+
+// NewRedisIdempotencyStore creates a new Redis idempotency store
+func NewRedisIdempotencyStore(client *redis.Client) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{
+		redisCache: newRedisCache(client, nil),
+	}
+}
+
+// Reserve claims a key or returns what it holds
+func (s *RedisIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (IdempotentResponse, bool, error) {
+	var stored IdempotentResponse
+	data, err := s.codec.Marshal(IdempotentResponse{Fingerprint: fingerprint})
+	if err != nil {
+		return stored, false, fmt.Errorf("failed to marshal idempotency key: %w", err)
+	}
+	redisKey := s.scopedKey(ctx, idempotencyKeyPrefix+key)
+
+	var held string
+	err = s.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		held, err = reserveKeyScript.Run(ctx, s.client, []string{redisKey}, data, ttl.Milliseconds()).Text()
+		return err
+	})
+	if err == redis.Nil {
+		return stored, true, nil
+	}
+	if err != nil {
+		return stored, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	if err := s.codec.Unmarshal([]byte(held), &stored); err != nil {
+		return stored, false, fmt.Errorf("failed to unmarshal idempotency key: %w", err)
+	}
+	return stored, false, nil
+}
+
+// Save stores a response under its key
+func (s *RedisIdempotencyStore) Save(ctx context.Context, key string, response IdempotentResponse, ttl time.Duration) error {
+	data, err := s.codec.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotent response: %w", err)
+	}
+	redisKey := s.scopedKey(ctx, idempotencyKeyPrefix+key)
+
+	err = s.executeWithTimeout(ctx, func(ctx context.Context) error {
+		return s.client.Set(ctx, redisKey, data, ttl).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// Release deletes a key
+func (s *RedisIdempotencyStore) Release(ctx context.Context, key string) error {
+	redisKey := s.scopedKey(ctx, idempotencyKeyPrefix+key)
+	err := s.executeWithTimeout(ctx, func(ctx context.Context) error {
+		return s.client.Del(ctx, redisKey).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
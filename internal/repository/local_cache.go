@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// cacheInvalidationsChannel is the Pub/Sub channel invalidations are broadcast
+// on. It is shared by all tenants since the keys it carries are scoped.
+const cacheInvalidationsChannel = "cache:invalidations"
+
+// cacheInvalidation lists the scoped keys to drop and the prefixes of the scoped keys to drop
+type cacheInvalidation struct {
+	Keys     []string `json:"keys,omitempty"`
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// localEntry is a decoded cache entry held in process
+type localEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// LocalCache is a bounded in-process tier in front of Redis, evicting the least
+// recently used entries. Instances drop their copies when another instance
+// invalidates a key, which they learn over Redis Pub/Sub; an invalidation
+// missed while disconnected is bounded by the TTL of the tier.
+type LocalCache struct {
+	client  *redis.Client
+	ttl     time.Duration
+	entries *lru.Cache[string, localEntry]
+	// mu orders removals by prefix against other writes
+	mu sync.Mutex
+}
+
+// NewLocalCache creates an in-process tier holding up to size entries for at most ttl
+func NewLocalCache(client *redis.Client, size int, ttl time.Duration) (*LocalCache, error) {
+	entries, err := lru.New[string, localEntry](size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create local cache: %w", err)
+	}
+	return &LocalCache{client: client, ttl: ttl, entries: entries}, nil
+}
+
+// Listen subscribes to the invalidations of other instances and applies them
+// in the background until ctx is done
+func (c *LocalCache) Listen(ctx context.Context) error {
+	pubsub := c.client.Subscribe(ctx, cacheInvalidationsChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to cache invalidations: %w", err)
+	}
+
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var invalidation cacheInvalidation
+				if err := json.Unmarshal([]byte(message.Payload), &invalidation); err != nil {
+					log.Printf("Failed to decode cache invalidation: %v", err)
+					continue
+				}
+				c.remove(invalidation)
+			}
+		}
+	}()
+	return nil
+}
+
+// get returns the entry held for a scoped key, if it has not expired
+func (c *LocalCache) get(namespace, key string) (interface{}, bool) {
+	entry, ok := c.entries.Get(key)
+	if !ok || time.Now().After(entry.expires) {
+		localCacheMisses.WithLabelValues(namespace).Inc()
+		return nil, false
+	}
+	localCacheHits.WithLabelValues(namespace).Inc()
+	return entry.value, true
+}
+
+// set holds an entry for a scoped key for the TTL of the tier, or less
+func (c *LocalCache) set(namespace, key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 || ttl > c.ttl {
+		ttl = c.ttl
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries.Add(key, localEntry{value: value, expires: time.Now().Add(ttl)}) {
+		localCacheEvictions.WithLabelValues(namespace).Inc()
+	}
+	localCacheEntries.Set(float64(c.entries.Len()))
+}
+
+// invalidate drops scoped keys and prefixed keys from this instance and
+// broadcasts the invalidation to the others
+func (c *LocalCache) invalidate(ctx context.Context, invalidation cacheInvalidation) {
+	c.remove(invalidation)
+
+	data, err := json.Marshal(invalidation)
+	if err != nil {
+		log.Printf("Failed to encode cache invalidation: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+	if err := c.client.Publish(ctx, cacheInvalidationsChannel, data).Err(); err != nil {
+		log.Printf("Failed to broadcast cache invalidation: %v", err)
+	}
+}
+
+// remove drops scoped keys and prefixed keys from this instance
+func (c *LocalCache) remove(invalidation cacheInvalidation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range invalidation.Keys {
+		c.entries.Remove(key)
+	}
+	if len(invalidation.Prefixes) > 0 {
+		for _, key := range c.entries.Keys() {
+			for _, prefix := range invalidation.Prefixes {
+				if strings.HasPrefix(key, prefix) {
+					c.entries.Remove(key)
+					break
+				}
+			}
+		}
+	}
+	localCacheEntries.Set(float64(c.entries.Len()))
+}
@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+	"github.com/go-redis/redis/v8"
+)
+
+// Outbox keys are shared by all tenants so that one relay serves them all;
+// members are "<tenant>/<event ID>", or the bare event ID without tenancy
+const (
+	// outboxEventsKey is a hash of every recorded event by member
+	outboxEventsKey = "outbox:events"
+	// outboxUnconfirmedKey is a sorted set of events whose change may not be
+	// written yet, scored by when they were recorded in Unix milliseconds
+	outboxUnconfirmedKey = "outbox:unconfirmed"
+	// outboxReadyKey is a sorted set of events to publish, scored by when they occurred
+	outboxReadyKey = "outbox:ready"
+	// outboxLeasesKey is a hash of when the lease of each claimed event ends
+	outboxLeasesKey = "outbox:leases"
+	// outboxSentKeyPrefix prefixes the set of sinks that published an event
+	outboxSentKeyPrefix = "outbox:sent:"
+
+	// outboxClaimWindow is how many ready events per claimed one are scanned for an expired lease
+	outboxClaimWindow = 10
+)
+
+// confirmEventScript moves an event from the unconfirmed to the ready set,
+// unless it was already discarded
+var confirmEventScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+return 1
+`)
+
+// claimEventsScript leases the oldest ready events whose lease expired at
+// ARGV[1] until ARGV[3] and returns them with their data
+var claimEventsScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local members = redis.call('ZRANGE', KEYS[1], 0, limit * tonumber(ARGV[4]) - 1)
+local claimed = {}
+for _, member in ipairs(members) do
+	local lease = tonumber(redis.call('HGET', KEYS[2], member) or '0')
+	if lease <= now then
+		redis.call('HSET', KEYS[2], member, ARGV[3])
+		table.insert(claimed, member)
+		table.insert(claimed, redis.call('HGET', KEYS[3], member) or '')
+		if #claimed >= limit * 2 then
+			break
+		end
+	end
+end
+return claimed
+`)
+
+// OutboxEntry is an outbox event and the tenant it belongs to
+type OutboxEntry struct {
+	Tenant string
+	Event  entity.OutboxEvent
+}
+
+// Context returns a copy of ctx carrying the tenant of the entry, if any
+func (e OutboxEntry) Context(ctx context.Context) context.Context {
+	if e.Tenant == "" {
+		return ctx
+	}
+	return tenant.WithID(ctx, e.Tenant)
+}
+
+// Outbox records user changes before they are written so that a relay can
+// publish every written change even if the writer dies right after writing
+type Outbox interface {
+	// Record stores an event as unconfirmed before its change is written
+	Record(ctx context.Context, event entity.OutboxEvent) error
+	// Confirm makes an event ready to publish once its change is written
+	Confirm(ctx context.Context, id string) error
+	// Discard removes an event whose change was not written
+	Discard(ctx context.Context, id string) error
+	// Unconfirmed retrieves up to limit events recorded before the given time
+	// that were neither confirmed nor discarded, across tenants
+	Unconfirmed(ctx context.Context, before time.Time, limit int) ([]OutboxEntry, error)
+	// Claim leases up to limit ready events, oldest first, across tenants; an
+	// event that is not completed within lease is claimed again
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]OutboxEntry, error)
+	// Sent lists the sinks that published an event
+	Sent(ctx context.Context, id string) ([]string, error)
+	// MarkSent records that a sink published an event
+	MarkSent(ctx context.Context, id, sink string) error
+	// Complete removes an event published to every sink
+	Complete(ctx context.Context, id string) error
+	// Backlog returns how many events are unconfirmed and ready, and when the
+	// oldest ready event occurred
+	Backlog(ctx context.Context) (unconfirmed, ready int64, oldest time.Time, err error)
+}
+
+// RedisOutbox implements Outbox with sorted sets of unconfirmed and ready events
+type RedisOutbox struct {
+	redisCache
+	// sentTTL bounds how long the sinks of an unfinished event are remembered
+	sentTTL time.Duration
+}
+
+// NewRedisOutbox creates a Redis outbox
+func NewRedisOutbox(client *redis.Client) *RedisOutbox {
+	return &RedisOutbox{
+		redisCache: newRedisCache(client, nil),
+		sentTTL:    7 * 24 * time.Hour,
+	}
+}
+
+// member identifies an event of the tenant in the context
+func (o *RedisOutbox) member(ctx context.Context, id string) string {
+	if t, ok := tenant.FromContext(ctx); ok {
+		return t + queueTenantSeparator + id
+	}
+	return id
+}
+
+// entry decodes an event stored under a member
+func (o *RedisOutbox) entry(member, data string) (OutboxEntry, error) {
+	var entry OutboxEntry
+	if t, _, ok := strings.Cut(member, queueTenantSeparator); ok {
+		entry.Tenant = t
+	}
+	if err := o.codec.Unmarshal([]byte(data), &entry.Event); err != nil {
+		return entry, fmt.Errorf("failed to unmarshal outbox event %s: %w", member, err)
+	}
+	return entry, nil
+}
+
+// Record stores an unconfirmed event
+func (o *RedisOutbox) Record(ctx context.Context, event entity.OutboxEvent) error {
+	data, err := o.codec.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox event: %w", err)
+	}
+	member := o.member(ctx, event.ID)
+
+	err = o.executeWithTimeout(ctx, func(ctx context.Context) error {
+		_, err := o.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, outboxEventsKey, member, data)
+			pipe.ZAdd(ctx, outboxUnconfirmedKey, &redis.Z{Score: float64(time.Now().UnixMilli()), Member: member})
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record outbox event: %w", err)
+	}
+	return nil
+}
+
+// Confirm moves an event to the ready set, ordered by when it occurred
+func (o *RedisOutbox) Confirm(ctx context.Context, id string) error {
+	member := o.member(ctx, id)
+	err := o.executeWithTimeout(ctx, func(ctx context.Context) error {
+		data, err := o.client.HGet(ctx, outboxEventsKey, member).Result()
+		if err == redis.Nil {
+			return fmt.Errorf("outbox event %s: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		entry, err := o.entry(member, data)
+		if err != nil {
+			return err
+		}
+		score := entry.Event.OccurredAt.UnixMilli()
+		return confirmEventScript.Run(ctx, o.client, []string{outboxUnconfirmedKey, outboxReadyKey}, member, score).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to confirm outbox event: %w", err)
+	}
+	return nil
+}
+
+// Discard removes an unconfirmed event
+func (o *RedisOutbox) Discard(ctx context.Context, id string) error {
+	member := o.member(ctx, id)
+	err := o.executeWithTimeout(ctx, func(ctx context.Context) error {
+		_, err := o.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, outboxUnconfirmedKey, member)
+			pipe.HDel(ctx, outboxEventsKey, member)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to discard outbox event: %w", err)
+	}
+	return nil
+}
+
+// Unconfirmed retrieves the events left unconfirmed since before
+func (o *RedisOutbox) Unconfirmed(ctx context.Context, before time.Time, limit int) ([]OutboxEntry, error) {
+	var members []string
+	var values []interface{}
+	err := o.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		members, err = o.client.ZRangeByScore(ctx, outboxUnconfirmedKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(before.UnixMilli(), 10),
+			Count: int64(limit),
+		}).Result()
+		if err != nil || len(members) == 0 {
+			return err
+		}
+		values, err = o.client.HMGet(ctx, outboxEventsKey, members...).Result()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unconfirmed outbox events: %w", err)
+	}
+
+	entries := make([]OutboxEntry, 0, len(values))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		entry, err := o.entry(members[i], data)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Claim leases the oldest ready events
+func (o *RedisOutbox) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]OutboxEntry, error) {
+	var claimed []string
+	err := o.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		claimed, err = claimEventsScript.Run(ctx, o.client, []string{outboxReadyKey, outboxLeasesKey, outboxEventsKey},
+			now.UnixMilli(), limit, now.Add(lease).UnixMilli(), outboxClaimWindow).StringSlice()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+
+	entries := make([]OutboxEntry, 0, len(claimed)/2)
+	for i := 0; i+1 < len(claimed); i += 2 {
+		entry, err := o.entry(claimed[i], claimed[i+1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Sent lists the sinks that published an event
+func (o *RedisOutbox) Sent(ctx context.Context, id string) ([]string, error) {
+	key := outboxSentKeyPrefix + o.member(ctx, id)
+	var sinks []string
+	err := o.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		sinks, err = o.client.SMembers(ctx, key).Result()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sent sinks: %w", err)
+	}
+	return sinks, nil
+}
+
+// MarkSent records that a sink published an event
+func (o *RedisOutbox) MarkSent(ctx context.Context, id, sink string) error {
+	key := outboxSentKeyPrefix + o.member(ctx, id)
+	err := o.executeWithTimeout(ctx, func(ctx context.Context) error {
+		_, err := o.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, key, sink)
+			pipe.Expire(ctx, key, o.sentTTL)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark event sent: %w", err)
+	}
+	return nil
+}
+
+// Complete removes a published event
+func (o *RedisOutbox) Complete(ctx context.Context, id string) error {
+	member := o.member(ctx, id)
+	err := o.executeWithTimeout(ctx, func(ctx context.Context) error {
+		_, err := o.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, outboxReadyKey, member)
+			pipe.HDel(ctx, outboxLeasesKey, member)
+			pipe.HDel(ctx, outboxEventsKey, member)
+			pipe.Del(ctx, outboxSentKeyPrefix+member)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete outbox event: %w", err)
+	}
+	return nil
+}
+
+// Backlog counts the unpublished events and finds the oldest ready one
+func (o *RedisOutbox) Backlog(ctx context.Context) (unconfirmed, ready int64, oldest time.Time, err error) {
+	err = o.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var unconfirmedCmd, readyCmd *redis.IntCmd
+		var oldestCmd *redis.ZSliceCmd
+		_, err := o.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			unconfirmedCmd = pipe.ZCard(ctx, outboxUnconfirmedKey)
+			readyCmd = pipe.ZCard(ctx, outboxReadyKey)
+			oldestCmd = pipe.ZRangeWithScores(ctx, outboxReadyKey, 0, 0)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		unconfirmed, ready = unconfirmedCmd.Val(), readyCmd.Val()
+		if z := oldestCmd.Val(); len(z) > 0 {
+			oldest = time.UnixMilli(int64(z[0].Score))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, time.Time{}, fmt.Errorf("failed to get outbox backlog: %w", err)
+	}
+	return unconfirmed, ready, oldest, nil
+}
@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultTimeout = 3 * time.Second
+	scanBatchSize  = 100
+
+	// tenantKeyPrefix namespaces the keys of the tenant carried in the context
+	tenantKeyPrefix = "tenant:"
+)
+
+// errCacheMiss is returned by cacheGet for a key that is not cached
+var errCacheMiss = errors.New("cache miss")
+
+// Codec encodes values stored in the cache
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes cached values as JSON
+type JSONCodec struct{}
+
+// Marshal encodes v as JSON
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes cached values with encoding/gob, which keeps the fields
+// hidden from JSON, e.g. the email HMAC of users
+type GobCodec struct{}
+
+// Marshal encodes v with gob
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes gob data into v
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// redisCache provides the Redis access shared by the caching repositories
+type redisCache struct {
+	client *redis.Client
+	codec  Codec
+	// breaker skips Redis calls while Redis is failing, if set
+	breaker *CircuitBreaker
+}
+
+// newRedisCache creates a cache helper, defaulting to JSONCodec
+func newRedisCache(client *redis.Client, codec Codec) redisCache {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return redisCache{client: client, codec: codec}
+}
+
+// scopedKey prefixes key with the tenant of the context, if any, so that
+// tenants never share cache entries
+func (c *redisCache) scopedKey(ctx context.Context, key string) string {
+	if id, ok := tenant.FromContext(ctx); ok {
+		return tenantKeyPrefix + id + ":" + key
+	}
+	return key
+}
+
+// executeWithTimeout executes a Redis operation with a timeout, unless the
+// circuit breaker is open
+func (c *redisCache) executeWithTimeout(ctx context.Context, operation func(context.Context) error) error {
+	if c.breaker == nil || ctx.Value(bypassBreakerKey{}) != nil {
+		return runWithTimeout(ctx, operation)
+	}
+	return c.breaker.execute(func() error {
+		return runWithTimeout(ctx, operation)
+	})
+}
+
+// runWithTimeout executes a Redis operation with a timeout
+func runWithTimeout(ctx context.Context, operation func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- operation(ctx)
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("redis operation timed out: %w", ctx.Err())
+	}
+}
+
+// cacheGet retrieves a value from Redis and decodes it
+func (c *redisCache) cacheGet(ctx context.Context, key string, result interface{}) error {
+	key = c.scopedKey(ctx, key)
+	var data []byte
+	err := c.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		data, err = c.client.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return fmt.Errorf("%w for key %s", errCacheMiss, key)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.codec.Unmarshal(data, result)
+}
+
+// cacheSet stores a value in Redis with the given TTL
+func (c *redisCache) cacheSet(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	key = c.scopedKey(ctx, key)
+	return c.executeWithTimeout(ctx, func(ctx context.Context) error {
+		data, err := c.codec.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to marshal data: %w", err)
+		}
+		return c.client.Set(ctx, key, data, ttl).Err()
+	})
+}
+
+// storeVersioned stores a value of the given version in Redis for ttl unless
+// one of the version keys holds a newer version, and tells whether it did.
+// The version is recorded under the first version key for recordTTL unless
+// that is zero.
+func (c *redisCache) storeVersioned(ctx context.Context, key string, value interface{}, version time.Time, ttl, recordTTL time.Duration, versionKeys ...string) (bool, error) {
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal data: %w", err)
+	}
+	keys := []string{c.scopedKey(ctx, key)}
+	for _, versionKey := range versionKeys {
+		keys = append(keys, c.scopedKey(ctx, versionKey))
+	}
+
+	var stored int64
+	err = c.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		stored, err = storeIfNewerScript.Run(ctx, c.client, keys, data, version.UnixMicro(), ttl.Milliseconds(), recordTTL.Milliseconds()).Int64()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to cache %s: %w", key, err)
+	}
+	return stored == 1, nil
+}
+
+// recordVersion records a version under a version key for ttl, unless it
+// holds a newer one, so that values older than that are not stored
+func (c *redisCache) recordVersion(ctx context.Context, versionKey string, version time.Time, ttl time.Duration) error {
+	keys := []string{c.scopedKey(ctx, versionKey)}
+	return c.executeWithTimeout(ctx, func(ctx context.Context) error {
+		return tombstoneScript.Run(ctx, c.client, keys, version.UnixMicro(), ttl.Milliseconds()).Err()
+	})
+}
+
+// deleteKeys removes the given keys and every key starting with one of the prefixes
+func (c *redisCache) deleteKeys(ctx context.Context, keys []string, prefixes ...string) error {
+	_, err := c.purgeKeys(ctx, keys, prefixes...)
+	return err
+}
+
+// purgeKeys removes the given keys and every key starting with one of the
+// prefixes, returning how many keys existed
+func (c *redisCache) purgeKeys(ctx context.Context, keys []string, prefixes ...string) (int64, error) {
+	scoped := make([]string, 0, len(keys))
+	for _, key := range keys {
+		scoped = append(scoped, c.scopedKey(ctx, key))
+	}
+	keys = scoped
+
+	var deleted int64
+	err := c.executeWithTimeout(ctx, func(ctx context.Context) error {
+		// DEL does not expand patterns, so prefixed keys are found with SCAN
+		for _, prefix := range prefixes {
+			iter := c.client.Scan(ctx, 0, c.scopedKey(ctx, prefix)+"*", scanBatchSize).Iterator()
+			for iter.Next(ctx) {
+				keys = append(keys, iter.Val())
+			}
+			if err := iter.Err(); err != nil {
+				return err
+			}
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		var err error
+		deleted, err = c.client.Del(ctx, keys...).Result()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
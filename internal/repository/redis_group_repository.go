@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/go-redis/redis/v8"
+)
+
+// groupNamespace prefixes all group cache keys
+const groupNamespace = "groups"
+
+// RedisGroupRepository implements a caching layer over another GroupRepository
+type RedisGroupRepository struct {
+	*CachedRepository[entity.Group]
+}
+
+// NewRedisGroupRepository creates a new Redis group repository
+func NewRedisGroupRepository(client *redis.Client, repository GroupRepository, ttl time.Duration) *RedisGroupRepository {
+	return &RedisGroupRepository{
+		CachedRepository: NewCachedRepository[entity.Group](client, repository, CacheOptions[entity.Group]{
+			Namespace: groupNamespace,
+			ID:        func(group entity.Group) string { return group.ID },
+			TTL:       FixedTTL(ttl),
+		}),
+	}
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Cache key prefixes
+	groupMembersKeyPrefix = "memberships:group:"
+	userGroupsKeyPrefix   = "memberships:user:"
+
+	// membershipScanPageSize is the page size used to walk all memberships of a group or user
+	membershipScanPageSize = 100
+)
+
+// RedisMembershipRepository implements a caching layer over another MembershipRepository
+type RedisMembershipRepository struct {
+	BaseRepositoryImpl[entity.Membership]
+	redisCache
+	repository MembershipRepository
+	ttl        time.Duration
+}
+
+// NewRedisMembershipRepository creates a new Redis membership repository
+func NewRedisMembershipRepository(client *redis.Client, repository MembershipRepository, ttl time.Duration) *RedisMembershipRepository {
+	return &RedisMembershipRepository{
+		redisCache: newRedisCache(client, nil),
+		repository: repository,
+		ttl:        ttl,
+	}
+}
+
+// groupMembersPrefix is the prefix of every cached member page of a group
+func (r *RedisMembershipRepository) groupMembersPrefix(groupID string) string {
+	return groupMembersKeyPrefix + groupID + ":"
+}
+
+// userGroupsPrefix is the prefix of every cached group page of a user
+func (r *RedisMembershipRepository) userGroupsPrefix(userID string) string {
+	return userGroupsKeyPrefix + userID + ":"
+}
+
+// invalidateCache removes the cached pages of the given groups and users,
+// leaving a tombstone for each first so that no page loaded before is cached again
+func (r *RedisMembershipRepository) invalidateCache(ctx context.Context, groupIDs, userIDs []string) error {
+	var prefixes []string
+	for _, id := range groupIDs {
+		prefixes = append(prefixes, r.groupMembersPrefix(id))
+	}
+	for _, id := range userIDs {
+		prefixes = append(prefixes, r.userGroupsPrefix(id))
+	}
+
+	now := time.Now()
+	for _, prefix := range prefixes {
+		if err := r.recordVersion(ctx, versionKeyPrefix+prefix, now, versionTTL(entryTTL{hard: r.ttl})); err != nil {
+			return fmt.Errorf("failed to invalidate %s: %w", prefix, err)
+		}
+	}
+	return r.deleteKeys(ctx, nil, prefixes...)
+}
+
+// AddMember adds a user to a group and invalidates both sides of the relation
+func (r *RedisMembershipRepository) AddMember(ctx context.Context, membership entity.Membership) error {
+	if err := r.repository.AddMember(ctx, membership); err != nil {
+		return fmt.Errorf("failed to add member in repository: %w", err)
+	}
+
+	if err := r.invalidateCache(ctx, []string{membership.GroupID}, []string{membership.UserID}); err != nil {
+		log.Printf("Failed to invalidate cache after add member: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveMember removes a user from a group and invalidates both sides of the relation
+func (r *RedisMembershipRepository) RemoveMember(ctx context.Context, groupID, userID string) error {
+	if err := r.repository.RemoveMember(ctx, groupID, userID); err != nil {
+		return fmt.Errorf("failed to remove member in repository: %w", err)
+	}
+
+	if err := r.invalidateCache(ctx, []string{groupID}, []string{userID}); err != nil {
+		log.Printf("Failed to invalidate cache after remove member: %v", err)
+	}
+
+	return nil
+}
+
+// ListByGroup retrieves the memberships of a group, using cache if possible
+func (r *RedisMembershipRepository) ListByGroup(ctx context.Context, groupID string, params PaginationParams) ([]entity.Membership, error) {
+	r.ValidatePagination(&params)
+	return r.cachedList(ctx, r.groupMembersPrefix(groupID), params, func() ([]entity.Membership, error) {
+		return r.repository.ListByGroup(ctx, groupID, params)
+	})
+}
+
+// ListByUser retrieves the memberships of a user, using cache if possible
+func (r *RedisMembershipRepository) ListByUser(ctx context.Context, userID string, params PaginationParams) ([]entity.Membership, error) {
+	r.ValidatePagination(&params)
+	return r.cachedList(ctx, r.userGroupsPrefix(userID), params, func() ([]entity.Membership, error) {
+		return r.repository.ListByUser(ctx, userID, params)
+	})
+}
+
+// cachedList serves a membership page from cache or loads and caches it
+func (r *RedisMembershipRepository) cachedList(ctx context.Context, prefix string, params PaginationParams, load func() ([]entity.Membership, error)) ([]entity.Membership, error) {
+	cacheKey := prefix + fmt.Sprintf(pageKeyFormat, params.Page, params.PageSize)
+
+	var memberships []entity.Membership
+	err := r.cacheGet(ctx, cacheKey, &memberships)
+	if err == nil {
+		return memberships, nil
+	}
+
+	// Cache miss, get from underlying repository
+	started := time.Now()
+	memberships, err = load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memberships from repository: %w", err)
+	}
+
+	// Update cache in background, unless the pages were invalidated since the load started
+	go func() {
+		if _, err := r.storeVersioned(context.WithoutCancel(ctx), cacheKey, memberships, started, r.ttl, 0, versionKeyPrefix+prefix); err != nil {
+			log.Printf("Failed to cache memberships: %v", err)
+		}
+	}()
+
+	return memberships, nil
+}
+
+// DeleteByGroup removes every member of a group and invalidates the members' group lists
+func (r *RedisMembershipRepository) DeleteByGroup(ctx context.Context, groupID string) error {
+	memberships, err := r.listAll(ctx, func(params PaginationParams) ([]entity.Membership, error) {
+		return r.repository.ListByGroup(ctx, groupID, params)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list group members: %w", err)
+	}
+
+	if err := r.repository.DeleteByGroup(ctx, groupID); err != nil {
+		return fmt.Errorf("failed to delete group memberships from repository: %w", err)
+	}
+
+	userIDs := make([]string, 0, len(memberships))
+	for _, m := range memberships {
+		userIDs = append(userIDs, m.UserID)
+	}
+	if err := r.invalidateCache(ctx, []string{groupID}, userIDs); err != nil {
+		log.Printf("Failed to invalidate cache after delete group memberships: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteByUser removes a user from every group and invalidates those groups' member lists
+func (r *RedisMembershipRepository) DeleteByUser(ctx context.Context, userID string) error {
+	memberships, err := r.listAll(ctx, func(params PaginationParams) ([]entity.Membership, error) {
+		return r.repository.ListByUser(ctx, userID, params)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list user groups: %w", err)
+	}
+
+	if err := r.repository.DeleteByUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete user memberships from repository: %w", err)
+	}
+
+	groupIDs := make([]string, 0, len(memberships))
+	for _, m := range memberships {
+		groupIDs = append(groupIDs, m.GroupID)
+	}
+	if err := r.invalidateCache(ctx, groupIDs, []string{userID}); err != nil {
+		log.Printf("Failed to invalidate cache after delete user memberships: %v", err)
+	}
+
+	return nil
+}
+
+// listAll walks every page of an uncached membership listing
+func (r *RedisMembershipRepository) listAll(ctx context.Context, list func(PaginationParams) ([]entity.Membership, error)) ([]entity.Membership, error) {
+	var all []entity.Membership
+	for page := 1; ; page++ {
+		memberships, err := list(PaginationParams{Page: page, PageSize: membershipScanPageSize})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, memberships...)
+		if len(memberships) < membershipScanPageSize {
+			return all, nil
+		}
+	}
+}
@@ -2,7 +2,7 @@ package repository
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -12,196 +12,178 @@ import (
 )
 
 const (
-	// Cache key prefixes
-	userKeyPrefix     = "users:"
-	userListKeyPrefix = "users:list:"
-	pageKeyFormat     = "page_%d:size_%d"
-	defaultTimeout    = 3 * time.Second
+	// userNamespace prefixes all user cache keys
+	userNamespace = "users"
+
+	// userEmailKeyPrefix prefixes the cached email→id secondary index. It is a
+	// separate namespace so that no user ID can collide with an index key.
+	userEmailKeyPrefix = "users_by_email:"
 )
 
 // RedisRepository implements a caching layer over another UserRepository
 type RedisRepository struct {
-	BaseRepositoryImpl[entity.User]
-	client     *redis.Client
-	repository UserRepository
-	ttl        time.Duration
+	*CachedRepository[entity.User]
+	users UserRepository
+	// trackedUsers is how many users reads are counted for; zero disables tracking
+	trackedUsers int
 }
 
 // NewRedisRepository creates a new Redis repository
 func NewRedisRepository(client *redis.Client, repository UserRepository, ttl time.Duration) *RedisRepository {
 	return &RedisRepository{
-		client:     client,
-		repository: repository,
-		ttl:        ttl,
-	}
-}
-
-// executeWithTimeout executes a Redis operation with a timeout
-func (r *RedisRepository) executeWithTimeout(ctx context.Context, operation func(context.Context) error) error {
-	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
-	defer cancel()
-
-	errChan := make(chan error, 1)
-	go func() {
-		errChan <- operation(ctx)
-	}()
-
-	select {
-	case err := <-errChan:
-		return err
-	case <-ctx.Done():
-		return fmt.Errorf("redis operation timed out: %w", ctx.Err())
-	}
-}
-
-// cacheGet retrieves a value from Redis and unmarshals it
-func (r *RedisRepository) cacheGet(ctx context.Context, key string, result interface{}) error {
-	var data string
-	err := r.executeWithTimeout(ctx, func(ctx context.Context) error {
-		var err error
-		data, err = r.client.Get(ctx, key).Result()
-		if err == redis.Nil {
-			return fmt.Errorf("cache miss for key %s", key)
-		}
-		return err
-	})
-	if err != nil {
-		return err
+		CachedRepository: NewCachedRepository[entity.User](client, repository, CacheOptions[entity.User]{
+			Namespace: userNamespace,
+			ID:        func(user entity.User) string { return user.ID },
+			// The email HMAC keys the email index, so it is cached with the user
+			Codec:   GobCodec{},
+			TTL:     FixedTTL(ttl),
+			Version: func(user entity.User) time.Time { return user.UpdatedAt },
+		}),
+		users: repository,
 	}
-
-	return json.Unmarshal([]byte(data), result)
-}
-
-// cacheSet stores a value in Redis with the configured TTL
-func (r *RedisRepository) cacheSet(ctx context.Context, key string, value interface{}) error {
-	return r.executeWithTimeout(ctx, func(ctx context.Context) error {
-		data, err := json.Marshal(value)
-		if err != nil {
-			return fmt.Errorf("failed to marshal data: %w", err)
-		}
-		return r.client.Set(ctx, key, data, r.ttl).Err()
-	})
-}
-
-// generateKey creates cache keys for different types of data
-func (r *RedisRepository) generateKey(id string) string {
-	return userKeyPrefix + id
-}
-
-func (r *RedisRepository) generateListKey(params PaginationParams) string {
-	return fmt.Sprintf("%s%s", userListKeyPrefix, fmt.Sprintf(pageKeyFormat, params.Page, params.PageSize))
 }
 
-// invalidateCache removes user-related cache entries
-func (r *RedisRepository) invalidateCache(ctx context.Context, id string) error {
-	return r.executeWithTimeout(ctx, func(ctx context.Context) error {
-		pipe := r.client.Pipeline()
-		pipe.Del(ctx, r.generateKey(id))
-		pipe.Del(ctx, userListKeyPrefix+"*")
-		_, err := pipe.Exec(ctx)
-		return err
-	})
+// emailKey creates the secondary index key of an email
+func (r *RedisRepository) emailKey(email string) string {
+	return userEmailKeyPrefix + email
 }
 
-// GetAll retrieves all users with pagination, using cache if possible
-func (r *RedisRepository) GetAll(ctx context.Context, params PaginationParams) ([]entity.User, error) {
-	r.ValidatePagination(&params)
-	cacheKey := r.generateListKey(params)
-
-	var users []entity.User
-	err := r.cacheGet(ctx, cacheKey, &users)
-	if err == nil {
-		return users, nil
-	}
-
-	// Cache miss, get from underlying repository
-	users, err = r.repository.GetAll(ctx, params)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get users from repository: %w", err)
-	}
-
-	// Update cache in background
-	go func() {
-		if err := r.cacheSet(context.Background(), cacheKey, users); err != nil {
-			log.Printf("Failed to cache users list: %v", err)
+// GetByEmail retrieves a user by the lookup value of their email, resolving the
+// ID through the cached index
+func (r *RedisRepository) GetByEmail(ctx context.Context, email string) (entity.User, error) {
+	cacheKey := r.emailKey(email)
+
+	var id string
+	if err := r.cacheGet(ctx, cacheKey, &id); err == nil {
+		// The index may point at a user whose email changed; verify before trusting it
+		user, err := r.GetByID(ctx, id)
+		if err == nil && user.LookupEmail() == email {
+			return user, nil
 		}
-	}()
-
-	return users, nil
-}
-
-// GetByID retrieves a user by ID, using cache if possible
-func (r *RedisRepository) GetByID(ctx context.Context, id string) (entity.User, error) {
-	if err := r.ValidateID(id); err != nil {
-		return entity.User{}, err
-	}
-
-	cacheKey := r.generateKey(id)
-	var user entity.User
-	err := r.cacheGet(ctx, cacheKey, &user)
-	if err == nil {
-		return user, nil
 	}
 
-	// Cache miss, get from underlying repository
-	user, err = r.repository.GetByID(ctx, id)
+	// Cache miss or stale index entry, get from underlying repository
+	user, err := r.users.GetByEmail(ctx, email)
 	if err != nil {
-		return entity.User{}, fmt.Errorf("failed to get user from repository: %w", err)
+		return user, fmt.Errorf("failed to get user by email from repository: %w", err)
 	}
 
 	// Update cache in background
 	go func() {
-		if err := r.cacheSet(context.Background(), cacheKey, user); err != nil {
-			log.Printf("Failed to cache user: %v", err)
+		if err := r.cacheSet(context.WithoutCancel(ctx), cacheKey, user.ID, r.ttl.Item); err != nil {
+			log.Printf("Failed to cache %s: %v", cacheKey, err)
 		}
 	}()
 
 	return user, nil
 }
 
-// Create creates a user and updates cache
+// Create creates a user and drops any stale index entry for its email
 func (r *RedisRepository) Create(ctx context.Context, user entity.User) error {
-	if err := r.repository.Create(ctx, user); err != nil {
-		return fmt.Errorf("failed to create user in repository: %w", err)
-	}
-
-	if err := r.invalidateCache(ctx, user.ID); err != nil {
-		log.Printf("Failed to invalidate cache after create: %v", err)
+	if err := r.CachedRepository.Create(ctx, user); err != nil {
+		return err
 	}
 
+	r.invalidateEmails(ctx, user.LookupEmail())
+	r.invalidateStats(ctx)
 	return nil
 }
 
-// Update updates a user and updates cache
+// Update updates a user and drops the index entries of its old and new email
 func (r *RedisRepository) Update(ctx context.Context, user entity.User) error {
-	if err := r.ValidateID(user.ID); err != nil {
+	previous := r.cachedEmail(ctx, user.ID)
+	if err := r.CachedRepository.Update(ctx, user); err != nil {
 		return err
 	}
 
-	if err := r.repository.Update(ctx, user); err != nil {
-		return fmt.Errorf("failed to update user in repository: %w", err)
-	}
+	r.invalidateEmails(ctx, previous, user.LookupEmail())
+	r.invalidateStats(ctx)
+	return nil
+}
 
-	if err := r.invalidateCache(ctx, user.ID); err != nil {
-		log.Printf("Failed to invalidate cache after update: %v", err)
+// UpdateColumns updates some columns of a user and drops the index entries of
+// its old and new email
+func (r *RedisRepository) UpdateColumns(ctx context.Context, user entity.User, columns []string) error {
+	previous := r.cachedEmail(ctx, user.ID)
+	if err := r.CachedRepository.UpdateColumns(ctx, user, columns); err != nil {
+		return err
 	}
 
+	r.invalidateEmails(ctx, previous, user.LookupEmail())
+	r.invalidateStats(ctx)
 	return nil
 }
 
-// Delete removes a user and updates cache
+// Delete removes a user and drops the index entry of its email
 func (r *RedisRepository) Delete(ctx context.Context, id string) error {
-	if err := r.ValidateID(id); err != nil {
+	previous := r.cachedEmail(ctx, id)
+	if err := r.CachedRepository.Delete(ctx, id); err != nil {
 		return err
 	}
 
-	if err := r.repository.Delete(ctx, id); err != nil {
-		return fmt.Errorf("failed to delete user from repository: %w", err)
+	r.invalidateEmails(ctx, previous)
+	r.invalidateStats(ctx)
+	return nil
+}
+
+// cachedEmail returns the email lookup value of a cached user, or "" when it is not cached.
+// Index entries of uncached users are verified on read, so a miss is safe.
+func (r *RedisRepository) cachedEmail(ctx context.Context, id string) string {
+	user, err := r.cached(ctx, id)
+	if err != nil {
+		return ""
+	}
+	return user.LookupEmail()
+}
+
+// invalidateEmails removes the secondary index entries of the given emails
+func (r *RedisRepository) invalidateEmails(ctx context.Context, emails ...string) {
+	var keys []string
+	for _, email := range emails {
+		if email != "" {
+			keys = append(keys, r.emailKey(email))
+		}
+	}
+	if len(keys) == 0 {
+		return
 	}
 
-	if err := r.invalidateCache(ctx, id); err != nil {
-		log.Printf("Failed to invalidate cache after delete: %v", err)
+	if err := r.deleteKeys(ctx, keys); err != nil {
+		log.Printf("Failed to invalidate email index: %v", err)
 	}
+}
 
-	return nil
+// invalidateStats bumps the statistics version in the background so that
+// writes never wait on it, even past an open circuit breaker; cached
+// statistics are recomputed on their next read. The time of the write is
+// recorded first, so that statistics computed before it are not cached.
+func (r *RedisRepository) invalidateStats(ctx context.Context) {
+	written := time.Now()
+	go func() {
+		ctx := withoutBreaker(context.WithoutCancel(ctx))
+		key := r.scopedKey(ctx, userStatsVersionKey)
+		err := errors.Join(
+			r.recordVersion(ctx, userStatsWrittenKey, written, versionTTL(r.ttl.item())),
+			r.executeWithTimeout(ctx, func(ctx context.Context) error {
+				return r.client.Incr(ctx, key).Err()
+			}),
+		)
+		if err != nil {
+			log.Printf("Failed to invalidate user stats: %v", err)
+		}
+	}()
+}
+
+// PurgeUser deletes every cache key holding the user's data: the cached
+// entity, list pages, the email index, the email reservation and the
+// user's membership pages. Erasures must not leave data behind, so the purge
+// is attempted even while the circuit breaker is open.
+func (r *RedisRepository) PurgeUser(ctx context.Context, user entity.User) (int64, error) {
+	ctx = withoutBreaker(ctx)
+	email := user.LookupEmail()
+	keys := []string{r.generateKey(user.ID), r.emailKey(email), emailKeyPrefix + email}
+	prefixes := []string{r.listKeyPrefix(), userGroupsKeyPrefix + user.ID + ":"}
+	purged, err := r.purgeKeys(ctx, keys, prefixes...)
+	r.evictLocal(ctx, keys, prefixes)
+	return purged, err
 }
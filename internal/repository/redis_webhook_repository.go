@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// webhookNamespace prefixes all webhook cache keys
+	webhookNamespace = "webhooks"
+
+	// activeWebhooksKey names the cached list of active webhooks under the list
+	// prefix, so that every webhook write invalidates it
+	activeWebhooksKey = "active"
+)
+
+// RedisWebhookRepository implements a caching layer over another WebhookRepository
+type RedisWebhookRepository struct {
+	*CachedRepository[entity.Webhook]
+	webhooks WebhookRepository
+}
+
+// NewRedisWebhookRepository creates a new Redis webhook repository
+func NewRedisWebhookRepository(client *redis.Client, repository WebhookRepository, ttl time.Duration) *RedisWebhookRepository {
+	return &RedisWebhookRepository{
+		CachedRepository: NewCachedRepository[entity.Webhook](client, repository, CacheOptions[entity.Webhook]{
+			Namespace: webhookNamespace,
+			ID:        func(webhook entity.Webhook) string { return webhook.ID },
+			TTL:       FixedTTL(ttl),
+		}),
+		webhooks: repository,
+	}
+}
+
+// ListActive retrieves every active webhook, using cache if possible
+func (r *RedisWebhookRepository) ListActive(ctx context.Context) ([]entity.Webhook, error) {
+	cacheKey := r.listKeyPrefix() + activeWebhooksKey
+
+	var webhooks []entity.Webhook
+	if err := r.cacheGet(ctx, cacheKey, &webhooks); err == nil {
+		return webhooks, nil
+	}
+
+	// Cache miss, get from underlying repository
+	started := time.Now()
+	webhooks, err := r.webhooks.ListActive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active webhooks from repository: %w", err)
+	}
+
+	// Update cache in background, unless a webhook was written since the load started
+	go func() {
+		if _, err := r.storeIfNewer(context.WithoutCancel(ctx), cacheKey, webhooks, started, r.ttl.List, false); err != nil {
+			log.Printf("Failed to cache active webhooks: %v", err)
+		}
+	}()
+
+	return webhooks, nil
+}
@@ -0,0 +1,2112 @@
+package repository_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/encryption"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/dragondarkon/bqredis-crud/internal/repository/repositorytest"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	testProject = "test-project"
+	testDataset = "users_dataset"
+	testTable   = "users"
+)
+
+var baseTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// userFixture builds users whose creation times increase with i
+var userFixture = repositorytest.Fixture[entity.User]{
+	New: func(i int) entity.User {
+		created := baseTime.Add(time.Duration(i) * time.Hour)
+		return entity.User{
+			ID:        fmt.Sprintf("user-%d", i),
+			Name:      fmt.Sprintf("User %d", i),
+			Email:     fmt.Sprintf("user%d@example.com", i),
+			CreatedAt: created,
+			UpdatedAt: created,
+		}
+	},
+	ID: func(user entity.User) string {
+		return user.ID
+	},
+	Modify: func(user entity.User) entity.User {
+		user.Name += " (updated)"
+		user.Email = "updated." + user.Email
+		user.UpdatedAt = user.UpdatedAt.Add(time.Minute)
+		return user
+	},
+	Equal: func(a, b entity.User) bool {
+		return a.ID == b.ID &&
+			a.Name == b.Name &&
+			a.Email == b.Email &&
+			a.CreatedAt.Equal(b.CreatedAt) &&
+			a.UpdatedAt.Equal(b.UpdatedAt)
+	},
+}
+
+func newBigQueryRepository(t *testing.T) *repository.BigQueryRepository {
+	t.Helper()
+
+	schema, err := bigquery.InferSchema(entity.User{})
+	if err != nil {
+		t.Fatalf("failed to infer schema: %v", err)
+	}
+	fake := repositorytest.NewFakeBigQuery(t, testProject)
+	if err := fake.CreateTable(testDataset, testTable, schema); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	return repository.NewBigQueryRepository(fake.Client(t), testProject, testDataset, testTable, repository.Tenancy{})
+}
+
+func TestBigQueryRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.BaseRepository[entity.User] {
+		return newBigQueryRepository(t)
+	}, userFixture)
+}
+
+func TestBigQueryRepositoryWithoutEmailHMAC(t *testing.T) {
+	ctx := context.Background()
+	schema, err := bigquery.InferSchema(entity.User{})
+	if err != nil {
+		t.Fatalf("failed to infer schema: %v", err)
+	}
+	var legacy bigquery.Schema
+	for _, field := range schema {
+		if field.Name != "email_hmac" {
+			legacy = append(legacy, field)
+		}
+	}
+	fake := repositorytest.NewFakeBigQuery(t, testProject)
+	if err := fake.CreateTable(testDataset, testTable, legacy); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	repo := repository.NewBigQueryRepository(fake.Client(t), testProject, testDataset, testTable, repository.Tenancy{})
+
+	// Tables created before encryption have no email_hmac column to read or write
+	user := userFixture.New(0)
+	user.EmailHMAC = "ignored"
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	updated := userFixture.Modify(user)
+	if err := repo.Update(ctx, updated); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	got, err := repo.GetByEmail(ctx, updated.Email)
+	if err != nil || !userFixture.Equal(got, updated) || got.EmailHMAC != "" {
+		t.Errorf("GetByEmail() = %+v, %v, want %+v", got, err, updated)
+	}
+}
+
+func TestRedisRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.BaseRepository[entity.User] {
+		client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		return repository.NewRedisRepository(client, newBigQueryRepository(t), time.Minute)
+	}, userFixture)
+}
+
+// article is an entity used only to exercise the generic repository
+type article struct {
+	Slug        string    `bigquery:"slug"`
+	Title       string    `bigquery:"title"`
+	Views       int64     `bigquery:"views"`
+	PublishedAt time.Time `bigquery:"published_at"`
+	Draft       string    `bigquery:"-"`
+}
+
+var articleFixture = repositorytest.Fixture[article]{
+	New: func(i int) article {
+		return article{
+			Slug:        fmt.Sprintf("article-%d", i),
+			Title:       fmt.Sprintf("Article %d", i),
+			Views:       int64(i),
+			PublishedAt: baseTime.Add(time.Duration(i) * time.Hour),
+		}
+	},
+	ID: func(a article) string {
+		return a.Slug
+	},
+	Modify: func(a article) article {
+		a.Title += " (revised)"
+		a.Views += 100
+		return a
+	},
+	Equal: func(a, b article) bool {
+		return a.Slug == b.Slug && a.Title == b.Title && a.Views == b.Views && a.PublishedAt.Equal(b.PublishedAt)
+	},
+}
+
+func newArticleRepository(t *testing.T) *repository.GenericBigQueryRepository[article] {
+	t.Helper()
+
+	schema, err := bigquery.InferSchema(article{})
+	if err != nil {
+		t.Fatalf("failed to infer schema: %v", err)
+	}
+	fake := repositorytest.NewFakeBigQuery(t, testProject)
+	if err := fake.CreateTable(testDataset, "articles", schema); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	repo, err := repository.NewGenericBigQueryRepository[article](fake.Client(t), repository.TableDescriptor{
+		Name:       "article",
+		ProjectID:  testProject,
+		Dataset:    testDataset,
+		Table:      "articles",
+		PrimaryKey: "slug",
+		OrderBy:    "published_at",
+		Immutable:  []string{"published_at"},
+	})
+	if err != nil {
+		t.Fatalf("NewGenericBigQueryRepository() error = %v", err)
+	}
+	return repo
+}
+
+func TestGenericBigQueryRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.BaseRepository[article] {
+		return newArticleRepository(t)
+	}, articleFixture)
+}
+
+func TestCachedRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.BaseRepository[article] {
+		client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		return repository.NewCachedRepository[article](client, newArticleRepository(t), repository.CacheOptions[article]{
+			Namespace: "articles",
+			ID:        func(a article) string { return a.Slug },
+			TTL:       repository.TTLPolicy{Item: time.Minute, List: 10 * time.Second},
+		})
+	}, articleFixture)
+}
+
+// slowRepository counts loads by ID and holds what they read until released
+type slowRepository[T any] struct {
+	repository.BaseRepository[T]
+	loads   atomic.Int32
+	release chan struct{}
+}
+
+// GetByID counts the load once it read, then waits for release
+func (r *slowRepository[T]) GetByID(ctx context.Context, id string) (T, error) {
+	entity, err := r.BaseRepository.GetByID(ctx, id)
+	r.loads.Add(1)
+	<-r.release
+	return entity, err
+}
+
+func TestCachedRepositoryCoalescesLoads(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	articles := newArticleRepository(t)
+	newCache := func(underlying repository.BaseRepository[article]) *repository.CachedRepository[article] {
+		return repository.NewCachedRepository[article](client, underlying, repository.CacheOptions[article]{
+			Namespace: "articles",
+			ID:        func(a article) string { return a.Slug },
+			TTL:       repository.FixedTTL(time.Minute),
+		})
+	}
+	// getConcurrently reads an article through each cache at once and releases
+	// the underlying repository once the first load started
+	getConcurrently := func(t *testing.T, slow *slowRepository[article], want article, caches ...*repository.CachedRepository[article]) {
+		t.Helper()
+		errs := make(chan error, len(caches))
+		for _, cache := range caches {
+			go func(cache *repository.CachedRepository[article]) {
+				got, err := cache.GetByID(ctx, want.Slug)
+				if err == nil && !articleFixture.Equal(got, want) {
+					err = fmt.Errorf("got %+v, want %+v", got, want)
+				}
+				errs <- err
+			}(cache)
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for slow.loads.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(slow.release)
+
+		for range caches {
+			if err := <-errs; err != nil {
+				t.Errorf("GetByID() error = %v", err)
+			}
+		}
+	}
+
+	t.Run("Singleflight", func(t *testing.T) {
+		want := articleFixture.New(0)
+		if err := articles.Create(ctx, want); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		slow := &slowRepository[article]{BaseRepository: articles, release: make(chan struct{})}
+		cache := newCache(slow)
+
+		caches := make([]*repository.CachedRepository[article], 10)
+		for i := range caches {
+			caches[i] = cache
+		}
+		getConcurrently(t, slow, want, caches...)
+		if loads := slow.loads.Load(); loads != 1 {
+			t.Errorf("loads = %d, want 1", loads)
+		}
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		want := articleFixture.New(1)
+		if err := articles.Create(ctx, want); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		slow := &slowRepository[article]{BaseRepository: articles, release: make(chan struct{})}
+		lock := repository.CacheLock{TTL: 5 * time.Second, Wait: 2 * time.Second}
+
+		// Separate caches stand for instances sharing Redis
+		getConcurrently(t, slow, want, newCache(slow).WithLock(lock), newCache(slow).WithLock(lock))
+		if loads := slow.loads.Load(); loads != 1 {
+			t.Errorf("loads = %d, want 1", loads)
+		}
+	})
+
+	t.Run("LockWaitTimeout", func(t *testing.T) {
+		want := articleFixture.New(2)
+		if err := articles.Create(ctx, want); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		slow := &slowRepository[article]{BaseRepository: articles, release: make(chan struct{})}
+		lock := repository.CacheLock{TTL: 5 * time.Second, Wait: 10 * time.Millisecond}
+
+		// An instance that waited in vain loads the entry itself
+		getConcurrently(t, slow, want, newCache(slow).WithLock(lock), newCache(slow).WithLock(lock))
+		if loads := slow.loads.Load(); loads != 2 {
+			t.Errorf("loads = %d, want 2", loads)
+		}
+	})
+}
+
+func TestCachedRepositoryStaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	articles := newArticleRepository(t)
+	counted := &slowRepository[article]{BaseRepository: articles, release: make(chan struct{})}
+	close(counted.release)
+	cache := repository.NewCachedRepository[article](client, counted, repository.CacheOptions[article]{
+		Namespace: "articles",
+		ID:        func(a article) string { return a.Slug },
+		TTL:       repository.TTLPolicy{Item: time.Minute, ItemSoft: 50 * time.Millisecond, List: time.Minute},
+	})
+
+	original := articleFixture.New(0)
+	if err := cache.Create(ctx, original); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := cache.GetByID(ctx, original.Slug); err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(mr.Keys()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A change the cache was not told about is served once the entry is refreshed
+	revised := articleFixture.Modify(original)
+	if err := articles.Update(ctx, revised); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got, err := cache.GetByID(ctx, original.Slug); err != nil || !articleFixture.Equal(got, original) {
+		t.Errorf("GetByID() of a fresh entry = %+v, %v, want the cached %+v", got, err, original)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got, err := cache.GetByID(ctx, original.Slug); err != nil || !articleFixture.Equal(got, original) {
+		t.Errorf("GetByID() of a stale entry = %+v, %v, want the cached %+v", got, err, original)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		got, err := cache.GetByID(ctx, original.Slug)
+		if err == nil && articleFixture.Equal(got, revised) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GetByID() after refresh = %+v, %v, want %+v", got, err, revised)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if loads := counted.loads.Load(); loads != 2 {
+		t.Errorf("loads = %d, want 2", loads)
+	}
+
+	// Past the hard TTL the entry is gone and loaded again
+	mr.FastForward(2 * time.Minute)
+	if got, err := cache.GetByID(ctx, original.Slug); err != nil || !articleFixture.Equal(got, revised) {
+		t.Errorf("GetByID() after hard TTL = %+v, %v, want %+v", got, err, revised)
+	}
+	if loads := counted.loads.Load(); loads != 3 {
+		t.Errorf("loads after hard TTL = %d, want 3", loads)
+	}
+}
+
+func TestCachedRepositoryNegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	articles := newArticleRepository(t)
+	counted := &slowRepository[article]{BaseRepository: articles, release: make(chan struct{})}
+	close(counted.release)
+	cache := repository.NewCachedRepository[article](client, counted, repository.CacheOptions[article]{
+		Namespace: "articles",
+		ID:        func(a article) string { return a.Slug },
+		TTL:       repository.TTLPolicy{Item: time.Hour, List: time.Hour, Missing: time.Minute},
+	})
+	// missTwice looks an article up twice, letting the miss be cached in between
+	missTwice := func(t *testing.T, slug string) {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			if _, err := cache.GetByID(ctx, slug); !errors.Is(err, repository.ErrNotFound) {
+				t.Fatalf("GetByID() of a missing article error = %v, want ErrNotFound", err)
+			}
+			deadline := time.Now().Add(time.Second)
+			for !mr.Exists("articles:"+slug) && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+	}
+
+	first := articleFixture.New(0)
+	missTwice(t, first.Slug)
+	if loads := counted.loads.Load(); loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+
+	// Creating the article clears the cached miss
+	if err := cache.Create(ctx, first); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if got, err := cache.GetByID(ctx, first.Slug); err != nil || !articleFixture.Equal(got, first) {
+		t.Errorf("GetByID() after Create() = %+v, %v, want %+v", got, err, first)
+	}
+
+	// A cached miss expires on its own
+	second := articleFixture.New(1)
+	missTwice(t, second.Slug)
+	if err := articles.Create(ctx, second); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := cache.GetByID(ctx, second.Slug); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetByID() before the miss expired error = %v, want ErrNotFound", err)
+	}
+	mr.FastForward(2 * time.Minute)
+	if got, err := cache.GetByID(ctx, second.Slug); err != nil || !articleFixture.Equal(got, second) {
+		t.Errorf("GetByID() after the miss expired = %+v, %v, want %+v", got, err, second)
+	}
+}
+
+func TestCachedRepositoryLocalCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	articles := newArticleRepository(t)
+	counted := &slowRepository[article]{BaseRepository: articles, release: make(chan struct{})}
+	close(counted.release)
+	// newInstance stands for an instance with its own in-process tier
+	newInstance := func() *repository.CachedRepository[article] {
+		local, err := repository.NewLocalCache(client, 10, time.Hour)
+		if err != nil {
+			t.Fatalf("NewLocalCache() error = %v", err)
+		}
+		if err := local.Listen(ctx); err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+		return repository.NewCachedRepository[article](client, counted, repository.CacheOptions[article]{
+			Namespace: "articles",
+			ID:        func(a article) string { return a.Slug },
+			TTL:       repository.FixedTTL(time.Hour),
+		}).WithLocalCache(local)
+	}
+	first, second := newInstance(), newInstance()
+
+	original := articleFixture.New(0)
+	if err := first.Create(ctx, original); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for _, instance := range []*repository.CachedRepository[article]{first, second} {
+		if _, err := instance.GetByID(ctx, original.Slug); err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for !mr.Exists("articles:"+original.Slug) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// Held entries are served without Redis
+	mr.FlushAll()
+	if got, err := first.GetByID(ctx, original.Slug); err != nil || !articleFixture.Equal(got, original) {
+		t.Errorf("GetByID() from the local tier = %+v, %v, want %+v", got, err, original)
+	}
+	loads := counted.loads.Load()
+
+	// A write on one instance drops the copy held by the other
+	revised := articleFixture.Modify(original)
+	if err := second.Update(ctx, revised); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		got, err := first.GetByID(ctx, original.Slug)
+		if err == nil && articleFixture.Equal(got, revised) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GetByID() after another instance updated = %+v, %v, want %+v", got, err, revised)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := counted.loads.Load(); got == loads {
+		t.Errorf("loads after update = %d, want the article loaded again", got)
+	}
+}
+
+func TestCachedRepositoryVersionedFills(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	// fillRacingUpdate reads an entity through the cache while it is updated,
+	// and returns what the cache holds once the read completed
+	fillRacingUpdate := func(t *testing.T, load func() error, update func() error, release chan struct{}, loaded func() bool) {
+		t.Helper()
+		done := make(chan error)
+		go func() { done <- load() }()
+		deadline := time.Now().Add(2 * time.Second)
+		for !loaded() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if err := update(); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Run("LoadStart", func(t *testing.T) {
+		articles := newArticleRepository(t)
+		original := articleFixture.New(0)
+		if err := articles.Create(ctx, original); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		slow := &slowRepository[article]{BaseRepository: articles, release: make(chan struct{})}
+		cache := repository.NewCachedRepository[article](client, slow, repository.CacheOptions[article]{
+			Namespace: "articles",
+			ID:        func(a article) string { return a.Slug },
+			TTL:       repository.FixedTTL(time.Hour),
+		})
+
+		revised := articleFixture.Modify(original)
+		fillRacingUpdate(t,
+			func() error { _, err := cache.GetByID(ctx, original.Slug); return err },
+			func() error { return cache.Update(ctx, revised) },
+			slow.release, func() bool { return slow.loads.Load() > 0 })
+		if mr.Exists("articles:" + original.Slug) {
+			t.Error("a fill that read before an update was cached")
+		}
+		if got, err := cache.GetByID(ctx, original.Slug); err != nil || !articleFixture.Equal(got, revised) {
+			t.Errorf("GetByID() = %+v, %v, want %+v", got, err, revised)
+		}
+	})
+
+	t.Run("UpdatedAt", func(t *testing.T) {
+		users := newBigQueryRepository(t)
+		original := userFixture.New(0)
+		if err := users.Create(ctx, original); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		slow := &slowRepository[entity.User]{BaseRepository: users, release: make(chan struct{})}
+		cache := repository.NewCachedRepository[entity.User](client, slow, repository.CacheOptions[entity.User]{
+			Namespace: "users",
+			ID:        func(user entity.User) string { return user.ID },
+			TTL:       repository.FixedTTL(time.Hour),
+			Version:   func(user entity.User) time.Time { return user.UpdatedAt },
+		})
+
+		updated := userFixture.Modify(original)
+		fillRacingUpdate(t,
+			func() error { _, err := cache.GetByID(ctx, original.ID); return err },
+			func() error { return cache.Update(ctx, updated) },
+			slow.release, func() bool { return slow.loads.Load() > 0 })
+		if mr.Exists("users:" + original.ID) {
+			t.Error("a fill that read before an update was cached")
+		}
+
+		// A fill of the current version is cached, even after the tombstone
+		if got, err := cache.GetByID(ctx, original.ID); err != nil || !userFixture.Equal(got, updated) {
+			t.Errorf("GetByID() = %+v, %v, want %+v", got, err, updated)
+		}
+		deadline := time.Now().Add(time.Second)
+		for !mr.Exists("users:"+original.ID) && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if !mr.Exists("users:" + original.ID) {
+			t.Error("a fill of the current version was not cached")
+		}
+	})
+
+	t.Run("MissingBeforeCreate", func(t *testing.T) {
+		users := newBigQueryRepository(t)
+		slow := &slowRepository[entity.User]{BaseRepository: users, release: make(chan struct{})}
+		cache := repository.NewCachedRepository[entity.User](client, slow, repository.CacheOptions[entity.User]{
+			Namespace: "users",
+			ID:        func(user entity.User) string { return user.ID },
+			TTL:       repository.TTLPolicy{Item: time.Hour, List: time.Hour, Missing: time.Minute},
+			Version:   func(user entity.User) time.Time { return user.UpdatedAt },
+		})
+
+		// The user was stamped before the lookup started, but the lookup
+		// read before the user was written
+		created := userFixture.New(1)
+		done := make(chan error)
+		go func() { _, err := cache.GetByID(ctx, created.ID); done <- err }()
+		deadline := time.Now().Add(2 * time.Second)
+		for slow.loads.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if err := cache.Create(ctx, created); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		close(slow.release)
+		if err := <-done; !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("GetByID() racing Create() error = %v, want ErrNotFound", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		if mr.Exists("users:" + created.ID) {
+			t.Error("a miss that read before a create was cached")
+		}
+		if got, err := cache.GetByID(ctx, created.ID); err != nil || !userFixture.Equal(got, created) {
+			t.Errorf("GetByID() after Create() = %+v, %v, want %+v", got, err, created)
+		}
+	})
+}
+
+func TestRedisMembershipRepositoryDeleteByUser(t *testing.T) {
+	ctx := context.Background()
+
+	schema, err := bigquery.InferSchema(entity.Membership{})
+	if err != nil {
+		t.Fatalf("failed to infer schema: %v", err)
+	}
+	fake := repositorytest.NewFakeBigQuery(t, testProject)
+	if err := fake.CreateTable(testDataset, "group_members", schema); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	primary := repository.NewBigQueryMembershipRepository(fake.Client(t), testProject, testDataset, "group_members", repository.Tenancy{})
+	repo := repository.NewRedisMembershipRepository(client, primary, time.Minute)
+
+	for i, m := range []entity.Membership{
+		{GroupID: "group-1", UserID: "user-1"},
+		{GroupID: "group-1", UserID: "user-2"},
+		{GroupID: "group-2", UserID: "user-1"},
+	} {
+		m.CreatedAt = baseTime.Add(time.Duration(i) * time.Minute)
+		if err := repo.AddMember(ctx, m); err != nil {
+			t.Fatalf("AddMember() error = %v", err)
+		}
+	}
+	// Adding an existing member is a no-op
+	if err := repo.AddMember(ctx, entity.Membership{GroupID: "group-1", UserID: "user-1", CreatedAt: baseTime}); err != nil {
+		t.Fatalf("AddMember() of existing member error = %v", err)
+	}
+
+	params := repository.PaginationParams{Page: 1, PageSize: 10}
+	members, err := repo.ListByGroup(ctx, "group-1", params)
+	if err != nil {
+		t.Fatalf("ListByGroup() error = %v", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("ListByGroup() returned %d members, want 2", len(members))
+	}
+
+	// Wait for the background cache fill so the delete has something to invalidate
+	deadline := time.Now().Add(time.Second)
+	for len(mr.Keys()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(mr.Keys()) == 0 {
+		t.Fatal("ListByGroup() did not cache the member page")
+	}
+
+	if err := repo.DeleteByUser(ctx, "user-1"); err != nil {
+		t.Fatalf("DeleteByUser() error = %v", err)
+	}
+
+	members, err = repo.ListByGroup(ctx, "group-1", params)
+	if err != nil {
+		t.Fatalf("ListByGroup() error = %v", err)
+	}
+	if len(members) != 1 || members[0].UserID != "user-2" {
+		t.Errorf("ListByGroup() after DeleteByUser() = %+v, want only user-2", members)
+	}
+
+	groups, err := repo.ListByUser(ctx, "user-1", params)
+	if err != nil {
+		t.Fatalf("ListByUser() error = %v", err)
+	}
+	if len(groups) != 0 {
+		t.Errorf("ListByUser() after DeleteByUser() = %+v, want none", groups)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	userSchema, err := bigquery.InferSchema(entity.User{})
+	if err != nil {
+		t.Fatalf("failed to infer schema: %v", err)
+	}
+	columnSchema := append(bigquery.Schema{{Name: "tenant_id", Type: bigquery.StringFieldType}}, userSchema...)
+
+	tests := []struct {
+		name    string
+		tenancy repository.Tenancy
+		tables  map[string]bigquery.Schema
+	}{
+		{
+			name:    "dataset",
+			tenancy: repository.Tenancy{Mode: repository.TenantModeDataset},
+			tables: map[string]bigquery.Schema{
+				testDataset + "_acme":   userSchema,
+				testDataset + "_globex": userSchema,
+			},
+		},
+		{
+			name:    "column",
+			tenancy: repository.Tenancy{Mode: repository.TenantModeColumn},
+			tables:  map[string]bigquery.Schema{testDataset: columnSchema},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := repositorytest.NewFakeBigQuery(t, testProject)
+			for dataset, schema := range tt.tables {
+				if err := fake.CreateTable(dataset, testTable, schema); err != nil {
+					t.Fatalf("failed to create table: %v", err)
+				}
+			}
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { client.Close() })
+
+			primary := repository.NewBigQueryRepository(fake.Client(t), testProject, testDataset, testTable, tt.tenancy)
+			repo := repository.NewRedisRepository(client, primary, time.Minute)
+
+			acme := tenant.WithID(context.Background(), "acme")
+			globex := tenant.WithID(context.Background(), "globex")
+
+			user := userFixture.New(0)
+			if err := repo.Create(acme, user); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if _, err := repo.GetByID(acme, user.ID); err != nil {
+				t.Fatalf("GetByID() for owning tenant error = %v", err)
+			}
+
+			// Wait for the background cache fill so the other tenant could hit it
+			deadline := time.Now().Add(time.Second)
+			for len(mr.Keys()) == 0 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			for _, key := range mr.Keys() {
+				if !strings.HasPrefix(key, "tenant:acme:") {
+					t.Errorf("cache key %q is not scoped to the tenant", key)
+				}
+			}
+
+			if _, err := repo.GetByID(globex, user.ID); !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("GetByID() for other tenant error = %v, want %v", err, repository.ErrNotFound)
+			}
+			users, err := repo.GetAll(globex, repository.PaginationParams{Page: 1, PageSize: 10})
+			if err != nil {
+				t.Fatalf("GetAll() error = %v", err)
+			}
+			if len(users) != 0 {
+				t.Errorf("GetAll() for other tenant = %+v, want none", users)
+			}
+			if err := repo.Delete(globex, user.ID); !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("Delete() for other tenant error = %v, want %v", err, repository.ErrNotFound)
+			}
+
+			if _, err := repo.GetByID(context.Background(), user.ID); !errors.Is(err, tenant.ErrMissing) {
+				t.Errorf("GetByID() without tenant error = %v, want %v", err, tenant.ErrMissing)
+			}
+		})
+	}
+}
+
+func TestRedisEmailIndex(t *testing.T) {
+	ctx := context.Background()
+
+	primary := newBigQueryRepository(t)
+	existing := userFixture.New(0)
+	if err := primary.Create(ctx, existing); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	index := repository.NewRedisEmailIndex(client, primary)
+
+	// Users stored before the index existed are found in BigQuery
+	if err := index.Reserve(ctx, existing.Email, "user-new"); !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("Reserve() of stored email error = %v, want %v", err, repository.ErrConflict)
+	}
+	if err := index.Reserve(ctx, existing.Email, existing.ID); err != nil {
+		t.Fatalf("Reserve() by owner error = %v", err)
+	}
+
+	if err := index.Reserve(ctx, "free@example.com", "user-a"); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if err := index.Reserve(ctx, "free@example.com", "user-a"); err != nil {
+		t.Errorf("Reserve() again by holder error = %v", err)
+	}
+	if err := index.Reserve(ctx, "free@example.com", "user-b"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("Reserve() of held email error = %v, want %v", err, repository.ErrConflict)
+	}
+
+	// Only the holder can release a reservation
+	if err := index.Release(ctx, "free@example.com", "user-b"); err != nil {
+		t.Fatalf("Release() by other user error = %v", err)
+	}
+	if err := index.Reserve(ctx, "free@example.com", "user-b"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("Reserve() after release by other user error = %v, want %v", err, repository.ErrConflict)
+	}
+	if err := index.Release(ctx, "free@example.com", "user-a"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if err := index.Reserve(ctx, "free@example.com", "user-b"); err != nil {
+		t.Errorf("Reserve() after release error = %v", err)
+	}
+
+	// A confirmed reservation whose holder does not store the email is taken over
+	if err := index.Confirm(ctx, "free@example.com", "user-b"); err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if err := index.Reserve(ctx, "free@example.com", "user-c"); err != nil {
+		t.Errorf("Reserve() of stale reservation error = %v", err)
+	}
+
+	// An unconfirmed reservation expires
+	if err := index.Reserve(ctx, "pending@example.com", "user-d"); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if err := index.Reserve(ctx, "pending@example.com", "user-e"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("Reserve() of pending email error = %v, want %v", err, repository.ErrConflict)
+	}
+	mr.FastForward(time.Minute)
+	if err := index.Reserve(ctx, "pending@example.com", "user-e"); err != nil {
+		t.Errorf("Reserve() after pending reservation expired error = %v", err)
+	}
+}
+
+func TestRedisRepositoryGetByEmail(t *testing.T) {
+	ctx := context.Background()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo := repository.NewRedisRepository(client, newBigQueryRepository(t), time.Minute)
+
+	user := userFixture.New(0)
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := repo.GetByEmail(ctx, user.Email)
+		if err != nil {
+			t.Fatalf("GetByEmail() error = %v", err)
+		}
+		if !userFixture.Equal(got, user) {
+			t.Errorf("GetByEmail() = %+v, want %+v", got, user)
+		}
+	}
+
+	oldEmail := user.Email
+	user = userFixture.Modify(user)
+	if err := repo.Update(ctx, user); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if _, err := repo.GetByEmail(ctx, oldEmail); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetByEmail() of old email error = %v, want %v", err, repository.ErrNotFound)
+	}
+	if got, err := repo.GetByEmail(ctx, user.Email); err != nil || got.ID != user.ID {
+		t.Errorf("GetByEmail() of new email = %+v, %v, want user %s", got, err, user.ID)
+	}
+
+	if err := repo.Delete(ctx, user.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := repo.GetByEmail(ctx, user.Email); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetByEmail() after Delete() error = %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func TestRedisRepositoryPurgeUser(t *testing.T) {
+	ctx := context.Background()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo := repository.NewRedisRepository(client, newBigQueryRepository(t), time.Minute)
+
+	user := userFixture.New(0)
+	other := userFixture.New(1)
+	for key, value := range map[string]string{
+		"users:" + user.ID:                                "{}",
+		"users:list:page_1:size_10":                       "[]",
+		"users_by_email:" + user.Email:                    user.ID,
+		"user_emails:" + user.Email:                       user.ID,
+		"memberships:user:" + user.ID + ":page_1:size_10": "[]",
+		"users:" + other.ID:                               "{}",
+		"user_emails:" + other.Email:                      other.ID,
+	} {
+		mr.Set(key, value)
+	}
+
+	purged, err := repo.PurgeUser(ctx, user)
+	if err != nil {
+		t.Fatalf("PurgeUser() error = %v", err)
+	}
+	if purged != 5 {
+		t.Errorf("PurgeUser() purged %d keys, want 5", purged)
+	}
+	if keys := mr.Keys(); len(keys) != 2 {
+		t.Errorf("keys after PurgeUser() = %v, want only the other user's", keys)
+	}
+}
+
+// newEnvelope loads a keyfile with the given primary key and one key per ID
+func newEnvelope(t *testing.T, primary string, ids ...string) *encryption.Envelope {
+	t.Helper()
+
+	key := func(seed string) string {
+		return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(seed, 32)[:32]))
+	}
+	var keys []string
+	for _, id := range ids {
+		keys = append(keys, fmt.Sprintf("%q: %q", id, key(id)))
+	}
+	data := fmt.Sprintf(`{"primary": %q, "keys": {%s}, "hmac_key": %q}`, primary, strings.Join(keys, ", "), key("h"))
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write keyfile: %v", err)
+	}
+	provider, err := encryption.LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyFile() error = %v", err)
+	}
+	return encryption.NewEnvelope(provider)
+}
+
+func TestEncryptedUserRepository(t *testing.T) {
+	ctx := context.Background()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	primary := newBigQueryRepository(t).WithEmailHMAC()
+	cache := repository.NewRedisRepository(client, primary, time.Minute)
+
+	repo, err := repository.NewEncryptedUserRepository(cache, newEnvelope(t, "k1", "k1"), []string{"name", "email"})
+	if err != nil {
+		t.Fatalf("NewEncryptedUserRepository() error = %v", err)
+	}
+
+	user := userFixture.New(0)
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := repo.GetByEmail(ctx, user.Email)
+		if err != nil {
+			t.Fatalf("GetByEmail() error = %v", err)
+		}
+		if !userFixture.Equal(got, user) || got.EmailHMAC != "" {
+			t.Errorf("GetByEmail() = %+v, want %+v", got, user)
+		}
+	}
+
+	stored, err := primary.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if !encryption.IsEncrypted(stored.Name) || !encryption.IsEncrypted(stored.Email) || stored.EmailHMAC == "" {
+		t.Errorf("stored user = %+v, want encrypted name and email", stored)
+	}
+	for _, key := range mr.Keys() {
+		value, _ := mr.Get(key)
+		if strings.Contains(key+value, user.Email) || strings.Contains(value, user.Name) {
+			t.Errorf("cache entry %s = %s contains plaintext", key, value)
+		}
+	}
+
+	if _, err := repository.NewEncryptedUserRepository(cache, newEnvelope(t, "k1", "k1"), []string{"phone"}); err == nil {
+		t.Error("NewEncryptedUserRepository() with unknown field error = nil")
+	}
+
+	rotated, err := repository.NewEncryptedUserRepository(cache, newEnvelope(t, "k2", "k1", "k2"), []string{"name", "email"})
+	if err != nil {
+		t.Fatalf("NewEncryptedUserRepository() error = %v", err)
+	}
+	reencrypted, changed, err := rotated.Reencrypt(ctx, stored)
+	if err != nil || !changed {
+		t.Fatalf("Reencrypt() = %v, %v, want a rotated user", changed, err)
+	}
+	if id, _ := encryption.KeyID(reencrypted.Email); id != "k2" || reencrypted.EmailHMAC != stored.EmailHMAC {
+		t.Errorf("Reencrypt() = %+v, want email under k2 with the same HMAC", reencrypted)
+	}
+	if _, changed, _ := rotated.Reencrypt(ctx, reencrypted); changed {
+		t.Error("Reencrypt() of a rotated user changed it again")
+	}
+}
+
+func TestBigQueryErasureRepository(t *testing.T) {
+	ctx := context.Background()
+	schema, err := bigquery.InferSchema(entity.ErasureTombstone{})
+	if err != nil {
+		t.Fatalf("failed to infer schema: %v", err)
+	}
+	fake := repositorytest.NewFakeBigQuery(t, testProject)
+	if err := fake.CreateTable(testDataset, "erasures", schema); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	// Tombstones are streamed in, so BigQuery refuses to update them for a while
+	if err := fake.KeepStreamingBuffer(testDataset, "erasures"); err != nil {
+		t.Fatalf("failed to keep streaming buffer: %v", err)
+	}
+	repo := repository.NewBigQueryErasureRepository(fake.Client(t), testProject, testDataset, "erasures", repository.Tenancy{})
+
+	pending := entity.ErasureTombstone{ID: "erasure-1", UserID: "user-1", EmailHash: "hash", Report: "{}"}
+	completed := pending
+	completed.Digest = "digest"
+	completed.CompletedAt = baseTime
+	earlier := entity.ErasureTombstone{ID: "erasure-0", UserID: "user-1", EmailHash: "hash", Report: "{}", Digest: "earlier", CompletedAt: baseTime.Add(-time.Hour)}
+	for _, tombstone := range []entity.ErasureTombstone{earlier, pending} {
+		if err := repo.Create(ctx, tombstone); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	if got, err := repo.GetByID(ctx, pending.ID); err != nil || !got.Pending() {
+		t.Errorf("GetByID() before completion = %+v, %v, want the pending tombstone", got, err)
+	}
+	if err := repo.Create(ctx, completed); err != nil {
+		t.Fatalf("Create() of the completed tombstone error = %v", err)
+	}
+	if got, err := repo.GetByID(ctx, pending.ID); err != nil || got.Digest != completed.Digest {
+		t.Errorf("GetByID() after completion = %+v, %v, want the completed tombstone", got, err)
+	}
+	found, err := repo.FindByUser(ctx, "user-1")
+	if err != nil || len(found) != 2 || found[0].Digest != completed.Digest || found[1].ID != earlier.ID {
+		t.Errorf("FindByUser() = %+v, %v, want the completed erasures, latest first", found, err)
+	}
+	if _, err := repo.GetByID(ctx, "erasure-2"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetByID() of unknown erasure error = %v, want %v", err, repository.ErrNotFound)
+	}
+
+	// The fake refuses DML on streamed rows like BigQuery does
+	if err := repo.Update(ctx, completed); err == nil {
+		t.Error("Update() of a streamed tombstone error = nil, want an error")
+	}
+}
+
+func TestNewSnapshotPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		retention time.Duration
+		wantErr   bool
+	}{
+		{retention: 0},
+		{retention: 48 * time.Hour},
+		{retention: 168 * time.Hour},
+		{retention: 24 * time.Hour, wantErr: true},
+		{retention: 50 * time.Hour, wantErr: true},
+		{retention: 192 * time.Hour, wantErr: true},
+	} {
+		policy, err := repository.NewSnapshotPolicy(tt.retention)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewSnapshotPolicy(%s) error = %v, wantErr %v", tt.retention, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			deleted := baseTime
+			if got := policy.PurgedBy(deleted); !got.After(deleted.Add(7 * 24 * time.Hour)) {
+				t.Errorf("NewSnapshotPolicy(%s).PurgedBy() = %s, want after the fail-safe period", tt.retention, got)
+			}
+		}
+	}
+}
+
+func TestDatasetSnapshotEnforcer(t *testing.T) {
+	fake := repositorytest.NewFakeBigQuery(t, testProject)
+	policy, err := repository.NewSnapshotPolicy(48 * time.Hour)
+	if err != nil {
+		t.Fatalf("NewSnapshotPolicy() error = %v", err)
+	}
+	enforcer := repository.NewDatasetSnapshotEnforcer(fake.Client(t), testProject, testDataset, repository.Tenancy{Mode: repository.TenantModeDataset}, policy)
+
+	if err := enforcer.Enforce(context.Background()); !errors.Is(err, tenant.ErrMissing) {
+		t.Errorf("Enforce() without tenant error = %v, want %v", err, tenant.ErrMissing)
+	}
+	if err := enforcer.Enforce(tenant.WithID(context.Background(), "acme")); err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	if got := fake.TimeTravel(testDataset + "_acme"); got != 48*time.Hour {
+		t.Errorf("time travel of tenant dataset = %s, want %s", got, 48*time.Hour)
+	}
+	if got := fake.TimeTravel(testDataset + "_globex"); got != 0 {
+		t.Errorf("time travel of other tenant dataset = %s, want it untouched", got)
+	}
+}
+
+func TestBigQueryRepositoryGetStats(t *testing.T) {
+	ctx := context.Background()
+	repo := newBigQueryRepository(t)
+
+	// Users 0-47 are created hourly from 2024-01-01, i.e. 24 per day
+	for i := 0; i < 48; i++ {
+		user := userFixture.New(i)
+		if i%3 == 0 {
+			user.Email = fmt.Sprintf("user%d@example.org", i)
+		}
+		if err := repo.Create(ctx, user); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	stats, err := repo.GetStats(ctx, repository.StatsQuery{
+		From:       baseTime.AddDate(0, 0, 1),
+		To:         baseTime.AddDate(0, 0, 7),
+		Interval:   entity.StatsIntervalDay,
+		TopDomains: 1,
+	})
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if stats.Total != 48 {
+		t.Errorf("GetStats() total = %d, want 48", stats.Total)
+	}
+	wantSignups := []entity.SignupCount{{Period: baseTime.AddDate(0, 0, 1), Count: 24}}
+	if len(stats.Signups) != 1 || !stats.Signups[0].Period.Equal(wantSignups[0].Period) || stats.Signups[0].Count != 24 {
+		t.Errorf("GetStats() signups = %+v, want %+v", stats.Signups, wantSignups)
+	}
+	if len(stats.TopDomains) != 1 || stats.TopDomains[0] != (entity.DomainCount{Domain: "example.com", Count: 32}) {
+		t.Errorf("GetStats() top domains = %+v, want example.com with 32 users", stats.TopDomains)
+	}
+
+	// 2024-01-01 is a Monday, so both days fall into one ISO week
+	weekly, err := repo.GetStats(ctx, repository.StatsQuery{
+		From:       baseTime,
+		To:         baseTime.AddDate(0, 0, 7),
+		Interval:   entity.StatsIntervalWeek,
+		TopDomains: 10,
+	})
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+	if len(weekly.Signups) != 1 || weekly.Signups[0].Count != 48 {
+		t.Errorf("GetStats() weekly signups = %+v, want one week of 48", weekly.Signups)
+	}
+	if len(weekly.TopDomains) != 2 {
+		t.Errorf("GetStats() top domains = %+v, want 2 domains", weekly.TopDomains)
+	}
+}
+
+func TestRedisRepositoryUpdateColumns(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	primary := newBigQueryRepository(t)
+	repo := repository.NewRedisRepository(client, primary, time.Hour)
+
+	user := userFixture.New(0)
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := repo.GetByID(ctx, user.ID); err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+
+	// Only the named columns are written and the cached copy is dropped
+	changed := user
+	changed.Name, changed.Email = "Changed", "changed@example.com"
+	changed.UpdatedAt = user.UpdatedAt.Add(time.Minute)
+	if err := repo.UpdateColumns(ctx, changed, []string{"name", "updated_at"}); err != nil {
+		t.Fatalf("UpdateColumns() error = %v", err)
+	}
+	got, err := repo.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if got.Name != "Changed" || got.Email != user.Email || !got.UpdatedAt.Equal(changed.UpdatedAt) || !got.CreatedAt.Equal(user.CreatedAt) {
+		t.Errorf("GetByID() after UpdateColumns() = %+v, want only the name and updated_at changed", got)
+	}
+
+	if err := repo.UpdateColumns(ctx, changed, []string{"created_at"}); err == nil {
+		t.Error("UpdateColumns() of an immutable column succeeded, want an error")
+	}
+	missing := userFixture.New(1)
+	if err := repo.UpdateColumns(ctx, missing, []string{"name"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateColumns() of a missing user error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRedisRepositoryWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	primary := newBigQueryRepository(t)
+	repo := repository.NewRedisRepository(client, primary, time.Hour)
+	repo.WithWriteStrategy(repository.CacheWriteThrough)
+
+	user := userFixture.New(0)
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if !mr.Exists("users:" + user.ID) {
+		t.Error("Create() did not cache the user")
+	}
+
+	// A write older than the cached version leaves the cache alone
+	updated := userFixture.Modify(user)
+	if err := repo.Update(ctx, updated); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	stale := user
+	stale.Name = "Stale"
+	if err := repo.Update(ctx, stale); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	// Reads are served from the cache without BigQuery
+	if err := primary.Delete(ctx, user.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got, err := repo.GetByID(ctx, user.ID); err != nil || !userFixture.Equal(got, updated) {
+		t.Errorf("GetByID() = %+v, %v, want the cached %+v", got, err, updated)
+	}
+
+	// A deletion is newer than any write that started before it
+	if err := primary.Create(ctx, updated); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := repo.Delete(ctx, user.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if mr.Exists("users:" + user.ID) {
+		t.Error("Delete() left the user cached")
+	}
+	if err := primary.Create(ctx, updated); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := repo.Update(ctx, updated); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if mr.Exists("users:" + user.ID) {
+		t.Error("Update() older than a deletion cached the user")
+	}
+
+	if _, err := repository.ParseCacheWriteStrategy("write-back"); err == nil {
+		t.Error("ParseCacheWriteStrategy() of an unknown strategy error = nil")
+	}
+}
+
+func TestRedisRepositoryWarmup(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	primary := newBigQueryRepository(t)
+	repo := repository.NewRedisRepository(client, primary, time.Hour)
+	repo.WithAccessTracking(1)
+
+	for i := 0; i < 3; i++ {
+		if err := primary.Create(ctx, userFixture.New(i)); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	updated := userFixture.Modify(userFixture.New(0))
+	updated.UpdatedAt = baseTime.Add(24 * time.Hour)
+	if err := primary.Update(ctx, updated); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	loadedAt := time.Now()
+	recent, err := primary.GetRecentlyUpdated(ctx, 2)
+	if err != nil {
+		t.Fatalf("GetRecentlyUpdated() error = %v", err)
+	}
+	if len(recent) != 2 || recent[0].ID != "user-0" || recent[1].ID != "user-2" {
+		t.Fatalf("GetRecentlyUpdated() = %+v, want user-0 and user-2", recent)
+	}
+
+	// A user deleted after it was loaded is not cached again
+	if err := repo.Delete(ctx, "user-2"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	primed, err := repo.Prime(ctx, recent, loadedAt)
+	if err != nil || primed != 1 {
+		t.Fatalf("Prime() = %d, %v, want 1", primed, err)
+	}
+	if !mr.Exists("users:user-0") || mr.Exists("users:user-2") {
+		t.Errorf("Prime() cached %v, want only users:user-0", mr.Keys())
+	}
+
+	// Reads are counted in the background, so each is awaited before the next
+	read := func(id string, count float64) {
+		t.Helper()
+		if _, err := repo.GetByID(ctx, id); err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for score, _ := mr.ZScore("users_by_access", id); score != count; score, _ = mr.ZScore("users_by_access", id) {
+			if time.Now().After(deadline) {
+				t.Fatalf("read count of %s = %v, want %v", id, score, count)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	read("user-1", 1)
+	read("user-1", 2)
+	read("user-0", 1)
+	if ids, err := repo.FrequentUserIDs(ctx, 5); err != nil || strings.Join(ids, ",") != "user-1,user-0" {
+		t.Errorf("FrequentUserIDs() = %v, %v, want [user-1 user-0]", ids, err)
+	}
+
+	// Beyond twice the tracked users, the least read are dropped
+	if err := primary.Create(ctx, userFixture.New(3)); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := repo.GetByID(ctx, "user-3"); err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for members, _ := mr.ZMembers("users_by_access"); len(members) != 1; members, _ = mr.ZMembers("users_by_access") {
+		if time.Now().After(deadline) {
+			t.Fatalf("tracked users = %v, want 1", members)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ids, err := repo.FrequentUserIDs(ctx, 5); err != nil || strings.Join(ids, ",") != "user-1" {
+		t.Errorf("FrequentUserIDs() = %v, %v, want [user-1]", ids, err)
+	}
+}
+
+func TestRedisRepositoryCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	primary := newBigQueryRepository(t)
+	repo := repository.NewRedisRepository(client, primary, time.Hour)
+	breaker := repository.NewCircuitBreaker("test", repository.CircuitBreakerSettings{
+		Failures:    2,
+		OpenTimeout: 200 * time.Millisecond,
+		Probes:      1,
+	})
+	repo.WithCircuitBreaker(breaker)
+
+	user := userFixture.New(0)
+	if err := primary.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// Cache misses are answers, not failures
+	for i := 0; i < 3; i++ {
+		if _, err := repo.GetByID(ctx, fmt.Sprintf("user-%d", i)); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+	}
+	if state := breaker.State(); state != repository.CircuitClosed {
+		t.Fatalf("State() after cache misses = %s, want %s", state, repository.CircuitClosed)
+	}
+
+	// Failing calls open the breaker, and reads keep being served by BigQuery
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	deadline := time.Now().Add(time.Second)
+	for breaker.State() != repository.CircuitOpen {
+		if time.Now().After(deadline) {
+			t.Fatalf("State() while Redis fails = %s, want %s", breaker.State(), repository.CircuitOpen)
+		}
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Fatalf("GetByID() while Redis fails error = %v", err)
+		}
+	}
+	if got, err := repo.GetByID(ctx, user.ID); err != nil || !userFixture.Equal(got, user) {
+		t.Errorf("GetByID() while open = %+v, %v, want %+v", got, err, user)
+	}
+
+	// Writes while open still invalidate, in the background, once Redis answers
+	mr.SetError("")
+	mr.Set("users:"+user.ID, "stale")
+	updated := userFixture.Modify(user)
+	if err := repo.Update(ctx, updated); err != nil {
+		t.Fatalf("Update() while open error = %v", err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for mr.Exists("users:" + user.ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("Update() while open left the user cached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if state := breaker.State(); state != repository.CircuitOpen {
+		t.Errorf("State() before the open timeout = %s, want %s", state, repository.CircuitOpen)
+	}
+
+	// After the open timeout a successful probe closes the breaker
+	time.Sleep(250 * time.Millisecond)
+	if state := breaker.State(); state != repository.CircuitHalfOpen {
+		t.Errorf("State() after the open timeout = %s, want %s", state, repository.CircuitHalfOpen)
+	}
+	if got, err := repo.GetByID(ctx, user.ID); err != nil || !userFixture.Equal(got, updated) {
+		t.Errorf("GetByID() after recovery = %+v, %v, want %+v", got, err, updated)
+	}
+	if state := breaker.State(); state != repository.CircuitClosed {
+		t.Errorf("State() after a successful probe = %s, want %s", state, repository.CircuitClosed)
+	}
+}
+
+func TestRedisRepositoryCircuitBreakerReplaysInvalidations(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	primary := newBigQueryRepository(t)
+	repo := repository.NewRedisRepository(client, primary, time.Hour)
+	breaker := repository.NewCircuitBreaker("test", repository.CircuitBreakerSettings{
+		Failures:    2,
+		OpenTimeout: 200 * time.Millisecond,
+		Probes:      1,
+	})
+	repo.WithCircuitBreaker(breaker)
+
+	// Cache the user before Redis fails
+	user := userFixture.New(0)
+	if err := primary.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := repo.GetByID(ctx, user.ID); err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !mr.Exists("users:"+user.ID) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Unlike LOADING, this error is not retried by the client
+	mr.SetError("ERR simulated outage")
+	deadline = time.Now().Add(time.Second)
+	for breaker.State() != repository.CircuitOpen {
+		if time.Now().After(deadline) {
+			t.Fatalf("State() while Redis fails = %s, want %s", breaker.State(), repository.CircuitOpen)
+		}
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Fatalf("GetByID() while Redis fails error = %v", err)
+		}
+	}
+
+	// The write cannot invalidate while Redis fails
+	updated := userFixture.Modify(user)
+	if err := repo.Update(ctx, updated); err != nil {
+		t.Fatalf("Update() while open error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mr.SetError("")
+	if !mr.Exists("users:" + user.ID) {
+		t.Fatal("Update() while Redis fails dropped the cached user, want it left behind")
+	}
+
+	// The probe replays the invalidation before reading the cache
+	time.Sleep(250 * time.Millisecond)
+	if got, err := repo.GetByID(ctx, user.ID); err != nil || !userFixture.Equal(got, updated) {
+		t.Errorf("GetByID() after recovery = %+v, %v, want %+v", got, err, updated)
+	}
+	if state := breaker.State(); state != repository.CircuitClosed {
+		t.Errorf("State() after recovery = %s, want %s", state, repository.CircuitClosed)
+	}
+}
+
+func TestRedisStatsRepository(t *testing.T) {
+	ctx := context.Background()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	primary := newBigQueryRepository(t)
+	users := repository.NewRedisRepository(client, primary, time.Minute)
+	stats := repository.NewRedisStatsRepository(client, primary, time.Minute)
+
+	query := repository.StatsQuery{From: baseTime, To: baseTime.AddDate(0, 0, 1), Interval: entity.StatsIntervalDay, TopDomains: 10}
+	waitForTotal := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			got, err := stats.GetStats(ctx, query)
+			if err != nil {
+				t.Fatalf("GetStats() error = %v", err)
+			}
+			if got.Total == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("GetStats() total = %d, want %d", got.Total, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := primary.Create(ctx, userFixture.New(0)); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	waitForTotal(1)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(mr.Keys()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Writes that bypass the cache are not seen until the cached statistics expire
+	if err := primary.Create(ctx, userFixture.New(1)); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if got, err := stats.GetStats(ctx, query); err != nil || got.Total != 1 {
+		t.Errorf("GetStats() from cache = %d, %v, want 1", got.Total, err)
+	}
+
+	// A write through the cache makes the next read recompute
+	if err := users.Create(ctx, userFixture.New(2)); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	waitForTotal(3)
+}
+
+func TestRedisChangeFeed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	feed := repository.NewRedisChangeFeed(client, 100)
+
+	receive := func(changes <-chan entity.UserChange) entity.UserChange {
+		t.Helper()
+		select {
+		case change := <-changes:
+			return change
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for a change")
+			return entity.UserChange{}
+		}
+	}
+
+	live, err := feed.Subscribe(ctx, "")
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	var ids []string
+	for i, changeType := range []string{entity.ChangeCreated, entity.ChangeUpdated, entity.ChangeDeleted} {
+		id, err := feed.Publish(ctx, entity.UserChange{Type: changeType, UserID: fmt.Sprintf("user-%d", i), OccurredAt: baseTime})
+		if err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+		ids = append(ids, id)
+	}
+	for i, id := range ids {
+		if change := receive(live); change.ID != id || change.UserID != fmt.Sprintf("user-%d", i) {
+			t.Errorf("live change = %+v, want %s of user-%d", change, id, i)
+		}
+	}
+
+	// Resuming replays the changes after the last seen one, then continues live
+	resumed, err := feed.Subscribe(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	for _, id := range ids[1:] {
+		if change := receive(resumed); change.ID != id {
+			t.Errorf("replayed change = %s, want %s", change.ID, id)
+		}
+	}
+	id, err := feed.Publish(ctx, entity.UserChange{Type: entity.ChangeCreated, UserID: "user-3"})
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if change := receive(resumed); change.ID != id {
+		t.Errorf("change after replay = %s, want %s", change.ID, id)
+	}
+
+	// Publishing an outbox event again returns the ID it was first published under
+	relayed := entity.UserChange{EventID: "event-1", Type: entity.ChangeDeleted, UserID: "user-3"}
+	first, err := feed.Publish(ctx, relayed)
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if again, err := feed.Publish(ctx, relayed); err != nil || again != first {
+		t.Errorf("Publish() of a published event = %s, %v, want %s", again, err, first)
+	}
+	if change := receive(resumed); change.ID != first || change.EventID != "event-1" {
+		t.Errorf("relayed change = %+v, want %s", change, first)
+	}
+	if n := client.XLen(ctx, "user_changes").Val(); n != 5 {
+		t.Errorf("stream length = %d, want 5", n)
+	}
+
+	if _, err := feed.Subscribe(ctx, "yesterday"); !errors.Is(err, repository.ErrInvalidEventID) {
+		t.Errorf("Subscribe() with malformed ID error = %v, want %v", err, repository.ErrInvalidEventID)
+	}
+
+	cancel()
+	for range resumed {
+	}
+}
+
+// webhookFixture builds webhooks whose creation times increase with i
+var webhookFixture = repositorytest.Fixture[entity.Webhook]{
+	New: func(i int) entity.Webhook {
+		created := baseTime.Add(time.Duration(i) * time.Hour)
+		return entity.Webhook{
+			ID:        fmt.Sprintf("webhook-%d", i),
+			URL:       fmt.Sprintf("https://hooks.example.com/%d", i),
+			Secret:    fmt.Sprintf("secret-%d", i),
+			Events:    []string{entity.WebhookUserCreated},
+			Active:    true,
+			CreatedAt: created,
+			UpdatedAt: created,
+		}
+	},
+	ID: func(webhook entity.Webhook) string {
+		return webhook.ID
+	},
+	Modify: func(webhook entity.Webhook) entity.Webhook {
+		webhook.URL += "/v2"
+		webhook.Events = append(webhook.Events, entity.WebhookUserDeleted)
+		webhook.Active = false
+		webhook.UpdatedAt = webhook.UpdatedAt.Add(time.Minute)
+		return webhook
+	},
+	Equal: func(a, b entity.Webhook) bool {
+		return a.ID == b.ID &&
+			a.URL == b.URL &&
+			a.Secret == b.Secret &&
+			strings.Join(a.Events, ",") == strings.Join(b.Events, ",") &&
+			a.Active == b.Active &&
+			a.CreatedAt.Equal(b.CreatedAt) &&
+			a.UpdatedAt.Equal(b.UpdatedAt)
+	},
+}
+
+func newBigQueryWebhookRepository(t *testing.T) *repository.BigQueryWebhookRepository {
+	t.Helper()
+
+	schema, err := bigquery.InferSchema(entity.Webhook{})
+	if err != nil {
+		t.Fatalf("failed to infer schema: %v", err)
+	}
+	fake := repositorytest.NewFakeBigQuery(t, testProject)
+	if err := fake.CreateTable(testDataset, "webhooks", schema); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	return repository.NewBigQueryWebhookRepository(fake.Client(t), testProject, testDataset, "webhooks", repository.Tenancy{})
+}
+
+func TestRedisWebhookRepository(t *testing.T) {
+	newRepo := func(t *testing.T) *repository.RedisWebhookRepository {
+		client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		return repository.NewRedisWebhookRepository(client, newBigQueryWebhookRepository(t), time.Minute)
+	}
+	repositorytest.Run(t, func(t *testing.T) repository.BaseRepository[entity.Webhook] {
+		return newRepo(t)
+	}, webhookFixture)
+
+	t.Run("ListActive", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		active, inactive := webhookFixture.New(0), webhookFixture.New(1)
+		inactive.Active = false
+		for _, webhook := range []entity.Webhook{active, inactive} {
+			if err := repo.Create(ctx, webhook); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		}
+
+		got, err := repo.ListActive(ctx)
+		if err != nil {
+			t.Fatalf("ListActive() error = %v", err)
+		}
+		if len(got) != 1 || !webhookFixture.Equal(got[0], active) {
+			t.Errorf("ListActive() = %+v, want [%+v]", got, active)
+		}
+
+		// Deactivating a webhook invalidates the cached list
+		if err := repo.Update(ctx, webhookFixture.Modify(active)); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if got, err := repo.ListActive(ctx); err != nil || len(got) != 0 {
+			t.Errorf("ListActive() after deactivation = %+v, %v, want none", got, err)
+		}
+	})
+}
+
+// readGate holds loads once they read until released, after which loads no longer wait
+type readGate struct {
+	read    chan struct{}
+	release chan struct{}
+}
+
+func newReadGate() readGate {
+	return readGate{read: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (g readGate) hold() {
+	select {
+	case <-g.release:
+		return
+	default:
+	}
+	g.read <- struct{}{}
+	<-g.release
+}
+
+// gatedWebhooks holds the active webhooks it read
+type gatedWebhooks struct {
+	repository.WebhookRepository
+	readGate
+}
+
+func (r gatedWebhooks) ListActive(ctx context.Context) ([]entity.Webhook, error) {
+	webhooks, err := r.WebhookRepository.ListActive(ctx)
+	r.hold()
+	return webhooks, err
+}
+
+// gatedMemberships holds the member pages it read
+type gatedMemberships struct {
+	repository.MembershipRepository
+	readGate
+}
+
+func (r gatedMemberships) ListByGroup(ctx context.Context, groupID string, params repository.PaginationParams) ([]entity.Membership, error) {
+	memberships, err := r.MembershipRepository.ListByGroup(ctx, groupID, params)
+	r.hold()
+	return memberships, err
+}
+
+// gatedStats holds the statistics it computed
+type gatedStats struct {
+	repository.StatsRepository
+	readGate
+}
+
+func (r gatedStats) GetStats(ctx context.Context, q repository.StatsQuery) (entity.UserStats, error) {
+	stats, err := r.StatsRepository.GetStats(ctx, q)
+	r.hold()
+	return stats, err
+}
+
+func TestBackgroundFillsRacingWrites(t *testing.T) {
+	ctx := context.Background()
+
+	// fillRacingWrite reads through a cache while something is written, and
+	// waits for the background fill of the read
+	fillRacingWrite := func(t *testing.T, gate readGate, read func() error, write func() error) {
+		t.Helper()
+		done := make(chan error)
+		go func() { done <- read() }()
+		<-gate.read
+		if err := write(); err != nil {
+			t.Fatalf("write error = %v", err)
+		}
+		close(gate.release)
+		if err := <-done; err != nil {
+			t.Fatalf("read error = %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Run("ActiveWebhooks", func(t *testing.T) {
+		client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		t.Cleanup(func() { client.Close() })
+		gated := gatedWebhooks{WebhookRepository: newBigQueryWebhookRepository(t), readGate: newReadGate()}
+		repo := repository.NewRedisWebhookRepository(client, gated, time.Minute)
+
+		active := webhookFixture.New(0)
+		if err := repo.Create(ctx, active); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		fillRacingWrite(t, gated.readGate,
+			func() error { _, err := repo.ListActive(ctx); return err },
+			func() error { return repo.Update(ctx, webhookFixture.Modify(active)) })
+
+		if got, err := repo.ListActive(ctx); err != nil || len(got) != 0 {
+			t.Errorf("ListActive() after deactivation = %+v, %v, want none", got, err)
+		}
+	})
+
+	t.Run("Memberships", func(t *testing.T) {
+		schema, err := bigquery.InferSchema(entity.Membership{})
+		if err != nil {
+			t.Fatalf("failed to infer schema: %v", err)
+		}
+		fake := repositorytest.NewFakeBigQuery(t, testProject)
+		if err := fake.CreateTable(testDataset, "group_members", schema); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+		client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		t.Cleanup(func() { client.Close() })
+		gated := gatedMemberships{
+			MembershipRepository: repository.NewBigQueryMembershipRepository(fake.Client(t), testProject, testDataset, "group_members", repository.Tenancy{}),
+			readGate:             newReadGate(),
+		}
+		repo := repository.NewRedisMembershipRepository(client, gated, time.Minute)
+
+		if err := repo.AddMember(ctx, entity.Membership{GroupID: "group-1", UserID: "user-1", CreatedAt: baseTime}); err != nil {
+			t.Fatalf("AddMember() error = %v", err)
+		}
+		params := repository.PaginationParams{Page: 1, PageSize: 10}
+		fillRacingWrite(t, gated.readGate,
+			func() error { _, err := repo.ListByGroup(ctx, "group-1", params); return err },
+			func() error { return repo.RemoveMember(ctx, "group-1", "user-1") })
+
+		if got, err := repo.ListByGroup(ctx, "group-1", params); err != nil || len(got) != 0 {
+			t.Errorf("ListByGroup() after RemoveMember() = %+v, %v, want none", got, err)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { client.Close() })
+		primary := newBigQueryRepository(t)
+		users := repository.NewRedisRepository(client, primary, time.Minute)
+		gated := gatedStats{StatsRepository: primary, readGate: newReadGate()}
+		stats := repository.NewRedisStatsRepository(client, gated, time.Minute)
+
+		if err := primary.Create(ctx, userFixture.New(0)); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		query := repository.StatsQuery{From: baseTime, To: baseTime.AddDate(0, 0, 1), Interval: entity.StatsIntervalDay, TopDomains: 10}
+		fillRacingWrite(t, gated.readGate,
+			func() error { _, err := stats.GetStats(ctx, query); return err },
+			func() error {
+				if err := users.Create(ctx, userFixture.New(1)); err != nil {
+					return err
+				}
+				// The write is recorded in the background
+				deadline := time.Now().Add(time.Second)
+				for !mr.Exists("written:user_stats:") && time.Now().Before(deadline) {
+					time.Sleep(5 * time.Millisecond)
+				}
+				return nil
+			})
+
+		for _, key := range mr.Keys() {
+			if strings.HasPrefix(key, "user_stats:v0:") {
+				t.Errorf("statistics computed before a write were cached under %s", key)
+			}
+		}
+		if got, err := stats.GetStats(ctx, query); err != nil || got.Total != 2 {
+			t.Errorf("GetStats() after Create() = %d, %v, want 2", got.Total, err)
+		}
+	})
+}
+
+func TestRedisWebhookQueue(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	queue := repository.NewRedisWebhookQueue(client, time.Hour)
+
+	now := baseTime
+	newDelivery := func(id, webhookID string, due time.Time) entity.WebhookDelivery {
+		return entity.WebhookDelivery{
+			ID:            id,
+			WebhookID:     webhookID,
+			Event:         entity.WebhookEvent{ID: "event-" + id, Type: entity.WebhookUserCreated, UserID: "user-1"},
+			Status:        entity.DeliveryPending,
+			NextAttemptAt: &due,
+			CreatedAt:     now,
+		}
+	}
+	tenantCtx := tenant.WithID(ctx, "acme")
+	for _, d := range []struct {
+		ctx      context.Context
+		delivery entity.WebhookDelivery
+	}{
+		{ctx, newDelivery("d1", "webhook-1", now)},
+		{ctx, newDelivery("d2", "webhook-1", now.Add(time.Minute))},
+		{tenantCtx, newDelivery("d3", "webhook-1", now)},
+	} {
+		if err := queue.Enqueue(d.ctx, d.delivery); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	// Only due deliveries are claimed, each with its tenant, and claiming leases them
+	claimed, err := queue.Claim(ctx, now, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	want := map[repository.QueuedDelivery]bool{{DeliveryID: "d1"}: true, {Tenant: "acme", DeliveryID: "d3"}: true}
+	if len(claimed) != len(want) || !want[claimed[0]] || !want[claimed[1]] {
+		t.Errorf("Claim() = %+v, want d1 and acme/d3", claimed)
+	}
+	if again, err := queue.Claim(ctx, now, 10, time.Minute); err != nil || len(again) != 0 {
+		t.Errorf("Claim() during lease = %+v, %v, want none", again, err)
+	}
+
+	// An expired lease makes a delivery due again, together with later deliveries
+	claimed, err = queue.Claim(ctx, now.Add(2*time.Minute), 10, time.Minute)
+	if err != nil || len(claimed) != 3 {
+		t.Errorf("Claim() after lease = %+v, %v, want 3 deliveries", claimed, err)
+	}
+
+	// Saving a finished delivery removes it from the queue and keeps its record
+	done := newDelivery("d1", "webhook-1", now)
+	done.Status, done.Attempts, done.NextAttemptAt = entity.DeliverySucceeded, 1, nil
+	if err := queue.Save(ctx, done); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	retry := now.Add(time.Hour)
+	failed := newDelivery("d2", "webhook-1", retry)
+	failed.Attempts, failed.LastError = 1, "unexpected status 500"
+	if err := queue.Save(ctx, failed); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := queue.Drop(tenantCtx, "d3"); err != nil {
+		t.Fatalf("Drop() error = %v", err)
+	}
+	claimed, err = queue.Claim(ctx, retry, 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].DeliveryID != "d2" {
+		t.Errorf("Claim() after Save() = %+v, %v, want d2", claimed, err)
+	}
+
+	got, err := queue.Get(ctx, "d1")
+	if err != nil || got.Status != entity.DeliverySucceeded || got.Attempts != 1 {
+		t.Errorf("Get() = %+v, %v, want succeeded after 1 attempt", got, err)
+	}
+	if _, err := queue.Get(ctx, "d3"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get() of another tenant's delivery error = %v, want ErrNotFound", err)
+	}
+
+	// The log lists a webhook's deliveries newest first, per tenant
+	deliveries, err := queue.ListByWebhook(ctx, "webhook-1", 10)
+	if err != nil {
+		t.Fatalf("ListByWebhook() error = %v", err)
+	}
+	if len(deliveries) != 2 || deliveries[0].ID != "d2" || deliveries[1].ID != "d1" {
+		t.Errorf("ListByWebhook() = %+v, want d2 then d1", deliveries)
+	}
+	if deliveries, err := queue.ListByWebhook(tenantCtx, "webhook-1", 10); err != nil || len(deliveries) != 1 || deliveries[0].ID != "d3" {
+		t.Errorf("ListByWebhook() for tenant = %+v, %v, want d3", deliveries, err)
+	}
+
+	// Enqueueing an existing delivery changes nothing
+	if err := queue.Enqueue(ctx, newDelivery("d1", "webhook-1", now)); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if deliveries, err := queue.ListByWebhook(ctx, "webhook-1", 10); err != nil || len(deliveries) != 2 || deliveries[1].Status != entity.DeliverySucceeded {
+		t.Errorf("ListByWebhook() after repeated Enqueue() = %+v, %v, want d2 then succeeded d1", deliveries, err)
+	}
+
+	// Expired records are skipped
+	mr.FastForward(2 * time.Hour)
+	if deliveries, err := queue.ListByWebhook(ctx, "webhook-1", 10); err != nil || len(deliveries) != 0 {
+		t.Errorf("ListByWebhook() after retention = %+v, %v, want none", deliveries, err)
+	}
+}
+
+func TestRedisOutbox(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	outbox := repository.NewRedisOutbox(client)
+
+	newEvent := func(id string, occurred time.Time) entity.OutboxEvent {
+		return entity.OutboxEvent{ID: id, Type: entity.ChangeCreated, UserID: "user-" + id, OccurredAt: occurred}
+	}
+	tenantCtx := tenant.WithID(ctx, "acme")
+	for _, e := range []struct {
+		ctx   context.Context
+		event entity.OutboxEvent
+	}{
+		{ctx, newEvent("e1", baseTime.Add(time.Second))},
+		{tenantCtx, newEvent("e2", baseTime)},
+		{ctx, newEvent("e3", baseTime.Add(2*time.Second))},
+	} {
+		if err := outbox.Record(e.ctx, e.event); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	// Unconfirmed events are not claimed
+	if claimed, err := outbox.Claim(ctx, time.Now(), 10, time.Minute); err != nil || len(claimed) != 0 {
+		t.Errorf("Claim() before Confirm() = %+v, %v, want none", claimed, err)
+	}
+	if err := outbox.Confirm(ctx, "e1"); err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if err := outbox.Confirm(tenantCtx, "e2"); err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if err := outbox.Confirm(ctx, "e2"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Confirm() of another tenant's event error = %v, want ErrNotFound", err)
+	}
+
+	// Events left unconfirmed are listed for reconciliation, and may be discarded
+	unconfirmed, err := outbox.Unconfirmed(ctx, time.Now().Add(time.Second), 10)
+	if err != nil || len(unconfirmed) != 1 || unconfirmed[0].Event.ID != "e3" {
+		t.Errorf("Unconfirmed() = %+v, %v, want e3", unconfirmed, err)
+	}
+	if unconfirmed, err := outbox.Unconfirmed(ctx, time.Now().Add(-time.Minute), 10); err != nil || len(unconfirmed) != 0 {
+		t.Errorf("Unconfirmed() before recording = %+v, %v, want none", unconfirmed, err)
+	}
+	if err := outbox.Discard(ctx, "e3"); err != nil {
+		t.Fatalf("Discard() error = %v", err)
+	}
+	if err := outbox.Confirm(ctx, "e3"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Confirm() of a discarded event error = %v, want ErrNotFound", err)
+	}
+
+	unconfirmedCount, ready, oldest, err := outbox.Backlog(ctx)
+	if err != nil || unconfirmedCount != 0 || ready != 2 || !oldest.Equal(baseTime) {
+		t.Errorf("Backlog() = %d, %d, %v, %v, want 0 unconfirmed and 2 ready since %v", unconfirmedCount, ready, oldest, err, baseTime)
+	}
+
+	// Ready events are claimed oldest first with their tenant, and leased
+	now := time.Now()
+	claimed, err := outbox.Claim(ctx, now, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if len(claimed) != 2 || claimed[0].Tenant != "acme" || claimed[0].Event.ID != "e2" || claimed[1].Event.ID != "e1" {
+		t.Errorf("Claim() = %+v, want acme/e2 then e1", claimed)
+	}
+	if again, err := outbox.Claim(ctx, now, 10, time.Minute); err != nil || len(again) != 0 {
+		t.Errorf("Claim() during lease = %+v, %v, want none", again, err)
+	}
+
+	// Sinks that published an event are remembered until it is completed
+	if err := outbox.MarkSent(ctx, "e1", "redis_stream"); err != nil {
+		t.Fatalf("MarkSent() error = %v", err)
+	}
+	if sent, err := outbox.Sent(ctx, "e1"); err != nil || len(sent) != 1 || sent[0] != "redis_stream" {
+		t.Errorf("Sent() = %v, %v, want [redis_stream]", sent, err)
+	}
+	if err := outbox.Complete(tenantCtx, "e2"); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+
+	// An expired lease makes the unfinished event claimable again
+	claimed, err = outbox.Claim(ctx, now.Add(2*time.Minute), 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].Event.ID != "e1" {
+		t.Errorf("Claim() after lease = %+v, %v, want e1", claimed, err)
+	}
+	if err := outbox.Complete(ctx, "e1"); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if sent, err := outbox.Sent(ctx, "e1"); err != nil || len(sent) != 0 {
+		t.Errorf("Sent() after Complete() = %v, %v, want none", sent, err)
+	}
+	if _, ready, _, err := outbox.Backlog(ctx); err != nil || ready != 0 {
+		t.Errorf("Backlog() after Complete() = %d ready, %v, want none", ready, err)
+	}
+}
+
+func TestRedisIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	store := repository.NewRedisIdempotencyStore(client)
+
+	if _, reserved, err := store.Reserve(ctx, "key-1", "fp-1", time.Minute); err != nil || !reserved {
+		t.Fatalf("Reserve() = %v, %v, want reserved", reserved, err)
+	}
+	held, reserved, err := store.Reserve(ctx, "key-1", "fp-2", time.Minute)
+	if err != nil || reserved || held.Fingerprint != "fp-1" || held.Status != 0 {
+		t.Errorf("Reserve() of a reserved key = %+v, %v, %v, want fp-1 in progress", held, reserved, err)
+	}
+
+	// Keys are per tenant
+	if _, reserved, err := store.Reserve(tenant.WithID(ctx, "acme"), "key-1", "fp-2", time.Minute); err != nil || !reserved {
+		t.Errorf("Reserve() for another tenant = %v, %v, want reserved", reserved, err)
+	}
+
+	response := repository.IdempotentResponse{Fingerprint: "fp-1", Status: 201, ContentType: "application/json", Body: []byte(`{"id":"1"}`)}
+	if err := store.Save(ctx, "key-1", response, time.Hour); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	held, reserved, err = store.Reserve(ctx, "key-1", "fp-1", time.Minute)
+	if err != nil || reserved || held.Status != 201 || string(held.Body) != `{"id":"1"}` {
+		t.Errorf("Reserve() of a saved key = %+v, %v, %v, want the saved response", held, reserved, err)
+	}
+
+	// A released key, or one whose request never completed, can be reserved again
+	if err := store.Release(ctx, "key-1"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, reserved, err := store.Reserve(ctx, "key-1", "fp-1", time.Minute); err != nil || !reserved {
+		t.Errorf("Reserve() after Release() = %v, %v, want reserved", reserved, err)
+	}
+	mr.FastForward(2 * time.Minute)
+	if _, reserved, err := store.Reserve(ctx, "key-1", "fp-1", time.Minute); err != nil || !reserved {
+		t.Errorf("Reserve() after lock timeout = %v, %v, want reserved", reserved, err)
+	}
+}
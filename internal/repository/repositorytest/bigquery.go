@@ -0,0 +1,1051 @@
+package repositorytest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+// bqTimestampFormat is the layout the BigQuery client uses for TIMESTAMP query parameters
+const bqTimestampFormat = "2006-01-02 15:04:05.999999-07:00"
+
+// FakeBigQuery is an in-memory stand-in for the BigQuery REST API.
+// It understands the subset of GoogleSQL issued by the repositories in this
+// module: single-table SELECT, UPDATE and DELETE statements with comparison
+// predicates joined by AND, ORDER BY, LIMIT and OFFSET, and COUNT(*) queries
+// grouped by a column, TIMESTAMP_TRUNC or REGEXP_EXTRACT. REPEATED columns
+// can be inserted, selected and assigned but not compared.
+type FakeBigQuery struct {
+	server    *httptest.Server
+	projectID string
+
+	mu         sync.Mutex
+	tables     map[string]*fakeTable
+	jobs       map[string]*fakeJob
+	timeTravel map[string]time.Duration
+}
+
+type fakeTable struct {
+	schema *bq.TableSchema
+	rows   []map[string]interface{}
+	// streamed rows stay in the streaming buffer, out of reach of DML
+	streaming bool
+}
+
+type fakeJob struct {
+	job    *bq.Job
+	schema *bq.TableSchema
+	rows   []*bq.TableRow
+}
+
+// NewFakeBigQuery starts a fake BigQuery server that is shut down when the test ends
+func NewFakeBigQuery(t testing.TB, projectID string) *FakeBigQuery {
+	t.Helper()
+
+	f := &FakeBigQuery{
+		projectID:  projectID,
+		tables:     make(map[string]*fakeTable),
+		jobs:       make(map[string]*fakeJob),
+		timeTravel: make(map[string]time.Duration),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL returns the endpoint of the fake server
+func (f *FakeBigQuery) URL() string {
+	return f.server.URL
+}
+
+// Client returns a BigQuery client connected to the fake server
+func (f *FakeBigQuery) Client(t testing.TB) *bigquery.Client {
+	t.Helper()
+
+	client, err := bigquery.NewClient(context.Background(), f.projectID,
+		option.WithEndpoint(f.server.URL),
+		option.WithoutAuthentication(),
+	)
+	if err != nil {
+		t.Fatalf("failed to create BigQuery client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// CreateTable registers an empty table with the given schema
+func (f *FakeBigQuery) CreateTable(dataset, table string, schema bigquery.Schema) error {
+	data, err := schema.ToJSONFields()
+	if err != nil {
+		return fmt.Errorf("failed to encode schema: %w", err)
+	}
+	var fields []*bq.TableFieldSchema
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("failed to decode schema: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tables[tableKey(f.projectID, dataset, table)] = &fakeTable{schema: &bq.TableSchema{Fields: fields}}
+	return nil
+}
+
+// TimeTravel returns the time travel window last set on a dataset, or zero
+func (f *FakeBigQuery) TimeTravel(dataset string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.timeTravel[f.projectID+"."+dataset]
+}
+
+// KeepStreamingBuffer makes rows inserted into the table stay in its
+// streaming buffer, where BigQuery refuses to UPDATE or DELETE them for up to
+// 90 minutes after the insert
+func (f *FakeBigQuery) KeepStreamingBuffer(dataset, table string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, err := f.table(tableKey(f.projectID, dataset, table))
+	if err != nil {
+		return err
+	}
+	t.streaming = true
+	return nil
+}
+
+// checkStreamingBuffer fails a DML statement matching rows still in the streaming buffer
+func checkStreamingBuffer(t *fakeTable, ref string, pred func(map[string]interface{}) bool) error {
+	if !t.streaming {
+		return nil
+	}
+	for _, row := range t.rows {
+		if pred(row) {
+			return invalidQuery("UPDATE or DELETE statement over table %s would affect rows in the streaming buffer, which is not supported", ref)
+		}
+	}
+	return nil
+}
+
+func tableKey(project, dataset, table string) string {
+	return project + "." + dataset + "." + table
+}
+
+var (
+	routeDatasets  = regexp.MustCompile(`^/projects/([^/]+)/datasets$`)
+	routeDataset   = regexp.MustCompile(`^/projects/([^/]+)/datasets/([^/]+)$`)
+	routeTables    = regexp.MustCompile(`^/projects/([^/]+)/datasets/([^/]+)/tables$`)
+	routeInsertAll = regexp.MustCompile(`^/projects/([^/]+)/datasets/([^/]+)/tables/([^/]+)/insertAll$`)
+	routeQueries   = regexp.MustCompile(`^/projects/([^/]+)/queries$`)
+	routeQuery     = regexp.MustCompile(`^/projects/([^/]+)/queries/([^/]+)$`)
+	routeJobs      = regexp.MustCompile(`^/projects/([^/]+)/jobs$`)
+	routeJob       = regexp.MustCompile(`^/projects/([^/]+)/jobs/([^/]+)$`)
+)
+
+// serveHTTP routes REST calls to their fake implementation
+func (f *FakeBigQuery) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/bigquery/v2")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var (
+		resp interface{}
+		err  error
+	)
+	switch {
+	case r.Method == http.MethodPost && routeDatasets.MatchString(path):
+		var ds bq.Dataset
+		if err = json.NewDecoder(r.Body).Decode(&ds); err == nil {
+			resp = &ds
+		}
+	case r.Method == http.MethodPatch && routeDataset.MatchString(path):
+		m := routeDataset.FindStringSubmatch(path)
+		resp, err = f.patchDataset(m[1], m[2], r)
+	case r.Method == http.MethodPost && routeTables.MatchString(path):
+		resp, err = f.insertTable(r)
+	case r.Method == http.MethodPost && routeInsertAll.MatchString(path):
+		m := routeInsertAll.FindStringSubmatch(path)
+		resp, err = f.insertAll(tableKey(m[1], m[2], m[3]), r)
+	case r.Method == http.MethodPost && routeQueries.MatchString(path):
+		resp, err = f.query(routeQueries.FindStringSubmatch(path)[1], r)
+	case r.Method == http.MethodGet && routeQuery.MatchString(path):
+		resp, err = f.queryResults(routeQuery.FindStringSubmatch(path)[2])
+	case r.Method == http.MethodPost && routeJobs.MatchString(path):
+		resp, err = f.insertJob(routeJobs.FindStringSubmatch(path)[1], r)
+	case r.Method == http.MethodGet && routeJob.MatchString(path):
+		resp, err = f.getJob(routeJob.FindStringSubmatch(path)[2])
+	default:
+		err = &fakeError{code: http.StatusNotFound, reason: "notFound", message: "unsupported call " + r.Method + " " + path}
+	}
+
+	if err != nil {
+		writeFakeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type fakeError struct {
+	code    int
+	reason  string
+	message string
+}
+
+func (e *fakeError) Error() string {
+	return e.message
+}
+
+func invalidQuery(format string, args ...interface{}) error {
+	return &fakeError{code: http.StatusBadRequest, reason: "invalidQuery", message: fmt.Sprintf(format, args...)}
+}
+
+func writeFakeError(w http.ResponseWriter, err error) {
+	fe, ok := err.(*fakeError)
+	if !ok {
+		fe = &fakeError{code: http.StatusBadRequest, reason: "invalid", message: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(fe.code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    fe.code,
+			"message": fe.message,
+			"errors":  []map[string]string{{"reason": fe.reason, "message": fe.message}},
+		},
+	})
+}
+
+// patchDataset implements datasets.patch for the time travel window
+func (f *FakeBigQuery) patchDataset(project, dataset string, r *http.Request) (interface{}, error) {
+	var ds bq.Dataset
+	if err := json.NewDecoder(r.Body).Decode(&ds); err != nil {
+		return nil, err
+	}
+	if ds.MaxTimeTravelHours != 0 {
+		f.timeTravel[project+"."+dataset] = time.Duration(ds.MaxTimeTravelHours) * time.Hour
+	}
+	return &bq.Dataset{
+		DatasetReference:   &bq.DatasetReference{ProjectId: project, DatasetId: dataset},
+		MaxTimeTravelHours: ds.MaxTimeTravelHours,
+	}, nil
+}
+
+func (f *FakeBigQuery) insertTable(r *http.Request) (interface{}, error) {
+	var table bq.Table
+	if err := json.NewDecoder(r.Body).Decode(&table); err != nil {
+		return nil, err
+	}
+	ref := table.TableReference
+	key := tableKey(ref.ProjectId, ref.DatasetId, ref.TableId)
+	if _, exists := f.tables[key]; exists {
+		return nil, &fakeError{code: http.StatusConflict, reason: "duplicate", message: "Already Exists: Table " + key}
+	}
+	f.tables[key] = &fakeTable{schema: table.Schema}
+	return &table, nil
+}
+
+func (f *FakeBigQuery) table(key string) (*fakeTable, error) {
+	t, ok := f.tables[key]
+	if !ok {
+		return nil, &fakeError{code: http.StatusNotFound, reason: "notFound", message: "Not found: Table " + key}
+	}
+	return t, nil
+}
+
+func (f *FakeBigQuery) insertAll(key string, r *http.Request) (interface{}, error) {
+	var req bq.TableDataInsertAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	t, err := f.table(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, in := range req.Rows {
+		row := make(map[string]interface{}, len(t.schema.Fields))
+		for _, field := range t.schema.Fields {
+			raw, ok := in.Json[field.Name]
+			if !ok || raw == nil {
+				row[field.Name] = nil
+				if field.Mode == "REPEATED" {
+					row[field.Name] = []interface{}{}
+				}
+				continue
+			}
+			v, err := convertFieldValue(raw, field)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.Name, err)
+			}
+			row[field.Name] = v
+		}
+		t.rows = append(t.rows, row)
+	}
+	return &bq.TableDataInsertAllResponse{}, nil
+}
+
+// query implements jobs.query, which the client uses for Query.Read
+func (f *FakeBigQuery) query(project string, r *http.Request) (interface{}, error) {
+	var req bq.QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	job, err := f.runJob(project, "", req.Query, req.QueryParameters)
+	if err != nil {
+		return nil, err
+	}
+	return &bq.QueryResponse{
+		JobComplete:  true,
+		JobReference: job.job.JobReference,
+		Schema:       job.schema,
+		Rows:         job.rows,
+		TotalRows:    uint64(len(job.rows)),
+	}, nil
+}
+
+// insertJob implements jobs.insert, which the client uses for Query.Run
+func (f *FakeBigQuery) insertJob(project string, r *http.Request) (interface{}, error) {
+	var req bq.Job
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.Configuration == nil || req.Configuration.Query == nil {
+		return nil, invalidQuery("only query jobs are supported")
+	}
+	jobID := ""
+	if req.JobReference != nil {
+		jobID = req.JobReference.JobId
+	}
+	cfg := req.Configuration.Query
+	job, err := f.runJob(project, jobID, cfg.Query, cfg.QueryParameters)
+	if err != nil {
+		return nil, err
+	}
+	job.job.Configuration = req.Configuration
+	return job.job, nil
+}
+
+func (f *FakeBigQuery) queryResults(jobID string) (interface{}, error) {
+	job, ok := f.jobs[jobID]
+	if !ok {
+		return nil, &fakeError{code: http.StatusNotFound, reason: "notFound", message: "Not found: Job " + jobID}
+	}
+	return &bq.GetQueryResultsResponse{
+		JobComplete:  true,
+		JobReference: job.job.JobReference,
+		Schema:       job.schema,
+		Rows:         job.rows,
+		TotalRows:    uint64(len(job.rows)),
+	}, nil
+}
+
+func (f *FakeBigQuery) getJob(jobID string) (interface{}, error) {
+	job, ok := f.jobs[jobID]
+	if !ok {
+		return nil, &fakeError{code: http.StatusNotFound, reason: "notFound", message: "Not found: Job " + jobID}
+	}
+	return job.job, nil
+}
+
+// runJob executes a statement and records the finished job
+func (f *FakeBigQuery) runJob(project, jobID, sql string, params []*bq.QueryParameter) (*fakeJob, error) {
+	if jobID == "" {
+		jobID = fmt.Sprintf("fake_job_%d", len(f.jobs)+1)
+	}
+	job := &fakeJob{
+		job: &bq.Job{
+			JobReference: &bq.JobReference{ProjectId: project, JobId: jobID},
+			Configuration: &bq.JobConfiguration{
+				Query: &bq.JobConfigurationQuery{Query: sql},
+			},
+			Status: &bq.JobStatus{State: "DONE"},
+		},
+	}
+
+	values, err := convertParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.execute(job, sql, values); err != nil {
+		return nil, err
+	}
+	f.jobs[jobID] = job
+	return job, nil
+}
+
+var (
+	selectStmt = regexp.MustCompile("(?is)^\\s*SELECT\\s+(.+?)\\s+FROM\\s+`([^`]+)`" +
+		`(?:\s+WHERE\s+(.+?))?(?:\s+ORDER\s+BY\s+(.+?))?(?:\s+LIMIT\s+(\S+))?(?:\s+OFFSET\s+(\S+))?\s*;?\s*$`)
+	updateStmt    = regexp.MustCompile("(?is)^\\s*UPDATE\\s+`([^`]+)`\\s+SET\\s+(.+?)\\s+WHERE\\s+(.+?)\\s*;?\\s*$")
+	deleteStmt    = regexp.MustCompile("(?is)^\\s*DELETE\\s+FROM\\s+`([^`]+)`\\s+WHERE\\s+(.+?)\\s*;?\\s*$")
+	aggregateStmt = regexp.MustCompile("(?is)^\\s*SELECT\\s+(.+?)\\s+FROM\\s+`([^`]+)`" +
+		`(?:\s+WHERE\s+(.+?))?(?:\s+GROUP\s+BY\s+(\w+))?(?:\s+HAVING\s+(\w+)\s+IS\s+NOT\s+NULL)?` +
+		`(?:\s+ORDER\s+BY\s+(.+?))?(?:\s+LIMIT\s+(\S+))?\s*;?\s*$`)
+	andSep      = regexp.MustCompile(`(?i)\s+AND\s+`)
+	assignment  = regexp.MustCompile(`^\s*(\w+)\s*=\s*(\S+)\s*$`)
+	comparison  = regexp.MustCompile(`^\s*(\w+)\s*(=|!=|<>|<=|>=|<|>)\s*(\S+)\s*$`)
+	countExpr   = regexp.MustCompile(`(?i)^COUNT\(\*\)$`)
+	truncExpr   = regexp.MustCompile(`(?i)^TIMESTAMP_TRUNC\(\s*(\w+)\s*,\s*(DAY|ISOWEEK)\s*\)$`)
+	extractExpr = regexp.MustCompile(`(?i)^REGEXP_EXTRACT\(\s*(\w+)\s*,\s*r'(.*)'\s*\)$`)
+	aliasExpr   = regexp.MustCompile(`(?is)^(.+?)\s+AS\s+(\w+)$`)
+)
+
+// execute runs a single supported statement against the in-memory tables
+func (f *FakeBigQuery) execute(job *fakeJob, sql string, params map[string]interface{}) error {
+	switch {
+	case isAggregate(sql):
+		m := aggregateStmt.FindStringSubmatch(sql)
+		return f.executeAggregate(job, m[1], m[2], m[3], m[4], m[5], m[6], m[7], params)
+	case selectStmt.MatchString(sql):
+		m := selectStmt.FindStringSubmatch(sql)
+		return f.executeSelect(job, m[1], m[2], m[3], m[4], m[5], m[6], params)
+	case updateStmt.MatchString(sql):
+		m := updateStmt.FindStringSubmatch(sql)
+		return f.executeUpdate(job, m[1], m[2], m[3], params)
+	case deleteStmt.MatchString(sql):
+		m := deleteStmt.FindStringSubmatch(sql)
+		return f.executeDelete(job, m[1], m[2], params)
+	default:
+		return invalidQuery("unsupported statement: %s", strings.TrimSpace(sql))
+	}
+}
+
+func (f *FakeBigQuery) executeSelect(job *fakeJob, columns, ref, where, orderBy, limit, offset string, params map[string]interface{}) error {
+	t, err := f.table(ref)
+	if err != nil {
+		return err
+	}
+	pred, err := parseWhere(t, where, params)
+	if err != nil {
+		return err
+	}
+
+	var fields []*bq.TableFieldSchema
+	if strings.TrimSpace(columns) == "*" {
+		fields = t.schema.Fields
+	} else {
+		for _, name := range strings.Split(columns, ",") {
+			field := t.field(strings.TrimSpace(name))
+			if field == nil {
+				return invalidQuery("Unrecognized name: %s", strings.TrimSpace(name))
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	var rows []map[string]interface{}
+	for _, row := range t.rows {
+		if pred(row) {
+			rows = append(rows, row)
+		}
+	}
+
+	if orderBy != "" {
+		if err := sortRows(t, rows, orderBy); err != nil {
+			return err
+		}
+	}
+
+	start, end := 0, len(rows)
+	if offset != "" {
+		n, err := intValue(offset, params)
+		if err != nil {
+			return err
+		}
+		start = min(int(n), len(rows))
+	}
+	if limit != "" {
+		n, err := intValue(limit, params)
+		if err != nil {
+			return err
+		}
+		end = min(start+int(n), len(rows))
+	}
+
+	job.schema = &bq.TableSchema{Fields: fields}
+	for _, row := range rows[start:end] {
+		out := &bq.TableRow{}
+		for _, field := range fields {
+			out.F = append(out.F, &bq.TableCell{V: formatCell(row[field.Name])})
+		}
+		job.rows = append(job.rows, out)
+	}
+	return nil
+}
+
+// isAggregate reports whether a statement is a SELECT of COUNT(*)
+func isAggregate(sql string) bool {
+	m := aggregateStmt.FindStringSubmatch(sql)
+	if m == nil {
+		return false
+	}
+	for _, item := range splitTopLevel(m[1]) {
+		if am := aliasExpr.FindStringSubmatch(item); am != nil && countExpr.MatchString(strings.TrimSpace(am[1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateColumn is an output column of an aggregate query
+type aggregateColumn struct {
+	field *bq.TableFieldSchema
+	count bool
+	key   func(row map[string]interface{}) interface{}
+}
+
+func (f *FakeBigQuery) executeAggregate(job *fakeJob, columns, ref, where, groupBy, having, orderBy, limit string, params map[string]interface{}) error {
+	t, err := f.table(ref)
+	if err != nil {
+		return err
+	}
+	pred, err := parseWhere(t, where, params)
+	if err != nil {
+		return err
+	}
+
+	var outputs []aggregateColumn
+	var key *aggregateColumn
+	for _, item := range splitTopLevel(columns) {
+		m := aliasExpr.FindStringSubmatch(item)
+		if m == nil {
+			return invalidQuery("aggregate columns need an alias: %s", item)
+		}
+		expr, alias := strings.TrimSpace(m[1]), m[2]
+		col, err := aggregateExpr(t, expr, alias)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, col)
+		if !col.count {
+			if key != nil {
+				return invalidQuery("only one grouping column is supported: %s", columns)
+			}
+			key = &outputs[len(outputs)-1]
+		}
+	}
+	if key != nil && !strings.EqualFold(groupBy, key.field.Name) {
+		return invalidQuery("SELECT list expression %s is not grouped", key.field.Name)
+	}
+
+	// Group the matching rows by the key, keeping groups in first-seen order
+	groups := make(map[interface{}]int64)
+	var order []interface{}
+	for _, row := range t.rows {
+		if !pred(row) {
+			continue
+		}
+		var k interface{}
+		if key != nil {
+			k = key.key(row)
+		}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k]++
+	}
+	if key == nil && len(order) == 0 {
+		order = append(order, nil)
+	}
+
+	result := &fakeTable{schema: &bq.TableSchema{}}
+	for _, col := range outputs {
+		result.schema.Fields = append(result.schema.Fields, col.field)
+	}
+	if having != "" && (key == nil || !strings.EqualFold(having, key.field.Name)) {
+		return invalidQuery("Unrecognized name: %s", having)
+	}
+	for _, k := range order {
+		if having != "" && k == nil {
+			continue
+		}
+		row := make(map[string]interface{})
+		for _, col := range outputs {
+			if col.count {
+				row[col.field.Name] = groups[k]
+			} else {
+				row[col.field.Name] = k
+			}
+		}
+		result.rows = append(result.rows, row)
+	}
+
+	if orderBy != "" {
+		if err := sortRows(result, result.rows, orderBy); err != nil {
+			return err
+		}
+	}
+	rows := result.rows
+	if limit != "" {
+		n, err := intValue(limit, params)
+		if err != nil {
+			return err
+		}
+		rows = rows[:min(int(n), len(rows))]
+	}
+
+	job.schema = result.schema
+	for _, row := range rows {
+		out := &bq.TableRow{}
+		for _, field := range result.schema.Fields {
+			out.F = append(out.F, &bq.TableCell{V: formatCell(row[field.Name])})
+		}
+		job.rows = append(job.rows, out)
+	}
+	return nil
+}
+
+// aggregateExpr resolves an aliased expression of an aggregate query
+func aggregateExpr(t *fakeTable, expr, alias string) (aggregateColumn, error) {
+	if countExpr.MatchString(expr) {
+		return aggregateColumn{field: &bq.TableFieldSchema{Name: alias, Type: "INTEGER"}, count: true}, nil
+	}
+	if m := truncExpr.FindStringSubmatch(expr); m != nil {
+		field := t.field(m[1])
+		if field == nil || field.Type != "TIMESTAMP" {
+			return aggregateColumn{}, invalidQuery("TIMESTAMP_TRUNC needs a TIMESTAMP column: %s", m[1])
+		}
+		weekly := strings.EqualFold(m[2], "ISOWEEK")
+		return aggregateColumn{
+			field: &bq.TableFieldSchema{Name: alias, Type: "TIMESTAMP"},
+			key: func(row map[string]interface{}) interface{} {
+				ts, ok := row[field.Name].(time.Time)
+				if !ok {
+					return nil
+				}
+				day := time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC)
+				if weekly {
+					day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+				}
+				return day
+			},
+		}, nil
+	}
+	if m := extractExpr.FindStringSubmatch(expr); m != nil {
+		field := t.field(m[1])
+		if field == nil || field.Type != "STRING" {
+			return aggregateColumn{}, invalidQuery("REGEXP_EXTRACT needs a STRING column: %s", m[1])
+		}
+		re, err := regexp.Compile(m[2])
+		if err != nil {
+			return aggregateColumn{}, invalidQuery("invalid regular expression: %v", err)
+		}
+		return aggregateColumn{
+			field: &bq.TableFieldSchema{Name: alias, Type: "STRING"},
+			key: func(row map[string]interface{}) interface{} {
+				value, _ := row[field.Name].(string)
+				sub := re.FindStringSubmatch(value)
+				if len(sub) < 2 {
+					return nil
+				}
+				return sub[1]
+			},
+		}, nil
+	}
+	if field := t.field(expr); field != nil {
+		return aggregateColumn{
+			field: &bq.TableFieldSchema{Name: alias, Type: field.Type},
+			key:   func(row map[string]interface{}) interface{} { return row[field.Name] },
+		}, nil
+	}
+	return aggregateColumn{}, invalidQuery("unsupported expression: %s", expr)
+}
+
+// splitTopLevel splits a select list on commas outside parentheses and quotes
+func splitTopLevel(list string) []string {
+	var items []string
+	depth, quoted, start := 0, false, 0
+	for i, r := range list {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	return append(items, strings.TrimSpace(list[start:]))
+}
+
+func (f *FakeBigQuery) executeUpdate(job *fakeJob, ref, set, where string, params map[string]interface{}) error {
+	t, err := f.table(ref)
+	if err != nil {
+		return err
+	}
+	pred, err := parseWhere(t, where, params)
+	if err != nil {
+		return err
+	}
+
+	changes := make(map[string]interface{})
+	for _, item := range strings.Split(set, ",") {
+		m := assignment.FindStringSubmatch(item)
+		if m == nil {
+			return invalidQuery("unsupported SET clause: %s", item)
+		}
+		field := t.field(m[1])
+		if field == nil {
+			return invalidQuery("Unrecognized name: %s", m[1])
+		}
+		v, err := operandValue(m[2], field.Type, params)
+		if err != nil {
+			return err
+		}
+		changes[field.Name] = v
+	}
+	if err := checkStreamingBuffer(t, ref, pred); err != nil {
+		return err
+	}
+
+	var affected int64
+	for _, row := range t.rows {
+		if !pred(row) {
+			continue
+		}
+		for name, v := range changes {
+			row[name] = v
+		}
+		affected++
+	}
+	job.job.Statistics = &bq.JobStatistics{Query: &bq.JobStatistics2{NumDmlAffectedRows: affected}}
+	return nil
+}
+
+func (f *FakeBigQuery) executeDelete(job *fakeJob, ref, where string, params map[string]interface{}) error {
+	t, err := f.table(ref)
+	if err != nil {
+		return err
+	}
+	pred, err := parseWhere(t, where, params)
+	if err != nil {
+		return err
+	}
+	if err := checkStreamingBuffer(t, ref, pred); err != nil {
+		return err
+	}
+
+	kept := t.rows[:0]
+	var affected int64
+	for _, row := range t.rows {
+		if pred(row) {
+			affected++
+			continue
+		}
+		kept = append(kept, row)
+	}
+	t.rows = kept
+	job.job.Statistics = &bq.JobStatistics{Query: &bq.JobStatistics2{NumDmlAffectedRows: affected}}
+	return nil
+}
+
+func (t *fakeTable) field(name string) *bq.TableFieldSchema {
+	for _, field := range t.schema.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+// parseWhere builds a row predicate from comparisons joined by AND
+func parseWhere(t *fakeTable, where string, params map[string]interface{}) (func(map[string]interface{}) bool, error) {
+	if where == "" {
+		return func(map[string]interface{}) bool { return true }, nil
+	}
+
+	type condition struct {
+		column string
+		op     string
+		value  interface{}
+	}
+	var conds []condition
+	for _, part := range andSep.Split(where, -1) {
+		m := comparison.FindStringSubmatch(part)
+		if m == nil {
+			return nil, invalidQuery("unsupported WHERE clause: %s", part)
+		}
+		field := t.field(m[1])
+		if field == nil {
+			return nil, invalidQuery("Unrecognized name: %s", m[1])
+		}
+		v, err := operandValue(m[3], field.Type, params)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, condition{column: field.Name, op: m[2], value: v})
+	}
+
+	return func(row map[string]interface{}) bool {
+		for _, c := range conds {
+			if row[c.column] == nil || c.value == nil {
+				return false
+			}
+			cmp := compareValues(row[c.column], c.value)
+			var ok bool
+			switch c.op {
+			case "=":
+				ok = cmp == 0
+			case "!=", "<>":
+				ok = cmp != 0
+			case "<":
+				ok = cmp < 0
+			case "<=":
+				ok = cmp <= 0
+			case ">":
+				ok = cmp > 0
+			case ">=":
+				ok = cmp >= 0
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func sortRows(t *fakeTable, rows []map[string]interface{}, orderBy string) error {
+	type key struct {
+		column string
+		desc   bool
+	}
+	var keys []key
+	for _, part := range strings.Split(orderBy, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return invalidQuery("unsupported ORDER BY clause: %s", part)
+		}
+		field := t.field(words[0])
+		if field == nil {
+			return invalidQuery("Unrecognized name: %s", words[0])
+		}
+		k := key{column: field.Name}
+		if len(words) == 2 {
+			switch strings.ToUpper(words[1]) {
+			case "DESC":
+				k.desc = true
+			case "ASC":
+			default:
+				return invalidQuery("unsupported ORDER BY clause: %s", part)
+			}
+		}
+		keys = append(keys, k)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, k := range keys {
+			c := compareValues(rows[i][k.column], rows[j][k.column])
+			if c == 0 {
+				continue
+			}
+			if k.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// operandValue resolves a literal or @parameter to a value of the column type
+func operandValue(operand, fieldType string, params map[string]interface{}) (interface{}, error) {
+	if strings.HasPrefix(operand, "@") {
+		v, ok := params[operand[1:]]
+		if !ok {
+			return nil, invalidQuery("Query parameter '%s' not found", operand[1:])
+		}
+		return v, nil
+	}
+	if strings.HasPrefix(operand, "'") && strings.HasSuffix(operand, "'") && len(operand) >= 2 {
+		return convertJSONValue(operand[1:len(operand)-1], fieldType)
+	}
+	return convertJSONValue(operand, fieldType)
+}
+
+func intValue(operand string, params map[string]interface{}) (int64, error) {
+	v, err := operandValue(operand, "INTEGER", params)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, invalidQuery("expected integer, got %v", v)
+	}
+	return n, nil
+}
+
+// convertParams decodes query parameters into Go values
+func convertParams(params []*bq.QueryParameter) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(params))
+	for _, p := range params {
+		if p.ParameterType == nil || p.ParameterValue == nil {
+			return nil, invalidQuery("unsupported parameter %s", p.Name)
+		}
+		if p.ParameterType.Type == "ARRAY" && p.ParameterType.ArrayType != nil {
+			elems := make([]interface{}, 0, len(p.ParameterValue.ArrayValues))
+			for _, elem := range p.ParameterValue.ArrayValues {
+				v, err := convertJSONValue(elem.Value, p.ParameterType.ArrayType.Type)
+				if err != nil {
+					return nil, invalidQuery("parameter %s: %v", p.Name, err)
+				}
+				elems = append(elems, v)
+			}
+			values[p.Name] = elems
+			continue
+		}
+		v, err := convertJSONValue(p.ParameterValue.Value, p.ParameterType.Type)
+		if err != nil {
+			return nil, invalidQuery("parameter %s: %v", p.Name, err)
+		}
+		values[p.Name] = v
+	}
+	return values, nil
+}
+
+// convertFieldValue converts a wire value of a column, which is a list for REPEATED columns
+func convertFieldValue(raw interface{}, field *bq.TableFieldSchema) (interface{}, error) {
+	if field.Mode != "REPEATED" {
+		return convertJSONValue(raw, field.Type)
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %v to a list of %s", raw, field.Type)
+	}
+	elems := make([]interface{}, 0, len(list))
+	for _, elem := range list {
+		v, err := convertJSONValue(elem, field.Type)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, v)
+	}
+	return elems, nil
+}
+
+// convertJSONValue converts a wire value into the Go representation of a BigQuery type
+func convertJSONValue(raw interface{}, fieldType string) (interface{}, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		switch fieldType {
+		case "STRING":
+			return v, nil
+		case "INTEGER", "INT64":
+			return strconv.ParseInt(v, 10, 64)
+		case "FLOAT", "FLOAT64":
+			return strconv.ParseFloat(v, 64)
+		case "BOOLEAN", "BOOL":
+			return strconv.ParseBool(v)
+		case "TIMESTAMP":
+			for _, layout := range []string{bqTimestampFormat, time.RFC3339Nano} {
+				if ts, err := time.Parse(layout, v); err == nil {
+					return ts.UTC().Truncate(time.Microsecond), nil
+				}
+			}
+			return nil, fmt.Errorf("invalid timestamp %q", v)
+		}
+	case float64:
+		switch fieldType {
+		case "INTEGER", "INT64":
+			return int64(v), nil
+		case "FLOAT", "FLOAT64":
+			return v, nil
+		}
+	case bool:
+		if fieldType == "BOOLEAN" || fieldType == "BOOL" {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %v to %s", raw, fieldType)
+}
+
+// formatCell renders a value the way the REST API does with int64 timestamps
+func formatCell(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return strconv.FormatInt(v.UnixMicro(), 10)
+	case []interface{}:
+		cells := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			cells = append(cells, map[string]interface{}{"v": formatCell(elem)})
+		}
+		return cells
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// compareValues orders two values of the same column, with NULLs first
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmpOrdered(a, b)
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return cmpOrdered(a, b)
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func cmpOrdered[T int64 | float64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,224 @@
+// Package repositorytest provides a contract test suite that every
+// repository.BaseRepository implementation must pass, together with local
+// fakes for the backends used in this module.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+)
+
+// Factory returns a new, empty repository for a single subtest
+type Factory[T any] func(t *testing.T) repository.BaseRepository[T]
+
+// Fixture describes how the suite builds and compares entities of type T
+type Fixture[T any] struct {
+	// New returns the i-th distinct entity. Repositories list newest first,
+	// so New(i+1) must sort before New(i) in GetAll.
+	New func(i int) T
+
+	// ID returns the identifier of an entity
+	ID func(entity T) string
+
+	// Modify returns a copy of the entity with its mutable fields changed
+	Modify func(entity T) T
+
+	// Equal reports whether two entities hold the same data
+	Equal func(a, b T) bool
+}
+
+// Run checks that the repositories built by newRepo honour the
+// BaseRepository contract. Every subtest gets a fresh repository.
+func Run[T any](t *testing.T, newRepo Factory[T], fx Fixture[T]) {
+	t.Run("CreateAndGetByID", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		want := fx.New(0)
+		mustCreate(t, repo, want)
+
+		got, err := repo.GetByID(ctx, fx.ID(want))
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if !fx.Equal(got, want) {
+			t.Errorf("GetByID() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("GetByIDNotFound", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t)
+		mustCreate(t, repo, fx.New(0))
+
+		_, err := repo.GetByID(ctx, fx.ID(fx.New(1)))
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() of missing entity error = %v, want ErrNotFound", err)
+		}
+
+		_, err = repo.GetByID(ctx, "")
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() with empty id error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		original := fx.New(0)
+		mustCreate(t, repo, original)
+
+		want := fx.Modify(original)
+		if err := repo.Update(ctx, want); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+
+		got, err := repo.GetByID(ctx, fx.ID(want))
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if !fx.Equal(got, want) {
+			t.Errorf("GetByID() after Update() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("UpdateNotFound", func(t *testing.T) {
+		repo := newRepo(t)
+
+		err := repo.Update(context.Background(), fx.Modify(fx.New(0)))
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Update() of missing entity error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		kept, deleted := fx.New(0), fx.New(1)
+		mustCreate(t, repo, kept)
+		mustCreate(t, repo, deleted)
+
+		if err := repo.Delete(ctx, fx.ID(deleted)); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+
+		if _, err := repo.GetByID(ctx, fx.ID(deleted)); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetByID() of deleted entity error = %v, want ErrNotFound", err)
+		}
+		if _, err := repo.GetByID(ctx, fx.ID(kept)); err != nil {
+			t.Errorf("GetByID() of remaining entity error = %v", err)
+		}
+	})
+
+	t.Run("DeleteNotFound", func(t *testing.T) {
+		repo := newRepo(t)
+
+		err := repo.Delete(context.Background(), fx.ID(fx.New(0)))
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Delete() of missing entity error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("GetAllOrdering", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		const n = 5
+		for i := 0; i < n; i++ {
+			mustCreate(t, repo, fx.New(i))
+		}
+
+		got, err := repo.GetAll(ctx, repository.PaginationParams{Page: 1, PageSize: n})
+		if err != nil {
+			t.Fatalf("GetAll() error = %v", err)
+		}
+		if len(got) != n {
+			t.Fatalf("GetAll() returned %d entities, want %d", len(got), n)
+		}
+		for i, entity := range got {
+			if want := fx.New(n - 1 - i); !fx.Equal(entity, want) {
+				t.Errorf("GetAll()[%d] = %+v, want %+v", i, entity, want)
+			}
+		}
+	})
+
+	t.Run("GetAllPagination", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t)
+
+		const n = 5
+		for i := 0; i < n; i++ {
+			mustCreate(t, repo, fx.New(i))
+		}
+
+		tests := []struct {
+			page, pageSize int
+			wantFirst      int
+			wantLen        int
+		}{
+			{page: 1, pageSize: 2, wantFirst: 4, wantLen: 2},
+			{page: 2, pageSize: 2, wantFirst: 2, wantLen: 2},
+			{page: 3, pageSize: 2, wantFirst: 0, wantLen: 1},
+			{page: 4, pageSize: 2, wantLen: 0},
+			// Out-of-range parameters fall back to page 1 with the default page size
+			{page: 0, pageSize: 0, wantFirst: 4, wantLen: n},
+			{page: -1, pageSize: -1, wantFirst: 4, wantLen: n},
+		}
+		for _, tt := range tests {
+			params := repository.PaginationParams{Page: tt.page, PageSize: tt.pageSize}
+			got, err := repo.GetAll(ctx, params)
+			if err != nil {
+				t.Fatalf("GetAll(%+v) error = %v", params, err)
+			}
+			if len(got) != tt.wantLen {
+				t.Errorf("GetAll(%+v) returned %d entities, want %d", params, len(got), tt.wantLen)
+				continue
+			}
+			if tt.wantLen > 0 && !fx.Equal(got[0], fx.New(tt.wantFirst)) {
+				t.Errorf("GetAll(%+v)[0] = %+v, want %+v", params, got[0], fx.New(tt.wantFirst))
+			}
+		}
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		repo := newRepo(t)
+
+		existing := fx.New(0)
+		mustCreate(t, repo, existing)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		checks := map[string]func() error{
+			"GetAll": func() error {
+				_, err := repo.GetAll(ctx, repository.PaginationParams{Page: 1, PageSize: 10})
+				return err
+			},
+			"GetByID": func() error {
+				_, err := repo.GetByID(ctx, fx.ID(existing))
+				return err
+			},
+			"Create": func() error { return repo.Create(ctx, fx.New(1)) },
+			"Update": func() error { return repo.Update(ctx, fx.Modify(existing)) },
+			"Delete": func() error { return repo.Delete(ctx, fx.ID(existing)) },
+		}
+		for name, call := range checks {
+			if err := call(); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s() with canceled context error = %v, want context.Canceled", name, err)
+			}
+		}
+	})
+}
+
+func mustCreate[T any](t *testing.T, repo repository.BaseRepository[T], entity T) {
+	t.Helper()
+
+	if err := repo.Create(context.Background(), entity); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+}
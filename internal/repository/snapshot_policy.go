@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// BigQuery accepts time travel windows of 2 to 7 days in whole days
+const (
+	minTimeTravel = 48 * time.Hour
+	maxTimeTravel = 168 * time.Hour
+)
+
+// SnapshotPolicy controls how long BigQuery keeps historical copies of deleted rows
+type SnapshotPolicy struct {
+	// TimeTravel is the dataset's time travel window
+	TimeTravel time.Duration
+}
+
+// NewSnapshotPolicy validates a time travel window; zero selects BigQuery's default of 7 days
+func NewSnapshotPolicy(timeTravel time.Duration) (SnapshotPolicy, error) {
+	if timeTravel == 0 {
+		timeTravel = maxTimeTravel
+	}
+	if timeTravel < minTimeTravel || timeTravel > maxTimeTravel || timeTravel%(24*time.Hour) != 0 {
+		return SnapshotPolicy{}, fmt.Errorf("snapshot retention must be 48h to 168h in whole days, got %s", timeTravel)
+	}
+	return SnapshotPolicy{TimeTravel: timeTravel}, nil
+}
+
+// PurgedBy returns when every historical copy of data deleted at t is gone,
+// including BigQuery's fixed fail-safe period after time travel
+func (p SnapshotPolicy) PurgedBy(t time.Time) time.Time {
+	return t.Add(p.TimeTravel + bigQueryFailSafePeriod)
+}
+
+// Apply sets the time travel window of a dataset to the policy
+func (p SnapshotPolicy) Apply(ctx context.Context, client *bigquery.Client, projectID, dataset string) error {
+	_, err := client.DatasetInProject(projectID, dataset).Update(ctx, bigquery.DatasetMetadataToUpdate{
+		MaxTimeTravel: p.TimeTravel,
+	}, "")
+	if err != nil {
+		return fmt.Errorf("failed to apply snapshot policy to dataset %s: %w", dataset, err)
+	}
+	return nil
+}
+
+// SnapshotEnforcer makes sure the snapshot policy holds for the dataset of
+// the tenant in the context
+type SnapshotEnforcer interface {
+	Enforce(ctx context.Context) error
+}
+
+// DatasetSnapshotEnforcer applies a snapshot policy to the dataset of each
+// tenant the first time one of its users is erased, since tenant datasets are
+// provisioned outside of the service and not covered at startup
+type DatasetSnapshotEnforcer struct {
+	client    *bigquery.Client
+	projectID string
+	dataset   string
+	tenancy   Tenancy
+	policy    SnapshotPolicy
+
+	mu      sync.Mutex
+	applied map[string]bool
+}
+
+// NewDatasetSnapshotEnforcer creates a snapshot enforcer for the datasets derived from dataset
+func NewDatasetSnapshotEnforcer(client *bigquery.Client, projectID, dataset string, tenancy Tenancy, policy SnapshotPolicy) *DatasetSnapshotEnforcer {
+	return &DatasetSnapshotEnforcer{
+		client:    client,
+		projectID: projectID,
+		dataset:   dataset,
+		tenancy:   tenancy,
+		policy:    policy,
+		applied:   make(map[string]bool),
+	}
+}
+
+// Enforce applies the policy to the tenant's dataset unless it already was
+func (e *DatasetSnapshotEnforcer) Enforce(ctx context.Context) error {
+	dataset, err := e.tenancy.dataset(ctx, e.dataset)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.applied[dataset] {
+		return nil
+	}
+	if err := e.policy.Apply(ctx, e.client, e.projectID, dataset); err != nil {
+		return err
+	}
+	e.applied[dataset] = true
+	return nil
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// userStatsKeyPrefix prefixes cached statistics, outside the users namespace
+	// so that no user ID can collide with a statistics key
+	userStatsKeyPrefix = "user_stats:"
+
+	// userStatsVersionKey is bumped by every user write; statistics cached under
+	// an older version are never read again and expire with their TTL
+	userStatsVersionKey = userStatsKeyPrefix + "version"
+
+	// userStatsWrittenKey holds when users were last written, so that
+	// statistics computed before are not cached under the version read earlier
+	userStatsWrittenKey = writtenKeyPrefix + userStatsKeyPrefix
+
+	// statsDateFormat formats the dates of a statistics range
+	statsDateFormat = "2006-01-02"
+)
+
+// StatsQuery selects the range and detail of user statistics
+type StatsQuery struct {
+	// From is the first day of the range
+	From time.Time
+	// To is the day after the range
+	To time.Time
+	// Interval buckets signups by entity.StatsIntervalDay or entity.StatsIntervalWeek
+	Interval string
+	// TopDomains is how many email domains to return
+	TopDomains int
+}
+
+// StatsRepository computes aggregate user statistics
+type StatsRepository interface {
+	// GetStats returns the total users, the non-empty signup buckets of the
+	// range and the most common email domains
+	GetStats(ctx context.Context, query StatsQuery) (entity.UserStats, error)
+}
+
+// statsTruncations maps intervals to the TIMESTAMP_TRUNC part starting their buckets
+var statsTruncations = map[string]string{
+	entity.StatsIntervalDay:  "DAY",
+	entity.StatsIntervalWeek: "ISOWEEK",
+}
+
+// GetStats computes user statistics with BigQuery aggregate queries. Encrypted
+// emails have no readable domain and are left out of the top domains.
+func (r *BigQueryRepository) GetStats(ctx context.Context, q StatsQuery) (entity.UserStats, error) {
+	part, ok := statsTruncations[q.Interval]
+	if !ok {
+		return entity.UserStats{}, fmt.Errorf("unknown stats interval %q", q.Interval)
+	}
+	tenancy := r.descriptor.Tenancy
+	stats := entity.UserStats{GeneratedAt: time.Now().UTC()}
+
+	totalSQL := fmt.Sprintf("SELECT COUNT(*) AS total FROM %%s %s", tenancy.where())
+	query, err := r.newQuery(ctx, totalSQL)
+	if err != nil {
+		return stats, err
+	}
+	totals, err := readRows[struct {
+		Total int64 `bigquery:"total"`
+	}](ctx, query, "user total")
+	if err != nil {
+		return stats, err
+	}
+	if len(totals) > 0 {
+		stats.Total = totals[0].Total
+	}
+
+	signupsSQL := fmt.Sprintf("SELECT TIMESTAMP_TRUNC(created_at, %s) AS period, COUNT(*) AS signups FROM %%s %s GROUP BY period ORDER BY period",
+		part, tenancy.where("created_at >= @from", "created_at < @to"))
+	query, err = r.newQuery(ctx, signupsSQL,
+		bigquery.QueryParameter{Name: "from", Value: q.From},
+		bigquery.QueryParameter{Name: "to", Value: q.To},
+	)
+	if err != nil {
+		return stats, err
+	}
+	if stats.Signups, err = readRows[entity.SignupCount](ctx, query, "signup count"); err != nil {
+		return stats, err
+	}
+
+	domainsSQL := fmt.Sprintf("SELECT REGEXP_EXTRACT(email, r'@(.+)$') AS domain, COUNT(*) AS users FROM %%s %s GROUP BY domain HAVING domain IS NOT NULL ORDER BY users DESC, domain LIMIT @top",
+		tenancy.where())
+	query, err = r.newQuery(ctx, domainsSQL, bigquery.QueryParameter{Name: "top", Value: q.TopDomains})
+	if err != nil {
+		return stats, err
+	}
+	if stats.TopDomains, err = readRows[entity.DomainCount](ctx, query, "domain count"); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// RedisStatsRepository caches user statistics with their own TTL
+type RedisStatsRepository struct {
+	redisCache
+	stats StatsRepository
+	ttl   time.Duration
+}
+
+// NewRedisStatsRepository creates a new statistics cache
+func NewRedisStatsRepository(client *redis.Client, stats StatsRepository, ttl time.Duration) *RedisStatsRepository {
+	return &RedisStatsRepository{
+		redisCache: newRedisCache(client, nil),
+		stats:      stats,
+		ttl:        ttl,
+	}
+}
+
+// statsKey creates the cache key of a query under a statistics version
+func (r *RedisStatsRepository) statsKey(version int64, q StatsQuery) string {
+	return fmt.Sprintf("%sv%d:%s:%s:%s:top_%d", userStatsKeyPrefix, version, q.Interval,
+		q.From.Format(statsDateFormat), q.To.Format(statsDateFormat), q.TopDomains)
+}
+
+// GetStats retrieves statistics, using cache if possible
+func (r *RedisStatsRepository) GetStats(ctx context.Context, q StatsQuery) (entity.UserStats, error) {
+	// A missing version reads as 0, so statistics are still cached before the first write
+	var version int64
+	if err := r.cacheGet(ctx, userStatsVersionKey, &version); err != nil {
+		version = 0
+	}
+	cacheKey := r.statsKey(version, q)
+
+	var stats entity.UserStats
+	if err := r.cacheGet(ctx, cacheKey, &stats); err == nil {
+		return stats, nil
+	}
+
+	// Cache miss, compute from underlying repository
+	started := time.Now()
+	stats, err := r.stats.GetStats(ctx, q)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get user stats from repository: %w", err)
+	}
+
+	// Update cache in background, unless users were written since the computation started
+	go func() {
+		if _, err := r.storeVersioned(context.WithoutCancel(ctx), cacheKey, stats, started, r.ttl, 0, userStatsWrittenKey); err != nil {
+			log.Printf("Failed to cache %s: %v", cacheKey, err)
+		}
+	}()
+
+	return stats, nil
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+)
+
+// TenantMode selects how BigQuery data is partitioned between tenants
+type TenantMode string
+
+// Supported tenant modes
+const (
+	// TenantModeNone stores all data in the configured dataset
+	TenantModeNone TenantMode = ""
+	// TenantModeDataset stores each tenant in its own dataset named <dataset>_<tenant>
+	TenantModeDataset TenantMode = "dataset"
+	// TenantModeColumn stores all tenants in one table and filters on a tenant column
+	TenantModeColumn TenantMode = "column"
+)
+
+// defaultTenantColumn is the column used by TenantModeColumn when none is configured
+const defaultTenantColumn = "tenant_id"
+
+// Tenancy configures how BigQuery tables are scoped to the tenant in the context
+type Tenancy struct {
+	Mode TenantMode
+	// Column holds the tenant ID in TenantModeColumn; defaults to "tenant_id"
+	Column string
+}
+
+// ParseTenantMode validates a tenant mode name
+func ParseTenantMode(mode string) (TenantMode, error) {
+	switch m := TenantMode(mode); m {
+	case TenantModeNone, TenantModeDataset, TenantModeColumn:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown tenant mode %q", mode)
+	}
+}
+
+// column returns the tenant column name
+func (t Tenancy) column() string {
+	if t.Column == "" {
+		return defaultTenantColumn
+	}
+	return t.Column
+}
+
+// tenantID returns the tenant of the context when tenancy is enabled
+func (t Tenancy) tenantID(ctx context.Context) (string, error) {
+	if t.Mode == TenantModeNone {
+		return "", nil
+	}
+	id, err := tenant.Require(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := tenant.Validate(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// dataset returns the dataset holding the tenant's data
+func (t Tenancy) dataset(ctx context.Context, base string) (string, error) {
+	id, err := t.tenantID(ctx)
+	if err != nil {
+		return "", err
+	}
+	if t.Mode == TenantModeDataset {
+		return base + "_" + id, nil
+	}
+	return base, nil
+}
+
+// tableRef returns the fully qualified, quoted table identifier for the tenant.
+// BigQuery does not accept query parameters in place of table names.
+func (t Tenancy) tableRef(ctx context.Context, projectID, dataset, table string) (string, error) {
+	dataset, err := t.dataset(ctx, dataset)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("`%s.%s.%s`", projectID, dataset, table), nil
+}
+
+// where builds a WHERE clause from the conditions plus the tenant filter
+func (t Tenancy) where(conditions ...string) string {
+	if t.Mode == TenantModeColumn {
+		conditions = append(conditions, fmt.Sprintf("%s = @%s", t.column(), t.column()))
+	}
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+// params returns the query parameters used by the tenant filter
+func (t Tenancy) params(ctx context.Context) ([]bigquery.QueryParameter, error) {
+	id, err := t.tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t.Mode != TenantModeColumn {
+		return nil, nil
+	}
+	return []bigquery.QueryParameter{{Name: t.column(), Value: id}}, nil
+}
+
+// saver wraps a row so that only the columns of the schema are inserted, with
+// the tenant column set in column mode
+func (t Tenancy) saver(ctx context.Context, row interface{}, schema bigquery.Schema) (interface{}, error) {
+	id, err := t.tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	saver := bigquery.StructSaver{Schema: schema, Struct: row}
+	if t.Mode != TenantModeColumn {
+		return &saver, nil
+	}
+	return &tenantSaver{
+		StructSaver: saver,
+		column:      t.column(),
+		tenantID:    id,
+	}, nil
+}
+
+// tenantSaver adds the tenant column to a struct row
+type tenantSaver struct {
+	bigquery.StructSaver
+	column   string
+	tenantID string
+}
+
+// Save implements bigquery.ValueSaver
+func (s *tenantSaver) Save() (map[string]bigquery.Value, string, error) {
+	row, insertID, err := s.StructSaver.Save()
+	if err != nil {
+		return nil, "", err
+	}
+	row[s.column] = s.tenantID
+	return row, insertID, nil
+}
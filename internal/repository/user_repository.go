@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
 )
@@ -9,6 +11,7 @@ import (
 // Common repository errors
 var (
 	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
 )
 
 // PaginationParams defines the parameters for pagination
@@ -20,4 +23,21 @@ type PaginationParams struct {
 // UserRepository extends BaseRepository for User entities
 type UserRepository interface {
 	BaseRepository[entity.User]
+	// GetByEmail retrieves the user with a normalized email
+	GetByEmail(ctx context.Context, email string) (entity.User, error)
+}
+
+// RecentUserLister lists the users written most recently
+type RecentUserLister interface {
+	// GetRecentlyUpdated retrieves up to limit users, most recently updated first
+	GetRecentlyUpdated(ctx context.Context, limit int) ([]entity.User, error)
+}
+
+// WarmableUserCache is a user cache that can be filled ahead of reads
+type WarmableUserCache interface {
+	UserRepository
+	// Prime caches users loaded elsewhere at loadedAt and returns how many were cached
+	Prime(ctx context.Context, users []entity.User, loadedAt time.Time) (int, error)
+	// FrequentUserIDs returns up to limit IDs of the most read users, most read first
+	FrequentUserIDs(ctx context.Context, limit int) ([]string, error)
 }
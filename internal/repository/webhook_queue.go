@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// webhookQueueKey is the sorted set of pending deliveries scored by their
+	// next attempt in Unix milliseconds. It is shared by all tenants so that one
+	// dispatcher serves them all; members carry the tenant instead.
+	webhookQueueKey = "webhook_queue"
+
+	webhookDeliveryKeyPrefix = "webhook_deliveries:"
+	webhookLogKeyPrefix      = "webhook_log:"
+
+	// webhookLogLength is how many recent deliveries are listed per webhook
+	webhookLogLength = 100
+
+	// queueTenantSeparator separates the tenant from the delivery ID in queue members
+	queueTenantSeparator = "/"
+)
+
+// claimDeliveriesScript leases the deliveries due at ARGV[1] by pushing their
+// score to ARGV[3], so that concurrent dispatchers never claim the same one and
+// a delivery abandoned by a crashed dispatcher becomes due again
+var claimDeliveriesScript = redis.NewScript(`
+local members = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, member in ipairs(members) do
+	redis.call('ZADD', KEYS[1], ARGV[3], member)
+end
+return members
+`)
+
+// enqueueDeliveryScript stores a new delivery, prepends it to its webhook's log
+// and schedules it, doing nothing if the delivery already exists
+var enqueueDeliveryScript = redis.NewScript(`
+if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 0
+end
+redis.call('LPUSH', KEYS[2], ARGV[3])
+redis.call('LTRIM', KEYS[2], 0, tonumber(ARGV[4]) - 1)
+redis.call('PEXPIRE', KEYS[2], ARGV[2])
+redis.call('ZADD', KEYS[3], ARGV[5], ARGV[6])
+return 1
+`)
+
+// QueuedDelivery identifies a claimed delivery and the tenant it belongs to
+type QueuedDelivery struct {
+	Tenant     string
+	DeliveryID string
+}
+
+// Context returns a copy of ctx carrying the tenant of the delivery, if any
+func (q QueuedDelivery) Context(ctx context.Context) context.Context {
+	if q.Tenant == "" {
+		return ctx
+	}
+	return tenant.WithID(ctx, q.Tenant)
+}
+
+// WebhookQueue schedules webhook deliveries and keeps a log of them
+type WebhookQueue interface {
+	// Enqueue records a new delivery in the log of its webhook and schedules it
+	// at its NextAttemptAt; enqueueing an existing delivery is a no-op
+	Enqueue(ctx context.Context, delivery entity.WebhookDelivery) error
+	// Claim leases up to limit deliveries due at now, across tenants; a claimed
+	// delivery that is not saved within lease is claimed again
+	Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]QueuedDelivery, error)
+	// Save stores the state of a delivery and schedules it at its NextAttemptAt,
+	// or removes it from the queue when that is nil
+	Save(ctx context.Context, delivery entity.WebhookDelivery) error
+	// Drop removes a delivery from the queue without touching its record
+	Drop(ctx context.Context, id string) error
+	// Get retrieves a delivery by ID
+	Get(ctx context.Context, id string) (entity.WebhookDelivery, error)
+	// ListByWebhook retrieves up to limit recent deliveries of a webhook, newest first
+	ListByWebhook(ctx context.Context, webhookID string, limit int) ([]entity.WebhookDelivery, error)
+}
+
+// RedisWebhookQueue implements WebhookQueue with a sorted set for scheduling,
+// a key per delivery and a capped list of delivery IDs per webhook
+type RedisWebhookQueue struct {
+	redisCache
+	retention time.Duration
+}
+
+// NewRedisWebhookQueue creates a webhook queue keeping delivery records for retention
+func NewRedisWebhookQueue(client *redis.Client, retention time.Duration) *RedisWebhookQueue {
+	return &RedisWebhookQueue{
+		redisCache: newRedisCache(client, nil),
+		retention:  retention,
+	}
+}
+
+// queueMember identifies a delivery in the shared queue
+func (q *RedisWebhookQueue) queueMember(ctx context.Context, id string) string {
+	if t, ok := tenant.FromContext(ctx); ok {
+		return t + queueTenantSeparator + id
+	}
+	return id
+}
+
+// schedule adds the commands placing a delivery in the queue, or removing it
+func (q *RedisWebhookQueue) schedule(ctx context.Context, pipe redis.Pipeliner, delivery entity.WebhookDelivery) {
+	member := q.queueMember(ctx, delivery.ID)
+	if delivery.NextAttemptAt == nil {
+		pipe.ZRem(ctx, webhookQueueKey, member)
+		return
+	}
+	pipe.ZAdd(ctx, webhookQueueKey, &redis.Z{Score: float64(delivery.NextAttemptAt.UnixMilli()), Member: member})
+}
+
+// Enqueue stores a new delivery, prepends it to the webhook's log and schedules it
+func (q *RedisWebhookQueue) Enqueue(ctx context.Context, delivery entity.WebhookDelivery) error {
+	if delivery.NextAttemptAt == nil {
+		return fmt.Errorf("delivery %s is not scheduled", delivery.ID)
+	}
+	data, err := q.codec.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery: %w", err)
+	}
+	keys := []string{
+		q.scopedKey(ctx, webhookDeliveryKeyPrefix+delivery.ID),
+		q.scopedKey(ctx, webhookLogKeyPrefix+delivery.WebhookID),
+		webhookQueueKey,
+	}
+
+	err = q.executeWithTimeout(ctx, func(ctx context.Context) error {
+		return enqueueDeliveryScript.Run(ctx, q.client, keys, data, q.retention.Milliseconds(), delivery.ID,
+			webhookLogLength, delivery.NextAttemptAt.UnixMilli(), q.queueMember(ctx, delivery.ID)).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue delivery: %w", err)
+	}
+	return nil
+}
+
+// Claim leases the deliveries that are due
+func (q *RedisWebhookQueue) Claim(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]QueuedDelivery, error) {
+	var members []string
+	err := q.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		members, err = claimDeliveriesScript.Run(ctx, q.client, []string{webhookQueueKey},
+			strconv.FormatInt(now.UnixMilli(), 10), limit, now.Add(lease).UnixMilli()).StringSlice()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+
+	claimed := make([]QueuedDelivery, 0, len(members))
+	for _, member := range members {
+		t, id, ok := strings.Cut(member, queueTenantSeparator)
+		if !ok {
+			t, id = "", member
+		}
+		claimed = append(claimed, QueuedDelivery{Tenant: t, DeliveryID: id})
+	}
+	return claimed, nil
+}
+
+// Save stores a delivery and reschedules it
+func (q *RedisWebhookQueue) Save(ctx context.Context, delivery entity.WebhookDelivery) error {
+	data, err := q.codec.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery: %w", err)
+	}
+	deliveryKey := q.scopedKey(ctx, webhookDeliveryKeyPrefix+delivery.ID)
+
+	err = q.executeWithTimeout(ctx, func(ctx context.Context) error {
+		_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, deliveryKey, data, q.retention)
+			q.schedule(ctx, pipe, delivery)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save delivery: %w", err)
+	}
+	return nil
+}
+
+// Drop removes a delivery from the queue
+func (q *RedisWebhookQueue) Drop(ctx context.Context, id string) error {
+	member := q.queueMember(ctx, id)
+	err := q.executeWithTimeout(ctx, func(ctx context.Context) error {
+		return q.client.ZRem(ctx, webhookQueueKey, member).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to drop delivery: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves a delivery by ID
+func (q *RedisWebhookQueue) Get(ctx context.Context, id string) (entity.WebhookDelivery, error) {
+	var delivery entity.WebhookDelivery
+	deliveryKey := q.scopedKey(ctx, webhookDeliveryKeyPrefix+id)
+
+	var data []byte
+	err := q.executeWithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		data, err = q.client.Get(ctx, deliveryKey).Bytes()
+		return err
+	})
+	if err == redis.Nil {
+		return delivery, fmt.Errorf("delivery %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return delivery, fmt.Errorf("failed to get delivery: %w", err)
+	}
+
+	if err := q.codec.Unmarshal(data, &delivery); err != nil {
+		return delivery, fmt.Errorf("failed to unmarshal delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// ListByWebhook retrieves the recent deliveries of a webhook, skipping expired ones
+func (q *RedisWebhookQueue) ListByWebhook(ctx context.Context, webhookID string, limit int) ([]entity.WebhookDelivery, error) {
+	logKey := q.scopedKey(ctx, webhookLogKeyPrefix+webhookID)
+
+	var values []interface{}
+	err := q.executeWithTimeout(ctx, func(ctx context.Context) error {
+		ids, err := q.client.LRange(ctx, logKey, 0, int64(limit)-1).Result()
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = q.scopedKey(ctx, webhookDeliveryKeyPrefix+id)
+		}
+		values, err = q.client.MGet(ctx, keys...).Result()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deliveries: %w", err)
+	}
+
+	deliveries := make([]entity.WebhookDelivery, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var delivery entity.WebhookDelivery
+		if err := q.codec.Unmarshal([]byte(data), &delivery); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
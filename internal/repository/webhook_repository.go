@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+)
+
+// WebhookRepository extends BaseRepository for Webhook entities
+type WebhookRepository interface {
+	BaseRepository[entity.Webhook]
+	// ListActive retrieves every active webhook
+	ListActive(ctx context.Context) ([]entity.Webhook, error)
+}
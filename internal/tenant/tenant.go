@@ -0,0 +1,48 @@
+// Package tenant carries the tenant of a request through the context.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Tenant errors
+var (
+	ErrMissing = errors.New("tenant is required")
+	ErrInvalid = errors.New("invalid tenant")
+)
+
+// validID restricts tenant IDs to characters that are safe in BigQuery dataset names and Redis keys
+var validID = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+type contextKey struct{}
+
+// Validate checks that id is a well-formed tenant ID
+func Validate(id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("%w: %q", ErrInvalid, id)
+	}
+	return nil
+}
+
+// WithID returns a copy of ctx carrying the tenant ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant ID carried by ctx, if any
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// Require returns the tenant ID carried by ctx or ErrMissing
+func Require(ctx context.Context) (string, error) {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return "", ErrMissing
+	}
+	return id, nil
+}
@@ -0,0 +1,213 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+	"golang.org/x/time/rate"
+)
+
+// WarmupSelection is how a cache warm-up selects the users it loads
+type WarmupSelection string
+
+const (
+	// WarmupRecent loads the most recently updated users
+	WarmupRecent WarmupSelection = "recent"
+	// WarmupFrequent loads the most read users, as counted by access tracking
+	WarmupFrequent WarmupSelection = "frequent"
+)
+
+// ParseWarmupSelection validates a warm-up selection name
+func ParseWarmupSelection(selection string) (WarmupSelection, error) {
+	switch s := WarmupSelection(selection); s {
+	case WarmupRecent, WarmupFrequent:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown warm-up selection %q", selection)
+	}
+}
+
+// CacheWarmupPlan is what a cache warm-up loads
+type CacheWarmupPlan struct {
+	// Pages is how many list pages are loaded, from the first
+	Pages int
+	// PageSize is the size of the list pages loaded
+	PageSize int
+	// Users is how many single users are loaded
+	Users int
+	// UsersBy selects the users loaded
+	UsersBy WarmupSelection
+}
+
+// CacheWarmer preloads the user cache, e.g. after a deploy or a Redis flush,
+// issuing at most a given number of BigQuery queries per second
+type CacheWarmer struct {
+	cache   repository.WarmableUserCache
+	users   repository.UserRepository
+	recent  repository.RecentUserLister
+	plan    CacheWarmupPlan
+	limiter *rate.Limiter
+
+	mu sync.Mutex
+	// running holds the tenants being warmed, so that a warm-up is not started twice
+	running map[string]bool
+}
+
+// NewCacheWarmer creates a cache warmer reading users from the primary
+// repository; a non-positive rate does not limit queries
+func NewCacheWarmer(cache repository.WarmableUserCache, users repository.UserRepository, recent repository.RecentUserLister, plan CacheWarmupPlan, queriesPerSecond float64) *CacheWarmer {
+	limit := rate.Inf
+	if queriesPerSecond > 0 {
+		limit = rate.Limit(queriesPerSecond)
+	}
+	return &CacheWarmer{
+		cache:   cache,
+		users:   users,
+		recent:  recent,
+		plan:    plan,
+		limiter: rate.NewLimiter(limit, 1),
+		running: make(map[string]bool),
+	}
+}
+
+// Warm loads the list pages and the users of the plan into the cache of the
+// tenant in ctx. Pages already cached are left as they are, while users are
+// reloaded so that the warm-up refreshes them. Pages and users that fail are
+// counted and skipped; only a cancelled ctx stops the warm-up early.
+func (w *CacheWarmer) Warm(ctx context.Context) (entity.CacheWarmup, error) {
+	report := entity.CacheWarmup{StartedAt: time.Now()}
+	tenantID, _ := tenant.FromContext(ctx)
+	if !w.start(tenantID) {
+		return report, fmt.Errorf("%w: a cache warm-up is already running", ErrConflict)
+	}
+	defer w.finish(tenantID)
+
+	if err := w.warmPages(ctx, &report); err != nil {
+		return report, err
+	}
+
+	var err error
+	switch w.plan.UsersBy {
+	case WarmupRecent:
+		err = w.warmRecent(ctx, &report)
+	case WarmupFrequent:
+		err = w.warmFrequent(ctx, &report)
+	}
+	report.CompletedAt = time.Now()
+	return report, err
+}
+
+// start marks a tenant as being warmed unless it already is
+func (w *CacheWarmer) start(tenantID string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.running[tenantID] {
+		return false
+	}
+	w.running[tenantID] = true
+	return true
+}
+
+// finish marks a tenant as no longer being warmed
+func (w *CacheWarmer) finish(tenantID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.running, tenantID)
+}
+
+// wait blocks until the rate limit allows another query
+func (w *CacheWarmer) wait(ctx context.Context) error {
+	if err := w.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("cache warm-up stopped: %w", err)
+	}
+	return nil
+}
+
+// warmPages loads the first list pages through the cache, stopping after the last page
+func (w *CacheWarmer) warmPages(ctx context.Context, report *entity.CacheWarmup) error {
+	for page := 1; page <= w.plan.Pages; page++ {
+		if err := w.wait(ctx); err != nil {
+			return err
+		}
+
+		users, err := w.cache.GetAll(ctx, repository.PaginationParams{Page: page, PageSize: w.plan.PageSize})
+		if err != nil {
+			log.Printf("Failed to warm user page %d: %v", page, err)
+			report.Failed++
+			continue
+		}
+		report.Pages++
+		if len(users) < w.plan.PageSize {
+			break
+		}
+	}
+	return nil
+}
+
+// warmRecent caches the most recently updated users, read with a single query
+func (w *CacheWarmer) warmRecent(ctx context.Context, report *entity.CacheWarmup) error {
+	if w.plan.Users <= 0 {
+		return nil
+	}
+	if err := w.wait(ctx); err != nil {
+		return err
+	}
+
+	loadedAt := time.Now()
+	users, err := w.recent.GetRecentlyUpdated(ctx, w.plan.Users)
+	if err != nil {
+		log.Printf("Failed to warm recently updated users: %v", err)
+		report.Failed++
+		return nil
+	}
+	w.prime(ctx, report, users, loadedAt)
+	return nil
+}
+
+// warmFrequent caches the most read users, read one query each
+func (w *CacheWarmer) warmFrequent(ctx context.Context, report *entity.CacheWarmup) error {
+	ids, err := w.cache.FrequentUserIDs(ctx, w.plan.Users)
+	if err != nil {
+		log.Printf("Failed to warm frequently read users: %v", err)
+		report.Failed++
+		return nil
+	}
+
+	for _, id := range ids {
+		if err := w.wait(ctx); err != nil {
+			return err
+		}
+
+		loadedAt := time.Now()
+		user, err := w.users.GetByID(ctx, id)
+		if err != nil {
+			// Users deleted since they were read are not worth a failure
+			if !errors.Is(err, repository.ErrNotFound) {
+				log.Printf("Failed to warm user %s: %v", id, err)
+				report.Failed++
+			}
+			continue
+		}
+		w.prime(ctx, report, []entity.User{user}, loadedAt)
+	}
+	return nil
+}
+
+// prime caches loaded users, counting those cached; users written since they
+// were loaded are left to the next read
+func (w *CacheWarmer) prime(ctx context.Context, report *entity.CacheWarmup, users []entity.User, loadedAt time.Time) {
+	primed, err := w.cache.Prime(ctx, users, loadedAt)
+	report.Users += primed
+	if err != nil {
+		log.Printf("Failed to cache warmed users: %v", err)
+		report.Failed += len(users) - primed
+	}
+}
@@ -0,0 +1,219 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+)
+
+// fakeWarmableCache serves pages from its users, returns canned frequent IDs
+// and records the users primed; primeErr fails Prime after priming one user
+type fakeWarmableCache struct {
+	*fakeUsers
+	frequent []string
+	primed   []entity.User
+	primeErr error
+}
+
+func (f *fakeWarmableCache) Prime(_ context.Context, users []entity.User, _ time.Time) (int, error) {
+	if f.primeErr != nil {
+		f.primed = append(f.primed, users[:1]...)
+		return 1, f.primeErr
+	}
+	f.primed = append(f.primed, users...)
+	return len(users), nil
+}
+
+func (f *fakeWarmableCache) FrequentUserIDs(_ context.Context, limit int) ([]string, error) {
+	return f.frequent[:min(limit, len(f.frequent))], nil
+}
+
+// fakeRecentUsers returns its users, most recent first; a non-nil block makes
+// the first call wait until it is closed
+type fakeRecentUsers struct {
+	users   []entity.User
+	started chan struct{}
+	block   chan struct{}
+}
+
+func (f *fakeRecentUsers) GetRecentlyUpdated(_ context.Context, limit int) ([]entity.User, error) {
+	if f.block != nil {
+		block := f.block
+		f.block = nil
+		close(f.started)
+		<-block
+	}
+	return f.users[:min(limit, len(f.users))], nil
+}
+
+func warmupUsers(n int) []entity.User {
+	users := make([]entity.User, n)
+	for i := range users {
+		users[i] = entity.User{ID: fmt.Sprintf("user-%02d", i), Name: "User", Email: fmt.Sprintf("user%d@example.com", i)}
+	}
+	return users
+}
+
+func TestParseWarmupSelection(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    WarmupSelection
+		wantErr bool
+	}{
+		{value: "recent", want: WarmupRecent},
+		{value: "frequent", want: WarmupFrequent},
+		{value: "", wantErr: true},
+		{value: "popular", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseWarmupSelection(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseWarmupSelection(%q) = %q, %v, want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCacheWarmerWarm(t *testing.T) {
+	errBackend := errors.New("backend unavailable")
+	users := warmupUsers(25)
+
+	tests := []struct {
+		name         string
+		plan         CacheWarmupPlan
+		frequent     []string
+		pageErr      error
+		primeErr     error
+		wantPages    int
+		wantUsers    int
+		wantFailed   int
+		wantPrimed   []string
+		wantErr      error
+		cancelledCtx bool
+	}{
+		{
+			name:       "RecentUsers",
+			plan:       CacheWarmupPlan{Pages: 5, PageSize: 10, Users: 3, UsersBy: WarmupRecent},
+			wantPages:  3,
+			wantUsers:  3,
+			wantPrimed: []string{"user-00", "user-01", "user-02"},
+		},
+		{
+			name:      "PagesOnly",
+			plan:      CacheWarmupPlan{Pages: 2, PageSize: 10, UsersBy: WarmupRecent},
+			wantPages: 2,
+		},
+		{
+			name:       "FrequentUsers",
+			plan:       CacheWarmupPlan{Pages: 1, PageSize: 10, Users: 3, UsersBy: WarmupFrequent},
+			frequent:   []string{"user-07", "deleted", "user-03", "user-04"},
+			wantPages:  1,
+			wantUsers:  2,
+			wantPrimed: []string{"user-07", "user-03"},
+		},
+		{
+			name:       "FailedPages",
+			plan:       CacheWarmupPlan{Pages: 3, PageSize: 10, Users: 2, UsersBy: WarmupRecent},
+			pageErr:    errBackend,
+			wantUsers:  2,
+			wantFailed: 3,
+			wantPrimed: []string{"user-00", "user-01"},
+		},
+		{
+			name:       "PartlyPrimed",
+			plan:       CacheWarmupPlan{Pages: 1, PageSize: 10, Users: 4, UsersBy: WarmupRecent},
+			primeErr:   errBackend,
+			wantPages:  1,
+			wantUsers:  1,
+			wantFailed: 3,
+			wantPrimed: []string{"user-00"},
+		},
+		{
+			name:         "CancelledContext",
+			plan:         CacheWarmupPlan{Pages: 1, PageSize: 10, Users: 2, UsersBy: WarmupRecent},
+			cancelledCtx: true,
+			wantErr:      context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.cancelledCtx {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				cancel()
+			}
+
+			cache := &fakeWarmableCache{fakeUsers: newFakeUsers(users...), frequent: tt.frequent, primeErr: tt.primeErr}
+			cache.err = tt.pageErr
+			// Frequent users are read from the primary store, where one was deleted
+			primary := newFakeUsers(users[:10]...)
+			warmer := NewCacheWarmer(cache, primary, &fakeRecentUsers{users: users}, tt.plan, 0)
+
+			report, err := warmer.Warm(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Warm() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if report.Pages != tt.wantPages || report.Users != tt.wantUsers || report.Failed != tt.wantFailed {
+				t.Errorf("Warm() = %d pages, %d users, %d failed, want %d, %d, %d", report.Pages, report.Users, report.Failed, tt.wantPages, tt.wantUsers, tt.wantFailed)
+			}
+			if report.StartedAt.IsZero() || report.CompletedAt.Before(report.StartedAt) {
+				t.Errorf("Warm() ran from %s to %s, want a completed run", report.StartedAt, report.CompletedAt)
+			}
+			var primed []string
+			for _, user := range cache.primed {
+				primed = append(primed, user.ID)
+			}
+			if fmt.Sprint(primed) != fmt.Sprint(tt.wantPrimed) {
+				t.Errorf("primed users = %v, want %v", primed, tt.wantPrimed)
+			}
+		})
+	}
+}
+
+func TestCacheWarmerRejectsConcurrentWarmups(t *testing.T) {
+	users := warmupUsers(3)
+	recent := &fakeRecentUsers{users: users, started: make(chan struct{}), block: make(chan struct{})}
+	block := recent.block
+	plan := CacheWarmupPlan{Users: 3, UsersBy: WarmupRecent}
+	warmer := NewCacheWarmer(&fakeWarmableCache{fakeUsers: newFakeUsers(users...)}, newFakeUsers(users...), recent, plan, 0)
+
+	acme := tenant.WithID(context.Background(), "acme")
+	done := make(chan error, 1)
+	go func() {
+		_, err := warmer.Warm(acme)
+		done <- err
+	}()
+	<-recent.started
+
+	// The tenant being warmed is refused, while another one is not
+	if _, err := warmer.Warm(acme); !errors.Is(err, ErrConflict) {
+		t.Errorf("Warm() while running error = %v, want %v", err, ErrConflict)
+	}
+	if _, err := warmer.Warm(tenant.WithID(context.Background(), "globex")); err != nil {
+		t.Errorf("Warm() of another tenant error = %v", err)
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("Warm() error = %v", err)
+	}
+	if _, err := warmer.Warm(acme); err != nil {
+		t.Errorf("Warm() after the run finished error = %v", err)
+	}
+}
+
+var _ repository.WarmableUserCache = (*fakeWarmableCache)(nil)
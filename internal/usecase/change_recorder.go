@@ -0,0 +1,56 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/google/uuid"
+)
+
+// changeRecorder records user changes in the outbox around the writes making them
+type changeRecorder struct {
+	outbox repository.Outbox
+}
+
+// recordChange records a user change in the outbox before it is written, so
+// that it is published even if the process dies right after the write
+func (r changeRecorder) recordChange(ctx context.Context, changeType, userID string, occurredAt time.Time) (entity.OutboxEvent, error) {
+	event := entity.OutboxEvent{
+		ID:         uuid.New().String(),
+		Type:       changeType,
+		UserID:     userID,
+		OccurredAt: occurredAt.UTC(),
+	}
+	if err := r.outbox.Record(ctx, event); err != nil {
+		return event, fmt.Errorf("failed to record %s change: %w", changeType, err)
+	}
+	return event, nil
+}
+
+// confirmChange makes a written change ready to publish, recording it again if
+// the relay already gave up on it. Failures are logged since the relay also
+// confirms changes it finds written.
+func (r changeRecorder) confirmChange(ctx context.Context, event entity.OutboxEvent) {
+	err := r.outbox.Confirm(ctx, event.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		if err = r.outbox.Record(ctx, event); err == nil {
+			err = r.outbox.Confirm(ctx, event.ID)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to confirm %s change of user %s: %v", event.Type, event.UserID, err)
+	}
+}
+
+// discardChange drops a change that was not written. Failures are logged since
+// the relay also discards changes it finds unwritten.
+func (r changeRecorder) discardChange(ctx context.Context, event entity.OutboxEvent) {
+	if err := r.outbox.Discard(ctx, event.ID); err != nil {
+		log.Printf("Failed to discard %s change of user %s: %v", event.Type, event.UserID, err)
+	}
+}
@@ -0,0 +1,334 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/google/uuid"
+)
+
+// Erasure error types
+var (
+	ErrErasureNotFound = errors.New("erasure not found")
+)
+
+// Erasure step names
+const (
+	erasureStepUser        = "user"
+	erasureStepMemberships = "memberships"
+	erasureStepCache       = "cache"
+	erasureStepSnapshots   = "snapshots"
+)
+
+// ErasureUseCase implements the right to erasure: it removes a user's
+// personal data everywhere and keeps a redacted, verifiable record of it
+type ErasureUseCase struct {
+	primaryRepo    repository.UserRepository
+	cacheRepo      repository.UserRepository
+	membershipRepo repository.MembershipRepository
+	purger         repository.UserPurger
+	erasureRepo    repository.ErasureRepository
+	snapshots      repository.SnapshotPolicy
+	emailHasher    EmailHasher
+	enforcer       repository.SnapshotEnforcer
+	changeRecorder
+}
+
+// EmailHasher derives a keyed digest of a value, such as the envelope HMAC
+// emails are looked up by
+type EmailHasher interface {
+	HMAC(ctx context.Context, value string) (string, error)
+}
+
+// NewErasureUseCase creates a new erasure use case; the deletion of the user
+// is recorded in the outbox like any other
+func NewErasureUseCase(primaryRepo, cacheRepo repository.UserRepository, membershipRepo repository.MembershipRepository, purger repository.UserPurger, erasureRepo repository.ErasureRepository, snapshots repository.SnapshotPolicy, outbox repository.Outbox) *ErasureUseCase {
+	return &ErasureUseCase{
+		primaryRepo:    primaryRepo,
+		cacheRepo:      cacheRepo,
+		membershipRepo: membershipRepo,
+		purger:         purger,
+		erasureRepo:    erasureRepo,
+		snapshots:      snapshots,
+		changeRecorder: changeRecorder{outbox: outbox},
+	}
+}
+
+// WithEmailHasher records a keyed digest of the email in tombstones, so that an
+// erasure can be matched to a request; without one the email leaves no trace
+func (uc *ErasureUseCase) WithEmailHasher(hasher EmailHasher) *ErasureUseCase {
+	uc.emailHasher = hasher
+	return uc
+}
+
+// WithSnapshotEnforcer applies the snapshot policy to the dataset holding the
+// erased user, so that the report's purge date also holds for tenant datasets
+func (uc *ErasureUseCase) WithSnapshotEnforcer(enforcer repository.SnapshotEnforcer) *ErasureUseCase {
+	uc.enforcer = enforcer
+	return uc
+}
+
+// EraseUser deletes a user, their memberships and every cached copy of their
+// data, then records a redacted tombstone completing the pending one written
+// before the delete. Steps after the user row is deleted are attempted even if
+// one fails; the report states which ones succeeded. Retrying an erasure whose
+// tombstone could not be completed finishes it, and retrying a finished one
+// returns its report.
+func (uc *ErasureUseCase) EraseUser(ctx context.Context, id string) (entity.ErasureReport, error) {
+	if id == "" {
+		return entity.ErasureReport{}, fmt.Errorf("%w: id is required", ErrValidation)
+	}
+
+	// Read from the primary store, the cache may be stale
+	user, err := uc.primaryRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return uc.resumeErasure(ctx, id)
+		}
+		return entity.ErasureReport{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	report, err := uc.startErasure(ctx, user)
+	if err != nil {
+		return entity.ErasureReport{}, err
+	}
+
+	event, err := uc.recordChange(ctx, entity.ChangeDeleted, id, time.Now())
+	if err != nil {
+		return entity.ErasureReport{}, err
+	}
+	if err := uc.cacheRepo.Delete(ctx, id); err != nil {
+		uc.discardChange(ctx, event)
+		if errors.Is(err, repository.ErrNotFound) {
+			return entity.ErasureReport{}, ErrUserNotFound
+		}
+		return entity.ErasureReport{}, fmt.Errorf("failed to delete user: %w", err)
+	}
+	uc.confirmChange(ctx, event)
+
+	return uc.finishErasure(ctx, report, user, entity.ErasureStep{Name: erasureStepUser, Status: entity.ErasureStepDone})
+}
+
+// startErasure writes the pending tombstone of an erasure of the user, or
+// picks up the one left by an attempt that failed before deleting the user
+func (uc *ErasureUseCase) startErasure(ctx context.Context, user entity.User) (entity.ErasureReport, error) {
+	tombstones, err := uc.erasureRepo.FindByUser(ctx, user.ID)
+	if err != nil {
+		return entity.ErasureReport{}, fmt.Errorf("failed to get erasures of user: %w", err)
+	}
+	for _, tombstone := range tombstones {
+		if tombstone.Pending() {
+			return decodeReport(tombstone)
+		}
+	}
+
+	emailHash, err := uc.hashEmail(ctx, user.Email)
+	if err != nil {
+		return entity.ErasureReport{}, err
+	}
+	report := entity.ErasureReport{
+		ID:          uuid.New().String(),
+		UserID:      user.ID,
+		EmailHash:   emailHash,
+		RequestedAt: time.Now().UTC(),
+	}
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		return entity.ErasureReport{}, fmt.Errorf("failed to encode erasure report: %w", err)
+	}
+	tombstone := entity.ErasureTombstone{
+		ID:        report.ID,
+		UserID:    report.UserID,
+		EmailHash: report.EmailHash,
+		Report:    string(encoded),
+	}
+	if err := uc.erasureRepo.Create(ctx, tombstone); err != nil {
+		return entity.ErasureReport{}, fmt.Errorf("failed to record erasure %s: %w", report.ID, err)
+	}
+	return report, nil
+}
+
+// resumeErasure handles the erasure of a user that no longer exists: it
+// returns the report of a finished erasure, or finishes one whose attempt
+// deleted the user but failed to complete the tombstone
+func (uc *ErasureUseCase) resumeErasure(ctx context.Context, id string) (entity.ErasureReport, error) {
+	tombstones, err := uc.erasureRepo.FindByUser(ctx, id)
+	if err != nil {
+		return entity.ErasureReport{}, fmt.Errorf("failed to get erasures of user: %w", err)
+	}
+	if len(tombstones) == 0 {
+		return entity.ErasureReport{}, ErrUserNotFound
+	}
+
+	for _, tombstone := range tombstones {
+		if !tombstone.Pending() {
+			continue
+		}
+		report, err := decodeReport(tombstone)
+		if err != nil {
+			return entity.ErasureReport{}, err
+		}
+		// The email is gone with the row, so only the keys by user ID are purged;
+		// the earlier attempt already purged the ones by email
+		return uc.finishErasure(ctx, report, entity.User{ID: id}, entity.ErasureStep{
+			Name:   erasureStepUser,
+			Status: entity.ErasureStepDone,
+			Detail: "deleted by an earlier attempt",
+		})
+	}
+
+	report, err := decodeReport(tombstones[0])
+	if err != nil {
+		return entity.ErasureReport{}, err
+	}
+	report.Digest = tombstones[0].Digest
+	return report, nil
+}
+
+// finishErasure runs the steps following the delete of the user and records
+// the completed tombstone of the erasure
+func (uc *ErasureUseCase) finishErasure(ctx context.Context, report entity.ErasureReport, user entity.User, deleted entity.ErasureStep) (entity.ErasureReport, error) {
+	id := user.ID
+	report.Steps = []entity.ErasureStep{deleted}
+
+	if err := uc.membershipRepo.DeleteByUser(ctx, id); err != nil {
+		log.Printf("Failed to delete memberships of erased user %s: %v", id, err)
+		report.Steps = append(report.Steps, failedStep(erasureStepMemberships))
+	} else {
+		report.Steps = append(report.Steps, entity.ErasureStep{Name: erasureStepMemberships, Status: entity.ErasureStepDone})
+	}
+
+	// Purge after the deletes so that no read can re-cache the user in between
+	if purged, err := uc.purger.PurgeUser(ctx, user); err != nil {
+		log.Printf("Failed to purge cache of erased user %s: %v", id, err)
+		report.Steps = append(report.Steps, failedStep(erasureStepCache))
+	} else {
+		report.Steps = append(report.Steps, entity.ErasureStep{
+			Name:   erasureStepCache,
+			Status: entity.ErasureStepDone,
+			Detail: fmt.Sprintf("purged %d keys", purged),
+		})
+	}
+
+	report.CompletedAt = time.Now().UTC()
+	report.SnapshotsPurgedBy = uc.snapshots.PurgedBy(report.CompletedAt)
+	if err := uc.enforceSnapshots(ctx); err != nil {
+		log.Printf("Failed to apply snapshot policy for erased user %s: %v", id, err)
+		report.Steps = append(report.Steps, failedStep(erasureStepSnapshots))
+	} else {
+		report.Steps = append(report.Steps, entity.ErasureStep{
+			Name:   erasureStepSnapshots,
+			Status: entity.ErasureStepScheduled,
+			Detail: fmt.Sprintf("BigQuery time travel and fail-safe copies expire by %s", report.SnapshotsPurgedBy.Format(time.RFC3339)),
+		})
+	}
+
+	report.Complete = true
+	for _, step := range report.Steps {
+		if step.Status == entity.ErasureStepFailed {
+			report.Complete = false
+		}
+	}
+
+	encoded, digest, err := digestReport(report)
+	if err != nil {
+		return entity.ErasureReport{}, err
+	}
+	report.Digest = digest
+
+	tombstone := entity.ErasureTombstone{
+		ID:          report.ID,
+		UserID:      report.UserID,
+		EmailHash:   report.EmailHash,
+		Report:      string(encoded),
+		Digest:      digest,
+		CompletedAt: report.CompletedAt,
+	}
+	if err := uc.erasureRepo.Create(ctx, tombstone); err != nil {
+		return entity.ErasureReport{}, fmt.Errorf("failed to record erasure %s with digest %s: %w", report.ID, digest, err)
+	}
+
+	return report, nil
+}
+
+// enforceSnapshots applies the snapshot policy with the enforcer, if any
+func (uc *ErasureUseCase) enforceSnapshots(ctx context.Context) error {
+	if uc.enforcer == nil {
+		return nil
+	}
+	return uc.enforcer.Enforce(ctx)
+}
+
+// GetErasure loads the report of an erasure and verifies it against the digest in its tombstone
+func (uc *ErasureUseCase) GetErasure(ctx context.Context, id string) (entity.ErasureReport, bool, error) {
+	if id == "" {
+		return entity.ErasureReport{}, false, fmt.Errorf("%w: id is required", ErrValidation)
+	}
+
+	tombstone, err := uc.erasureRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entity.ErasureReport{}, false, ErrErasureNotFound
+		}
+		return entity.ErasureReport{}, false, fmt.Errorf("failed to get erasure: %w", err)
+	}
+
+	report, err := decodeReport(tombstone)
+	if err != nil {
+		return entity.ErasureReport{}, false, err
+	}
+	_, digest, err := digestReport(report)
+	if err != nil {
+		return entity.ErasureReport{}, false, err
+	}
+	report.Digest = tombstone.Digest
+
+	return report, digest == tombstone.Digest, nil
+}
+
+// decodeReport decodes the report stored in a tombstone, without its digest
+func decodeReport(tombstone entity.ErasureTombstone) (entity.ErasureReport, error) {
+	var report entity.ErasureReport
+	if err := json.Unmarshal([]byte(tombstone.Report), &report); err != nil {
+		return entity.ErasureReport{}, fmt.Errorf("failed to decode erasure report: %w", err)
+	}
+	return report, nil
+}
+
+// digestReport encodes a report without its digest and returns the encoding and its SHA-256
+func digestReport(report entity.ErasureReport) ([]byte, string, error) {
+	report.Digest = ""
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode erasure report: %w", err)
+	}
+	sum := sha256.Sum256(encoded)
+	return encoded, hex.EncodeToString(sum[:]), nil
+}
+
+// hashEmail pseudonymizes an email with the keyed hasher, if any. An unkeyed
+// hash would not do since emails can be guessed and hashed by anyone.
+func (uc *ErasureUseCase) hashEmail(ctx context.Context, email string) (string, error) {
+	if uc.emailHasher == nil {
+		return "", nil
+	}
+	hash, err := uc.emailHasher.HMAC(ctx, entity.NormalizeEmail(email))
+	if err != nil {
+		return "", fmt.Errorf("failed to hash email: %w", err)
+	}
+	return hash, nil
+}
+
+// failedStep records a failed erasure step. The error is only logged since
+// it may mention personal data such as cache keys.
+func failedStep(name string) entity.ErasureStep {
+	return entity.ErasureStep{Name: name, Status: entity.ErasureStepFailed}
+}
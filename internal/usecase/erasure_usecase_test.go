@@ -0,0 +1,253 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+)
+
+// erasureFixture wires an erasure use case to fakes holding one user
+type erasureFixture struct {
+	users       *fakeUsers
+	memberships *fakeMemberships
+	purger      *fakePurger
+	erasures    *fakeErasures
+	enforcer    *fakeEnforcer
+	outbox      *fakeOutbox
+	useCase     *ErasureUseCase
+}
+
+var erasedUser = entity.User{ID: "user-1", Name: "Ada Lovelace", Email: "ada@example.com"}
+
+// fakeHasher prefixes values with its key instead of computing an HMAC
+type fakeHasher struct {
+	key string
+}
+
+func (f fakeHasher) HMAC(_ context.Context, value string) (string, error) {
+	return f.key + ":" + value, nil
+}
+
+// fakeEnforcer counts how often the snapshot policy is enforced
+type fakeEnforcer struct {
+	calls int
+	err   error
+}
+
+func (f *fakeEnforcer) Enforce(context.Context) error {
+	f.calls++
+	return f.err
+}
+
+func newErasureFixture(t *testing.T) *erasureFixture {
+	t.Helper()
+
+	snapshots, err := repository.NewSnapshotPolicy(0)
+	if err != nil {
+		t.Fatalf("NewSnapshotPolicy() error = %v", err)
+	}
+	f := &erasureFixture{
+		users:       newFakeUsers(erasedUser),
+		memberships: &fakeMemberships{},
+		purger:      &fakePurger{},
+		erasures:    newFakeErasures(),
+		enforcer:    &fakeEnforcer{},
+		outbox:      newFakeOutbox(),
+	}
+	f.useCase = NewErasureUseCase(f.users, f.users, f.memberships, f.purger, f.erasures, snapshots, f.outbox)
+	f.useCase.WithEmailHasher(fakeHasher{key: "key"}).WithSnapshotEnforcer(f.enforcer)
+	return f
+}
+
+func TestErasureUseCaseEraseUser(t *testing.T) {
+	errBackend := errors.New("backend unavailable")
+
+	tests := []struct {
+		name         string
+		id           string
+		setup        func(f *erasureFixture)
+		wantErr      error
+		wantComplete bool
+		wantFailed   string
+		wantDeleted  bool
+	}{
+		{name: "ErasesUser", id: erasedUser.ID, wantComplete: true, wantDeleted: true},
+		{name: "MissingID", wantErr: ErrValidation},
+		{name: "UnknownUser", id: "user-2", wantErr: ErrUserNotFound},
+		{
+			name:        "FailedMemberships",
+			id:          erasedUser.ID,
+			setup:       func(f *erasureFixture) { f.memberships.err = errBackend },
+			wantFailed:  erasureStepMemberships,
+			wantDeleted: true,
+		},
+		{
+			name:        "FailedPurge",
+			id:          erasedUser.ID,
+			setup:       func(f *erasureFixture) { f.purger.err = errBackend },
+			wantFailed:  erasureStepCache,
+			wantDeleted: true,
+		},
+		{
+			name:        "FailedSnapshotPolicy",
+			id:          erasedUser.ID,
+			setup:       func(f *erasureFixture) { f.enforcer.err = errBackend },
+			wantFailed:  erasureStepSnapshots,
+			wantDeleted: true,
+		},
+		{
+			name:    "FailedTombstone",
+			id:      erasedUser.ID,
+			setup:   func(f *erasureFixture) { f.erasures.pendingErr = errBackend },
+			wantErr: errBackend,
+		},
+		{
+			name:    "FailedDelete",
+			id:      erasedUser.ID,
+			setup:   func(f *erasureFixture) { f.users.deleteErr = errBackend },
+			wantErr: errBackend,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newErasureFixture(t)
+			if tt.setup != nil {
+				tt.setup(f)
+			}
+
+			report, err := f.useCase.EraseUser(ctx, tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EraseUser() error = %v, want %v", err, tt.wantErr)
+			}
+
+			_, getErr := f.users.GetByID(ctx, erasedUser.ID)
+			if deleted := errors.Is(getErr, repository.ErrNotFound); deleted != tt.wantDeleted {
+				t.Errorf("user deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+
+			events := f.outbox.confirmedEvents()
+			if !tt.wantDeleted {
+				if len(events) != 0 {
+					t.Errorf("confirmed events = %+v, want none", events)
+				}
+				return
+			}
+			if len(events) != 1 || events[0].Type != entity.ChangeDeleted || events[0].UserID != erasedUser.ID {
+				t.Errorf("confirmed events = %+v, want one deleted change of %s", events, erasedUser.ID)
+			}
+
+			if report.Complete != tt.wantComplete || report.UserID != erasedUser.ID || report.Digest == "" {
+				t.Errorf("EraseUser() = %+v, want complete %v for %s", report, tt.wantComplete, erasedUser.ID)
+			}
+			if want := "key:" + erasedUser.Email; report.EmailHash != want {
+				t.Errorf("EraseUser() email hash = %q, want the keyed hash %q", report.EmailHash, want)
+			}
+			for _, step := range report.Steps {
+				if failed := step.Status == entity.ErasureStepFailed; failed != (step.Name == tt.wantFailed) {
+					t.Errorf("step %s status = %s, want failed only for %q", step.Name, step.Status, tt.wantFailed)
+				}
+			}
+
+			stored, verified, err := f.useCase.GetErasure(ctx, report.ID)
+			if err != nil || !verified || stored.Digest != report.Digest {
+				t.Errorf("GetErasure() = %+v, %v, %v, want the verified report", stored, verified, err)
+			}
+		})
+	}
+}
+
+func TestErasureUseCaseRetries(t *testing.T) {
+	ctx := context.Background()
+	f := newErasureFixture(t)
+
+	// The user is deleted, but the completed tombstone is not written
+	f.erasures.completedErr = errors.New("backend unavailable")
+	if _, err := f.useCase.EraseUser(ctx, erasedUser.ID); err == nil {
+		t.Fatal("EraseUser() with failing tombstone error = nil, want an error")
+	}
+	if _, err := f.users.GetByID(ctx, erasedUser.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("GetByID() after erasure error = %v, want %v", err, repository.ErrNotFound)
+	}
+	if len(f.erasures.rows) != 1 || !f.erasures.rows[0].Pending() {
+		t.Fatalf("tombstones = %+v, want one pending", f.erasures.rows)
+	}
+	pending := f.erasures.rows[0]
+
+	// A retry finishes the erasure without deleting or publishing again
+	report, err := f.useCase.EraseUser(ctx, erasedUser.ID)
+	if err != nil {
+		t.Fatalf("EraseUser() retry error = %v", err)
+	}
+	if !report.Complete || report.Digest == "" || report.Steps[0].Detail == "" {
+		t.Errorf("EraseUser() retry = %+v, want a complete report noting the earlier delete", report)
+	}
+	if events := f.outbox.confirmedEvents(); len(events) != 1 {
+		t.Errorf("confirmed events = %+v, want the one deleted change", events)
+	}
+	if got := f.purger.purged; len(got) != 2 || got[1].ID != erasedUser.ID {
+		t.Errorf("purged = %+v, want the user purged again by ID", got)
+	}
+
+	// The tombstones are append-only: the pending one is left as written
+	rows := f.erasures.rows
+	if len(rows) != 2 || rows[0] != pending || rows[1].ID != pending.ID || rows[1].Digest != report.Digest {
+		t.Errorf("tombstones = %+v, want the pending one followed by the completed one", rows)
+	}
+
+	// Retrying a finished erasure returns its report
+	again, err := f.useCase.EraseUser(ctx, erasedUser.ID)
+	if err != nil {
+		t.Fatalf("EraseUser() of erased user error = %v", err)
+	}
+	if again.ID != report.ID || again.Digest != report.Digest {
+		t.Errorf("EraseUser() of erased user = %+v, want report %s", again, report.ID)
+	}
+	if len(f.erasures.rows) != 2 {
+		t.Errorf("tombstones = %+v, want no more written", f.erasures.rows)
+	}
+}
+
+func TestErasureUseCaseDetectsTamperedReport(t *testing.T) {
+	ctx := context.Background()
+	f := newErasureFixture(t)
+
+	report, err := f.useCase.EraseUser(ctx, erasedUser.ID)
+	if err != nil {
+		t.Fatalf("EraseUser() error = %v", err)
+	}
+	for i := range f.erasures.rows {
+		f.erasures.rows[i].Digest = strings.Repeat("0", len(report.Digest))
+	}
+
+	if _, verified, err := f.useCase.GetErasure(ctx, report.ID); err != nil || verified {
+		t.Errorf("GetErasure() of tampered tombstone = verified %v, %v, want unverified", verified, err)
+	}
+	if _, _, err := f.useCase.GetErasure(ctx, "missing"); !errors.Is(err, ErrErasureNotFound) {
+		t.Errorf("GetErasure() of unknown erasure error = %v, want %v", err, ErrErasureNotFound)
+	}
+}
+
+func TestErasureUseCaseWithoutEmailHasher(t *testing.T) {
+	ctx := context.Background()
+	f := newErasureFixture(t)
+	f.useCase.WithEmailHasher(nil)
+
+	report, err := f.useCase.EraseUser(ctx, erasedUser.ID)
+	if err != nil {
+		t.Fatalf("EraseUser() error = %v", err)
+	}
+	if report.EmailHash != "" {
+		t.Errorf("EraseUser() email hash = %q, want none without a key", report.EmailHash)
+	}
+	for _, row := range f.erasures.rows {
+		if row.EmailHash != "" || strings.Contains(row.Report, erasedUser.Email) {
+			t.Errorf("tombstone = %+v, want no trace of the email", row)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+)
+
+// In-memory fakes of the repositories. Each embeds its interface, so calling a
+// method a test does not expect panics.
+
+// fakeUsers stores users in a map; a non-nil err fails every call and
+// deleteErr fails deletes
+type fakeUsers struct {
+	repository.UserRepository
+	mu        sync.Mutex
+	users     map[string]entity.User
+	err       error
+	deleteErr error
+}
+
+func newFakeUsers(users ...entity.User) *fakeUsers {
+	f := &fakeUsers{users: make(map[string]entity.User)}
+	for _, user := range users {
+		f.users[user.ID] = user
+	}
+	return f
+}
+
+func (f *fakeUsers) GetAll(_ context.Context, params repository.PaginationParams) ([]entity.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	ids := make([]string, 0, len(f.users))
+	for id := range f.users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	start := min((params.Page-1)*params.PageSize, len(ids))
+	end := min(start+params.PageSize, len(ids))
+
+	var page []entity.User
+	for _, id := range ids[start:end] {
+		page = append(page, f.users[id])
+	}
+	return page, nil
+}
+
+func (f *fakeUsers) GetByID(_ context.Context, id string) (entity.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return entity.User{}, f.err
+	}
+	user, ok := f.users[id]
+	if !ok {
+		return entity.User{}, repository.ErrNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeUsers) Delete(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	if _, ok := f.users[id]; !ok {
+		return repository.ErrNotFound
+	}
+	delete(f.users, id)
+	return nil
+}
+
+// fakeMemberships records the users whose memberships were deleted
+type fakeMemberships struct {
+	repository.MembershipRepository
+	deleted []string
+	err     error
+}
+
+func (f *fakeMemberships) DeleteByUser(_ context.Context, userID string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.deleted = append(f.deleted, userID)
+	return nil
+}
+
+// fakePurger records the users whose cache was purged
+type fakePurger struct {
+	purged []entity.User
+	err    error
+}
+
+func (f *fakePurger) PurgeUser(_ context.Context, user entity.User) (int64, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.purged = append(f.purged, user)
+	return 3, nil
+}
+
+// fakeErasures keeps the tombstones written in an append-only log, like the
+// BigQuery audit table, and refuses to write the pending or completed
+// tombstone of an erasure twice; pendingErr and completedErr fail the next
+// write of one
+type fakeErasures struct {
+	rows         []entity.ErasureTombstone
+	pendingErr   error
+	completedErr error
+}
+
+func newFakeErasures() *fakeErasures {
+	return &fakeErasures{}
+}
+
+func (f *fakeErasures) Create(_ context.Context, tombstone entity.ErasureTombstone) error {
+	failure := &f.completedErr
+	if tombstone.Pending() {
+		failure = &f.pendingErr
+	}
+	if err := *failure; err != nil {
+		*failure = nil
+		return err
+	}
+	for _, row := range f.rows {
+		if row.ID == tombstone.ID && row.Pending() == tombstone.Pending() {
+			return fmt.Errorf("tombstone %s written twice", tombstone.ID)
+		}
+	}
+	f.rows = append(f.rows, tombstone)
+	return nil
+}
+
+func (f *fakeErasures) GetByID(_ context.Context, id string) (entity.ErasureTombstone, error) {
+	for _, tombstone := range f.tombstones() {
+		if tombstone.ID == id {
+			return tombstone, nil
+		}
+	}
+	return entity.ErasureTombstone{}, repository.ErrNotFound
+}
+
+func (f *fakeErasures) FindByUser(_ context.Context, userID string) ([]entity.ErasureTombstone, error) {
+	var found []entity.ErasureTombstone
+	for _, tombstone := range f.tombstones() {
+		if tombstone.UserID == userID {
+			found = append(found, tombstone)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].CompletedAt.After(found[j].CompletedAt) })
+	return found, nil
+}
+
+// tombstones returns one tombstone per erasure, the completed one once written
+func (f *fakeErasures) tombstones() []entity.ErasureTombstone {
+	var tombstones []entity.ErasureTombstone
+	index := make(map[string]int)
+	for _, row := range f.rows {
+		i, ok := index[row.ID]
+		switch {
+		case !ok:
+			index[row.ID] = len(tombstones)
+			tombstones = append(tombstones, row)
+		case !row.Pending():
+			tombstones[i] = row
+		}
+	}
+	return tombstones
+}
+
+// fakeOutbox tracks the events recorded, confirmed and discarded
+type fakeOutbox struct {
+	repository.Outbox
+	recorded  map[string]entity.OutboxEvent
+	confirmed []string
+	discarded []string
+}
+
+func newFakeOutbox() *fakeOutbox {
+	return &fakeOutbox{recorded: make(map[string]entity.OutboxEvent)}
+}
+
+func (f *fakeOutbox) Record(_ context.Context, event entity.OutboxEvent) error {
+	f.recorded[event.ID] = event
+	return nil
+}
+
+func (f *fakeOutbox) Confirm(_ context.Context, id string) error {
+	if _, ok := f.recorded[id]; !ok {
+		return repository.ErrNotFound
+	}
+	f.confirmed = append(f.confirmed, id)
+	return nil
+}
+
+func (f *fakeOutbox) Discard(_ context.Context, id string) error {
+	delete(f.recorded, id)
+	f.discarded = append(f.discarded, id)
+	return nil
+}
+
+// confirmedEvents returns the confirmed events in the order they were confirmed
+func (f *fakeOutbox) confirmedEvents() []entity.OutboxEvent {
+	var events []entity.OutboxEvent
+	for _, id := range f.confirmed {
+		events = append(events, f.recorded[id])
+	}
+	return events
+}
@@ -0,0 +1,254 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/google/uuid"
+)
+
+// Group error types
+var (
+	ErrGroupNotFound      = errors.New("group not found")
+	ErrMembershipNotFound = errors.New("membership not found")
+)
+
+// GroupUseCase implements the business logic for groups and their members
+type GroupUseCase struct {
+	groupRepo      repository.GroupRepository
+	userRepo       repository.UserRepository
+	membershipRepo repository.MembershipRepository
+}
+
+// NewGroupUseCase creates a new group use case
+func NewGroupUseCase(groupRepo repository.GroupRepository, userRepo repository.UserRepository, membershipRepo repository.MembershipRepository) *GroupUseCase {
+	return &GroupUseCase{
+		groupRepo:      groupRepo,
+		userRepo:       userRepo,
+		membershipRepo: membershipRepo,
+	}
+}
+
+// validateGroup validates group fields
+func (uc *GroupUseCase) validateGroup(group *entity.Group, isCreate bool) error {
+	if !isCreate && group.ID == "" {
+		return fmt.Errorf("%w: id is required", ErrValidation)
+	}
+	if group.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrValidation)
+	}
+	return nil
+}
+
+// GetAllGroups retrieves all groups with pagination
+func (uc *GroupUseCase) GetAllGroups(ctx context.Context, page, pageSize int) ([]entity.Group, error) {
+	params := repository.PaginationParams{
+		Page:     max(page, 1),
+		PageSize: max(pageSize, 10),
+	}
+
+	groups, err := uc.groupRepo.GetAll(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+// GetGroupByID retrieves a group by ID
+func (uc *GroupUseCase) GetGroupByID(ctx context.Context, id string) (entity.Group, error) {
+	if id == "" {
+		return entity.Group{}, fmt.Errorf("%w: id is required", ErrValidation)
+	}
+
+	group, err := uc.groupRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entity.Group{}, ErrGroupNotFound
+		}
+		return entity.Group{}, fmt.Errorf("failed to get group: %w", err)
+	}
+
+	return group, nil
+}
+
+// CreateGroup creates a new group
+func (uc *GroupUseCase) CreateGroup(ctx context.Context, group entity.Group) (entity.Group, error) {
+	if err := uc.validateGroup(&group, true); err != nil {
+		return entity.Group{}, err
+	}
+
+	// Set ID and timestamps
+	if group.ID == "" {
+		group.ID = uuid.New().String()
+	}
+	now := time.Now()
+	group.CreatedAt = now
+	group.UpdatedAt = now
+
+	if err := uc.groupRepo.Create(ctx, group); err != nil {
+		return entity.Group{}, fmt.Errorf("failed to create group: %w", err)
+	}
+
+	return group, nil
+}
+
+// UpdateGroup updates an existing group
+func (uc *GroupUseCase) UpdateGroup(ctx context.Context, group entity.Group) (entity.Group, error) {
+	if err := uc.validateGroup(&group, false); err != nil {
+		return entity.Group{}, err
+	}
+
+	group.UpdatedAt = time.Now()
+
+	if err := uc.groupRepo.Update(ctx, group); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entity.Group{}, ErrGroupNotFound
+		}
+		return entity.Group{}, fmt.Errorf("failed to update group: %w", err)
+	}
+
+	return group, nil
+}
+
+// DeleteGroup removes a group and all of its memberships
+func (uc *GroupUseCase) DeleteGroup(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: id is required", ErrValidation)
+	}
+
+	if err := uc.groupRepo.Delete(ctx, id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrGroupNotFound
+		}
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+
+	if err := uc.membershipRepo.DeleteByGroup(ctx, id); err != nil {
+		log.Printf("Failed to delete memberships of group %s: %v", id, err)
+	}
+
+	return nil
+}
+
+// AddMember adds an existing user to an existing group
+func (uc *GroupUseCase) AddMember(ctx context.Context, groupID, userID string) (entity.Membership, error) {
+	if err := uc.checkMembershipParties(ctx, groupID, userID); err != nil {
+		return entity.Membership{}, err
+	}
+
+	membership := entity.Membership{
+		GroupID:   groupID,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+	if err := uc.membershipRepo.AddMember(ctx, membership); err != nil {
+		return entity.Membership{}, fmt.Errorf("failed to add member: %w", err)
+	}
+
+	return membership, nil
+}
+
+// RemoveMember removes a user from a group
+func (uc *GroupUseCase) RemoveMember(ctx context.Context, groupID, userID string) error {
+	if groupID == "" || userID == "" {
+		return fmt.Errorf("%w: group id and user id are required", ErrValidation)
+	}
+
+	if err := uc.membershipRepo.RemoveMember(ctx, groupID, userID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrMembershipNotFound
+		}
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	return nil
+}
+
+// GetGroupMembers retrieves the users belonging to a group with pagination
+func (uc *GroupUseCase) GetGroupMembers(ctx context.Context, groupID string, page, pageSize int) ([]entity.User, error) {
+	if _, err := uc.GetGroupByID(ctx, groupID); err != nil {
+		return nil, err
+	}
+
+	memberships, err := uc.membershipRepo.ListByGroup(ctx, groupID, repository.PaginationParams{
+		Page:     max(page, 1),
+		PageSize: max(pageSize, 10),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+
+	users := make([]entity.User, 0, len(memberships))
+	for _, m := range memberships {
+		user, err := uc.userRepo.GetByID(ctx, m.UserID)
+		if err != nil {
+			// Skip memberships whose user disappeared concurrently
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get group member: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// GetUserGroups retrieves the groups a user belongs to with pagination
+func (uc *GroupUseCase) GetUserGroups(ctx context.Context, userID string, page, pageSize int) ([]entity.Group, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("%w: id is required", ErrValidation)
+	}
+	if _, err := uc.userRepo.GetByID(ctx, userID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	memberships, err := uc.membershipRepo.ListByUser(ctx, userID, repository.PaginationParams{
+		Page:     max(page, 1),
+		PageSize: max(pageSize, 10),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user groups: %w", err)
+	}
+
+	groups := make([]entity.Group, 0, len(memberships))
+	for _, m := range memberships {
+		group, err := uc.groupRepo.GetByID(ctx, m.GroupID)
+		if err != nil {
+			// Skip memberships whose group disappeared concurrently
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get user group: %w", err)
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// checkMembershipParties ensures both the group and the user exist
+func (uc *GroupUseCase) checkMembershipParties(ctx context.Context, groupID, userID string) error {
+	if groupID == "" || userID == "" {
+		return fmt.Errorf("%w: group id and user id are required", ErrValidation)
+	}
+	if _, err := uc.GetGroupByID(ctx, groupID); err != nil {
+		return err
+	}
+	if _, err := uc.userRepo.GetByID(ctx, userID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+)
+
+// HealthUseCase reports the health of the service
+type HealthUseCase struct {
+	breakers []*repository.CircuitBreaker
+}
+
+// NewHealthUseCase creates a new health use case reporting the given circuit breakers
+func NewHealthUseCase(breakers ...*repository.CircuitBreaker) *HealthUseCase {
+	return &HealthUseCase{
+		breakers: breakers,
+	}
+}
+
+// GetHealth reports the service as degraded while a circuit breaker is not
+// closed; requests are still served, bypassing what the breaker guards
+func (uc *HealthUseCase) GetHealth(ctx context.Context) entity.Health {
+	health := entity.Health{Status: entity.HealthOK}
+	for _, breaker := range uc.breakers {
+		if health.CircuitBreakers == nil {
+			health.CircuitBreakers = make(map[string]string)
+		}
+		state := breaker.State()
+		health.CircuitBreakers[breaker.Name()] = state
+		if state != repository.CircuitClosed {
+			health.Status = entity.HealthDegraded
+		}
+	}
+	return health
+}
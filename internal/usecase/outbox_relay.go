@@ -0,0 +1,213 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// relayBatchSize is how many outbox events are reconciled or claimed at once
+const relayBatchSize = 50
+
+// Outbox relay metrics
+var (
+	outboxLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_lag_seconds",
+		Help: "Age of the oldest outbox event waiting to be published.",
+	})
+	outboxUnconfirmed = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_unconfirmed_events",
+		Help: "Outbox events whose change may not be written yet.",
+	})
+	outboxReady = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_ready_events",
+		Help: "Outbox events waiting to be published.",
+	})
+	outboxPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_published_total",
+		Help: "Outbox events published, by sink.",
+	}, []string{"sink"})
+	outboxPublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_publish_failures_total",
+		Help: "Failed attempts to publish an outbox event, by sink.",
+	}, []string{"sink"})
+	outboxReconciled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_reconciled_total",
+		Help: "Unconfirmed outbox events resolved by the relay, by outcome.",
+	}, []string{"outcome"})
+)
+
+// OutboxRelay publishes the events in the outbox to every sink. An event is
+// removed once all sinks published it and a sink that already published it is
+// skipped when it is retried, so each sink sees an event once unless the relay
+// dies between publishing and recording that, which sinks deduplicate.
+type OutboxRelay struct {
+	outbox repository.Outbox
+	users  repository.UserRepository
+	sinks  []EventSink
+	lease  time.Duration
+	// reconcileAfter is how long an event may stay unconfirmed before the relay
+	// checks whether its change was written
+	reconcileAfter time.Duration
+}
+
+// NewOutboxRelay creates a relay checking unconfirmed events against users
+func NewOutboxRelay(outbox repository.Outbox, users repository.UserRepository, sinks []EventSink, lease, reconcileAfter time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		outbox:         outbox,
+		users:          users,
+		sinks:          sinks,
+		lease:          lease,
+		reconcileAfter: reconcileAfter,
+	}
+}
+
+// Run relays events every interval until ctx is done
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.reconcile(ctx)
+		r.relayReady(ctx)
+		r.updateMetrics(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile confirms the events left unconfirmed by a writer that died and
+// whose change was written, and discards the others
+func (r *OutboxRelay) reconcile(ctx context.Context) {
+	entries, err := r.outbox.Unconfirmed(ctx, time.Now().Add(-r.reconcileAfter), relayBatchSize)
+	if err != nil {
+		log.Printf("Failed to list unconfirmed outbox events: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		ctx := entry.Context(ctx)
+		written, err := r.written(ctx, entry.Event)
+		if err != nil {
+			log.Printf("Failed to reconcile outbox event %s: %v", entry.Event.ID, err)
+			continue
+		}
+
+		outcome := "confirmed"
+		if written {
+			err = r.outbox.Confirm(ctx, entry.Event.ID)
+		} else {
+			outcome = "discarded"
+			err = r.outbox.Discard(ctx, entry.Event.ID)
+		}
+		if err != nil {
+			log.Printf("Failed to reconcile outbox event %s: %v", entry.Event.ID, err)
+			continue
+		}
+		outboxReconciled.WithLabelValues(outcome).Inc()
+	}
+}
+
+// written tells whether the change of an event is in the primary store
+func (r *OutboxRelay) written(ctx context.Context, event entity.OutboxEvent) (bool, error) {
+	user, err := r.users.GetByID(ctx, event.UserID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+	exists := err == nil
+
+	switch event.Type {
+	case entity.ChangeCreated:
+		return exists, nil
+	case entity.ChangeUpdated:
+		// Updates write the time the event occurred as updated_at
+		return exists && !user.UpdatedAt.Before(event.OccurredAt.Truncate(time.Microsecond)), nil
+	case entity.ChangeDeleted:
+		return !exists, nil
+	default:
+		return false, fmt.Errorf("unknown change type %q", event.Type)
+	}
+}
+
+// relayReady publishes the ready events, a batch at a time
+func (r *OutboxRelay) relayReady(ctx context.Context) {
+	for ctx.Err() == nil {
+		entries, err := r.outbox.Claim(ctx, time.Now(), relayBatchSize, r.lease)
+		if err != nil {
+			log.Printf("Failed to claim outbox events: %v", err)
+			return
+		}
+
+		// Events are published oldest first; one that fails is retried once its lease ends
+		for _, entry := range entries {
+			r.publish(entry.Context(ctx), entry.Event)
+		}
+
+		if len(entries) < relayBatchSize {
+			return
+		}
+	}
+}
+
+// publish sends an event to the sinks that have not published it yet and
+// completes it once all of them have
+func (r *OutboxRelay) publish(ctx context.Context, event entity.OutboxEvent) {
+	sent, err := r.outbox.Sent(ctx, event.ID)
+	if err != nil {
+		log.Printf("Failed to get sinks of outbox event %s: %v", event.ID, err)
+		return
+	}
+
+	done := true
+	for _, sink := range r.sinks {
+		if slices.Contains(sent, sink.Name()) {
+			continue
+		}
+		if err := sink.Publish(ctx, event); err != nil {
+			log.Printf("Failed to publish outbox event %s to %s: %v", event.ID, sink.Name(), err)
+			outboxPublishFailures.WithLabelValues(sink.Name()).Inc()
+			done = false
+			continue
+		}
+		outboxPublished.WithLabelValues(sink.Name()).Inc()
+		if err := r.outbox.MarkSent(ctx, event.ID, sink.Name()); err != nil {
+			log.Printf("Failed to mark outbox event %s sent to %s: %v", event.ID, sink.Name(), err)
+			done = false
+		}
+	}
+
+	if !done {
+		return
+	}
+	if err := r.outbox.Complete(ctx, event.ID); err != nil {
+		log.Printf("Failed to complete outbox event %s: %v", event.ID, err)
+	}
+}
+
+// updateMetrics reports the size and lag of the outbox
+func (r *OutboxRelay) updateMetrics(ctx context.Context) {
+	unconfirmed, ready, oldest, err := r.outbox.Backlog(ctx)
+	if err != nil {
+		log.Printf("Failed to get outbox backlog: %v", err)
+		return
+	}
+
+	outboxUnconfirmed.Set(float64(unconfirmed))
+	outboxReady.Set(float64(ready))
+	lag := time.Duration(0)
+	if ready > 0 && time.Since(oldest) > 0 {
+		lag = time.Since(oldest)
+	}
+	outboxLag.Set(lag.Seconds())
+}
@@ -0,0 +1,103 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/dragondarkon/bqredis-crud/internal/tenant"
+)
+
+// Outbox sink names
+const (
+	SinkRedisStream = "redis_stream"
+	SinkStdout      = "stdout"
+	SinkWebhooks    = "webhooks"
+)
+
+// EventSink publishes outbox events. The relay may publish an event again after
+// a failure, so a sink must either deduplicate events by ID or tolerate repeats.
+type EventSink interface {
+	Name() string
+	Publish(ctx context.Context, event entity.OutboxEvent) error
+}
+
+// changeFeedSink publishes events to the Redis Stream change feed, which drops
+// events it already holds
+type changeFeedSink struct {
+	feed repository.ChangeFeed
+}
+
+// NewChangeFeedSink creates a sink publishing to the change feed
+func NewChangeFeedSink(feed repository.ChangeFeed) EventSink {
+	return &changeFeedSink{feed: feed}
+}
+
+func (s *changeFeedSink) Name() string {
+	return SinkRedisStream
+}
+
+func (s *changeFeedSink) Publish(ctx context.Context, event entity.OutboxEvent) error {
+	_, err := s.feed.Publish(ctx, entity.UserChange{
+		EventID:    event.ID,
+		Type:       event.Type,
+		UserID:     event.UserID,
+		OccurredAt: event.OccurredAt,
+	})
+	return err
+}
+
+// writerSink writes events as JSON lines; consumers deduplicate them by ID
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates a sink writing one JSON line per event to w, such as stdout
+func NewWriterSink(w io.Writer) EventSink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Name() string {
+	return SinkStdout
+}
+
+func (s *writerSink) Publish(ctx context.Context, event entity.OutboxEvent) error {
+	line := struct {
+		Tenant string `json:"tenant,omitempty"`
+		entity.OutboxEvent
+	}{OutboxEvent: event}
+	line.Tenant, _ = tenant.FromContext(ctx)
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// webhookSink queues webhook deliveries of events, once per event and webhook
+type webhookSink struct {
+	webhooks *WebhookUseCase
+}
+
+// NewWebhookSink creates a sink handing events to the webhook dispatcher
+func NewWebhookSink(webhooks *WebhookUseCase) EventSink {
+	return &webhookSink{webhooks: webhooks}
+}
+
+func (s *webhookSink) Name() string {
+	return SinkWebhooks
+}
+
+func (s *webhookSink) Publish(ctx context.Context, event entity.OutboxEvent) error {
+	return s.webhooks.PublishEvent(ctx, event)
+}
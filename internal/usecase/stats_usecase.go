@@ -0,0 +1,121 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+)
+
+const (
+	statsDateFormat     = "2006-01-02"
+	defaultStatsDays    = 30
+	maxStatsDays        = 731
+	defaultStatsDomains = 10
+	maxStatsDomains     = 100
+	statsDayDuration    = 24 * time.Hour
+	statsWeekDuration   = 7 * statsDayDuration
+)
+
+// StatsUseCase implements aggregate statistics about users
+type StatsUseCase struct {
+	statsRepo repository.StatsRepository
+}
+
+// NewStatsUseCase creates a new statistics use case
+func NewStatsUseCase(statsRepo repository.StatsRepository) *StatsUseCase {
+	return &StatsUseCase{
+		statsRepo: statsRepo,
+	}
+}
+
+// GetUserStats returns user statistics between the inclusive dates from and to
+// (YYYY-MM-DD, defaulting to the last 30 days) with signups per interval and
+// the top email domains. Every bucket of the range is present, empty ones with 0.
+func (uc *StatsUseCase) GetUserStats(ctx context.Context, from, to, interval string, top int) (entity.UserStats, error) {
+	today := time.Now().UTC().Truncate(statsDayDuration)
+	end, err := parseStatsDate(to, today)
+	if err != nil {
+		return entity.UserStats{}, err
+	}
+	start, err := parseStatsDate(from, end.AddDate(0, 0, 1-defaultStatsDays))
+	if err != nil {
+		return entity.UserStats{}, err
+	}
+	if end.Before(start) {
+		return entity.UserStats{}, fmt.Errorf("%w: from must not be after to", ErrValidation)
+	}
+	if days := int(end.Sub(start)/statsDayDuration) + 1; days > maxStatsDays {
+		return entity.UserStats{}, fmt.Errorf("%w: range must not exceed %d days", ErrValidation, maxStatsDays)
+	}
+
+	if interval == "" {
+		interval = entity.StatsIntervalDay
+	}
+	if interval != entity.StatsIntervalDay && interval != entity.StatsIntervalWeek {
+		return entity.UserStats{}, fmt.Errorf("%w: interval must be %s or %s", ErrValidation, entity.StatsIntervalDay, entity.StatsIntervalWeek)
+	}
+
+	if top == 0 {
+		top = defaultStatsDomains
+	}
+	if top < 1 || top > maxStatsDomains {
+		return entity.UserStats{}, fmt.Errorf("%w: top must be between 1 and %d", ErrValidation, maxStatsDomains)
+	}
+
+	query := repository.StatsQuery{From: start, To: end.AddDate(0, 0, 1), Interval: interval, TopDomains: top}
+	stats, err := uc.statsRepo.GetStats(ctx, query)
+	if err != nil {
+		return entity.UserStats{}, fmt.Errorf("failed to get user stats: %w", err)
+	}
+
+	stats.From = start.Format(statsDateFormat)
+	stats.To = end.Format(statsDateFormat)
+	stats.Interval = interval
+	stats.Signups = fillSignups(stats.Signups, query)
+	stats.SignupsTotal = 0
+	for _, bucket := range stats.Signups {
+		stats.SignupsTotal += bucket.Count
+	}
+	if stats.TopDomains == nil {
+		stats.TopDomains = []entity.DomainCount{}
+	}
+
+	return stats, nil
+}
+
+// parseStatsDate parses a YYYY-MM-DD date, or returns the default for ""
+func parseStatsDate(value string, defaultDate time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultDate, nil
+	}
+	date, err := time.Parse(statsDateFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid date %q, expected YYYY-MM-DD", ErrValidation, value)
+	}
+	return date, nil
+}
+
+// fillSignups returns a bucket for every period of the query, in order,
+// with 0 for periods without signups
+func fillSignups(signups []entity.SignupCount, query repository.StatsQuery) []entity.SignupCount {
+	counts := make(map[time.Time]int64, len(signups))
+	for _, bucket := range signups {
+		counts[bucket.Period.UTC()] = bucket.Count
+	}
+
+	period, step := query.From, statsDayDuration
+	if query.Interval == entity.StatsIntervalWeek {
+		// Weeks start on Monday, like BigQuery's ISOWEEK
+		period = period.AddDate(0, 0, -(int(period.Weekday())+6)%7)
+		step = statsWeekDuration
+	}
+
+	filled := []entity.SignupCount{}
+	for ; period.Before(query.To); period = period.Add(step) {
+		filled = append(filled, entity.SignupCount{Period: period, Count: counts[period]})
+	}
+	return filled
+}
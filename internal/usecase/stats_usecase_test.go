@@ -0,0 +1,176 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+)
+
+// fakeStats returns canned stats and records the query it was asked
+type fakeStats struct {
+	stats entity.UserStats
+	query repository.StatsQuery
+	err   error
+}
+
+func (f *fakeStats) GetStats(_ context.Context, query repository.StatsQuery) (entity.UserStats, error) {
+	f.query = query
+	return f.stats, f.err
+}
+
+func statsDate(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	date, err := time.Parse(statsDateFormat, value)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", value, err)
+	}
+	return date
+}
+
+func TestStatsUseCaseGetUserStats(t *testing.T) {
+	tests := []struct {
+		name         string
+		from, to     string
+		interval     string
+		top          int
+		wantErr      error
+		wantQuery    repository.StatsQuery
+		wantBuckets  int
+		wantSignups  int64
+		wantInterval string
+	}{
+		{
+			name: "Days",
+			from: "2024-01-01", to: "2024-01-03",
+			wantQuery:    repository.StatsQuery{From: statsDate(t, "2024-01-01"), To: statsDate(t, "2024-01-04"), Interval: entity.StatsIntervalDay, TopDomains: defaultStatsDomains},
+			wantBuckets:  3,
+			wantSignups:  5,
+			wantInterval: entity.StatsIntervalDay,
+		},
+		{
+			name: "Weeks",
+			from: "2024-01-03", to: "2024-01-10", interval: entity.StatsIntervalWeek, top: 3,
+			wantQuery:    repository.StatsQuery{From: statsDate(t, "2024-01-03"), To: statsDate(t, "2024-01-11"), Interval: entity.StatsIntervalWeek, TopDomains: 3},
+			wantBuckets:  2,
+			wantSignups:  4,
+			wantInterval: entity.StatsIntervalWeek,
+		},
+		{name: "FromAfterTo", from: "2024-01-05", to: "2024-01-01", wantErr: ErrValidation},
+		{name: "RangeTooLong", from: "2020-01-01", to: "2024-01-01", wantErr: ErrValidation},
+		{name: "InvalidDate", from: "01/01/2024", wantErr: ErrValidation},
+		{name: "UnknownInterval", from: "2024-01-01", to: "2024-01-03", interval: "month", wantErr: ErrValidation},
+		{name: "TopTooLarge", from: "2024-01-01", to: "2024-01-03", top: maxStatsDomains + 1, wantErr: ErrValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeStats{stats: entity.UserStats{
+				Total: 42,
+				Signups: []entity.SignupCount{
+					{Period: statsDate(t, "2024-01-01"), Count: 2},
+					{Period: statsDate(t, "2024-01-03"), Count: 3},
+					{Period: statsDate(t, "2024-01-08"), Count: 2},
+				},
+			}}
+			uc := NewStatsUseCase(repo)
+
+			stats, err := uc.GetUserStats(context.Background(), tt.from, tt.to, tt.interval, tt.top)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetUserStats() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if repo.query != tt.wantQuery {
+				t.Errorf("GetStats() query = %+v, want %+v", repo.query, tt.wantQuery)
+			}
+			if stats.From != tt.from || stats.To != tt.to || stats.Interval != tt.wantInterval {
+				t.Errorf("GetUserStats() range = %s..%s by %s, want %s..%s by %s", stats.From, stats.To, stats.Interval, tt.from, tt.to, tt.wantInterval)
+			}
+			if len(stats.Signups) != tt.wantBuckets || stats.SignupsTotal != tt.wantSignups {
+				t.Errorf("GetUserStats() signups = %+v (total %d), want %d buckets totalling %d", stats.Signups, stats.SignupsTotal, tt.wantBuckets, tt.wantSignups)
+			}
+			if stats.Total != 42 || stats.TopDomains == nil {
+				t.Errorf("GetUserStats() = %+v, want the total and non-nil top domains", stats)
+			}
+		})
+	}
+}
+
+func TestStatsUseCaseRepositoryError(t *testing.T) {
+	errBackend := errors.New("backend unavailable")
+	uc := NewStatsUseCase(&fakeStats{err: errBackend})
+
+	if _, err := uc.GetUserStats(context.Background(), "", "", "", 0); !errors.Is(err, errBackend) {
+		t.Errorf("GetUserStats() error = %v, want %v", err, errBackend)
+	}
+}
+
+func TestFillSignups(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		interval string
+		signups  map[string]int64
+		want     []string
+		counts   []int64
+	}{
+		{
+			name: "Days",
+			from: "2024-01-30", to: "2024-02-02", interval: entity.StatsIntervalDay,
+			signups: map[string]int64{"2024-01-31": 4},
+			want:    []string{"2024-01-30", "2024-01-31", "2024-02-01"},
+			counts:  []int64{0, 4, 0},
+		},
+		{
+			name: "WeeksFromMonday",
+			from: "2024-01-01", to: "2024-01-15", interval: entity.StatsIntervalWeek,
+			signups: map[string]int64{"2024-01-08": 2},
+			want:    []string{"2024-01-01", "2024-01-08"},
+			counts:  []int64{0, 2},
+		},
+		{
+			name: "WeeksFromMidweek",
+			from: "2024-01-03", to: "2024-01-11", interval: entity.StatsIntervalWeek,
+			signups: map[string]int64{"2024-01-01": 1, "2024-01-08": 5},
+			want:    []string{"2024-01-01", "2024-01-08"},
+			counts:  []int64{1, 5},
+		},
+		{
+			name: "WeeksFromSunday",
+			from: "2024-01-07", to: "2024-01-08", interval: entity.StatsIntervalWeek,
+			want:   []string{"2024-01-01"},
+			counts: []int64{0},
+		},
+		{
+			name: "EmptyRange",
+			from: "2024-01-01", to: "2024-01-01", interval: entity.StatsIntervalDay,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var signups []entity.SignupCount
+			for period, count := range tt.signups {
+				signups = append(signups, entity.SignupCount{Period: statsDate(t, period), Count: count})
+			}
+			query := repository.StatsQuery{From: statsDate(t, tt.from), To: statsDate(t, tt.to), Interval: tt.interval}
+
+			got := fillSignups(signups, query)
+			if got == nil || len(got) != len(tt.want) {
+				t.Fatalf("fillSignups() = %+v, want periods %v", got, tt.want)
+			}
+			for i, bucket := range got {
+				if !bucket.Period.Equal(statsDate(t, tt.want[i])) || bucket.Count != tt.counts[i] {
+					t.Errorf("fillSignups()[%d] = %s: %d, want %s: %d", i, bucket.Period.Format(statsDateFormat), bucket.Count, tt.want[i], tt.counts[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Patch formats accepted by PatchUser, by media type
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// ErrUnsupportedPatch is returned for a patch in a format PatchUser does not accept
+var ErrUnsupportedPatch = errors.New("unsupported patch format")
+
+// PatchUser applies a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902)
+// to the stored user and writes only the fields it changed. The patch applies
+// to the user as the API returns it; id, created_at and updated_at are read-only.
+func (uc *UserUseCase) PatchUser(ctx context.Context, id, patchType string, patch []byte) (entity.User, error) {
+	if id == "" {
+		return entity.User{}, fmt.Errorf("%w: id is required", ErrValidation)
+	}
+
+	current, err := uc.currentUser(ctx, id)
+	if err != nil {
+		return entity.User{}, err
+	}
+
+	user, err := applyPatch(current, patchType, patch)
+	if err != nil {
+		return entity.User{}, err
+	}
+	if user.ID != current.ID || !user.CreatedAt.Equal(current.CreatedAt) || !user.UpdatedAt.Equal(current.UpdatedAt) || user.EmailHMAC != current.EmailHMAC {
+		return entity.User{}, fmt.Errorf("%w: id, created_at and updated_at cannot be changed", ErrValidation)
+	}
+	user.Email = entity.NormalizeEmail(user.Email)
+	if err := uc.validateUser(&user, false); err != nil {
+		return entity.User{}, err
+	}
+
+	var columns []string
+	if user.Name != current.Name {
+		columns = append(columns, "name")
+	}
+	if user.Email != current.Email {
+		columns = append(columns, "email")
+	}
+	if len(columns) == 0 {
+		return current, nil
+	}
+
+	return uc.writeUpdate(ctx, current, user, columns)
+}
+
+// applyPatch returns the user with the patch applied to its JSON form
+func applyPatch(user entity.User, patchType string, patch []byte) (entity.User, error) {
+	doc, err := json.Marshal(user)
+	if err != nil {
+		return entity.User{}, fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	var patched []byte
+	switch patchType {
+	case MergePatchType:
+		if !json.Valid(patch) {
+			return entity.User{}, fmt.Errorf("%w: invalid merge patch", ErrValidation)
+		}
+		patched, err = jsonpatch.MergePatch(doc, patch)
+	case JSONPatchType:
+		var operations jsonpatch.Patch
+		if operations, err = jsonpatch.DecodePatch(patch); err != nil {
+			return entity.User{}, fmt.Errorf("%w: invalid JSON patch: %v", ErrValidation, err)
+		}
+		patched, err = operations.Apply(doc)
+	default:
+		return entity.User{}, fmt.Errorf("%w: %q", ErrUnsupportedPatch, patchType)
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return entity.User{}, fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	if err != nil {
+		return entity.User{}, fmt.Errorf("%w: failed to apply patch: %v", ErrValidation, err)
+	}
+
+	var result entity.User
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return entity.User{}, fmt.Errorf("%w: patched user is invalid: %v", ErrValidation, err)
+	}
+	return result, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
@@ -15,12 +16,17 @@ import (
 var (
 	ErrUserNotFound = errors.New("user not found")
 	ErrValidation   = errors.New("validation error")
+	ErrConflict     = errors.New("conflict")
 )
 
 // UserUseCase implements the business logic for user operations
 type UserUseCase struct {
-	primaryRepo repository.UserRepository
-	cacheRepo   repository.UserRepository
+	primaryRepo    repository.UserRepository
+	cacheRepo      repository.UserRepository
+	membershipRepo repository.MembershipRepository
+	emailIndex     repository.EmailIndex
+	changes        repository.ChangeFeed
+	changeRecorder
 }
 
 // validateUser validates user fields
@@ -37,11 +43,64 @@ func (uc *UserUseCase) validateUser(user *entity.User, isCreate bool) error {
 	return nil
 }
 
-// NewUserUseCase creates a new user use case
-func NewUserUseCase(primaryRepo, cacheRepo repository.UserRepository) *UserUseCase {
+// NewUserUseCase creates a new user use case; changes are recorded in the outbox
+// and watched on the change feed the outbox relay publishes them to
+func NewUserUseCase(primaryRepo, cacheRepo repository.UserRepository, membershipRepo repository.MembershipRepository, emailIndex repository.EmailIndex, changes repository.ChangeFeed, outbox repository.Outbox) *UserUseCase {
 	return &UserUseCase{
-		primaryRepo: primaryRepo,
-		cacheRepo:   cacheRepo,
+		primaryRepo:    primaryRepo,
+		cacheRepo:      cacheRepo,
+		membershipRepo: membershipRepo,
+		emailIndex:     emailIndex,
+		changes:        changes,
+		changeRecorder: changeRecorder{outbox: outbox},
+	}
+}
+
+// emailKey returns the value an email is indexed by, which differs from the
+// email when the repository encrypts it
+func (uc *UserUseCase) emailKey(ctx context.Context, email string) (string, error) {
+	keyer, ok := uc.cacheRepo.(repository.EmailKeyer)
+	if !ok {
+		return email, nil
+	}
+	return keyer.EmailKey(ctx, email)
+}
+
+// reserveEmail claims an email for a user, mapping a taken email to ErrConflict
+func (uc *UserUseCase) reserveEmail(ctx context.Context, email, userID string) error {
+	key, err := uc.emailKey(ctx, email)
+	if err != nil {
+		return err
+	}
+	if err := uc.emailIndex.Reserve(ctx, key, userID); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return fmt.Errorf("%w: email %s is already in use", ErrConflict, email)
+		}
+		return fmt.Errorf("failed to reserve email: %w", err)
+	}
+	return nil
+}
+
+// confirmEmail keeps the reservation of an email the user was written with,
+// logging failures since the reservation then merely expires
+func (uc *UserUseCase) confirmEmail(ctx context.Context, email, userID string) {
+	key, err := uc.emailKey(ctx, email)
+	if err == nil {
+		err = uc.emailIndex.Confirm(ctx, key, userID)
+	}
+	if err != nil {
+		log.Printf("Failed to confirm email of user %s: %v", userID, err)
+	}
+}
+
+// releaseEmail frees a user's email, logging failures since the write already succeeded or failed
+func (uc *UserUseCase) releaseEmail(ctx context.Context, email, userID string) {
+	key, err := uc.emailKey(ctx, email)
+	if err == nil {
+		err = uc.emailIndex.Release(ctx, key, userID)
+	}
+	if err != nil {
+		log.Printf("Failed to release email of user %s: %v", userID, err)
 	}
 }
 
@@ -79,8 +138,28 @@ func (uc *UserUseCase) GetUserByID(ctx context.Context, id string) (entity.User,
 	return user, nil
 }
 
+// GetUserByEmail retrieves a user by email, compared case-insensitively
+func (uc *UserUseCase) GetUserByEmail(ctx context.Context, email string) (entity.User, error) {
+	email = entity.NormalizeEmail(email)
+	if email == "" {
+		return entity.User{}, fmt.Errorf("%w: email is required", ErrValidation)
+	}
+
+	// Use cache repository which handles caching internally
+	user, err := uc.cacheRepo.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entity.User{}, ErrUserNotFound
+		}
+		return entity.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
 // CreateUser creates a new user
 func (uc *UserUseCase) CreateUser(ctx context.Context, user entity.User) (entity.User, error) {
+	user.Email = entity.NormalizeEmail(user.Email)
 	if err := uc.validateUser(&user, true); err != nil {
 		return entity.User{}, err
 	}
@@ -89,34 +168,109 @@ func (uc *UserUseCase) CreateUser(ctx context.Context, user entity.User) (entity
 	if user.ID == "" {
 		user.ID = uuid.New().String()
 	}
-	now := time.Now()
+	// BigQuery keeps microseconds, so the created user matches what reads return
+	now := time.Now().Truncate(time.Microsecond)
 	user.CreatedAt = now
 	user.UpdatedAt = now
 
+	if err := uc.reserveEmail(ctx, user.Email, user.ID); err != nil {
+		return entity.User{}, err
+	}
+
+	event, err := uc.recordChange(ctx, entity.ChangeCreated, user.ID, now)
+	if err != nil {
+		uc.releaseEmail(ctx, user.Email, user.ID)
+		return entity.User{}, err
+	}
+
 	// Use cache repository which handles cache invalidation internally
 	if err := uc.cacheRepo.Create(ctx, user); err != nil {
+		uc.discardChange(ctx, event)
+		uc.releaseEmail(ctx, user.Email, user.ID)
 		return entity.User{}, fmt.Errorf("failed to create user: %w", err)
 	}
 
+	uc.confirmChange(ctx, event)
+	uc.confirmEmail(ctx, user.Email, user.ID)
 	return user, nil
 }
 
-// UpdateUser updates an existing user
+// UpdateUser replaces the fields of an existing user
 func (uc *UserUseCase) UpdateUser(ctx context.Context, user entity.User) (entity.User, error) {
+	user.Email = entity.NormalizeEmail(user.Email)
 	if err := uc.validateUser(&user, false); err != nil {
 		return entity.User{}, err
 	}
 
-	user.UpdatedAt = time.Now()
+	current, err := uc.currentUser(ctx, user.ID)
+	if err != nil {
+		return entity.User{}, err
+	}
+	user.CreatedAt = current.CreatedAt
+
+	return uc.writeUpdate(ctx, current, user, nil)
+}
+
+// currentUser reads a user from the primary store, since the cache may be stale
+func (uc *UserUseCase) currentUser(ctx context.Context, id string) (entity.User, error) {
+	current, err := uc.primaryRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entity.User{}, ErrUserNotFound
+		}
+		return entity.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	return current, nil
+}
+
+// writeUpdate stores a validated update of the current user, writing only the
+// given columns and updated_at, or every column when columns is nil
+func (uc *UserUseCase) writeUpdate(ctx context.Context, current, user entity.User, columns []string) (entity.User, error) {
+	// Truncated to the precision BigQuery stores, see recordChange below
+	user.UpdatedAt = time.Now().Truncate(time.Microsecond)
+
+	emailChanged := current.Email != user.Email
+	if emailChanged {
+		if err := uc.reserveEmail(ctx, user.Email, user.ID); err != nil {
+			return entity.User{}, err
+		}
+	}
+
+	// The event occurs at the written updated_at, which is how the relay tells
+	// whether an unconfirmed update was written
+	event, err := uc.recordChange(ctx, entity.ChangeUpdated, user.ID, user.UpdatedAt)
+	if err != nil {
+		if emailChanged {
+			uc.releaseEmail(ctx, user.Email, user.ID)
+		}
+		return entity.User{}, err
+	}
 
 	// Use cache repository which handles cache invalidation internally
-	if err := uc.cacheRepo.Update(ctx, user); err != nil {
+	updater, partial := uc.cacheRepo.(repository.PartialUpdater[entity.User])
+	if columns != nil && partial {
+		err = updater.UpdateColumns(ctx, user, append(columns, "updated_at"))
+	} else {
+		err = uc.cacheRepo.Update(ctx, user)
+	}
+	if err != nil {
+		uc.discardChange(ctx, event)
+		if emailChanged {
+			uc.releaseEmail(ctx, user.Email, user.ID)
+		}
 		if errors.Is(err, repository.ErrNotFound) {
 			return entity.User{}, ErrUserNotFound
 		}
 		return entity.User{}, fmt.Errorf("failed to update user: %w", err)
 	}
 
+	uc.confirmChange(ctx, event)
+
+	if emailChanged {
+		uc.confirmEmail(ctx, user.Email, user.ID)
+		uc.releaseEmail(ctx, current.Email, user.ID)
+	}
+
 	return user, nil
 }
 
@@ -126,17 +280,81 @@ func (uc *UserUseCase) DeleteUser(ctx context.Context, id string) error {
 		return fmt.Errorf("%w: id is required", ErrValidation)
 	}
 
+	// Read the email to release from the primary store, the cache may be stale
+	user, err := uc.primaryRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	event, err := uc.recordChange(ctx, entity.ChangeDeleted, id, time.Now())
+	if err != nil {
+		return err
+	}
+
 	// Use cache repository which handles cache invalidation internally
 	if err := uc.cacheRepo.Delete(ctx, id); err != nil {
+		uc.discardChange(ctx, event)
 		if errors.Is(err, repository.ErrNotFound) {
 			return ErrUserNotFound
 		}
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 
+	uc.confirmChange(ctx, event)
+	uc.releaseEmail(ctx, user.Email, id)
+
+	// Remove the user from every group, which also invalidates membership caches
+	if err := uc.membershipRepo.DeleteByUser(ctx, id); err != nil {
+		log.Printf("Failed to delete memberships of user %s: %v", id, err)
+	}
+
 	return nil
 }
 
+// WatchChanges streams the user changes after lastEventID, or only new ones when
+// it is empty, restricted to userIDs when given. Created and updated events carry
+// the current state of the user. The channel is closed when ctx is done.
+func (uc *UserUseCase) WatchChanges(ctx context.Context, lastEventID string, userIDs []string) (<-chan entity.UserChange, error) {
+	changes, err := uc.changes.Subscribe(ctx, lastEventID)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidEventID) {
+			return nil, fmt.Errorf("%w: %v", ErrValidation, err)
+		}
+		return nil, fmt.Errorf("failed to watch changes: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(userIDs))
+	for _, id := range userIDs {
+		wanted[id] = true
+	}
+
+	out := make(chan entity.UserChange)
+	go func() {
+		defer close(out)
+		for change := range changes {
+			if len(wanted) > 0 && !wanted[change.UserID] {
+				continue
+			}
+			if change.Type != entity.ChangeDeleted {
+				// The user may have been deleted since; the event is still delivered
+				if user, err := uc.cacheRepo.GetByID(ctx, change.UserID); err == nil {
+					change.User = &user
+				}
+			}
+			select {
+			case out <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
 // Helper function for max value
 func max(a, b int) int {
 	if a > b {
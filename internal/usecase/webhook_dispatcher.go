@@ -0,0 +1,216 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+)
+
+// Headers sent with every webhook delivery
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// dispatchBatchSize is how many due deliveries are claimed at once
+	dispatchBatchSize = 20
+
+	// maxResponseBody is how much of a receiver's response is read before closing it
+	maxResponseBody = 64 << 10
+)
+
+// SignWebhook returns the signature of a payload sent at the given Unix time:
+// "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>" keyed by the secret
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookRetryPolicy defines how failed deliveries are retried
+type WebhookRetryPolicy struct {
+	// MaxAttempts is how many times a delivery is attempted before it fails
+	MaxAttempts int
+	// Backoff is the delay after the first failed attempt; it doubles after each failure
+	Backoff time.Duration
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+}
+
+// delay returns how long to wait after the given number of failed attempts
+func (p WebhookRetryPolicy) delay(attempts int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempts && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxBackoff)
+}
+
+// WebhookDispatcher posts queued deliveries to their webhooks and retries
+// failures with exponential backoff
+type WebhookDispatcher struct {
+	webhookRepo repository.WebhookRepository
+	queue       repository.WebhookQueue
+	client      *http.Client
+	retry       WebhookRetryPolicy
+	lease       time.Duration
+}
+
+// NewWebhookDispatcher creates a dispatcher whose requests time out after timeout
+func NewWebhookDispatcher(webhookRepo repository.WebhookRepository, queue repository.WebhookQueue, retry WebhookRetryPolicy, timeout time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		webhookRepo: webhookRepo,
+		queue:       queue,
+		client:      newTargetClient(timeout),
+		retry:       retry,
+		// A claimed delivery is only claimed again once its attempt must have ended
+		lease: 2 * timeout,
+	}
+}
+
+// WithPrivateTargets lets deliveries reach loopback, link-local and private
+// addresses, which are refused by default
+func (d *WebhookDispatcher) WithPrivateTargets() *WebhookDispatcher {
+	d.client.Transport = nil
+	return d
+}
+
+// Run delivers due deliveries every interval until ctx is done
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatchDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchDue attempts every due delivery, a batch at a time
+func (d *WebhookDispatcher) dispatchDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		claimed, err := d.queue.Claim(ctx, time.Now(), dispatchBatchSize, d.lease)
+		if err != nil {
+			log.Printf("Failed to claim webhook deliveries: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, queued := range claimed {
+			wg.Add(1)
+			go func(queued repository.QueuedDelivery) {
+				defer wg.Done()
+				d.attempt(queued.Context(ctx), queued.DeliveryID)
+			}(queued)
+		}
+		wg.Wait()
+
+		if len(claimed) < dispatchBatchSize {
+			return
+		}
+	}
+}
+
+// attempt delivers a claimed delivery once and records the outcome
+func (d *WebhookDispatcher) attempt(ctx context.Context, id string) {
+	delivery, err := d.queue.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			// The record expired, so there is nothing left to deliver
+			if err := d.queue.Drop(ctx, id); err != nil {
+				log.Printf("Failed to drop webhook delivery %s: %v", id, err)
+			}
+			return
+		}
+		log.Printf("Failed to get webhook delivery %s: %v", id, err)
+		return
+	}
+
+	final := false
+	webhook, err := d.webhookRepo.GetByID(ctx, delivery.WebhookID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		err, final = errors.New("webhook was deleted"), true
+	case err != nil:
+		err = fmt.Errorf("failed to get webhook: %w", err)
+	case !webhook.Active:
+		err, final = errors.New("webhook is inactive"), true
+	default:
+		delivery.LastStatus, err = d.post(ctx, webhook, delivery)
+	}
+
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.UpdatedAt = now
+	delivery.LastError = ""
+	delivery.NextAttemptAt = nil
+	switch {
+	case err == nil:
+		delivery.Status = entity.DeliverySucceeded
+	case final || delivery.Attempts >= d.retry.MaxAttempts:
+		delivery.Status = entity.DeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		next := now.Add(d.retry.delay(delivery.Attempts))
+		delivery.Status = entity.DeliveryPending
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = &next
+	}
+
+	if err := d.queue.Save(ctx, delivery); err != nil {
+		log.Printf("Failed to save webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// post sends the signed event to the webhook and returns the response status
+func (d *WebhookDispatcher) post(ctx context.Context, webhook entity.Webhook, delivery entity.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to build request: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.Event.Type)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post event: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,190 @@
+package usecase
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+)
+
+// fakeWebhooks stores webhooks in a map
+type fakeWebhooks struct {
+	repository.WebhookRepository
+	webhooks map[string]entity.Webhook
+}
+
+func (f *fakeWebhooks) GetByID(_ context.Context, id string) (entity.Webhook, error) {
+	webhook, ok := f.webhooks[id]
+	if !ok {
+		return entity.Webhook{}, repository.ErrNotFound
+	}
+	return webhook, nil
+}
+
+// fakeWebhookQueue stores deliveries in a map and records the dropped ones
+type fakeWebhookQueue struct {
+	repository.WebhookQueue
+	deliveries map[string]entity.WebhookDelivery
+	dropped    []string
+}
+
+func (f *fakeWebhookQueue) Get(_ context.Context, id string) (entity.WebhookDelivery, error) {
+	delivery, ok := f.deliveries[id]
+	if !ok {
+		return entity.WebhookDelivery{}, repository.ErrNotFound
+	}
+	return delivery, nil
+}
+
+func (f *fakeWebhookQueue) Save(_ context.Context, delivery entity.WebhookDelivery) error {
+	f.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (f *fakeWebhookQueue) Drop(_ context.Context, id string) error {
+	f.dropped = append(f.dropped, id)
+	return nil
+}
+
+func TestWebhookRetryPolicyDelay(t *testing.T) {
+	policy := WebhookRetryPolicy{MaxAttempts: 8, Backoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 3, want: 4 * time.Second},
+		{attempts: 4, want: 8 * time.Second},
+		{attempts: 5, want: 10 * time.Second},
+		{attempts: 20, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempts), func(t *testing.T) {
+			if got := policy.delay(tt.attempts); got != tt.want {
+				t.Errorf("delay(%d) = %s, want %s", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookDispatcherAttempt(t *testing.T) {
+	retry := WebhookRetryPolicy{MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour}
+
+	tests := []struct {
+		name          string
+		status        int
+		attempts      int
+		webhook       *entity.Webhook
+		wantStatus    string
+		wantAttempts  int
+		wantLast      int
+		wantError     bool
+		wantNextAfter time.Duration
+	}{
+		{name: "Succeeds", status: http.StatusNoContent, wantStatus: entity.DeliverySucceeded, wantAttempts: 1, wantLast: http.StatusNoContent},
+		{
+			name: "FirstFailureIsRetried", status: http.StatusInternalServerError,
+			wantStatus: entity.DeliveryPending, wantAttempts: 1, wantLast: http.StatusInternalServerError, wantError: true, wantNextAfter: time.Minute,
+		},
+		{
+			name: "RetriesBackOff", status: http.StatusBadGateway, attempts: 1,
+			wantStatus: entity.DeliveryPending, wantAttempts: 2, wantLast: http.StatusBadGateway, wantError: true, wantNextAfter: 2 * time.Minute,
+		},
+		{
+			name: "LastAttemptFails", status: http.StatusInternalServerError, attempts: 2,
+			wantStatus: entity.DeliveryFailed, wantAttempts: 3, wantLast: http.StatusInternalServerError, wantError: true,
+		},
+		{
+			name: "DeletedWebhookFails", status: http.StatusNoContent, webhook: &entity.Webhook{ID: "other"},
+			wantStatus: entity.DeliveryFailed, wantAttempts: 1, wantError: true,
+		},
+		{
+			name: "InactiveWebhookFails", status: http.StatusNoContent, webhook: &entity.Webhook{ID: "webhook-1"},
+			wantStatus: entity.DeliveryFailed, wantAttempts: 1, wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r.Header
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			webhook := entity.Webhook{ID: "webhook-1", URL: server.URL, Secret: "secret", Active: true}
+			if tt.webhook != nil {
+				webhook = *tt.webhook
+			}
+			queue := &fakeWebhookQueue{deliveries: map[string]entity.WebhookDelivery{
+				"delivery-1": {
+					ID:        "delivery-1",
+					WebhookID: "webhook-1",
+					Event:     entity.WebhookEvent{ID: "event-1", Type: entity.WebhookUserCreated, UserID: "user-1"},
+					Status:    entity.DeliveryPending,
+					Attempts:  tt.attempts,
+				},
+			}}
+			dispatcher := NewWebhookDispatcher(&fakeWebhooks{webhooks: map[string]entity.Webhook{webhook.ID: webhook}}, queue, retry, time.Second).WithPrivateTargets()
+
+			before := time.Now()
+			dispatcher.attempt(context.Background(), "delivery-1")
+
+			got := queue.deliveries["delivery-1"]
+			if got.Status != tt.wantStatus || got.Attempts != tt.wantAttempts || got.LastStatus != tt.wantLast {
+				t.Errorf("delivery = %+v, want status %s after %d attempts with last status %d", got, tt.wantStatus, tt.wantAttempts, tt.wantLast)
+			}
+			if (got.LastError != "") != tt.wantError {
+				t.Errorf("delivery last error = %q, want error %v", got.LastError, tt.wantError)
+			}
+			switch {
+			case tt.wantNextAfter == 0 && got.NextAttemptAt != nil:
+				t.Errorf("delivery next attempt = %s, want none", got.NextAttemptAt)
+			case tt.wantNextAfter != 0 && (got.NextAttemptAt == nil || got.NextAttemptAt.Before(before.Add(tt.wantNextAfter))):
+				t.Errorf("delivery next attempt = %v, want %s after the attempt", got.NextAttemptAt, tt.wantNextAfter)
+			}
+			if tt.wantLast != 0 && received.Get(WebhookDeliveryHeader) != "delivery-1" {
+				t.Errorf("request delivery header = %q, want %q", received.Get(WebhookDeliveryHeader), "delivery-1")
+			}
+		})
+	}
+}
+
+func TestWebhookDispatcherDropsExpiredDelivery(t *testing.T) {
+	queue := &fakeWebhookQueue{deliveries: map[string]entity.WebhookDelivery{}}
+	dispatcher := NewWebhookDispatcher(&fakeWebhooks{}, queue, WebhookRetryPolicy{MaxAttempts: 1}, time.Second)
+
+	dispatcher.attempt(context.Background(), "expired")
+	if len(queue.dropped) != 1 || queue.dropped[0] != "expired" {
+		t.Errorf("dropped = %v, want the expired delivery", queue.dropped)
+	}
+}
+
+func TestWebhookDispatcherRefusesPrivateTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	queue := &fakeWebhookQueue{deliveries: map[string]entity.WebhookDelivery{
+		"delivery-1": {ID: "delivery-1", WebhookID: "webhook-1", Status: entity.DeliveryPending},
+	}}
+	webhooks := &fakeWebhooks{webhooks: map[string]entity.Webhook{
+		"webhook-1": {ID: "webhook-1", URL: server.URL, Active: true},
+	}}
+	dispatcher := NewWebhookDispatcher(webhooks, queue, WebhookRetryPolicy{MaxAttempts: 1}, time.Second)
+
+	dispatcher.attempt(context.Background(), "delivery-1")
+	if got := queue.deliveries["delivery-1"]; got.Status != entity.DeliveryFailed || got.LastStatus != 0 {
+		t.Errorf("delivery = %+v, want failed without reaching the server", got)
+	}
+}
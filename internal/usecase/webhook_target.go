@@ -0,0 +1,90 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errForbiddenTarget rejects connections to addresses webhooks may not reach
+var errForbiddenTarget = errors.New("webhook target address is not public")
+
+// reservedPrefixes are special-purpose IPv4 ranges that netip does not
+// classify: carrier-grade NAT, IETF protocol assignments, benchmarking and the
+// reserved class E range including broadcast
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// forbiddenAddr reports whether an address is loopback, link-local, private,
+// reserved or otherwise not a public unicast address
+func forbiddenAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTargetHost resolves a webhook host and rejects it when any of its
+// addresses is forbidden
+func checkTargetHost(ctx context.Context, host string) error {
+	addrs := []netip.Addr{}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, addr)
+	} else {
+		resolved, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return fmt.Errorf("%w: url host %q does not resolve", ErrValidation, host)
+		}
+		addrs = resolved
+	}
+
+	for _, addr := range addrs {
+		if forbiddenAddr(addr) {
+			return fmt.Errorf("%w: url must not resolve to a loopback, link-local, private or reserved address", ErrValidation)
+		}
+	}
+	return nil
+}
+
+// newTargetClient returns a client that refuses to connect to forbidden
+// addresses, checked when dialing so that DNS changes and redirects cannot
+// reach them either
+func newTargetClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || forbiddenAddr(addr) {
+				return fmt.Errorf("%w: %s", errForbiddenTarget, address)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Deliveries go straight to the receiver, so that its address is the one checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
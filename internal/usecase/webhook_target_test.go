@@ -0,0 +1,65 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckTargetHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		{host: "93.184.216.34"},
+		{host: "2606:2800:220:1:248:1893:25c8:1946"},
+		{host: "127.0.0.1", wantErr: true},
+		{host: "::1", wantErr: true},
+		{host: "10.0.0.8", wantErr: true},
+		{host: "172.16.4.2", wantErr: true},
+		{host: "192.168.1.1", wantErr: true},
+		{host: "169.254.169.254", wantErr: true},
+		{host: "fe80::1", wantErr: true},
+		{host: "fd00::1", wantErr: true},
+		{host: "0.0.0.0", wantErr: true},
+		{host: "100.64.0.1", wantErr: true},
+		{host: "100.127.255.254", wantErr: true},
+		{host: "192.0.0.170", wantErr: true},
+		{host: "198.18.0.1", wantErr: true},
+		{host: "198.19.255.1", wantErr: true},
+		{host: "240.0.0.1", wantErr: true},
+		{host: "255.255.255.255", wantErr: true},
+		{host: "::ffff:100.64.0.1", wantErr: true},
+		{host: "100.128.0.1"},
+		{host: "198.20.0.1"},
+		{host: "::ffff:127.0.0.1", wantErr: true},
+		{host: "localhost", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := checkTargetHost(context.Background(), tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkTargetHost(%q) = %v, want error %v", tt.host, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrValidation) {
+				t.Errorf("checkTargetHost(%q) = %v, want a validation error", tt.host, err)
+			}
+		})
+	}
+}
+
+func TestTargetClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	_, err := newTargetClient(time.Second).Get(server.URL)
+	if !errors.Is(err, errForbiddenTarget) {
+		t.Fatalf("GET %s = %v, want %v", server.URL, err, errForbiddenTarget)
+	}
+}
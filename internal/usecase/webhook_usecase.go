@@ -0,0 +1,302 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/dragondarkon/bqredis-crud/internal/domain/entity"
+	"github.com/dragondarkon/bqredis-crud/internal/repository"
+	"github.com/google/uuid"
+)
+
+// Webhook error types
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+)
+
+const (
+	// webhookSecretBytes is the length of generated signing secrets
+	webhookSecretBytes = 32
+
+	defaultDeliveryLimit = 20
+	maxDeliveryLimit     = 100
+)
+
+// webhookEvents lists the event types webhooks can subscribe to
+var webhookEvents = []string{entity.WebhookUserCreated, entity.WebhookUserUpdated, entity.WebhookUserDeleted}
+
+// WebhookUseCase manages webhook subscriptions and queues deliveries of user changes
+type WebhookUseCase struct {
+	webhookRepo    repository.WebhookRepository
+	queue          repository.WebhookQueue
+	privateTargets bool
+}
+
+// NewWebhookUseCase creates a new webhook use case
+func NewWebhookUseCase(webhookRepo repository.WebhookRepository, queue repository.WebhookQueue) *WebhookUseCase {
+	return &WebhookUseCase{
+		webhookRepo: webhookRepo,
+		queue:       queue,
+	}
+}
+
+// WithPrivateTargets accepts webhook URLs resolving to loopback, link-local
+// or private addresses, which are rejected by default
+func (uc *WebhookUseCase) WithPrivateTargets() *WebhookUseCase {
+	uc.privateTargets = true
+	return uc
+}
+
+// validateWebhook validates webhook fields, subscribing to every event when none is given
+func (uc *WebhookUseCase) validateWebhook(ctx context.Context, webhook *entity.Webhook, isCreate bool) error {
+	if !isCreate && webhook.ID == "" {
+		return fmt.Errorf("%w: id is required", ErrValidation)
+	}
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrValidation)
+	}
+	if !uc.privateTargets {
+		if err := checkTargetHost(ctx, target.Hostname()); err != nil {
+			return err
+		}
+	}
+
+	if len(webhook.Events) == 0 {
+		webhook.Events = slices.Clone(webhookEvents)
+	}
+	var events []string
+	for _, event := range webhook.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("%w: unknown event %q", ErrValidation, event)
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	webhook.Events = events
+	return nil
+}
+
+// generateSecret returns a random signing secret
+func generateSecret() (string, error) {
+	secret := make([]byte, webhookSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// withoutSecret returns a copy of the webhook that is safe to show after creation
+func withoutSecret(webhook entity.Webhook) entity.Webhook {
+	webhook.Secret = ""
+	return webhook
+}
+
+// GetAllWebhooks retrieves all webhooks with pagination
+func (uc *WebhookUseCase) GetAllWebhooks(ctx context.Context, page, pageSize int) ([]entity.Webhook, error) {
+	params := repository.PaginationParams{
+		Page:     max(page, 1),
+		PageSize: max(pageSize, 10),
+	}
+
+	webhooks, err := uc.webhookRepo.GetAll(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", err)
+	}
+
+	for i := range webhooks {
+		webhooks[i] = withoutSecret(webhooks[i])
+	}
+	return webhooks, nil
+}
+
+// getWebhook retrieves a webhook including its secret
+func (uc *WebhookUseCase) getWebhook(ctx context.Context, id string) (entity.Webhook, error) {
+	if id == "" {
+		return entity.Webhook{}, fmt.Errorf("%w: id is required", ErrValidation)
+	}
+
+	webhook, err := uc.webhookRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entity.Webhook{}, ErrWebhookNotFound
+		}
+		return entity.Webhook{}, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// GetWebhookByID retrieves a webhook by ID
+func (uc *WebhookUseCase) GetWebhookByID(ctx context.Context, id string) (entity.Webhook, error) {
+	webhook, err := uc.getWebhook(ctx, id)
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	return withoutSecret(webhook), nil
+}
+
+// CreateWebhook creates a new webhook, generating its secret unless one is
+// given; the response is the only one that includes the secret
+func (uc *WebhookUseCase) CreateWebhook(ctx context.Context, webhook entity.Webhook) (entity.Webhook, error) {
+	if err := uc.validateWebhook(ctx, &webhook, true); err != nil {
+		return entity.Webhook{}, err
+	}
+
+	// Set ID, secret and timestamps
+	if webhook.ID == "" {
+		webhook.ID = uuid.New().String()
+	}
+	if webhook.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return entity.Webhook{}, err
+		}
+		webhook.Secret = secret
+	}
+	now := time.Now()
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+
+	if err := uc.webhookRepo.Create(ctx, webhook); err != nil {
+		return entity.Webhook{}, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+// UpdateWebhook updates an existing webhook, keeping its secret unless a new one is given
+func (uc *WebhookUseCase) UpdateWebhook(ctx context.Context, webhook entity.Webhook) (entity.Webhook, error) {
+	if err := uc.validateWebhook(ctx, &webhook, false); err != nil {
+		return entity.Webhook{}, err
+	}
+
+	current, err := uc.getWebhook(ctx, webhook.ID)
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = current.Secret
+	}
+	webhook.CreatedAt = current.CreatedAt
+	webhook.UpdatedAt = time.Now()
+
+	if err := uc.webhookRepo.Update(ctx, webhook); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entity.Webhook{}, ErrWebhookNotFound
+		}
+		return entity.Webhook{}, fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	return withoutSecret(webhook), nil
+}
+
+// DeleteWebhook removes a webhook; its pending deliveries fail when they are due
+func (uc *WebhookUseCase) DeleteWebhook(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: id is required", ErrValidation)
+	}
+
+	if err := uc.webhookRepo.Delete(ctx, id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrWebhookNotFound
+		}
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	return nil
+}
+
+// GetDeliveries retrieves the recent deliveries of a webhook, newest first
+func (uc *WebhookUseCase) GetDeliveries(ctx context.Context, webhookID string, limit int) ([]entity.WebhookDelivery, error) {
+	if _, err := uc.getWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	if limit < 1 {
+		limit = defaultDeliveryLimit
+	}
+
+	deliveries, err := uc.queue.ListByWebhook(ctx, webhookID, min(limit, maxDeliveryLimit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// Redeliver queues a new delivery of the event of an earlier delivery
+func (uc *WebhookUseCase) Redeliver(ctx context.Context, webhookID, deliveryID string) (entity.WebhookDelivery, error) {
+	webhook, err := uc.getWebhook(ctx, webhookID)
+	if err != nil {
+		return entity.WebhookDelivery{}, err
+	}
+	if !webhook.Active {
+		return entity.WebhookDelivery{}, fmt.Errorf("%w: webhook %s is inactive", ErrValidation, webhookID)
+	}
+
+	original, err := uc.queue.Get(ctx, deliveryID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return entity.WebhookDelivery{}, ErrDeliveryNotFound
+		}
+		return entity.WebhookDelivery{}, fmt.Errorf("failed to get delivery: %w", err)
+	}
+	if original.WebhookID != webhookID {
+		return entity.WebhookDelivery{}, ErrDeliveryNotFound
+	}
+
+	delivery := newDelivery(webhookID, original.Event)
+	delivery.RedeliveryOf = original.ID
+	if err := uc.queue.Enqueue(ctx, delivery); err != nil {
+		return entity.WebhookDelivery{}, fmt.Errorf("failed to queue redelivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// PublishEvent queues a delivery of an outbox event to every webhook subscribed
+// to it. Delivery IDs are derived from the event and the webhook, so publishing
+// an event again queues nothing new.
+func (uc *WebhookUseCase) PublishEvent(ctx context.Context, outboxEvent entity.OutboxEvent) error {
+	webhooks, err := uc.webhookRepo.ListActive(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	event := entity.WebhookEvent{
+		ID:         outboxEvent.ID,
+		Type:       "user." + outboxEvent.Type,
+		UserID:     outboxEvent.UserID,
+		OccurredAt: outboxEvent.OccurredAt,
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event.Type) {
+			continue
+		}
+		delivery := newDelivery(webhook.ID, event)
+		delivery.ID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(event.ID+"/"+webhook.ID)).String()
+		if err := uc.queue.Enqueue(ctx, delivery); err != nil {
+			return fmt.Errorf("failed to queue %s event for webhook %s: %w", event.Type, webhook.ID, err)
+		}
+	}
+	return nil
+}
+
+// newDelivery creates a pending delivery that is due immediately
+func newDelivery(webhookID string, event entity.WebhookEvent) entity.WebhookDelivery {
+	now := time.Now().UTC()
+	return entity.WebhookDelivery{
+		ID:            uuid.New().String(),
+		WebhookID:     webhookID,
+		Event:         event,
+		Status:        entity.DeliveryPending,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
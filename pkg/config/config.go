@@ -3,20 +3,80 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+	"google.golang.org/api/option"
 )
 
 // Config holds application configuration
 type Config struct {
-	GoogleCloudProject string
-	BigQueryDataset    string
-	BigQueryTable      string
-	RedisAddr          string
-	RedisPassword      string
-	RedisTTL           time.Duration
-	Port               string
+	GoogleCloudProject       string
+	BigQueryDataset          string
+	BigQueryTable            string
+	BigQueryGroupsTable      string
+	BigQueryMembershipsTable string
+	BigQueryErasuresTable    string
+	BigQueryWebhooksTable    string
+	BigQueryEndpoint         string
+	BigQueryNoAuth           bool
+	RedisAddr                string
+	RedisPassword            string
+	RedisTTL                 time.Duration
+	CacheItemTTL             time.Duration
+	CacheItemSoftTTL         time.Duration
+	CacheListTTL             time.Duration
+	CacheListSoftTTL         time.Duration
+	CacheMissingTTL          time.Duration
+	CacheWriteStrategy       string
+	CacheLock                bool
+	LocalCacheSize           int
+	LocalCacheTTL            time.Duration
+	CacheLockTTL             time.Duration
+	CacheLockWait            time.Duration
+	RedisBreakerFailures     int
+	RedisBreakerOpenTimeout  time.Duration
+	RedisBreakerProbes       int
+	WarmupOnStart            bool
+	WarmupTenants            []string
+	WarmupPages              int
+	WarmupPageSize           int
+	WarmupUsers              int
+	WarmupUsersBy            string
+	WarmupRate               int
+	Port                     string
+	TenantMode               string
+	TenantColumn             string
+	TenantHeader             string
+	TenantJWTClaim           string
+	TenantTrustHeader        bool
+	JWTSecret                string
+	SnapshotRetention        time.Duration
+	ApplySnapshotRetention   bool
+	EncryptionKeyFile        string
+	EncryptedFields          []string
+	MaskingPolicy            string
+	RoleHeader               string
+	RoleJWTClaim             string
+	RoleTrustHeader          bool
+	AdminRole                string
+	StatsCacheTTL            time.Duration
+	ChangeStreamMaxLen       int64
+	WebhookMaxAttempts       int
+	WebhookBackoff           time.Duration
+	WebhookMaxBackoff        time.Duration
+	WebhookTimeout           time.Duration
+	WebhookPollInterval      time.Duration
+	WebhookRetention         time.Duration
+	WebhookPrivateTargets    bool
+	OutboxSinks              []string
+	OutboxPollInterval       time.Duration
+	OutboxLease              time.Duration
+	OutboxReconcileAfter     time.Duration
+	IdempotencyWindow        time.Duration
+	IdempotencyLockTimeout   time.Duration
 }
 
 // LoadConfig loads configuration from environment variables
@@ -29,18 +89,90 @@ func LoadConfig() *Config {
 
 	// Set defaults and override with environment variables
 	config := &Config{
-		GoogleCloudProject: getEnv("GOOGLE_CLOUD_PROJECT", ""),
-		BigQueryDataset:    getEnv("BIGQUERY_DATASET", "users_dataset"),
-		BigQueryTable:      getEnv("BIGQUERY_TABLE", "users"),
-		RedisAddr:          getEnv("REDIS_ADDR", "localhost:6379"),
-		RedisPassword:      getEnv("REDIS_PASSWORD", ""),
-		RedisTTL:           time.Duration(getEnvAsInt("REDIS_TTL_MINUTES", 5)) * time.Minute,
-		Port:               getEnv("PORT", "8080"),
+		GoogleCloudProject:       getEnv("GOOGLE_CLOUD_PROJECT", ""),
+		BigQueryDataset:          getEnv("BIGQUERY_DATASET", "users_dataset"),
+		BigQueryTable:            getEnv("BIGQUERY_TABLE", "users"),
+		BigQueryGroupsTable:      getEnv("BIGQUERY_GROUPS_TABLE", "groups"),
+		BigQueryMembershipsTable: getEnv("BIGQUERY_MEMBERSHIPS_TABLE", "group_members"),
+		BigQueryErasuresTable:    getEnv("BIGQUERY_ERASURES_TABLE", "erasure_audit"),
+		BigQueryWebhooksTable:    getEnv("BIGQUERY_WEBHOOKS_TABLE", "webhooks"),
+		BigQueryEndpoint:         getEnv("BIGQUERY_ENDPOINT", ""),
+		BigQueryNoAuth:           getEnvAsBool("BIGQUERY_NO_AUTH", false),
+		RedisAddr:                getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:            getEnv("REDIS_PASSWORD", ""),
+		RedisTTL:                 time.Duration(getEnvAsInt("REDIS_TTL_MINUTES", 5)) * time.Minute,
+		CacheItemSoftTTL:         getEnvAsDuration("CACHE_ITEM_SOFT_TTL", 0),
+		CacheListSoftTTL:         getEnvAsDuration("CACHE_LIST_SOFT_TTL", 0),
+		CacheMissingTTL:          getEnvAsDuration("CACHE_MISSING_TTL", 30*time.Second),
+		CacheWriteStrategy:       getEnv("CACHE_WRITE_STRATEGY", "invalidate"),
+		CacheLock:                getEnvAsBool("CACHE_LOCK", false),
+		CacheLockTTL:             getEnvAsDuration("CACHE_LOCK_TTL", 5*time.Second),
+		CacheLockWait:            getEnvAsDuration("CACHE_LOCK_WAIT", 250*time.Millisecond),
+		LocalCacheSize:           getEnvAsIntValue("LOCAL_CACHE_SIZE", 0),
+		LocalCacheTTL:            getEnvAsDuration("LOCAL_CACHE_TTL", 10*time.Second),
+		RedisBreakerFailures:     getEnvAsIntValue("REDIS_BREAKER_FAILURES", 5),
+		RedisBreakerOpenTimeout:  getEnvAsDuration("REDIS_BREAKER_OPEN_TIMEOUT", 10*time.Second),
+		RedisBreakerProbes:       getEnvAsIntValue("REDIS_BREAKER_PROBES", 3),
+		WarmupOnStart:            getEnvAsBool("WARMUP_ON_START", false),
+		WarmupTenants:            getEnvAsList("WARMUP_TENANTS", nil),
+		WarmupPages:              getEnvAsIntValue("WARMUP_PAGES", 5),
+		WarmupPageSize:           getEnvAsIntValue("WARMUP_PAGE_SIZE", 10),
+		WarmupUsers:              getEnvAsIntValue("WARMUP_USERS", 100),
+		WarmupUsersBy:            getEnv("WARMUP_USERS_BY", "recent"),
+		WarmupRate:               getEnvAsIntValue("WARMUP_RATE", 5),
+		Port:                     getEnv("PORT", "8080"),
+		TenantMode:               getEnv("TENANT_MODE", ""),
+		TenantColumn:             getEnv("TENANT_COLUMN", "tenant_id"),
+		TenantHeader:             getEnv("TENANT_HEADER", "X-Tenant-ID"),
+		TenantJWTClaim:           getEnv("TENANT_JWT_CLAIM", "tenant_id"),
+		TenantTrustHeader:        getEnvAsBool("TENANT_TRUST_HEADER", false),
+		JWTSecret:                getEnv("JWT_SECRET", ""),
+		SnapshotRetention:        getEnvAsDuration("SNAPSHOT_RETENTION", 168*time.Hour),
+		ApplySnapshotRetention:   getEnvAsBool("APPLY_SNAPSHOT_RETENTION", false),
+		EncryptionKeyFile:        getEnv("ENCRYPTION_KEYFILE", ""),
+		EncryptedFields:          getEnvAsList("ENCRYPTED_FIELDS", []string{"name", "email"}),
+		MaskingPolicy:            getEnv("MASKING_POLICY", ""),
+		RoleHeader:               getEnv("ROLE_HEADER", ""),
+		RoleJWTClaim:             getEnv("ROLE_JWT_CLAIM", "role"),
+		RoleTrustHeader:          getEnvAsBool("ROLE_TRUST_HEADER", false),
+		AdminRole:                getEnv("ADMIN_ROLE", "admin"),
+		StatsCacheTTL:            getEnvAsDuration("STATS_CACHE_TTL", 10*time.Minute),
+		ChangeStreamMaxLen:       getEnvAsInt64("CHANGE_STREAM_MAXLEN", 10000),
+		WebhookMaxAttempts:       getEnvAsIntValue("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookBackoff:           getEnvAsDuration("WEBHOOK_BACKOFF", 30*time.Second),
+		WebhookMaxBackoff:        getEnvAsDuration("WEBHOOK_MAX_BACKOFF", time.Hour),
+		WebhookTimeout:           getEnvAsDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookPollInterval:      getEnvAsDuration("WEBHOOK_POLL_INTERVAL", time.Second),
+		WebhookRetention:         getEnvAsDuration("WEBHOOK_DELIVERY_RETENTION", 168*time.Hour),
+		WebhookPrivateTargets:    getEnvAsBool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false),
+		OutboxSinks:              getEnvAsList("OUTBOX_SINKS", []string{"redis_stream", "webhooks"}),
+		OutboxPollInterval:       getEnvAsDuration("OUTBOX_POLL_INTERVAL", 500*time.Millisecond),
+		OutboxLease:              getEnvAsDuration("OUTBOX_LEASE", 30*time.Second),
+		OutboxReconcileAfter:     getEnvAsDuration("OUTBOX_RECONCILE_AFTER", time.Minute),
+		IdempotencyWindow:        getEnvAsDuration("IDEMPOTENCY_WINDOW", 24*time.Hour),
+		IdempotencyLockTimeout:   getEnvAsDuration("IDEMPOTENCY_LOCK_TIMEOUT", time.Minute),
 	}
 
+	// Hard cache TTLs default to REDIS_TTL_MINUTES
+	config.CacheItemTTL = getEnvAsDuration("CACHE_ITEM_TTL", config.RedisTTL)
+	config.CacheListTTL = getEnvAsDuration("CACHE_LIST_TTL", config.RedisTTL)
+
 	return config
 }
 
+// BigQueryOptions returns the client options for the configured BigQuery API,
+// e.g. a local emulator that does not require credentials
+func (c *Config) BigQueryOptions() []option.ClientOption {
+	var opts []option.ClientOption
+	if c.BigQueryEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(c.BigQueryEndpoint))
+	}
+	if c.BigQueryNoAuth {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	return opts
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -57,3 +189,54 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvAsIntValue gets an environment variable as a plain integer or returns a default value
+func getEnvAsIntValue(key string, defaultValue int) int {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.Atoi(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsInt64 gets an environment variable as a 64-bit integer or returns a default value
+func getEnvAsInt64(key string, defaultValue int64) int64 {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseInt(valueStr, 10, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsDuration gets an environment variable as a duration or returns a default value
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr := getEnv(key, "")
+	if value, err := time.ParseDuration(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsList gets a comma-separated environment variable or returns a default value
+func getEnvAsList(key string, defaultValue []string) []string {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+	var values []string
+	for _, value := range strings.Split(valueStr, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvAsBool gets an environment variable as a boolean or returns a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}